@@ -9,6 +9,7 @@ import (
 	"runtime"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
@@ -52,7 +53,7 @@ func main() {
 			},
 			&cli.BoolFlag{
 				Name:  "archives",
-				Usage: "Also scan archives (.zip,.tar,.gz,.bz2,.xz,.rar,.7z,...)",
+				Usage: "Also scan archives (.zip,.tar,.gz,.bz2,.xz,.rar,.7z,...) and ISO 9660/FAT disk images (.iso,.img)",
 			},
 			&cli.IntFlag{
 				Name:  "depth",
@@ -75,6 +76,117 @@ func main() {
 				Name:  "save-matches-folder",
 				Usage: "Create per-pattern files with matched lines inside this folder",
 			},
+			&cli.BoolFlag{
+				Name:  "names-only",
+				Usage: "Only apply 'name:' and 'path:' rules to file and archive entry names, skip content scanning",
+			},
+			&cli.StringSliceFlag{
+				Name:  "yara",
+				Usage: "YARA rule file(s) evaluated alongside the pattern file (subset: text/hex/regex strings, counts, filesize, 'of' sets)",
+			},
+			&cli.StringFlag{
+				Name:  "binary",
+				Usage: "Binary files (NUL/control bytes): skip - no line matching, text - scan raw lines, strings - match printable ASCII/UTF-16 runs only",
+				Value: "text",
+			},
+			&cli.IntFlag{
+				Name:  "strings-min",
+				Usage: "Minimal run length for --binary=strings (like strings -n)",
+				Value: 4,
+			},
+			&cli.IntFlag{
+				Name:  "max-line",
+				Usage: "Max line length in bytes kept in memory; longer lines are matched in chunks and reported as offset + snippet",
+				Value: 1 << 20,
+			},
+			&cli.IntFlag{
+				Name:  "snippet",
+				Usage: "Report N runes of context around each match instead of the whole line (0 = whole line)",
+			},
+			&cli.BoolFlag{
+				Name:  "full-line",
+				Usage: "With --snippet, also keep the whole matched line in results",
+			},
+			&cli.IntFlag{
+				Name:    "after-context",
+				Aliases: []string{"A"},
+				Usage:   "Lines of trailing context to report with each line match",
+			},
+			&cli.IntFlag{
+				Name:    "before-context",
+				Aliases: []string{"B"},
+				Usage:   "Lines of leading context to report with each line match",
+			},
+			&cli.IntFlag{
+				Name:    "context",
+				Aliases: []string{"C"},
+				Usage:   "Lines of context on both sides; -A/-B override it",
+			},
+			&cli.BoolFlag{
+				Name:  "decode",
+				Usage: "Also match base64, hex and URL-encoded tokens after decoding them (nested encodings too); findings carry the decoding chain",
+			},
+			&cli.IntFlag{
+				Name:  "decode-min",
+				Usage: "Shortest encoded token to decode with --decode",
+				Value: 16,
+			},
+			&cli.IntFlag{
+				Name:  "decode-depth",
+				Usage: "Max nested decodings per token with --decode",
+				Value: 3,
+			},
+			&cli.BoolFlag{
+				Name:  "qr",
+				Usage: "Decode QR codes in PNG, JPEG and GIF images and match their payloads; findings carry the code's region",
+			},
+			&cli.Int64Flag{
+				Name:  "qr-max-pixels",
+				Usage: "Skip QR decoding of images with more pixels than this",
+				Value: 40_000_000,
+			},
+			&cli.DurationFlag{
+				Name:  "qr-timeout",
+				Usage: "Max QR search time per image",
+				Value: 5 * time.Second,
+			},
+			&cli.BoolFlag{
+				Name:  "git-history",
+				Usage: "Scan the lines added by every commit of git repositories found under the roots; findings carry commit, author and date",
+			},
+			&cli.StringFlag{
+				Name:  "git-range",
+				Usage: "Commits to scan with --git-history: A..B or a single revision (default: all refs)",
+			},
+			&cli.BoolFlag{
+				Name:  "git-unreachable",
+				Usage: "With --git-history also scan commits and blobs no ref leads to (dangling, loose or packed)",
+			},
+			&cli.BoolFlag{
+				Name:  "images",
+				Usage: "Scan container images (docker save tarballs, OCI layouts) layer by layer, including files deleted by later layers; findings carry the layer digest and Dockerfile instruction",
+			},
+			&cli.StringFlag{
+				Name:  "archive-passwords",
+				Usage: "File of passwords, one per line, tried on encrypted zip, 7z and rar archives; encrypted entries are reported either way",
+			},
+			&cli.Int64Flag{
+				Name:  "split-size",
+				Usage: "Scan regular files larger than this many bytes as parallel line-aligned parts without overlap (default 0 = never); only with --no-hash, as no part sees the whole file, and not with --save-full, context, --binary other than text, or regex, hash, hex:/wide: and YARA rules, which need the whole stream",
+			},
+			&cli.BoolFlag{
+				Name:  "no-hash",
+				Usage: "Do not hash every file read: by default each file costs a sha256 pass over all its bytes and its findings wait for the end of the file to carry the hash; with this flag they are reported at once without it (files are still hashed for hash: rules)",
+			},
+			&cli.BoolFlag{
+				Name:  "mmap",
+				Usage: "Memory-map local regular files instead of streaming them (Linux; archives, pipes and network filesystems are streamed)",
+			},
+			&cli.StringFlag{
+				Name:  "stdin-name",
+				Usage: "Virtual file name for stdin when '-' is passed as a path (archives on stdin are detected by content)",
+				Value: "stdin",
+			},
 			&cli.StringFlag{
 				Name:  "log-level",
 				Usage: "Log level: debug, info, warn, error",
@@ -102,18 +214,23 @@ func main() {
 			// roots
 			roots := c.Args().Slice()
 			var validRoots []string
+			stdin := false
 			if len(roots) == 0 {
 				validRoots = internal.DetectRoots(runtime.GOOS)
 				logrus.Infof("No search paths provided, using auto roots: %v", validRoots)
 			} else {
 				for _, r := range roots {
+					if r == internal.StdinArg {
+						stdin = true
+						continue
+					}
 					if st, err := os.Stat(r); err == nil && st.IsDir() {
 						validRoots = append(validRoots, r)
 					} else {
 						logrus.Warnf("Skip: not a dir or inaccessible: %s", r)
 					}
 				}
-				if len(validRoots) == 0 {
+				if len(validRoots) == 0 && !stdin {
 					return cli.Exit("No valid search paths", 1)
 				}
 			}
@@ -150,6 +267,37 @@ func main() {
 				FailFast:                   c.Bool("fail-fast"),
 				SaveMatchesFile:            c.String("save-matches-file"),
 				SaveMatchesByPatternFolder: c.String("save-matches-folder"),
+				Stdin:                      stdin,
+				StdinName:                  c.String("stdin-name"),
+				NamesOnly:                  c.Bool("names-only"),
+				YaraFiles:                  c.StringSlice("yara"),
+				Binary:                     c.String("binary"),
+				StringsMin:                 c.Int("strings-min"),
+				MaxLineLen:                 c.Int("max-line"),
+				SnippetLen:                 c.Int("snippet"),
+				FullLine:                   c.Bool("full-line"),
+				SplitSize:                  c.Int64("split-size"),
+				NoHash:                     c.Bool("no-hash"),
+				Mmap:                       c.Bool("mmap"),
+				Decode:                     c.Bool("decode"),
+				DecodeMin:                  c.Int("decode-min"),
+				DecodeDepth:                c.Int("decode-depth"),
+				QR:                         c.Bool("qr"),
+				QRMaxPixels:                c.Int64("qr-max-pixels"),
+				QRTimeout:                  c.Duration("qr-timeout"),
+				GitHistory:                 c.Bool("git-history"),
+				GitRange:                   c.String("git-range"),
+				GitUnreachable:             c.Bool("git-unreachable"),
+				Images:                     c.Bool("images"),
+				ArchivePasswords:           c.String("archive-passwords"),
+				ContextBefore:              c.Int("context"),
+				ContextAfter:               c.Int("context"),
+			}
+			if c.IsSet("before-context") {
+				opts.ContextBefore = c.Int("before-context")
+			}
+			if c.IsSet("after-context") {
+				opts.ContextAfter = c.Int("after-context")
 			}
 			if err := opts.Validate(); err != nil {
 				return cli.Exit(err.Error(), 1)
@@ -157,6 +305,7 @@ func main() {
 			opts.Prepare() // build fast lookup maps, set thread defaults
 
 			var stats internal.AppStats
+			opts.Stats = &stats
 			finder := internal.NewFileScanner()
 
 			if err := finder.Scan(ctx, opts, internal.NewResultSink(opts, &stats)); err != nil {
@@ -168,8 +317,8 @@ func main() {
 			}
 
 			fmt.Printf(
-				"\n======= Scan finished in %s =======\nTotal files scanned: %d\nTotal matches found: %d\nErrors: %d\n",
-				stats.Elapsed(), stats.FilesProcessed.Load(), stats.Matches.Load(), stats.Errors.Load(),
+				"\n======= Scan finished in %s =======\nTotal files scanned: %d\nBinary files: %d (mode: %s)\nTotal matches found: %d\nErrors: %d\n",
+				stats.Elapsed(), stats.FilesProcessed.Load(), stats.BinaryFiles.Load(), stats.BinaryMode, stats.Matches.Load(), stats.Errors.Load(),
 			)
 			return nil
 		},
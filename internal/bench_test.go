@@ -1,8 +1,13 @@
 package internal
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
 	"testing"
 )
 
@@ -24,3 +29,57 @@ func BenchmarkLoadPatterns(b *testing.B) {
 		}
 	}
 }
+
+// benchFile writes ~16 MiB of log-like text with a rare hit.
+func benchFile(b *testing.B) string {
+	b.Helper()
+	fp := filepath.Join(b.TempDir(), "bench.log")
+	var sb strings.Builder
+	for i := 0; sb.Len() < 16<<20; i++ {
+		if i%5000 == 0 {
+			fmt.Fprintf(&sb, "2024-01-01T00:00:%02d INFO user=alice Password=hunter%d\n", i%60, i)
+		} else {
+			fmt.Fprintf(&sb, "2024-01-01T00:00:%02d INFO request id=%d path=/api/v1/items status=200 took=%dms\n", i%60, i, i%300)
+		}
+	}
+	if err := os.WriteFile(fp, []byte(sb.String()), 0644); err != nil {
+		b.Fatal(err)
+	}
+	return fp
+}
+
+func benchScanFile(b *testing.B, mmap bool) {
+	fp := benchFile(b)
+	fi, _ := os.Stat(fp)
+	rules := &RuleSet{
+		Patterns: []Pattern{
+			&PlainPattern{s: []byte("password="), insensitive: true},
+			&RegexPattern{re: regexp.MustCompile(`AKIA[0-9A-Z]{16}`)},
+		},
+		HasInsensitive: true,
+	}
+	if mmap {
+		f, err := os.Open(fp)
+		if err != nil {
+			b.Fatal(err)
+		}
+		m, err := mapFile(f)
+		f.Close()
+		if err != nil {
+			b.Skipf("mmap unavailable: %v", err)
+		}
+		m.Close()
+	}
+	opts := ScanOptions{Mmap: mmap}
+	fs := NewFileScanner()
+	var matchCnt, errCnt atomic.Int64
+	b.SetBytes(fi.Size())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fs.scanRegularFile(context.Background(), fp, rules, opts, func(MatchResult) {}, &matchCnt, &errCnt)
+	}
+}
+
+func BenchmarkScanFile_Stream(b *testing.B) { benchScanFile(b, false) }
+func BenchmarkScanFile_Mmap(b *testing.B)   { benchScanFile(b, true) }
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+)
+
+// Binary file handling modes (--binary).
+const (
+	BinarySkip    = "skip"    // no line matching on binaries
+	BinaryText    = "text"    // scan binaries line by line like text
+	BinaryStrings = "strings" // match printable runs only, like strings(1)
+)
+
+const (
+	binarySniffSize = 8 << 10
+	maxStringRun    = 64 << 10 // longer runs are split, keeps memory bounded
+)
+
+// isBinary classifies content by its first bytes: any NUL, or more than 10%
+// control bytes other than the usual whitespace, means binary.
+func isBinary(sample []byte) bool {
+	if len(sample) == 0 {
+		return false
+	}
+	if bytes.IndexByte(sample, 0) >= 0 {
+		return true
+	}
+	ctrl := 0
+	for _, c := range sample {
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' && c != '\f' && c != '\b' && c != 0x1b {
+			ctrl++
+		}
+	}
+	return ctrl*10 > len(sample)
+}
+
+func isPrintable(c byte) bool { return c >= 0x20 && c < 0x7f || c == '\t' }
+
+// extractStrings streams r and calls fn for every run of at least minLen
+// printable ASCII characters, and for every UTF-16LE run of the same length
+// (decoded to ASCII). off is the byte offset of the run in r.
+func extractStrings(r io.Reader, minLen int, fn func(run []byte, off int64)) error {
+	if minLen < 1 {
+		minLen = 1
+	}
+	var (
+		buf   = make([]byte, 32<<10)
+		ascii []byte
+		aOff  int64
+		wide  [2][]byte // UTF-16LE runs by start parity
+		wOff  [2]int64
+		prev  = -1
+		pos   int64
+	)
+	flushASCII := func() {
+		if len(ascii) >= minLen {
+			fn(ascii, aOff)
+		}
+		ascii = ascii[:0]
+	}
+	flushWide := func(p int) {
+		if len(wide[p]) >= minLen {
+			fn(wide[p], wOff[p])
+		}
+		wide[p] = wide[p][:0]
+	}
+	for {
+		n, err := r.Read(buf)
+		for _, c := range buf[:n] {
+			if isPrintable(c) {
+				if len(ascii) == 0 {
+					aOff = pos
+				}
+				ascii = append(ascii, c)
+				if len(ascii) >= maxStringRun {
+					flushASCII()
+				}
+			} else {
+				flushASCII()
+			}
+			// pair (prev, c) starts at pos-1
+			if prev >= 0 {
+				p := int((pos - 1) & 1)
+				if c == 0 && isPrintable(byte(prev)) {
+					if len(wide[p]) == 0 {
+						wOff[p] = pos - 1
+					}
+					wide[p] = append(wide[p], byte(prev))
+					if len(wide[p]) >= maxStringRun {
+						flushWide(p)
+					}
+				} else {
+					flushWide(p)
+				}
+			}
+			prev = int(c)
+			pos++
+		}
+		if err != nil {
+			flushASCII()
+			flushWide(0)
+			flushWide(1)
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
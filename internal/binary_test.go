@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestIsBinary(t *testing.T) {
+	if isBinary([]byte("plain text\r\n\twith tabs\n")) {
+		t.Fatal("text classified as binary")
+	}
+	if !isBinary([]byte("MZ\x90\x00\x03")) {
+		t.Fatal("NUL must mean binary")
+	}
+	if !isBinary(bytes.Repeat([]byte{0x01, 0x02, 'a'}, 100)) {
+		t.Fatal("control-heavy data must be binary")
+	}
+	if isBinary(nil) {
+		t.Fatal("empty is not binary")
+	}
+}
+
+func TestExtractStrings(t *testing.T) {
+	data := []byte("\x00\x01abc\x02hello world\x00\x00")
+	data = append(data, toUTF16LE("wide secret")...)
+	data = append(data, 0, 0, 'x')
+
+	type run struct {
+		s   string
+		off int64
+	}
+	var got []run
+	err := extractStrings(bytes.NewReader(data), 4, func(r []byte, off int64) {
+		got = append(got, run{string(r), off})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []run{{"hello world", 6}, {"wide secret", 19}}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("run %d: got %+v want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestMatchReader_BinaryModes(t *testing.T) {
+	data := "\x7fELF\x00\x00junk\x01\x02token=abc\x00\x00more\n"
+	rules := &RuleSet{Patterns: []Pattern{stubPattern{sub: "token"}}}
+
+	run := func(mode string) ([]MatchResult, *AppStats) {
+		stats := &AppStats{}
+		opts := ScanOptions{Binary: mode, StringsMin: 4, Stats: stats}
+		var got []MatchResult
+		var matchCnt, errCnt atomic.Int64
+		matchReader(bytes.NewBufferString(data), rules, opts, func(m MatchResult) { got = append(got, m) }, "/bin", "", &matchCnt, &errCnt)
+		return got, stats
+	}
+
+	got, stats := run(BinarySkip)
+	if len(got) != 0 || stats.BinaryFiles.Load() != 1 {
+		t.Fatalf("skip: %+v, binaries=%d", got, stats.BinaryFiles.Load())
+	}
+
+	got, _ = run(BinaryText)
+	if len(got) != 1 || !strings.Contains(got[0].Line, "\x00") {
+		t.Fatalf("text: expected raw line match, got %+v", got)
+	}
+
+	got, _ = run(BinaryStrings)
+	if len(got) != 1 || got[0].Line != "token=abc\n" || got[0].Offset != 12 || got[0].LineNumber != 1 {
+		t.Fatalf("strings: got %+v", got)
+	}
+}
+
+func TestMatchReader_SkipStillFeedsByteRules(t *testing.T) {
+	p, _ := parseBytePattern("hex:7F 45 4C 46")
+	rules := &RuleSet{Bytes: []*BytePattern{p}, Patterns: []Pattern{stubPattern{sub: "ELF"}}}
+	var got []MatchResult
+	var matchCnt, errCnt atomic.Int64
+	matchReader(bytes.NewBufferString("\x7fELF\x00\x00"), rules, ScanOptions{Binary: BinarySkip}, func(m MatchResult) { got = append(got, m) }, "/bin", "", &matchCnt, &errCnt)
+	if len(got) != 1 || !got[0].ByteMatch {
+		t.Fatalf("unexpected: %+v", got)
+	}
+}
@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	byteChunk      = 1 << 20
+	byteOverlap    = 4 << 10
+	maxByteMatches = 1000 // per file, protects sinks from floods on binary junk
+)
+
+// BytePattern matches raw byte sequences, not lines.
+//
+//	hex:DE AD ?? EF       - hex bytes, ?? / ?F / F? wildcards, [n-m] jumps, ( AA | BB ) alternatives
+//	wide:password         - UTF-16LE encoding of the text
+//	wide:i:password       - same, ASCII case-insensitive
+type BytePattern struct {
+	hex         []hexTok
+	needle      []byte
+	insensitive bool
+	desc        string
+}
+
+func (p *BytePattern) Desc() string { return p.desc }
+
+// find calls fn for every match starting before limit until fn returns false.
+// fold is win with ASCII letters lowercased (only read for insensitive patterns).
+func (p *BytePattern) find(win, fold []byte, limit int, fn func(start, end int) bool) {
+	if p.hex != nil {
+		first := p.hex[0]
+		for i := 0; i < limit; i++ {
+			if first.kind == hexByte && first.mask == 0xFF {
+				j := bytes.IndexByte(win[i:limit], first.val)
+				if j < 0 {
+					return
+				}
+				i += j
+			}
+			if end, ok := matchHex(win, i, p.hex); ok && !fn(i, end) {
+				return
+			}
+		}
+		return
+	}
+	hay := win
+	if p.insensitive {
+		hay = fold
+	}
+	for i := 0; i < limit; {
+		j := bytes.Index(hay[i:], p.needle)
+		if j < 0 || i+j >= limit {
+			return
+		}
+		if !fn(i+j, i+j+len(p.needle)) {
+			return
+		}
+		i += j + 1
+	}
+}
+
+func parseBytePattern(line string) (*BytePattern, error) {
+	p := &BytePattern{desc: line}
+	switch {
+	case strings.HasPrefix(line, "hex:"):
+		toks, err := (&yaraParser{src: "{" + line[4:] + "}"}).hexString()
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex pattern %q: %w", line, err)
+		}
+		p.hex = toks
+	case strings.HasPrefix(line, "wide:i:"):
+		// the content is folded ASCII-only, byte by byte; so is the needle
+		s := line[7:]
+		if strings.IndexFunc(s, func(r rune) bool { return r >= utf8.RuneSelf }) >= 0 {
+			return nil, fmt.Errorf("invalid pattern %q: wide:i: takes ASCII only", line)
+		}
+		p.insensitive = true
+		p.needle = toUTF16LE(string(foldASCII([]byte(s))))
+	default:
+		p.needle = toUTF16LE(line[5:]) // "wide:"
+	}
+	if p.hex == nil && len(p.needle) == 0 {
+		return nil, fmt.Errorf("empty pattern %q", line)
+	}
+	return p, nil
+}
+
+// byteScanner runs byte patterns over the content stream in overlapping chunks
+// and reports every occurrence with its absolute offset.
+type byteScanner struct {
+	*windowScanner
+	pats     []*BytePattern
+	fold     []byte
+	needFold bool
+	hits     int
+	onHit    func(p *BytePattern, off int64, m []byte)
+}
+
+func newByteScanner(pats []*BytePattern, onHit func(p *BytePattern, off int64, m []byte)) *byteScanner {
+	bs := &byteScanner{pats: pats, onHit: onHit}
+	for _, p := range pats {
+		bs.needFold = bs.needFold || p.insensitive
+	}
+	bs.windowScanner = newWindowScanner(byteChunk, byteOverlap, bs.scan)
+	return bs
+}
+
+func (bs *byteScanner) scan(win []byte, base int64, limit int) {
+	if bs.needFold {
+		bs.fold = append(bs.fold[:0], win...)
+		foldASCIIInPlace(bs.fold)
+	}
+	for _, p := range bs.pats {
+		p.find(win, bs.fold, limit, func(start, end int) bool {
+			if bs.hits >= maxByteMatches {
+				return false
+			}
+			bs.hits++
+			bs.onHit(p, base+int64(start), win[start:end])
+			return true
+		})
+	}
+}
+
+// hexPreview renders matched bytes as "DE AD 00 EF", capped for sinks.
+func hexPreview(m []byte) string {
+	const maxPreview = 64
+	if len(m) > maxPreview {
+		return fmt.Sprintf("% X ...", m[:maxPreview])
+	}
+	return fmt.Sprintf("% X", m)
+}
@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+)
+
+// byteHit reports whether p matches anywhere in s.
+func byteHit(p *BytePattern, s string) bool {
+	b := []byte(s)
+	found := false
+	p.find(b, foldASCII(b), len(b), func(int, int) bool {
+		found = true
+		return false
+	})
+	return found
+}
+
+func TestParseBytePattern(t *testing.T) {
+	p, err := parseBytePattern("hex:DE AD ?? EF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !byteHit(p, "x\xde\xad\x00\xef") || byteHit(p, "\xde\xad\xef") {
+		t.Fatal("hex wildcard match failed")
+	}
+	p, _ = parseBytePattern("wide:i:Secret")
+	if !byteHit(p, string(toUTF16LE("my SECRET"))) || byteHit(p, "secret") {
+		t.Fatal("wide match failed")
+	}
+	for _, bad := range []string{"hex:DE AD ?", "hex:ZZ", "wide:", "hex:[2] AA", "wide:i:Пароль"} {
+		if _, err := parseBytePattern(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestMatchReader_BytePatternsWithOffsets(t *testing.T) {
+	p1, _ := parseBytePattern("hex:DE AD ?? EF")
+	p2, _ := parseBytePattern("wide:token")
+	rules := &RuleSet{Bytes: []*BytePattern{p1, p2}}
+
+	// place the first hit right on a chunk edge and the second far behind it
+	data := bytes.Repeat([]byte{0}, byteChunk+byteOverlap-2)
+	off1 := int64(len(data))
+	data = append(data, 0xde, 0xad, 0x42, 0xef)
+	data = append(data, bytes.Repeat([]byte{0}, 3*byteChunk)...)
+	off2 := int64(len(data))
+	data = append(data, toUTF16LE("token")...)
+
+	var got []MatchResult
+	var matchCnt, errCnt atomic.Int64
+	matchReader(bytes.NewReader(data), rules, ScanOptions{}, func(m MatchResult) { got = append(got, m) }, "/bin", "", &matchCnt, &errCnt)
+
+	if len(got) != 2 {
+		t.Fatalf("want 2 byte matches, got %+v", got)
+	}
+	if !got[0].ByteMatch || got[0].Offset != off1 || got[0].Line != "DE AD 42 EF" {
+		t.Fatalf("unexpected first match: %+v", got[0])
+	}
+	if got[1].Offset != off2 || got[1].Pattern != "wide:token" {
+		t.Fatalf("unexpected second match: %+v", got[1])
+	}
+}
+
+func TestMatchReader_LineOffsets(t *testing.T) {
+	var got []MatchResult
+	var matchCnt, errCnt atomic.Int64
+	rules := &RuleSet{Patterns: []Pattern{stubPattern{sub: "b"}}}
+	matchReader(bytes.NewBufferString("aaa\nbbb\n"), rules, ScanOptions{}, func(m MatchResult) { got = append(got, m) }, "/f", "", &matchCnt, &errCnt)
+	if len(got) != 1 || got[0].Offset != 4 || got[0].LineNumber != 1 {
+		t.Fatalf("unexpected: %+v", got)
+	}
+}
@@ -0,0 +1,227 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// Compound File Binary (OLE2) is the container of legacy Office files: a
+// small FAT file system of fixed-size sectors with a directory of streams.
+// Streams below the mini-stream cutoff live in 64-byte mini sectors inside
+// the root entry's stream.
+
+var cfbMagic = []byte{0xd0, 0xcf, 0x11, 0xe0, 0xa1, 0xb1, 0x1a, 0xe1}
+
+const (
+	cfbEndOfChain = 0xfffffffe
+	maxCFBStream  = 256 << 20
+)
+
+var errCFB = errors.New("cfb: malformed container")
+
+type cfbEntry struct {
+	name  string
+	typ   byte // 1 storage, 2 stream, 5 root
+	start uint32
+	size  uint64
+}
+
+type cfbFile struct {
+	r        io.ReaderAt
+	size     int64
+	secSize  int64
+	fat      []uint32
+	miniFAT  []uint32
+	cutoff   uint64
+	entries  []cfbEntry
+	ministrm []byte
+}
+
+// openCFB reads the header, FAT, directory and mini stream of a container.
+func openCFB(r io.ReaderAt, size int64) (*cfbFile, error) {
+	hdr := make([]byte, 512)
+	if _, err := r.ReadAt(hdr, 0); err != nil {
+		return nil, errCFB
+	}
+	if !bytes.Equal(hdr[:8], cfbMagic) {
+		return nil, errors.New("cfb: not a compound file")
+	}
+	le := binary.LittleEndian
+	shift := le.Uint16(hdr[0x1e:])
+	if shift != 9 && shift != 12 {
+		return nil, errCFB
+	}
+	c := &cfbFile{r: r, size: size, secSize: 1 << shift, cutoff: uint64(le.Uint32(hdr[0x38:]))}
+
+	// DIFAT: 109 entries in the header, then a chain of DIFAT sectors
+	var difat []uint32
+	for i := 0; i < 109; i++ {
+		difat = append(difat, le.Uint32(hdr[0x4c+4*i:]))
+	}
+	// the sector count comes from the file: no chain is longer than the
+	// file has sectors, and none may visit a sector twice
+	next, n := le.Uint32(hdr[0x44:]), int64(le.Uint32(hdr[0x48:]))
+	n = min(n, size/c.secSize)
+	seen := make(map[uint32]bool)
+	for i := int64(0); i < n && next < cfbEndOfChain; i++ {
+		if seen[next] {
+			return nil, errCFB
+		}
+		seen[next] = true
+		sec, err := c.sector(next)
+		if err != nil {
+			return nil, err
+		}
+		per := len(sec)/4 - 1
+		for j := 0; j < per; j++ {
+			difat = append(difat, le.Uint32(sec[4*j:]))
+		}
+		next = le.Uint32(sec[4*per:])
+	}
+	for _, id := range difat[:min(len(difat), int(le.Uint32(hdr[0x2c:])))] {
+		if id >= cfbEndOfChain {
+			continue
+		}
+		sec, err := c.sector(id)
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j+4 <= len(sec); j += 4 {
+			c.fat = append(c.fat, le.Uint32(sec[j:]))
+		}
+	}
+
+	dir, err := c.chain(le.Uint32(hdr[0x30:]), -1)
+	if err != nil {
+		return nil, err
+	}
+	for off := 0; off+128 <= len(dir); off += 128 {
+		e := dir[off : off+128]
+		nameLen := int(le.Uint16(e[64:]))
+		if nameLen > 64 || nameLen < 2 {
+			c.entries = append(c.entries, cfbEntry{})
+			continue
+		}
+		u := make([]uint16, nameLen/2-1)
+		for i := range u {
+			u[i] = le.Uint16(e[2*i:])
+		}
+		c.entries = append(c.entries, cfbEntry{
+			name:  string(utf16.Decode(u)),
+			typ:   e[66],
+			start: le.Uint32(e[116:]),
+			size:  le.Uint64(e[120:]),
+		})
+	}
+	if len(c.entries) == 0 || c.entries[0].typ != 5 {
+		return nil, errCFB
+	}
+	if shift == 9 {
+		// version 3 files may leave garbage in the high size dword
+		for i := range c.entries {
+			c.entries[i].size &= 0xffffffff
+		}
+	}
+
+	root := c.entries[0]
+	if root.size > 0 {
+		if c.ministrm, err = c.chain(root.start, int64(root.size)); err != nil {
+			return nil, err
+		}
+		mf, err := c.chain(le.Uint32(hdr[0x3c:]), -1)
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j+4 <= len(mf); j += 4 {
+			c.miniFAT = append(c.miniFAT, le.Uint32(mf[j:]))
+		}
+	}
+	return c, nil
+}
+
+func (c *cfbFile) sector(id uint32) ([]byte, error) {
+	off := (int64(id) + 1) * c.secSize
+	if off+c.secSize > c.size {
+		return nil, errCFB
+	}
+	buf := make([]byte, c.secSize)
+	if _, err := c.r.ReadAt(buf, off); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// chain reads a FAT sector chain; size < 0 reads it to the end.
+func (c *cfbFile) chain(start uint32, size int64) ([]byte, error) {
+	var out []byte
+	for id, steps := start, 0; id < cfbEndOfChain; steps++ {
+		if steps > len(c.fat) || int64(len(out)) > maxCFBStream {
+			return nil, errCFB // loop or runaway chain
+		}
+		sec, err := c.sector(id)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sec...)
+		if size >= 0 && int64(len(out)) >= size {
+			break
+		}
+		if int(id) >= len(c.fat) {
+			return nil, errCFB
+		}
+		id = c.fat[id]
+	}
+	if size >= 0 {
+		if int64(len(out)) < size {
+			return nil, errCFB
+		}
+		out = out[:size]
+	}
+	return out, nil
+}
+
+// miniChain reads a stream stored in the mini stream.
+func (c *cfbFile) miniChain(start uint32, size int64) ([]byte, error) {
+	const miniSize = 64
+	var out []byte
+	for id, steps := start, 0; id < cfbEndOfChain && int64(len(out)) < size; steps++ {
+		off := int(id) * miniSize
+		if steps > len(c.miniFAT) || int(id) >= len(c.miniFAT) || off+miniSize > len(c.ministrm) {
+			return nil, errCFB
+		}
+		out = append(out, c.ministrm[off:off+miniSize]...)
+		id = c.miniFAT[id]
+	}
+	if int64(len(out)) < size {
+		return nil, errCFB
+	}
+	return out[:size], nil
+}
+
+// stream returns the content of the first stream named name (case-insensitive);
+// legacy Office keeps its streams at the top of the directory.
+func (c *cfbFile) stream(name string) ([]byte, bool, error) {
+	for _, e := range c.entries[1:] {
+		if e.typ != 2 || !strings.EqualFold(e.name, name) {
+			continue
+		}
+		if e.size > maxCFBStream {
+			return nil, true, errors.New("cfb: stream too large")
+		}
+		var (
+			b   []byte
+			err error
+		)
+		if e.size < c.cutoff {
+			b, err = c.miniChain(e.start, int64(e.size))
+		} else {
+			b, err = c.chain(e.start, int64(e.size))
+		}
+		return b, true, err
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,507 @@
+package internal
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Container images: with --images a `docker save` tarball or an OCI image
+// layout (a directory, or a tar of one) is read through its manifests
+// instead of as nested archives. Every file of every layer is scanned, so
+// both the final filesystem and what a later layer deleted (a whiteout) or
+// overwrote are covered. Results carry the layer digest and the Dockerfile
+// instruction that created the layer, from the image config history; the
+// Location tells the layer number and what became of the file.
+
+const maxImageTarEntries = 4096 // image tars hold a few entries per layer
+
+// imageStore opens a file of an image by its slash path in the tar or
+// layout directory.
+type imageStore func(name string) (io.ReadCloser, error)
+
+// imageLayer is one layer of an image.
+type imageLayer struct {
+	blob   string // path of the layer tar in the image
+	digest string // "sha256:<hex>"
+	cmd    string // instruction from the config history
+}
+
+// isImageLayout reports whether dir is an OCI image layout.
+func isImageLayout(dir string) bool {
+	for _, n := range []string{"oci-layout", "index.json"} {
+		if fi, err := os.Stat(filepath.Join(dir, n)); err != nil || fi.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// imageAt reports whether a walked entry is an image: a layout directory
+// or a .tar holding a `docker save` image or a layout.
+func imageAt(path string, d os.DirEntry) bool {
+	if d.IsDir() {
+		return isImageLayout(path)
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".tar") {
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	idx, err := indexImageTar(f)
+	if err != nil {
+		return false
+	}
+	_, saved := idx["manifest.json"]
+	_, index := idx["index.json"]
+	_, layout := idx["oci-layout"]
+	return saved || index && layout
+}
+
+// tarEntry locates the data of a regular file in a tar.
+type tarEntry struct{ off, size int64 }
+
+// indexImageTar records where each regular file of an image tar starts. It
+// gives up at the first name an image does not have, so other tars cost a
+// header read.
+func indexImageTar(f *os.File) (map[string]tarEntry, error) {
+	idx := map[string]tarEntry{}
+	tr := tar.NewReader(f) // skips data with Seek
+	for n := 0; ; n++ {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return idx, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := layerPath(h.Name)
+		if n >= maxImageTarEntries || !imageEntry(name) {
+			return nil, errors.New("not an image")
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		off, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		idx[name] = tarEntry{off, h.Size}
+	}
+}
+
+// imageEntry reports whether name may appear in a `docker save` tar or an
+// OCI layout: the index files, blobs/..., and <id>.json or <id>/... of the
+// legacy format.
+func imageEntry(name string) bool {
+	switch name {
+	case "", "manifest.json", "index.json", "oci-layout", "repositories":
+		return true
+	}
+	first, _, _ := strings.Cut(name, "/")
+	if first == "blobs" {
+		return true
+	}
+	first = strings.TrimSuffix(first, ".json")
+	_, err := hex.DecodeString(first)
+	return len(first) == 64 && err == nil
+}
+
+// openImage returns the store of the image at path.
+func openImage(path string) (imageStore, func(), error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fi.IsDir() {
+		return func(name string) (io.ReadCloser, error) {
+			if !iofs.ValidPath(name) {
+				return nil, fmt.Errorf("%s: %w", name, iofs.ErrInvalid)
+			}
+			return os.Open(filepath.Join(path, filepath.FromSlash(name)))
+		}, func() {}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	idx, err := indexImageTar(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return func(name string) (io.ReadCloser, error) {
+		e, ok := idx[name]
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", name, iofs.ErrNotExist)
+		}
+		return io.NopCloser(io.NewSectionReader(f, e.off, e.size)), nil
+	}, func() { f.Close() }, nil
+}
+
+func readImageJSON(open imageStore, name string, v any) error {
+	rc, err := open(name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := json.NewDecoder(rc).Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+}
+
+// ociManifest is an image index (Manifests) or an image manifest.
+type ociManifest struct {
+	Manifests []ociDescriptor `json:"manifests"`
+	Config    ociDescriptor   `json:"config"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+type imageConfig struct {
+	History []struct {
+		CreatedBy  string `json:"created_by"`
+		EmptyLayer bool   `json:"empty_layer"`
+	} `json:"history"`
+	RootFS struct {
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// blobPath is where a layout keeps the blob of a digest.
+func blobPath(digest string) (string, error) {
+	alg, h, ok := strings.Cut(digest, ":")
+	if !ok || alg == "" || h == "" || strings.ContainsAny(digest, "/\\") {
+		return "", fmt.Errorf("bad digest %q", digest)
+	}
+	return "blobs/" + alg + "/" + h, nil
+}
+
+// imageManifests lists the layers, bottom first, of every image in the
+// store: the entries of manifest.json when there is one, otherwise the
+// image manifests index.json leads to.
+func imageManifests(open imageStore) ([][]imageLayer, error) {
+	var saved []struct {
+		Config string
+		Layers []string
+	}
+	err := readImageJSON(open, "manifest.json", &saved)
+	if err == nil {
+		var images [][]imageLayer
+		for _, m := range saved {
+			var cfg imageConfig
+			if err := readImageJSON(open, m.Config, &cfg); err != nil {
+				return nil, err
+			}
+			images = append(images, imageLayers(cfg, m.Layers, nil))
+		}
+		return images, nil
+	}
+	if !errors.Is(err, iofs.ErrNotExist) {
+		return nil, err
+	}
+	var images [][]imageLayer
+	return images, ociImages(open, "index.json", 0, &images)
+}
+
+// ociImages follows an index or image manifest; indexes nest for multi
+// platform images. Manifests whose config is not an image config, such as
+// build attestations, are left out.
+func ociImages(open imageStore, name string, depth int, images *[][]imageLayer) error {
+	if depth > 4 {
+		return fmt.Errorf("%s: indexes nested too deep", name)
+	}
+	var m ociManifest
+	if err := readImageJSON(open, name, &m); err != nil {
+		return err
+	}
+	for _, d := range m.Manifests {
+		p, err := blobPath(d.Digest)
+		if err != nil {
+			return err
+		}
+		// a layout may keep only the platforms it was saved for
+		if err := ociImages(open, p, depth+1, images); err != nil && !errors.Is(err, iofs.ErrNotExist) {
+			return err
+		}
+	}
+	switch m.Config.MediaType {
+	case "application/vnd.oci.image.config.v1+json", "application/vnd.docker.container.image.v1+json":
+	default:
+		return nil
+	}
+	p, err := blobPath(m.Config.Digest)
+	if err != nil {
+		return err
+	}
+	var cfg imageConfig
+	if err := readImageJSON(open, p, &cfg); err != nil {
+		return err
+	}
+	var blobs, digests []string
+	for _, l := range m.Layers {
+		p, err := blobPath(l.Digest)
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, p)
+		digests = append(digests, l.Digest)
+	}
+	*images = append(*images, imageLayers(cfg, blobs, digests))
+	return nil
+}
+
+// imageLayers pairs layer blobs with their digests and with the history
+// entries that made a layer. Without digests from a manifest, blobs under
+// blobs/ are named by their path and others by the config diff_ids.
+func imageLayers(cfg imageConfig, blobs, digests []string) []imageLayer {
+	var cmds []string
+	for _, h := range cfg.History {
+		if !h.EmptyLayer {
+			cmds = append(cmds, instruction(h.CreatedBy))
+		}
+	}
+	layers := make([]imageLayer, len(blobs))
+	for i, b := range blobs {
+		l := imageLayer{blob: layerPath(b)}
+		switch alg, h, _ := strings.Cut(strings.TrimPrefix(l.blob, "blobs/"), "/"); {
+		case i < len(digests):
+			l.digest = digests[i]
+		case strings.HasPrefix(l.blob, "blobs/") && h != "":
+			l.digest = alg + ":" + h
+		case i < len(cfg.RootFS.DiffIDs):
+			l.digest = cfg.RootFS.DiffIDs[i]
+		}
+		// a history that does not match the layers would misattribute them
+		if len(cmds) == len(blobs) {
+			l.cmd = cmds[i]
+		}
+		layers[i] = l
+	}
+	return layers
+}
+
+// instruction turns a history created_by into the Dockerfile instruction:
+// the classic builder records "/bin/sh -c #(nop) COPY ..." for metadata
+// and "/bin/sh -c cmd" for RUN, BuildKit the instruction with a comment.
+func instruction(createdBy string) string {
+	s := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(createdBy), "# buildkit"))
+	if rest, ok := strings.CutPrefix(s, "/bin/sh -c "); ok {
+		rest = strings.TrimSpace(rest)
+		if nop, ok := strings.CutPrefix(rest, "#(nop)"); ok {
+			return strings.TrimSpace(nop)
+		}
+		return "RUN " + rest
+	}
+	return s
+}
+
+// layerPath is a tar entry name as a clean relative slash path.
+func layerPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// layerFate records what the layers above the one being read did to its
+// paths, each with the lowest such layer.
+type layerFate struct {
+	files   map[string]int // non-directory entries
+	deleted map[string]int // .wh.<name> whiteouts
+	opaque  map[string]int // directories emptied with .wh..wh..opq
+}
+
+// of tells what became of p: "" when it is in the final filesystem.
+func (f *layerFate) of(p string) string {
+	n, how := 0, ""
+	later := func(k int, h string) {
+		if k > 0 && (n == 0 || k < n) {
+			n, how = k, h
+		}
+	}
+	for d := p; ; d = path.Dir(d) {
+		later(f.files[d], "replaced")
+		later(f.deleted[d], "deleted")
+		if d != p {
+			later(f.opaque[d], "deleted")
+		}
+		if d == "." {
+			break
+		}
+	}
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s in layer %d", how, n)
+}
+
+// scanImage scans the layers of the image at path. Layers are read top
+// down, so the whiteouts of later layers are known when a file is met; a
+// layer shared by several images in one tar is scanned once.
+func (fs *FileScanner) scanImage(
+	ctx context.Context,
+	path string,
+	rules *RuleSet,
+	opts ScanOptions,
+	onMatch func(MatchResult),
+	matchCnt, errCnt *atomic.Int64,
+) {
+	fail := func(err error) {
+		errCnt.Add(1)
+		onMatch(MatchResult{FilePath: path, Error: fmt.Errorf("image: %w", err)})
+	}
+	open, closeImage, err := openImage(path)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer closeImage()
+	images, err := imageManifests(open)
+	if err != nil {
+		fail(err)
+		return
+	}
+	done := map[string]bool{}
+	for _, layers := range images {
+		fate := &layerFate{files: map[string]int{}, deleted: map[string]int{}, opaque: map[string]int{}}
+		for i := len(layers) - 1; i >= 0 && ctx.Err() == nil; i-- {
+			l := layers[i]
+			err := fs.scanLayer(ctx, open, l, i+1, fate, !done[l.blob], path, rules, opts, onMatch, matchCnt, errCnt)
+			if err != nil {
+				fail(fmt.Errorf("layer %s: %w", l.digest, err))
+			}
+			done[l.blob] = true
+		}
+	}
+}
+
+// scanLayer reads layer n and then adds its entries to fate. With scan
+// unset only the entries are recorded.
+func (fs *FileScanner) scanLayer(
+	ctx context.Context,
+	open imageStore,
+	l imageLayer,
+	n int,
+	fate *layerFate,
+	scan bool,
+	imagePath string,
+	rules *RuleSet,
+	opts ScanOptions,
+	onMatch func(MatchResult),
+	matchCnt, errCnt *atomic.Int64,
+) error {
+	rc, err := open(l.blob)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	r, closeLayer, err := layerReader(rc)
+	if err != nil {
+		return err
+	}
+	defer closeLayer()
+	var files, deleted, opaque []string
+	tr := tar.NewReader(r)
+	for ctx.Err() == nil {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := layerPath(h.Name)
+		if name == "" {
+			continue
+		}
+		dir, base := path.Split(name)
+		switch {
+		case base == ".wh..wh..opq":
+			d := layerPath(dir)
+			if d == "" {
+				d = "."
+			}
+			opaque = append(opaque, d)
+			continue
+		case strings.HasPrefix(base, ".wh."):
+			deleted = append(deleted, dir+base[len(".wh."):])
+			continue
+		case h.Typeflag == tar.TypeDir:
+			continue
+		}
+		files = append(files, name)
+		if !scan || h.Typeflag != tar.TypeReg || !opts.allowedExt(strings.ToLower(filepath.Ext(name))) {
+			continue
+		}
+		loc := fmt.Sprintf("layer %d", n)
+		if f := fate.of(name); f != "" {
+			loc += ", " + f
+		}
+		tag := func(r MatchResult) {
+			r.Layer, r.LayerCmd = l.digest, l.cmd
+			if r.Location != "" {
+				r.Location = loc + ", " + r.Location
+			} else {
+				r.Location = loc
+			}
+			onMatch(r)
+		}
+		var hash string
+		if !opts.NamesOnly {
+			hash = fs.scanContent(ctx, tr, imagePath, name, rules, opts, tag, matchCnt, errCnt)
+		}
+		matchName(rules, imagePath, name, hash, tag, matchCnt)
+	}
+	for _, p := range files {
+		fate.files[p] = n
+	}
+	for _, p := range deleted {
+		fate.deleted[p] = n
+	}
+	for _, p := range opaque {
+		fate.opaque[p] = n
+	}
+	return nil
+}
+
+// layerReader undoes the gzip or zstd compression of a layer blob.
+func layerReader(r io.Reader) (io.Reader, func(), error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, func() { zr.Close() }, nil
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	}
+	return br, func() {}, nil
+}
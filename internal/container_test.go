@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// tarOf builds a tar from name/content pairs; names ending in "/" are
+// directories.
+func tarOf(t *testing.T, entries ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := 0; i < len(entries); i += 2 {
+		h := &tar.Header{Name: entries[i], Mode: 0o644, Size: int64(len(entries[i+1])), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(h.Name, "/") {
+			h.Typeflag, h.Mode = tar.TypeDir, 0o755
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		_, _ = tw.Write([]byte(entries[i+1]))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// imageFixture returns three layer blobs: plain, gzip and zstd, with a
+// whiteout, an overwrite and an opaque directory, and the image config.
+func imageFixture(t *testing.T) ([][]byte, string) {
+	t.Helper()
+	var gz, zs bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write(tarOf(t, "etc/", "", "etc/.wh.secret.txt", "", "app/config.yml", "password=new\n"))
+	_ = zw.Close()
+	enc, _ := zstd.NewWriter(&zs)
+	_, _ = enc.Write(tarOf(t, "app/cache/.wh..wh..opq", "", "app/cache/y.txt", "password=fresh\n"))
+	_ = enc.Close()
+	layers := [][]byte{
+		tarOf(t, "./", "", "./etc/", "", "./etc/secret.txt", "password=base\n", "./app/config.yml", "password=old\n", "./app/cache/x.txt", "password=cache\n"),
+		gz.Bytes(),
+		zs.Bytes(),
+	}
+	var diffIDs []string
+	for _, l := range layers {
+		diffIDs = append(diffIDs, "sha256:"+sha256Hex(l))
+	}
+	config := mustJSON(t, map[string]any{
+		"history": []map[string]any{
+			{"created_by": "/bin/sh -c #(nop) ADD file:0ab1 in / "},
+			{"created_by": `/bin/sh -c #(nop)  CMD ["sh"]`, "empty_layer": true},
+			{"created_by": "RUN /bin/sh -c rm /etc/secret.txt # buildkit"},
+			{"created_by": "COPY y.txt /app/cache/ # buildkit"},
+		},
+		"rootfs": map[string]any{"type": "layers", "diff_ids": diffIDs},
+	})
+	return layers, config
+}
+
+func TestScan_Images(t *testing.T) {
+	dir := t.TempDir()
+	pf := filepath.Join(dir, "p.txt")
+	_ = os.WriteFile(pf, []byte("password=\n"), 0o644)
+	layers, config := imageFixture(t)
+	root := filepath.Join(dir, "root")
+	_ = os.MkdirAll(root, 0o755)
+
+	// docker save, legacy format: <id>/layer.tar and <id>.json
+	var saved []string
+	var paths []string
+	for _, l := range layers {
+		id := sha256Hex(l)
+		saved = append(saved, id+"/", "", id+"/layer.tar", string(l))
+		paths = append(paths, id+"/layer.tar")
+	}
+	cfgName := sha256Hex([]byte(config)) + ".json"
+	saved = append(saved, cfgName, config,
+		"manifest.json", mustJSON(t, []map[string]any{{"Config": cfgName, "RepoTags": []string{"app:1"}, "Layers": paths}}))
+	savedTar := filepath.Join(root, "app.tar")
+	_ = os.WriteFile(savedTar, tarOf(t, saved...), 0o644)
+
+	// OCI layout directory: index -> index -> image manifest and an attestation
+	layout := filepath.Join(root, "oci")
+	blob := func(b string) string {
+		d := sha256Hex([]byte(b))
+		p := filepath.Join(layout, "blobs", "sha256", d)
+		_ = os.MkdirAll(filepath.Dir(p), 0o755)
+		_ = os.WriteFile(p, []byte(b), 0o644)
+		return "sha256:" + d
+	}
+	var descs []map[string]any
+	for _, l := range layers {
+		descs = append(descs, map[string]any{"mediaType": "application/vnd.oci.image.layer.v1.tar", "digest": blob(string(l))})
+	}
+	manifest := blob(mustJSON(t, map[string]any{
+		"config": map[string]any{"mediaType": "application/vnd.oci.image.config.v1+json", "digest": blob(config)},
+		"layers": descs,
+	}))
+	attestation := blob(mustJSON(t, map[string]any{
+		"config": map[string]any{"mediaType": "application/vnd.in-toto+json", "digest": blob("{}")},
+		"layers": []map[string]any{{"digest": blob("password=attestation")}},
+	}))
+	list := blob(mustJSON(t, map[string]any{"manifests": []map[string]any{
+		{"digest": manifest}, {"digest": attestation}, {"digest": "sha256:" + strings.Repeat("0", 64)},
+	}}))
+	_ = os.WriteFile(filepath.Join(layout, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0o644)
+	_ = os.WriteFile(filepath.Join(layout, "index.json"), []byte(mustJSON(t, map[string]any{"manifests": []map[string]any{{"digest": list}}})), 0o644)
+
+	// an ordinary tar is not an image
+	plain := filepath.Join(dir, "plain.tar")
+	_ = os.WriteFile(plain, tarOf(t, "manifest.json", "[]", "notes.txt", "password=plain\n"), 0o644)
+	if fi, _ := os.Lstat(plain); imageAt(plain, iofs.FileInfoToDirEntry(fi)) {
+		t.Fatal("plain tar taken for an image")
+	}
+
+	got := map[string][]string{}
+	for _, m := range collectScan(t, ScanOptions{Roots: []string{root}, PatternFile: pf, Threads: 2, Images: true}) {
+		n := 0
+		for i, l := range layers {
+			if m.Layer == "sha256:"+sha256Hex(l) {
+				n = i + 1
+			}
+		}
+		got[m.FilePath] = append(got[m.FilePath], strings.Join([]string{
+			fmt.Sprint(n), m.InnerPath, m.Location, m.LayerCmd, strings.TrimSpace(m.Line),
+		}, "|"))
+	}
+	want := []string{
+		"1|app/cache/x.txt|layer 1, deleted in layer 3|ADD file:0ab1 in /|password=cache",
+		"1|app/config.yml|layer 1, replaced in layer 2|ADD file:0ab1 in /|password=old",
+		"1|etc/secret.txt|layer 1, deleted in layer 2|ADD file:0ab1 in /|password=base",
+		"2|app/config.yml|layer 2|RUN /bin/sh -c rm /etc/secret.txt|password=new",
+		"3|app/cache/y.txt|layer 3|COPY y.txt /app/cache/|password=fresh",
+	}
+	for _, p := range []string{savedTar, layout} {
+		slices.Sort(got[p])
+		if !slices.Equal(got[p], want) {
+			t.Errorf("%s: got %q", filepath.Base(p), got[p])
+		}
+	}
+	if len(got) != 2 {
+		t.Errorf("unexpected files: %q", got)
+	}
+}
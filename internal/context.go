@@ -0,0 +1,124 @@
+package internal
+
+import "bytes"
+
+type ctxLine struct {
+	num  int
+	text []byte // reused as the ring turns
+}
+
+// lineContext attaches -B/-A context lines to line results, like grep.
+// Previous lines live in a small ring; results are held until their trailing
+// context has been read. Every line is shown at most once, so overlapping
+// windows merge: a match inside another one's trailing context ends it.
+// Windows that touch form one group; the last result of a group is marked
+// GroupEnd once a gap or the end of the text shows nothing joins it.
+type lineContext struct {
+	before, after int
+	ring          []ctxLine // last `before` lines, circular
+	next          int
+	shown         int // last line number already attached to some result
+	cur           int // line number of the pending results
+	pending       []MatchResult
+	held          []MatchResult // complete, but the group may go on
+	tail          []string
+	out           func(MatchResult)
+}
+
+func newLineContext(before, after int, out func(MatchResult)) *lineContext {
+	c := &lineContext{before: before, after: after, shown: -1, cur: -1, out: out}
+	c.ring = make([]ctxLine, before)
+	for i := range c.ring {
+		c.ring[i].num = -1
+	}
+	return c
+}
+
+// add takes a result for the current line in place of emitting it.
+func (c *lineContext) add(r MatchResult) {
+	if r.LineNumber != c.cur {
+		c.flush()
+		c.cur = r.LineNumber
+		r.Before = c.collect(r.LineNumber)
+		if r.LineNumber-len(r.Before) > c.shown+1 {
+			c.release()
+		}
+	}
+	c.pending = append(c.pending, r)
+	c.shown = r.LineNumber
+}
+
+// line records line n after it has been matched.
+func (c *lineContext) line(n int, b []byte) {
+	if len(c.pending) == 0 && n > c.shown+c.before {
+		c.release() // a match from here on leaves a gap
+	}
+	if c.before == 0 && len(c.pending) == 0 {
+		return
+	}
+	b = bytes.TrimRight(b, "\r\n")
+	if c.before > 0 {
+		slot := &c.ring[c.next]
+		slot.num, slot.text = n, append(slot.text[:0], b...)
+		c.next = (c.next + 1) % c.before
+	}
+	if len(c.pending) == 0 {
+		return
+	}
+	if n > c.cur {
+		c.tail = append(c.tail, string(b))
+		c.shown = n
+	}
+	if len(c.tail) >= c.after {
+		c.flush()
+	}
+}
+
+// collect returns the buffered lines before n that nobody has shown yet.
+func (c *lineContext) collect(n int) []string {
+	var out []string
+	for i := range c.before {
+		l := c.ring[(c.next+i)%c.before]
+		if l.num > c.shown && l.num < n {
+			out = append(out, string(l.text))
+		}
+	}
+	return out
+}
+
+// flush completes pending results with the trailing context read so far.
+func (c *lineContext) flush() {
+	for _, r := range c.pending {
+		r.After = c.tail
+		c.held = append(c.held, r)
+	}
+	c.pending = nil
+	c.tail = nil
+}
+
+// release emits the held results, closing their group.
+func (c *lineContext) release() {
+	if len(c.held) == 0 {
+		return
+	}
+	c.held[len(c.held)-1].GroupEnd = true
+	for _, r := range c.held {
+		c.out(r)
+	}
+	c.held = nil
+}
+
+// end emits everything, at the end of the text.
+func (c *lineContext) end() {
+	c.flush()
+	c.release()
+}
+
+// reset ends the text and forgets all lines, for a new text unit.
+func (c *lineContext) reset() {
+	c.end()
+	for i := range c.ring {
+		c.ring[i].num = -1
+	}
+	c.shown, c.cur = -1, -1
+}
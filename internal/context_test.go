@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func contextScan(t *testing.T, data string, before, after int) []MatchResult {
+	t.Helper()
+	var got []MatchResult
+	var matchCnt, errCnt atomic.Int64
+	opts := ScanOptions{ContextBefore: before, ContextAfter: after}
+	matchReader(bytes.NewBufferString(data), &RuleSet{Patterns: []Pattern{&PlainPattern{s: []byte("hit")}}}, opts, func(m MatchResult) { got = append(got, m) }, "/f", "", &matchCnt, &errCnt)
+	return got
+}
+
+func TestMatchReader_Context(t *testing.T) {
+	data := "l0\nl1\nl2 hit\nl3\nl4\nl5\nl6\nl7 hit\r\nl8\n"
+	got := contextScan(t, data, 2, 1)
+	if len(got) != 2 {
+		t.Fatalf("want 2 results, got %+v", got)
+	}
+	if !slices.Equal(got[0].Before, []string{"l0", "l1"}) || !slices.Equal(got[0].After, []string{"l3"}) || got[0].LineNumber != 2 {
+		t.Fatalf("first: %+v", got[0])
+	}
+	if !slices.Equal(got[1].Before, []string{"l5", "l6"}) || !slices.Equal(got[1].After, []string{"l8"}) {
+		t.Fatalf("second: %+v", got[1])
+	}
+
+	// trailing context cut by EOF
+	got = contextScan(t, "a\nhit\nb", 0, 5)
+	if len(got) != 1 || !slices.Equal(got[0].After, []string{"b"}) || got[0].Before != nil {
+		t.Fatalf("eof: %+v", got)
+	}
+}
+
+func TestMatchReader_ContextMerged(t *testing.T) {
+	// windows overlap: every line is attached to exactly one result
+	data := "l0\nhit 1\nl2\nhit 3\nhit 4\nl5\nl6\nl7\n"
+	got := contextScan(t, data, 2, 2)
+	if len(got) != 3 {
+		t.Fatalf("want 3 results, got %+v", got)
+	}
+	want := [][2][]string{
+		{{"l0"}, {"l2"}},
+		{nil, nil},
+		{nil, {"l5", "l6"}},
+	}
+	var lines []string
+	for i, m := range got {
+		if !slices.Equal(m.Before, want[i][0]) || !slices.Equal(m.After, want[i][1]) {
+			t.Fatalf("result %d: before=%q after=%q", i, m.Before, m.After)
+		}
+		lines = append(lines, m.Before...)
+		lines = append(lines, strings.TrimSuffix(m.Line, "\n"))
+		lines = append(lines, m.After...)
+	}
+	if strings.Join(lines, "\n") != "l0\nhit 1\nl2\nhit 3\nhit 4\nl5\nl6" {
+		t.Fatalf("merged output: %q", lines)
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	if s := withContext([]string{"a"}, "hit\n", []string{"b", "c"}, true); s != "a\nhit\nb\nc\n--" {
+		t.Fatalf("got %q", s)
+	}
+	if s := withContext([]string{"a"}, "hit\n", []string{"b"}, false); s != "a\nhit\nb" {
+		t.Fatalf("open group: got %q", s)
+	}
+}
+
+// sinkContext writes the context results of each file through the sink and
+// returns the matches file.
+func sinkContext(t *testing.T, before, after int, files ...string) string {
+	t.Helper()
+	opts := ScanOptions{SaveMatchesFile: filepath.Join(t.TempDir(), "all.txt")}
+	var stats AppStats
+	sink := NewResultSink(opts, &stats)
+	for _, data := range files {
+		for _, m := range contextScan(t, data, before, after) {
+			m.Matched = true
+			sink(m)
+		}
+	}
+	b, _ := os.ReadFile(opts.SaveMatchesFile)
+	return string(b)
+}
+
+func TestResultSink_ContextGroups(t *testing.T) {
+	// adjacent windows form one group: no separator between them
+	got := sinkContext(t, 1, 1, "l0\nhit 1\nl2\nl3\nhit 4\nl5\nl6\nl7\nl8\nhit 9\n")
+	if want := "l0\nhit 1\nl2\nl3\nhit 4\nl5\n--\nl8\nhit 9\n--\n"; got != want {
+		t.Fatalf("adjacent: got %q, want %q", got, want)
+	}
+	// a window fully taken by its neighbour still closes the file's group
+	// before the next file begins
+	got = sinkContext(t, 1, 1, "hit 0\nl1\nhit 2\n", "l0\nhit 1\n")
+	if want := "hit 0\nl1\nhit 2\n--\nl0\nhit 1\n--\n"; got != want {
+		t.Fatalf("absorbed: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
+// With --decode a line that matches nothing as is gets a second chance: its
+// base64, hex and percent-encoded tokens are decoded and the line patterns
+// run over the decoded text. Decoded text is searched for tokens again, so a
+// base64 Kubernetes secret holding a JWT is taken apart layer by layer.
+
+const (
+	defaultDecodeMin   = 16 // shortest token worth decoding
+	defaultDecodeDepth = 3  // nested decodings per token
+)
+
+var (
+	base64Token  = regexp.MustCompile(`[A-Za-z0-9+/_-]+={0,2}`)
+	percentToken = regexp.MustCompile(`[^\s"'<>]*%[0-9A-Fa-f]{2}[^\s"'<>]*`)
+)
+
+// decodeTokens hands fn every token of b at least minLen long that decodes to
+// text, with its decoding chain ("base64", "url>base64") and the byte column
+// of the outermost token in b. Decoded text is searched again up to depth
+// levels. fn returns true to stop; decodeTokens reports whether it did.
+func decodeTokens(b []byte, minLen, depth int, fn func(dec []byte, chain string, col int) bool) bool {
+	return decodeLevel(b, minLen, depth, "", -1, fn)
+}
+
+func decodeLevel(b []byte, minLen, depth int, chain string, col int, fn func([]byte, string, int) bool) bool {
+	if depth <= 0 {
+		return false
+	}
+	try := func(tok []byte, at int, percent bool) bool {
+		if len(tok) < minLen {
+			return false
+		}
+		dec, kind := decodeToken(tok, percent)
+		if dec == nil {
+			return false
+		}
+		if chain != "" {
+			kind = chain + ">" + kind
+		}
+		if col >= 0 {
+			at = col
+		}
+		return fn(dec, kind, at) || decodeLevel(dec, minLen, depth-1, kind, at, fn)
+	}
+	for _, m := range base64Token.FindAllIndex(b, -1) {
+		if try(b[m[0]:m[1]], m[0], false) {
+			return true
+		}
+	}
+	if bytes.IndexByte(b, '%') < 0 {
+		return false
+	}
+	for _, m := range percentToken.FindAllIndex(b, -1) {
+		if try(b[m[0]:m[1]], m[0], true) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeToken decodes a token and names its encoding; it returns nil unless
+// the result is text. A run of hex digits is hex if that yields text, base64
+// otherwise.
+func decodeToken(tok []byte, percent bool) ([]byte, string) {
+	if percent {
+		s, err := url.QueryUnescape(string(tok))
+		if err != nil || !isText([]byte(s)) {
+			return nil, ""
+		}
+		return []byte(s), "url"
+	}
+	if len(tok)%2 == 0 {
+		if dec, err := hex.DecodeString(string(tok)); err == nil && isText(dec) {
+			return dec, "hex"
+		}
+	}
+	raw := bytes.TrimRight(tok, "=")
+	enc := base64.RawStdEncoding
+	if bytes.ContainsAny(raw, "-_") {
+		enc = base64.RawURLEncoding
+	}
+	dec := make([]byte, enc.DecodedLen(len(raw)))
+	n, err := enc.Decode(dec, raw)
+	if err != nil || !isText(dec[:n]) {
+		return nil, ""
+	}
+	return dec[:n], "base64"
+}
+
+// isText reports whether b is non-empty UTF-8 without control characters
+// other than tab and line breaks.
+func isText(b []byte) bool {
+	if len(b) == 0 || !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r' {
+			return false
+		}
+	}
+	return true
+}
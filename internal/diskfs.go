@@ -0,0 +1,245 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Disk images: .iso, .img and .ima files holding an ISO 9660 or FAT
+// filesystem are opened as an fs.FS, so with --archives they are walked and
+// scanned like any archive, with the path in the image as the inner path.
+// A FAT volume may also sit in the first matching MBR or GPT partition of a
+// whole-disk dump.
+
+const (
+	maxDiskDepth    = 64      // directory levels below the root
+	maxDiskDirReads = 1 << 16 // directory reads per opened image; bounds cyclic trees
+)
+
+var diskImageExt = map[string]struct{}{".iso": {}, ".img": {}, ".ima": {}}
+
+// diskEntry is a file or directory of a volume; it is its own fs.FileInfo.
+type diskEntry struct {
+	name    string
+	dir     bool
+	size    int64
+	mod     time.Time
+	extents [][2]int64 // ISO 9660: byte offset and length of each extent
+	cluster uint32     // FAT: first cluster
+}
+
+func (e diskEntry) Name() string       { return e.name }
+func (e diskEntry) Size() int64        { return e.size }
+func (e diskEntry) ModTime() time.Time { return e.mod }
+func (e diskEntry) IsDir() bool        { return e.dir }
+func (e diskEntry) Sys() any           { return nil }
+func (e diskEntry) Mode() iofs.FileMode {
+	if e.dir {
+		return iofs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+// diskVolume is a filesystem read from an image.
+type diskVolume interface {
+	root() diskEntry
+	readDir(e diskEntry) ([]diskEntry, error)
+	open(e diskEntry) io.Reader
+}
+
+// diskFS exposes a volume as an fs.FS. Directories are read on demand, so
+// opening an entry costs only the directories on its path.
+type diskFS struct {
+	vol   diskVolume
+	f     *os.File
+	reads int
+}
+
+func (d *diskFS) Close() error { return d.f.Close() }
+
+func (d *diskFS) readDir(e diskEntry) ([]diskEntry, error) {
+	if d.reads++; d.reads > maxDiskDirReads {
+		return nil, errors.New("too many directory reads")
+	}
+	return d.vol.readDir(e)
+}
+
+func (d *diskFS) Open(name string) (iofs.File, error) {
+	if !iofs.ValidPath(name) {
+		return nil, &iofs.PathError{Op: "open", Path: name, Err: iofs.ErrInvalid}
+	}
+	e := d.vol.root()
+	if name != "." {
+		parts := strings.Split(name, "/")
+		if len(parts) > maxDiskDepth {
+			return nil, &iofs.PathError{Op: "open", Path: name, Err: iofs.ErrInvalid}
+		}
+		for _, p := range parts {
+			if !e.dir {
+				return nil, &iofs.PathError{Op: "open", Path: name, Err: iofs.ErrNotExist}
+			}
+			ents, err := d.readDir(e)
+			if err != nil {
+				return nil, &iofs.PathError{Op: "open", Path: name, Err: err}
+			}
+			found := -1
+			for i, c := range ents {
+				if c.name == p {
+					found = i
+					break
+				}
+				if found < 0 && strings.EqualFold(c.name, p) {
+					found = i
+				}
+			}
+			if found < 0 {
+				return nil, &iofs.PathError{Op: "open", Path: name, Err: iofs.ErrNotExist}
+			}
+			e = ents[found]
+		}
+	}
+	if e.dir {
+		return &diskDir{fs: d, e: e}, nil
+	}
+	return &diskFile{e: e, r: d.vol.open(e)}, nil
+}
+
+type diskFile struct {
+	e diskEntry
+	r io.Reader
+}
+
+func (f *diskFile) Stat() (iofs.FileInfo, error) { return f.e, nil }
+func (f *diskFile) Read(p []byte) (int, error)   { return f.r.Read(p) }
+func (f *diskFile) Close() error                 { return nil }
+
+type diskDir struct {
+	fs   *diskFS
+	e    diskEntry
+	ents []diskEntry
+	read bool
+}
+
+func (d *diskDir) Stat() (iofs.FileInfo, error) { return d.e, nil }
+func (d *diskDir) Read([]byte) (int, error) {
+	return 0, &iofs.PathError{Op: "read", Path: d.e.name, Err: iofs.ErrInvalid}
+}
+func (d *diskDir) Close() error { return nil }
+
+func (d *diskDir) ReadDir(n int) ([]iofs.DirEntry, error) {
+	if !d.read {
+		ents, err := d.fs.readDir(d.e)
+		if err != nil {
+			return nil, err
+		}
+		d.ents, d.read = ents, true
+	}
+	take := len(d.ents)
+	if n > 0 {
+		if take == 0 {
+			return nil, io.EOF
+		}
+		take = min(take, n)
+	}
+	out := make([]iofs.DirEntry, take)
+	for i, e := range d.ents[:take] {
+		out[i] = iofs.FileInfoToDirEntry(e)
+	}
+	d.ents = d.ents[take:]
+	return out, nil
+}
+
+// isDiskImage reports whether path has a disk image extension and holds a
+// filesystem this package reads.
+func isDiskImage(path string) bool {
+	if _, ok := diskImageExt[strings.ToLower(filepath.Ext(path))]; !ok {
+		return false
+	}
+	fsys, err := openDiskImage(path)
+	if err != nil {
+		return false
+	}
+	fsys.Close()
+	return true
+}
+
+// openDiskImage opens the ISO 9660 or FAT filesystem of an image file.
+func openDiskImage(path string) (*diskFS, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	vol, err := diskVolumeOf(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &diskFS{vol: vol, f: f}, nil
+}
+
+func diskVolumeOf(r io.ReaderAt) (diskVolume, error) {
+	if v, err := openISO(r); err == nil {
+		return v, nil
+	}
+	if v, err := openFAT(r, 0); err == nil {
+		return v, nil
+	}
+	for _, off := range partitionStarts(r) {
+		if v, err := openFAT(r, off); err == nil {
+			return v, nil
+		}
+	}
+	return nil, errors.New("no ISO 9660 or FAT filesystem")
+}
+
+// partitionStarts lists the byte offsets of the partitions in an MBR, or
+// in the GPT a protective MBR stands for.
+func partitionStarts(r io.ReaderAt) []int64 {
+	mbr := make([]byte, 512)
+	if _, err := r.ReadAt(mbr, 0); err != nil || mbr[510] != 0x55 || mbr[511] != 0xaa {
+		return nil
+	}
+	var starts []int64
+	for i := range 4 {
+		p := mbr[446+16*i:]
+		typ, lba := p[4], binary.LittleEndian.Uint32(p[8:])
+		switch {
+		case typ == 0xee:
+			return gptStarts(r)
+		case typ != 0 && lba != 0:
+			starts = append(starts, int64(lba)*512)
+		}
+	}
+	return starts
+}
+
+func gptStarts(r io.ReaderAt) []int64 {
+	hdr := make([]byte, 92)
+	if _, err := r.ReadAt(hdr, 512); err != nil || !bytes.Equal(hdr[:8], []byte("EFI PART")) {
+		return nil
+	}
+	lba := int64(binary.LittleEndian.Uint64(hdr[72:]))
+	n := min(binary.LittleEndian.Uint32(hdr[80:]), 128)
+	size := int64(binary.LittleEndian.Uint32(hdr[84:]))
+	if size < 128 || size > 4096 {
+		return nil
+	}
+	var starts []int64
+	e := make([]byte, 128)
+	for i := range int64(n) {
+		if _, err := r.ReadAt(e, lba*512+i*size); err != nil {
+			break
+		}
+		if first := binary.LittleEndian.Uint64(e[32:]); first != 0 && !bytes.Equal(e[:16], make([]byte, 16)) {
+			starts = append(starts, int64(first)*512)
+		}
+	}
+	return starts
+}
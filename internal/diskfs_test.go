@@ -0,0 +1,441 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// walkImage lists "path=content" for the files of a disk image.
+func walkImage(t *testing.T, p string) []string {
+	t.Helper()
+	fsys, err := openDiskImage(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+	var out []string
+	err = iofs.WalkDir(fsys, ".", func(name string, d iofs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := iofs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		out = append(out, name+"="+string(b))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// isoImage writes an image with a root holding README.TXT, BIG.BIN (two
+// extents) and DOCS/SECRET.CFG, whose Rock Ridge name is split by a
+// continuation area.
+func isoImage(t *testing.T, p string, joliet, rr bool) {
+	t.Helper()
+	const sec = isoSector
+	img := make([]byte, 40*sec)
+	lbaOf := func(s int) []byte {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint32(b, uint32(s))
+		binary.BigEndian.PutUint32(b[4:], uint32(s))
+		return b
+	}
+	record := func(name []byte, lba, size int, flags byte, su []byte) []byte {
+		n := 33 + len(name)
+		if len(name)%2 == 0 {
+			n++
+		}
+		at := n
+		n += len(su)
+		n += n % 2
+		r := make([]byte, n)
+		r[0] = byte(n)
+		copy(r[2:], lbaOf(lba))
+		copy(r[10:], lbaOf(size))
+		copy(r[18:], []byte{124, 3, 1, 12, 0, 0, 0})
+		r[25] = flags
+		r[28], r[31] = 1, 1
+		r[32] = byte(len(name))
+		copy(r[33:], name)
+		copy(r[at:], su)
+		return r
+	}
+	nm := func(flags byte, s string) []byte { return append([]byte{'N', 'M', byte(5 + len(s)), 1, flags}, s...) }
+	ucs2 := func(s string) []byte {
+		var b []byte
+		for _, u := range utf16.Encode([]rune(s)) {
+			b = binary.BigEndian.AppendUint16(b, u)
+		}
+		return b
+	}
+
+	// file data and the Rock Ridge continuation area
+	copy(img[30*sec:], "hello\n")
+	copy(img[31*sec:], strings.Repeat("A", sec))
+	copy(img[33*sec:], "BBBB")
+	copy(img[34*sec:], "password=iso\n")
+	copy(img[35*sec:], append(nm(0, "(rr).config"), 'S', 'T', 4, 1))
+	ce := append([]byte{'C', 'E', 28, 1}, lbaOf(35)...)
+	ce = append(ce, lbaOf(0)...)
+	ce = append(ce, lbaOf(20)...)
+
+	// directories at sectors 20/21 (8.3 and Rock Ridge) and 22/23 (Joliet)
+	dir := func(at, parent int, entries ...[]byte) {
+		b := slices.Concat(append([][]byte{
+			record([]byte{0}, at, sec, 2, nil),
+			record([]byte{1}, parent, sec, 2, nil),
+		}, entries...)...)
+		copy(img[at*sec:], b)
+	}
+	var sp, nmReadme, nmBig, nmDocs, nmSecret []byte
+	if rr {
+		sp = []byte{'S', 'P', 7, 1, 0xbe, 0xef, 0}
+		nmReadme, nmBig, nmDocs = nm(0, "readme.txt"), nm(0, "big.bin"), nm(0, "docs")
+		nmSecret = append(nm(1, "my secret "), ce...)
+	}
+	root := slices.Concat(record([]byte{0}, 20, sec, 2, sp), record([]byte{1}, 20, sec, 2, nil),
+		record([]byte("BIG.BIN;1"), 31, sec, 0x80, nmBig), record([]byte("BIG.BIN;1"), 33, 4, 0, nmBig),
+		record([]byte("DOCS"), 21, sec, 2, nmDocs),
+		record([]byte("README.TXT;1"), 30, 6, 0, nmReadme))
+	copy(img[20*sec:], root)
+	dir(21, 20, record([]byte("SECRET.CFG;1"), 34, 13, 0, nmSecret))
+	dir(22, 22,
+		record(ucs2("big.bin;1"), 31, sec, 0x80, nil), record(ucs2("big.bin;1"), 33, 4, 0, nil),
+		record(ucs2("docs"), 23, sec, 2, nil),
+		record(ucs2("readme.txt;1"), 30, 6, 0, nil))
+	dir(23, 22, record(ucs2("My Secret.config;1"), 34, 13, 0, nil))
+
+	desc := func(at int, typ byte, rootAt int) {
+		d := img[at*sec:]
+		d[0] = typ
+		copy(d[1:], "CD001")
+		d[6] = 1
+		if typ == 2 {
+			copy(d[88:], "%/E")
+		}
+		copy(d[156:], record([]byte{0}, rootAt, sec, 2, nil))
+	}
+	desc(16, 1, 20)
+	term := 17
+	if joliet {
+		desc(17, 2, 22)
+		term = 18
+	}
+	img[term*sec] = 255
+	copy(img[term*sec+1:], "CD001")
+	if err := os.WriteFile(p, img, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestISO9660(t *testing.T) {
+	dir := t.TempDir()
+	big := strings.Repeat("A", isoSector) + "BBBB"
+	for _, c := range []struct {
+		joliet, rr bool
+		want       []string
+	}{
+		{false, false, []string{"BIG.BIN=" + big, "DOCS/SECRET.CFG=password=iso\n", "README.TXT=hello\n"}},
+		{true, false, []string{"big.bin=" + big, "docs/My Secret.config=password=iso\n", "readme.txt=hello\n"}},
+		{true, true, []string{"big.bin=" + big, "docs/my secret (rr).config=password=iso\n", "readme.txt=hello\n"}},
+	} {
+		p := filepath.Join(dir, fmt.Sprintf("cd-%v-%v.iso", c.joliet, c.rr))
+		isoImage(t, p, c.joliet, c.rr)
+		if got := walkImage(t, p); !slices.Equal(got, c.want) {
+			t.Errorf("joliet %v, rock ridge %v: got %q", c.joliet, c.rr, got)
+		}
+	}
+}
+
+// fatImage writes a FAT volume of the given type at base in f, holding
+// files by slash path. Chains of files longer than a cluster run backwards.
+func fatImage(t *testing.T, f *os.File, base int64, bits int, files map[string]string) {
+	t.Helper()
+	type geometry struct {
+		spc, reserved, rootEnts, total, fatSize int
+	}
+	g := map[int]geometry{
+		12: {1, 1, 224, 2880, 9},
+		16: {4, 4, 512, 160000, 157},
+		32: {1, 32, 0, 70000, 547},
+	}[bits]
+	const bps = 512
+	cs := g.spc * bps
+	boot := make([]byte, bps)
+	copy(boot, []byte{0xeb, 0x3c, 0x90})
+	copy(boot[3:], "MSWIN4.1")
+	binary.LittleEndian.PutUint16(boot[11:], bps)
+	boot[13] = byte(g.spc)
+	binary.LittleEndian.PutUint16(boot[14:], uint16(g.reserved))
+	boot[16] = 2
+	binary.LittleEndian.PutUint16(boot[17:], uint16(g.rootEnts))
+	if g.total < 65536 {
+		binary.LittleEndian.PutUint16(boot[19:], uint16(g.total))
+	} else {
+		binary.LittleEndian.PutUint32(boot[32:], uint32(g.total))
+	}
+	boot[21] = 0xf8
+	if bits == 32 {
+		binary.LittleEndian.PutUint32(boot[36:], uint32(g.fatSize))
+		binary.LittleEndian.PutUint32(boot[44:], 2)
+	} else {
+		binary.LittleEndian.PutUint16(boot[22:], uint16(g.fatSize))
+	}
+	boot[510], boot[511] = 0x55, 0xaa
+
+	fat := make([]byte, g.fatSize*bps)
+	eoc := uint32(1)<<bits - 1
+	set := func(c, v uint32) {
+		switch bits {
+		case 12:
+			off := c * 3 / 2
+			if c&1 != 0 {
+				fat[off] = fat[off]&0x0f | byte(v<<4)
+				fat[off+1] = byte(v >> 4)
+			} else {
+				fat[off] = byte(v)
+				fat[off+1] = fat[off+1]&0xf0 | byte(v>>8&0x0f)
+			}
+		case 16:
+			binary.LittleEndian.PutUint16(fat[2*c:], uint16(v))
+		default:
+			binary.LittleEndian.PutUint32(fat[4*c:], v&0x0fffffff)
+		}
+	}
+	set(0, eoc&^0xff|0xf8)
+	set(1, eoc)
+	rootSecs := (g.rootEnts*32 + bps - 1) / bps
+	dataOff := base + int64(g.reserved+2*g.fatSize+rootSecs)*bps
+	next := uint32(2)
+	alloc := func(size int) []uint32 {
+		var cl []uint32
+		for range max(1, (size+cs-1)/cs) {
+			cl = append(cl, next)
+			next++
+		}
+		slices.Reverse(cl)
+		for i, c := range cl {
+			if i+1 < len(cl) {
+				set(c, cl[i+1])
+			} else {
+				set(c, eoc)
+			}
+		}
+		return cl
+	}
+	write := func(cl []uint32, data []byte) {
+		for i, c := range cl {
+			chunk := data[min(i*cs, len(data)):min((i+1)*cs, len(data))]
+			if _, err := f.WriteAt(chunk, dataOff+int64(c-2)*int64(cs)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	entry := func(short string, attr byte, cluster uint32, size int, nt byte) []byte {
+		e := make([]byte, 32)
+		copy(e, fmt.Sprintf("%-11s", short))
+		e[11], e[12] = attr, nt
+		binary.LittleEndian.PutUint16(e[20:], uint16(cluster>>16))
+		binary.LittleEndian.PutUint16(e[26:], uint16(cluster))
+		binary.LittleEndian.PutUint16(e[24:], 44<<9|3<<5|1) // 2024-03-01
+		binary.LittleEndian.PutUint32(e[28:], uint32(size))
+		return e
+	}
+	// names with a long name get a ~n short name; 8.3 lower case names use
+	// the NT case flags
+	named := func(name string, n int, attr byte, cluster uint32, size int) []byte {
+		base, ext, _ := strings.Cut(name, ".")
+		if len(base) <= 8 && len(ext) <= 3 && strings.ToLower(name) == name && !strings.ContainsAny(name, " ()") {
+			return entry(fmt.Sprintf("%-8s%s", strings.ToUpper(base), strings.ToUpper(ext)), attr, cluster, size, 0x18)
+		}
+		short := fmt.Sprintf("%-8s%-3s", fmt.Sprintf("LONG~%d", n), "")
+		var out []byte
+		u := utf16.Encode([]rune(name))
+		if len(u)%13 != 0 {
+			u = append(u, 0)
+		}
+		for len(u)%13 != 0 {
+			u = append(u, 0xffff)
+		}
+		sum := fatChecksum([]byte(short))
+		for i := len(u) / 13; i >= 1; i-- {
+			l := make([]byte, 32)
+			l[0] = byte(i)
+			if i == len(u)/13 {
+				l[0] |= 0x40
+			}
+			l[11], l[13] = 0x0f, sum
+			for j, at := range []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+				binary.LittleEndian.PutUint16(l[at:], u[(i-1)*13+j])
+			}
+			out = append(out, l...)
+		}
+		return append(out, entry(short, attr, cluster, size, 0)...)
+	}
+
+	// the tree: directory name -> children
+	children := map[string][]string{}
+	for p := range files {
+		for d := p; d != "."; d = path.Dir(d) {
+			if parent := path.Dir(d); !slices.Contains(children[parent], d) {
+				children[parent] = append(children[parent], d)
+			}
+		}
+	}
+	for _, c := range children {
+		sort.Strings(c)
+	}
+	clusters := map[string][]uint32{}
+	var place func(dir string)
+	place = func(dir string) {
+		for _, c := range children[dir] {
+			if data, ok := files[c]; ok {
+				clusters[c] = alloc(len(data))
+				continue
+			}
+			clusters[c] = alloc((len(children[c]) + 2) * 3 * 32)
+			place(c)
+		}
+	}
+	if bits == 32 {
+		clusters["."] = alloc(len(children["."]) * 3 * 32)
+	}
+	place(".")
+	n := 0
+	var fill func(dir string) []byte
+	fill = func(dir string) []byte {
+		var b []byte
+		if dir != "." {
+			parent := uint32(0)
+			if p := path.Dir(dir); p != "." {
+				parent = clusters[p][0]
+			}
+			b = append(entry(".", 0x10, clusters[dir][0], 0, 0), entry("..", 0x10, parent, 0, 0)...)
+		} else {
+			b = entry("VOLUME", 0x08, 0, 0, 0)
+		}
+		for _, c := range children[dir] {
+			n++
+			if data, ok := files[c]; ok {
+				b = append(b, named(path.Base(c), n, 0x20, clusters[c][0], len(data))...)
+				write(clusters[c], []byte(data))
+				continue
+			}
+			b = append(b, named(path.Base(c), n, 0x10, clusters[c][0], 0)...)
+			write(clusters[c], fill(c))
+		}
+		return b
+	}
+	rootDir := fill(".")
+	if bits == 32 {
+		write(clusters["."], rootDir)
+	} else if _, err := f.WriteAt(rootDir, base+int64(g.reserved+2*g.fatSize)*bps); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 2 {
+		if _, err := f.WriteAt(fat, base+int64(g.reserved+i*g.fatSize)*bps); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := f.WriteAt(boot, base); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(base + int64(g.total)*bps); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFAT(t *testing.T) {
+	dir := t.TempDir()
+	big := strings.Repeat("0123456789abcdef", 300)
+	files := map[string]string{
+		"readme.txt":                     "hello\n",
+		"Documents/My Passwords (1).txt": "password=fat\n",
+		"Documents/notes/big.log":        big,
+		"CONFIG.SYS":                     "FILES=40\n",
+	}
+	want := []string{
+		"CONFIG.SYS=FILES=40\n",
+		"Documents/My Passwords (1).txt=password=fat\n",
+		"Documents/notes/big.log=" + big,
+		"readme.txt=hello\n",
+	}
+	for _, bits := range []int{12, 16, 32} {
+		p := filepath.Join(dir, fmt.Sprintf("fat%d.img", bits))
+		f, err := os.Create(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fatImage(t, f, 0, bits, files)
+		f.Close()
+		if got := walkImage(t, p); !slices.Equal(got, want) {
+			t.Errorf("FAT%d: got %q", bits, got)
+		}
+		fsys, _ := openDiskImage(p)
+		if v := fsys.vol.(*fatVolume); v.bits != bits {
+			t.Errorf("FAT%d read as FAT%d", bits, v.bits)
+		}
+		fsys.Close()
+	}
+}
+
+func TestScan_DiskImages(t *testing.T) {
+	dir := t.TempDir()
+	pf := filepath.Join(dir, "p.txt")
+	_ = os.WriteFile(pf, []byte("password=\n"), 0o644)
+	root := filepath.Join(dir, "root")
+	_ = os.MkdirAll(root, 0o755)
+	isoImage(t, filepath.Join(root, "backup.iso"), true, true)
+
+	// a USB stick dump: an MBR with a FAT16 partition at 1 MiB
+	f, err := os.Create(filepath.Join(root, "usb.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fatImage(t, f, 1<<20, 16, map[string]string{"keys/id_rsa.txt": "password=usb\n"})
+	mbr := make([]byte, 512)
+	mbr[446+4] = 0x0e
+	binary.LittleEndian.PutUint32(mbr[446+8:], 2048)
+	binary.LittleEndian.PutUint32(mbr[446+12:], 160000)
+	mbr[510], mbr[511] = 0x55, 0xaa
+	_, _ = f.WriteAt(mbr, 0)
+	f.Close()
+
+	// an .img that is no filesystem is scanned as a file
+	_ = os.WriteFile(filepath.Join(root, "raw.img"), []byte("password=raw\n"), 0o644)
+
+	scan := func(archives bool) []string {
+		var got []string
+		for _, m := range collectScan(t, ScanOptions{Roots: []string{root}, PatternFile: pf, Threads: 2, Archives: archives}) {
+			got = append(got, filepath.Base(m.FilePath)+"::"+m.InnerPath+"|"+strings.TrimSpace(m.Line))
+		}
+		slices.Sort(got)
+		return got
+	}
+	want := []string{
+		"backup.iso::docs/my secret (rr).config|password=iso",
+		"raw.img::|password=raw",
+		"usb.img::keys/id_rsa.txt|password=usb",
+	}
+	if got := scan(true); !slices.Equal(got, want) {
+		t.Fatalf("got %q", got)
+	}
+	// without --archives the images are read as plain files
+	if got := scan(false); !slices.Contains(got, "raw.img::|password=raw") || slices.Contains(got, want[2]) {
+		t.Fatalf("without archives: got %q", got)
+	}
+}
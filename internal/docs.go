@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// docExtractor emits the text of a document in units (paragraph, cell,
+// slide, page, ...) together with a human-readable location of each unit.
+type docExtractor func(r io.ReaderAt, size int64, emit func(loc string, text []byte)) error
+
+// ErrEncrypted and ErrNoText mark documents whose text could not be
+// extracted; they are reported instead of silently matching nothing.
+var (
+	ErrEncrypted = errors.New("encrypted, content not scanned")
+	ErrNoText    = errors.New("no text layer, content not scanned")
+)
+
+// docExtractors by lowercase extension; filled by the format files.
+var docExtractors = map[string]docExtractor{}
+
+// docReader is a document handed to matchReader: the stream feeds the
+// byte-level rules, line rules run over the extracted text instead. A
+// container matched record by record sets records instead of extract.
+type docReader struct {
+	io.Reader
+	ra      io.ReaderAt
+	size    int64
+	extract docExtractor
+	records recordMatcher
+}
+
+// recordMatcher matches the records of a container (mail store, LevelDB
+// file, SQLite database) and reports their findings to emit.
+type recordMatcher func(r io.ReaderAt, size int64, emit func(MatchResult)) error
+
+// recordRules returns what the records of a container are matched with: its
+// line patterns, without hashing or --save-full. Hashes, YARA, byte rules and
+// --save-full see the raw container stream, once, in matchReader.
+func recordRules(rules *RuleSet, opts ScanOptions) (*RuleSet, ScanOptions) {
+	opts.SaveFull, opts.noHash, opts.Stats = false, true, nil
+	return &RuleSet{Patterns: rules.Patterns, HasInsensitive: rules.HasInsensitive}, opts
+}
+
+// openDocument wraps r in a docReader if name has a text extractor. Streams
+// without random access are spooled to a temp file; call done when finished.
+func openDocument(r io.Reader, name string) (io.Reader, func(), error) {
+	ex := docExtractors[strings.ToLower(filepath.Ext(name))]
+	if ex == nil {
+		return r, func() {}, nil
+	}
+	f, size, done, err := randomAccess(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &docReader{Reader: f, ra: f, size: size, extract: ex}, done, nil
+}
+
+// randomAccess returns r itself if it is a regular file, else a temp file
+// holding its content; call done when finished.
+func randomAccess(r io.Reader) (*os.File, int64, func(), error) {
+	if f, ok := r.(*os.File); ok {
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			return f, fi.Size(), func() {}, nil
+		}
+	}
+	tmp, err := spoolTemp(r)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	done := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	fi, err := tmp.Stat()
+	if err != nil {
+		done()
+		return nil, 0, nil, err
+	}
+	return tmp, fi.Size(), done, nil
+}
+
+// isDocument reports whether name has a text extractor.
+func isDocument(name string) bool {
+	_, ok := docExtractors[strings.ToLower(filepath.Ext(name))]
+	return ok
+}
+
+// scanDocument matches a document's text; r is spooled if it cannot be read at random.
+// It returns the hash of the raw document.
+func (fs *FileScanner) scanDocument(
+	r io.Reader,
+	filePath, innerPath string,
+	rules *RuleSet,
+	opts ScanOptions,
+	onMatch func(MatchResult),
+	matchCnt, errCnt *atomic.Int64,
+) string {
+	name := filePath
+	if innerPath != "" {
+		name = innerPath
+	}
+	dr, done, err := openDocument(r, name)
+	if err != nil {
+		errCnt.Add(1)
+		onMatch(MatchResult{FilePath: filePath, InnerPath: innerPath, Error: err})
+		return ""
+	}
+	defer done()
+	if d, ok := dr.(*docReader); ok && opts.QR && isQRImage(name) {
+		d.extract = withQR(d.extract, opts)
+	}
+	return matchReader(dr, rules, opts, onMatch, filePath, innerPath, matchCnt, errCnt)
+}
+
+// isStructured reports whether name is read by a format reader (document,
+// mail store, LevelDB) instead of line by line.
+func isStructured(name string) bool {
+	return isDocument(name) || isMail(name) || isLevelDB(name)
+}
+
+// scanContent routes an entry by name: mail stores, LevelDB files and
+// documents go to their readers, SQLite databases are recognised by their
+// header, anything else goes straight to matchReader. It returns the hash of
+// the content.
+func (fs *FileScanner) scanContent(
+	ctx context.Context,
+	r io.Reader,
+	filePath, innerPath string,
+	rules *RuleSet,
+	opts ScanOptions,
+	onMatch func(MatchResult),
+	matchCnt, errCnt *atomic.Int64,
+) string {
+	name := filePath
+	if innerPath != "" {
+		name = innerPath
+	}
+	switch {
+	case isMail(name):
+		return fs.scanMail(ctx, r, filePath, innerPath, rules, opts, onMatch, matchCnt, errCnt)
+	case isLevelDB(name):
+		return fs.scanLevelDB(r, filePath, innerPath, rules, opts, onMatch, matchCnt, errCnt)
+	case isDocument(name):
+		return fs.scanDocument(r, filePath, innerPath, rules, opts, onMatch, matchCnt, errCnt)
+	default:
+		r, db := sniffSQLite(r)
+		if db {
+			return fs.scanSQLite(r, filePath, innerPath, rules, opts, onMatch, matchCnt, errCnt)
+		}
+		return matchReader(r, rules, opts, onMatch, filePath, innerPath, matchCnt, errCnt)
+	}
+}
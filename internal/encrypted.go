@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/bodgit/sevenzip"
+	kzip "github.com/klauspost/compress/zip"
+	"github.com/mholt/archives"
+	"github.com/nwaples/rardecode/v2"
+)
+
+// Encrypted archives are findings of their own: an encrypted zip, 7z or rar
+// entry is reported with the pattern "encrypted" whatever it holds. With
+// --archive-passwords each listed password is tried in turn; an entry one of
+// them opens is scanned like any other, and its finding names the password.
+// Zip entries are decrypted in zipcrypt.go, 7z and rar through the password
+// hooks of their readers. Archives whose listing is encrypted too are
+// reported once, without an inner path.
+
+const encryptedPattern = "encrypted"
+
+// loadPasswords reads one password per line; empty lines are skipped.
+func loadPasswords(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, l := range strings.Split(string(data), "\n") {
+		if l = strings.TrimSuffix(l, "\r"); l != "" {
+			out = append(out, l)
+		}
+	}
+	return out, nil
+}
+
+// isEncryptedErr reports whether a 7z or rar reader failed for want of a
+// (correct) password.
+func isEncryptedErr(err error) bool {
+	var re *sevenzip.ReadError
+	if errors.As(err, &re) && re.Encrypted {
+		return true
+	}
+	return errors.Is(err, rardecode.ErrArchiveEncrypted) ||
+		errors.Is(err, rardecode.ErrArchivedFileEncrypted) ||
+		errors.Is(err, rardecode.ErrBadPassword)
+}
+
+// zipEncrypted reports whether an archive entry is a zip entry with the
+// encryption flag set.
+func zipEncrypted(info iofs.FileInfo) bool {
+	h, ok := info.Sys().(*kzip.FileHeader)
+	return ok && h.Flags&1 != 0
+}
+
+// withPassword returns a copy of a 7z or rar archive filesystem that reads
+// with pw; ok is false for other filesystems.
+func withPassword(fsys iofs.FS, pw string) (iofs.FS, bool) {
+	afs, ok := fsys.(*archives.ArchiveFS)
+	if !ok {
+		return nil, false
+	}
+	format := afs.Format
+	switch f := format.(type) {
+	case archives.SevenZip:
+		f.Password = pw
+		format = f
+	case archives.Rar:
+		f.Password = pw
+		format = f
+	default:
+		return nil, false
+	}
+	return &archives.ArchiveFS{Path: afs.Path, Stream: afs.Stream, Format: format, Prefix: afs.Prefix, Context: afs.Context}, true
+}
+
+// findPassword returns the first password with which open yields the whole
+// entry without error; a wrong password may pass the quick checks, so the
+// entry is read to its end.
+func findPassword(open func(pw string) (io.ReadCloser, error), passwords []string) (string, bool) {
+	for _, pw := range passwords {
+		r, err := open(pw)
+		if err != nil {
+			continue
+		}
+		_, err = io.Copy(io.Discard, r)
+		r.Close()
+		if err == nil {
+			return pw, true
+		}
+	}
+	return "", false
+}
+
+// scanEncrypted reports an encrypted entry and scans it when one of the
+// passwords opens it; the hash is that of the decrypted content.
+func (fs *FileScanner) scanEncrypted(
+	ctx context.Context,
+	open func(pw string) (io.ReadCloser, error),
+	archivePath, innerPath string,
+	rules *RuleSet,
+	opts ScanOptions,
+	onMatch func(MatchResult),
+	matchCnt, errCnt *atomic.Int64,
+) string {
+	pw, ok := findPassword(open, opts.passwords)
+	matchCnt.Add(1)
+	onMatch(MatchResult{FilePath: archivePath, InnerPath: innerPath, Matched: true, Encrypted: true, Password: pw, Pattern: encryptedPattern})
+	if !ok {
+		return ""
+	}
+	r, err := open(pw)
+	if err != nil {
+		errCnt.Add(1)
+		onMatch(MatchResult{FilePath: archivePath, InnerPath: innerPath, Error: err})
+		return ""
+	}
+	defer r.Close()
+	return fs.scanContent(ctx, r, archivePath, innerPath, rules, opts, onMatch, matchCnt, errCnt)
+}
+
+// scanArchiveEntry scans an opened archive entry, turning to scanEncrypted
+// when it turns out to be encrypted, and returns its hash.
+func (fs *FileScanner) scanArchiveEntry(
+	ctx context.Context,
+	fsys iofs.FS,
+	f iofs.File,
+	archivePath, innerPath string,
+	rules *RuleSet,
+	opts ScanOptions,
+	onMatch func(MatchResult),
+	matchCnt, errCnt *atomic.Int64,
+) string {
+	if info, err := f.Stat(); err == nil && zipEncrypted(info) {
+		return fs.scanEncrypted(ctx, openZip(archivePath, innerPath), archivePath, innerPath, rules, opts, onMatch, matchCnt, errCnt)
+	}
+	// 7z and rar fail on the first read of an entry they cannot decrypt
+	br := bufio.NewReader(f)
+	if _, err := br.Peek(1); isEncryptedErr(err) {
+		return fs.scanEncrypted(ctx, openWithPassword(fsys, innerPath), archivePath, innerPath, rules, opts, onMatch, matchCnt, errCnt)
+	}
+	return fs.scanContent(ctx, br, archivePath, innerPath, rules, opts, onMatch, matchCnt, errCnt)
+}
+
+// lockedEntry returns how to open an entry with a password when err, from
+// opening it without one, shows it is encrypted, and nil otherwise. Zip
+// entries with WinZip AES fail as an unknown method.
+func lockedEntry(fsys iofs.FS, err error, archivePath, innerPath string) func(pw string) (io.ReadCloser, error) {
+	if isEncryptedErr(err) {
+		return openWithPassword(fsys, innerPath)
+	}
+	if errors.Is(err, kzip.ErrAlgorithm) {
+		if info, err := iofs.Stat(fsys, innerPath); err == nil && zipEncrypted(info) {
+			return openZip(archivePath, innerPath)
+		}
+	}
+	return nil
+}
+
+func openZip(archivePath, innerPath string) func(pw string) (io.ReadCloser, error) {
+	return func(pw string) (io.ReadCloser, error) { return openZipEncrypted(archivePath, innerPath, pw) }
+}
+
+// openWithPassword opens innerPath of a 7z or rar filesystem with a password.
+func openWithPassword(fsys iofs.FS, innerPath string) func(pw string) (io.ReadCloser, error) {
+	return func(pw string) (io.ReadCloser, error) {
+		locked, ok := withPassword(fsys, pw)
+		if !ok {
+			return nil, errors.New("archive format takes no password")
+		}
+		return locked.Open(innerPath)
+	}
+}
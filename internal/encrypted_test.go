@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	deflate "compress/flate"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// 7z archives from the bodgit/sevenzip test data, password "password", each
+// holding foo ("foo\n") and bar ("bar\n"): t2 with encrypted headers, t4
+// with plain headers and encrypted, compressed content.
+const (
+	sevenZipEncHeaders = "377abcaf271c00041f4171c7c0000000000000002800000000000000b9fb275aa044c1456ad5f47b9bf189836409cd1b38e4d49af8be29ab6aaed50046d843ea2936990d9181d33c0371f1b578aece6518ab9dce9bc5fab04de467e0356ebd9896fda7be1deb50c71681fd62f8c0bc51791c02241999ee97a2933bd66f8e78d96ef257c56242a9fc403848a34e55db629da6278f463aa51250261453551597f578129cdddf774b05c0a9a7747ee2d1631d3348110b863a738c12b61664575925947bd8be07089e84a75de7d180a055690df8902642ab4c68da0f74f8949ba0ef170620010980a000070b0100012406f107010a5307f4c1ea750f99e7630c80960a01f0f04c3b0000"
+	sevenZipEncContent = "377abcaf271c0004193f0abf7d00000000000000200000000000000037e9d2ecbfc858e415d69d6d3aba10349f48e6930000813307ae0fcef2b20c07b0c3daf75f458a97538229519801100212d33d249679dc0d4cbb35a48140bac19b5cfa610eefcb252344346e39110f7dd205f61fc6966c9a94bedb715a3a34927812194c75bb1a1d7cfba5ef6765e3ea99bb303f98e756c10e0fddf8048100000017061001096d00070b01000123030101055d001000000c760a015687ca730000"
+)
+
+// zipEntry is an entry for encZip; cipher is "", "zipcrypto", "aes128" or
+// "aes256" (the latter as AE-2, without CRC).
+type zipEntry struct {
+	name, body, cipher string
+	store              bool
+}
+
+func encZip(t *testing.T, path, password string, entries []zipEntry) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		data := []byte(e.body)
+		method := zip.Deflate
+		if e.store {
+			method = zip.Store
+		} else {
+			var c bytes.Buffer
+			fw, _ := deflate.NewWriter(&c, deflate.DefaultCompression)
+			fw.Write(data)
+			fw.Close()
+			data = c.Bytes()
+		}
+		h := &zip.FileHeader{Name: e.name, Method: method, CRC32: crc32.ChecksumIEEE([]byte(e.body)), UncompressedSize64: uint64(len(e.body))}
+		switch e.cipher {
+		case "zipcrypto":
+			k := newZipKeys(password)
+			hdr := make([]byte, 12)
+			hdr[11] = byte(h.CRC32 >> 24)
+			plain := append(hdr, data...)
+			data = make([]byte, len(plain))
+			for i, p := range plain {
+				data[i] = p ^ k.stream()
+				k.update(p)
+			}
+			h.Flags |= 1
+		case "aes128", "aes256":
+			strength, version := byte(1), uint16(1)
+			if e.cipher == "aes256" {
+				strength, version, h.CRC32 = 3, 2, 0
+			}
+			keyLen := 8 + 8*int(strength)
+			salt := bytes.Repeat([]byte{7}, keyLen/2)
+			dk, _ := pbkdf2.Key(sha1.New, password, salt, 1000, 2*keyLen+2)
+			block, _ := aes.NewCipher(dk[:keyLen])
+			enc := make([]byte, len(data))
+			var ctr, ks [16]byte
+			for i := range data {
+				if i%16 == 0 {
+					binary.LittleEndian.PutUint64(ctr[:], uint64(i/16+1))
+					block.Encrypt(ks[:], ctr[:])
+				}
+				enc[i] = data[i] ^ ks[i%16]
+			}
+			mac := hmac.New(sha1.New, dk[keyLen:2*keyLen])
+			mac.Write(enc)
+			data = slices.Concat(salt, dk[2*keyLen:], enc, mac.Sum(nil)[:10])
+			h.Extra = binary.LittleEndian.AppendUint16(nil, 0x9901)
+			h.Extra = binary.LittleEndian.AppendUint16(h.Extra, 7)
+			h.Extra = binary.LittleEndian.AppendUint16(h.Extra, version)
+			h.Extra = append(h.Extra, 'A', 'E', strength)
+			h.Extra = binary.LittleEndian.AppendUint16(h.Extra, method)
+			h.Method = 99
+			h.Flags |= 1
+		}
+		h.CompressedSize64 = uint64(len(data))
+		w, err := zw.CreateRaw(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestZipDecrypt(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "enc.zip")
+	body := strings.Repeat("password=hunter2\n", 50)
+	encZip(t, p, "s3cret", []zipEntry{
+		{name: "crypto.txt", body: body, cipher: "zipcrypto"},
+		{name: "crypto-stored.txt", body: body, cipher: "zipcrypto", store: true},
+		{name: "dir/aes128.txt", body: body, cipher: "aes128", store: true},
+		{name: "aes256.txt", body: body, cipher: "aes256"},
+	})
+	for _, name := range []string{"crypto.txt", "crypto-stored.txt", "dir/aes128.txt", "aes256.txt"} {
+		r, err := openZipEncrypted(p, name, "s3cret")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || string(got) != body {
+			t.Fatalf("%s: %q, %v", name, got, err)
+		}
+		// a wrong password fails on the check bytes or, at the end, on the
+		// CRC or authentication code
+		if r, err := openZipEncrypted(p, name, "guess"); err == nil {
+			_, err = io.ReadAll(r)
+			r.Close()
+			if err == nil {
+				t.Fatalf("%s: wrong password read without error", name)
+			}
+		}
+	}
+}
+
+func TestScan_Encrypted(t *testing.T) {
+	dir := t.TempDir()
+	pf := filepath.Join(dir, "p.txt")
+	_ = os.WriteFile(pf, []byte("password=\nfoo\n"), 0o644)
+	pw := filepath.Join(dir, "passwords.txt")
+	_ = os.WriteFile(pw, []byte("letmein\r\n\r\ns3cret\npassword\n"), 0o644)
+	root := filepath.Join(dir, "root")
+	_ = os.MkdirAll(root, 0o755)
+	encZip(t, filepath.Join(root, "backup.zip"), "s3cret", []zipEntry{
+		{name: "keys.txt", body: "password=zip\n", cipher: "zipcrypto"},
+		{name: "aes.txt", body: "password=aes\n", cipher: "aes256"},
+		{name: "readme.txt", body: "password=plain\n"},
+	})
+	for name, h := range map[string]string{"headers.7z": sevenZipEncHeaders, "content.7z": sevenZipEncContent} {
+		b, _ := hex.DecodeString(h)
+		_ = os.WriteFile(filepath.Join(root, name), b, 0o644)
+	}
+
+	scan := func(passwords string) []string {
+		var got []string
+		for _, m := range collectScan(t, ScanOptions{Roots: []string{root}, PatternFile: pf, Threads: 2, Archives: true, ArchivePasswords: passwords}) {
+			s := filepath.Base(m.FilePath) + "::" + m.InnerPath + "|" + strings.TrimSpace(m.Line)
+			if m.Encrypted {
+				s += "encrypted " + m.Password
+			}
+			got = append(got, strings.TrimSpace(s))
+		}
+		slices.Sort(got)
+		return got
+	}
+	want := []string{
+		"backup.zip::aes.txt|encrypted",
+		"backup.zip::keys.txt|encrypted",
+		"backup.zip::readme.txt|password=plain",
+		"content.7z::bar|encrypted",
+		"content.7z::foo|encrypted",
+		"headers.7z::|encrypted",
+	}
+	if got := scan(""); !slices.Equal(got, want) {
+		t.Fatalf("without passwords: got %q", got)
+	}
+	want = []string{
+		"backup.zip::aes.txt|encrypted s3cret",
+		"backup.zip::aes.txt|password=aes",
+		"backup.zip::keys.txt|encrypted s3cret",
+		"backup.zip::keys.txt|password=zip",
+		"backup.zip::readme.txt|password=plain",
+		"content.7z::bar|encrypted password",
+		"content.7z::foo|encrypted password",
+		"content.7z::foo|foo",
+		"headers.7z::foo|foo",
+		"headers.7z::|encrypted password",
+	}
+	if got := scan(pw); !slices.Equal(got, want) {
+		t.Fatalf("with passwords: got %q", got)
+	}
+}
@@ -0,0 +1,274 @@
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// FAT12/16/32 with VFAT long names. The type follows from the cluster count
+// as the specification defines it; short names are decoded as code page 437.
+
+type fatVolume struct {
+	r        io.ReaderAt
+	bits     int   // 12, 16 or 32
+	cluster  int64 // bytes per cluster
+	clusters uint32
+	fatOff   int64 // first FAT
+	dataOff  int64 // cluster 2
+	rootOff  int64 // FAT12/16 root directory
+	rootSize int64
+	rootClus uint32 // FAT32 root directory
+}
+
+func openFAT(r io.ReaderAt, base int64) (*fatVolume, error) {
+	b := make([]byte, 512)
+	if _, err := r.ReadAt(b, base); err != nil {
+		return nil, err
+	}
+	bps := int64(binary.LittleEndian.Uint16(b[11:]))
+	spc := int64(b[13])
+	reserved := int64(binary.LittleEndian.Uint16(b[14:]))
+	fats := int64(b[16])
+	rootEnts := int64(binary.LittleEndian.Uint16(b[17:]))
+	total := int64(binary.LittleEndian.Uint16(b[19:]))
+	if total == 0 {
+		total = int64(binary.LittleEndian.Uint32(b[32:]))
+	}
+	fatSize := int64(binary.LittleEndian.Uint16(b[22:]))
+	if fatSize == 0 {
+		fatSize = int64(binary.LittleEndian.Uint32(b[36:]))
+	}
+	switch {
+	case b[0] != 0xeb && b[0] != 0xe9,
+		bps != 512 && bps != 1024 && bps != 2048 && bps != 4096,
+		spc == 0 || spc&(spc-1) != 0,
+		reserved == 0, fats == 0, fatSize == 0,
+		b[21] != 0xf0 && b[21] < 0xf8:
+		return nil, errors.New("fat: no boot sector")
+	}
+	rootSecs := (rootEnts*32 + bps - 1) / bps
+	first := reserved + fats*fatSize + rootSecs
+	if total <= first {
+		return nil, errors.New("fat: bad geometry")
+	}
+	v := &fatVolume{
+		r:        r,
+		cluster:  bps * spc,
+		clusters: uint32((total - first) / spc),
+		fatOff:   base + reserved*bps,
+		dataOff:  base + first*bps,
+		rootOff:  base + (reserved+fats*fatSize)*bps,
+		rootSize: rootEnts * 32,
+	}
+	switch {
+	case v.clusters < 4085:
+		v.bits = 12
+	case v.clusters < 65525:
+		v.bits = 16
+	default:
+		v.bits = 32
+		v.rootClus = binary.LittleEndian.Uint32(b[44:])
+	}
+	return v, nil
+}
+
+// next returns the cluster after c in its chain, false at the end.
+func (v *fatVolume) next(c uint32) (uint32, bool) {
+	var b [4]byte
+	switch v.bits {
+	case 12:
+		if _, err := v.r.ReadAt(b[:2], v.fatOff+int64(c+c/2)); err != nil {
+			return 0, false
+		}
+		n := uint32(binary.LittleEndian.Uint16(b[:]))
+		if c&1 != 0 {
+			n >>= 4
+		}
+		c = n & 0xfff
+	case 16:
+		if _, err := v.r.ReadAt(b[:2], v.fatOff+int64(c)*2); err != nil {
+			return 0, false
+		}
+		c = uint32(binary.LittleEndian.Uint16(b[:]))
+	default:
+		if _, err := v.r.ReadAt(b[:], v.fatOff+int64(c)*4); err != nil {
+			return 0, false
+		}
+		c = binary.LittleEndian.Uint32(b[:]) & 0x0fffffff
+	}
+	return c, c >= 2 && c < v.clusters+2
+}
+
+func (v *fatVolume) root() diskEntry {
+	return diskEntry{name: ".", dir: true, cluster: v.rootClus}
+}
+
+// fatReader reads a cluster chain, up to left bytes.
+type fatReader struct {
+	v     *fatVolume
+	c     uint32
+	pos   int64 // in the current cluster
+	left  int64
+	steps uint32
+}
+
+func (f *fatReader) Read(p []byte) (int, error) {
+	if f.left <= 0 {
+		return 0, io.EOF
+	}
+	if f.pos == f.v.cluster {
+		c, ok := f.v.next(f.c)
+		if f.steps++; !ok || f.steps > f.v.clusters {
+			return 0, io.ErrUnexpectedEOF
+		}
+		f.c, f.pos = c, 0
+	}
+	n := min(int64(len(p)), f.v.cluster-f.pos, f.left)
+	n2, err := f.v.r.ReadAt(p[:n], f.v.dataOff+int64(f.c-2)*f.v.cluster+f.pos)
+	f.pos += int64(n2)
+	f.left -= int64(n2)
+	if err == io.EOF && n2 > 0 {
+		err = nil
+	}
+	return n2, err
+}
+
+func (v *fatVolume) open(e diskEntry) io.Reader {
+	if e.size == 0 || e.cluster < 2 || e.cluster >= v.clusters+2 {
+		return strings.NewReader("")
+	}
+	return &fatReader{v: v, c: e.cluster, left: e.size}
+}
+
+func (v *fatVolume) readDir(dir diskEntry) ([]diskEntry, error) {
+	var data []byte
+	if dir.cluster == 0 && v.bits != 32 {
+		data = make([]byte, v.rootSize)
+		if _, err := v.r.ReadAt(data, v.rootOff); err != nil {
+			return nil, err
+		}
+	} else {
+		if dir.cluster < 2 || dir.cluster >= v.clusters+2 {
+			return nil, fmt.Errorf("fat: directory at cluster %d", dir.cluster)
+		}
+		// a directory holds at most 65536 entries
+		r := &fatReader{v: v, c: dir.cluster, left: 65536 * 32}
+		var err error
+		data, err = io.ReadAll(r)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+	}
+	return fatEntries(data), nil
+}
+
+// fatEntries decodes 32-byte directory entries, joining long name entries
+// with the short entry they precede when the checksum agrees.
+func fatEntries(data []byte) []diskEntry {
+	var out []diskEntry
+	var long []uint16
+	var sum byte
+	for i := 0; i+32 <= len(data); i += 32 {
+		d := data[i : i+32]
+		if d[0] == 0 {
+			break
+		}
+		if d[0] == 0xe5 {
+			long = nil
+			continue
+		}
+		attr := d[11]
+		if attr&0x3f == 0x0f {
+			if d[0]&0x40 != 0 {
+				long, sum = nil, d[13]
+			}
+			var part []uint16
+			for _, at := range []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+				part = append(part, binary.LittleEndian.Uint16(d[at:]))
+			}
+			long = append(part, long...) // entries come last part first
+			continue
+		}
+		if attr&0x08 != 0 { // volume label
+			long = nil
+			continue
+		}
+		e := diskEntry{
+			dir:     attr&0x10 != 0,
+			size:    int64(binary.LittleEndian.Uint32(d[28:])),
+			cluster: uint32(binary.LittleEndian.Uint16(d[20:]))<<16 | uint32(binary.LittleEndian.Uint16(d[26:])),
+			mod:     fatTime(binary.LittleEndian.Uint16(d[24:]), binary.LittleEndian.Uint16(d[22:])),
+		}
+		if long != nil && fatChecksum(d[:11]) == sum {
+			e.name = fatLongName(long)
+		} else {
+			e.name = fatShortName(d)
+		}
+		long = nil
+		if e.dir {
+			e.size = 0
+		}
+		if e.name == "" || e.name == "." || e.name == ".." || strings.ContainsRune(e.name, '/') {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func fatChecksum(short []byte) byte {
+	var s byte
+	for _, c := range short {
+		s = (s>>1 | s<<7) + c
+	}
+	return s
+}
+
+func fatLongName(u []uint16) string {
+	for i, c := range u {
+		if c == 0 {
+			u = u[:i]
+			break
+		}
+	}
+	return string(utf16.Decode(u))
+}
+
+// fatShortName is the 8.3 name, lower-cased where Windows NT flags say so.
+func fatShortName(d []byte) string {
+	b := append([]byte(nil), d[:11]...)
+	if b[0] == 0x05 {
+		b[0] = 0xe5
+	}
+	base := strings.TrimRight(string(b[:8]), " ")
+	ext := strings.TrimRight(string(b[8:11]), " ")
+	if d[12]&0x08 != 0 {
+		base = strings.ToLower(base)
+	}
+	if d[12]&0x10 != 0 {
+		ext = strings.ToLower(ext)
+	}
+	name := base
+	if ext != "" {
+		name += "." + ext
+	}
+	if s, err := charmap.CodePage437.NewDecoder().String(name); err == nil {
+		name = s
+	}
+	return name
+}
+
+func fatTime(date, tm uint16) time.Time {
+	if date == 0 {
+		return time.Time{}
+	}
+	return time.Date(1980+int(date>>9), time.Month(date>>5&15), int(date&31),
+		int(tm>>11), int(tm>>5&63), int(tm&31)*2, 0, time.UTC)
+}
@@ -28,6 +28,13 @@ type Task struct {
 	path      string
 	innerPath string
 	isArchive bool
+	isStdin   bool
+	isGit     bool       // path is a repository whose history is scanned
+	isImage   bool       // path is a container image read layer by layer
+	encrypted bool       // report the archive itself as encrypted
+	password  string     // unlocked the listing of an encrypted archive
+	split     *splitFile // set for one part of a large file
+	part      int
 }
 
 // DetectRoots returns default roots for OS if user didn't provide any.
@@ -76,7 +83,7 @@ func WalkWithDepth(ctx context.Context, root string, maxDepth int, fn func(path
 
 // WalkArchive Feed archive entries as tasks.
 func WalkArchive(ctx context.Context, path string, send func(Task), found *atomic.Int64, opts ScanOptions) {
-	fs, err := archives.FileSystem(ctx, path, nil)
+	fs, err := archiveFS(ctx, path)
 	if err != nil {
 		logrus.WithError(err).WithField("archive", path).Error("open archive")
 		return
@@ -85,11 +92,37 @@ func WalkArchive(ctx context.Context, path string, send func(Task), found *atomi
 		defer closer.Close()
 	}
 
+	err = walkArchive(ctx, fs, path, "", send, found, opts)
+	if !isEncryptedErr(err) {
+		return
+	}
+	// the listing itself is encrypted: 7z and rar with encrypted headers
+	for _, pw := range opts.passwords {
+		locked, ok := withPassword(fs, pw)
+		if !ok {
+			break
+		}
+		if _, err := iofs.ReadDir(locked, "."); err == nil {
+			send(Task{path: path, encrypted: true, password: pw})
+			_ = walkArchive(ctx, locked, path, pw, send, found, opts)
+			return
+		}
+	}
+	send(Task{path: path, encrypted: true})
+}
+
+// walkArchive sends the entries of fs and returns the error listing its
+// root, if any.
+func walkArchive(ctx context.Context, fs iofs.FS, path, password string, send func(Task), found *atomic.Int64, opts ScanOptions) error {
+	var rootErr error
 	count := 0
 	_ = iofs.WalkDir(fs, ".", func(inner string, d iofs.DirEntry, err error) error {
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
+		if err != nil && inner == "." {
+			rootErr = err
+		}
 		if err != nil || d.IsDir() {
 			return nil
 		}
@@ -102,10 +135,20 @@ func WalkArchive(ctx context.Context, path string, send func(Task), found *atomi
 			return nil
 		}
 		found.Add(1)
-		send(Task{path: path, innerPath: inner, isArchive: true})
+		send(Task{path: path, innerPath: inner, isArchive: true, password: password})
 		count++
 		return nil
 	})
+	return rootErr
+}
+
+// archiveFS opens an archive, or the filesystem of a disk image, as an
+// fs.FS; close it through io.Closer.
+func archiveFS(ctx context.Context, path string) (iofs.FS, error) {
+	if _, ok := diskImageExt[strings.ToLower(filepath.Ext(path))]; ok {
+		return openDiskImage(path)
+	}
+	return archives.FileSystem(ctx, path, nil)
 }
 
 func depthCount(rel string) int {
@@ -0,0 +1,358 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// Git history: with --git-history every repository met under the roots (a
+// .git entry, or a bare repository) is opened read-only and the lines each
+// commit added to a text file, against its first parent, are matched with
+// the line patterns; binary files a commit added or changed are scanned
+// whole. Merges only contribute what differs from every parent. Results
+// carry the commit, its author and author date, with the repository in
+// FilePath and the path in the commit in InnerPath.
+
+// isGitRepo reports whether dir is a bare repository or a .git directory.
+func isGitRepo(dir string) bool {
+	for _, n := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(dir, n)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// gitRepoAt returns the repository a walked entry stands for: the worktree
+// of a .git directory or file, or a bare repository itself.
+func gitRepoAt(path string, d os.DirEntry) (string, bool) {
+	if d.Name() == ".git" {
+		return filepath.Dir(path), true
+	}
+	if d.IsDir() && isGitRepo(path) {
+		return path, true
+	}
+	return "", false
+}
+
+// gitCommit is what a result tells about its commit.
+type gitCommit struct {
+	*object.Commit
+	loc string // "unreachable" for commits no ref leads to
+}
+
+func (c gitCommit) tag(onMatch func(MatchResult)) func(MatchResult) {
+	return func(r MatchResult) {
+		r.Commit = c.Hash.String()
+		r.Author = c.Author.Name + " <" + c.Author.Email + ">"
+		r.Date = c.Author.When
+		switch {
+		case c.loc == "":
+		case r.Location == "":
+			r.Location = c.loc
+		default:
+			r.Location = c.loc + ", " + r.Location
+		}
+		onMatch(r)
+	}
+}
+
+// scanGitRepo scans the history of the repository at path.
+func (fs *FileScanner) scanGitRepo(
+	ctx context.Context,
+	path string,
+	rules *RuleSet,
+	opts ScanOptions,
+	onMatch func(MatchResult),
+	matchCnt, errCnt *atomic.Int64,
+) {
+	fail := func(err error) {
+		errCnt.Add(1)
+		onMatch(MatchResult{FilePath: path, Error: fmt.Errorf("git: %w", err)})
+	}
+	repo, err := git.PlainOpenWithOptions(path, &git.PlainOpenOptions{EnableDotGitCommonDir: true})
+	if err != nil {
+		fail(err)
+		return
+	}
+	tips, done, err := gitRange(repo, opts.GitRange)
+	if err != nil {
+		fail(err)
+		return
+	}
+	blobs := map[plumbing.Hash]bool{} // blobs of the scanned trees
+	scan := func(c *object.Commit, loc string) {
+		if err := fs.scanCommit(ctx, repo, gitCommit{c, loc}, path, rules, opts, onMatch, blobs, matchCnt, errCnt); err != nil {
+			fail(fmt.Errorf("commit %s: %w", c.Hash, err))
+		}
+	}
+	// depth first over the parents; commits missing from a shallow clone end
+	// their line
+	stack := tips
+	for len(stack) > 0 && ctx.Err() == nil {
+		h := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if done[h] {
+			continue
+		}
+		done[h] = true
+		c, err := repo.CommitObject(h)
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			continue
+		}
+		if err != nil {
+			fail(err)
+			continue
+		}
+		scan(c, "")
+		stack = append(stack, c.ParentHashes...)
+	}
+	if !opts.GitUnreachable || opts.GitRange != "" || ctx.Err() != nil {
+		return
+	}
+	// commits and blobs in the object store (loose and packed) no ref leads to
+	commits, err := repo.CommitObjects()
+	if err != nil {
+		fail(err)
+		return
+	}
+	_ = commits.ForEach(func(c *object.Commit) error {
+		if !done[c.Hash] {
+			done[c.Hash] = true
+			scan(c, "unreachable")
+		}
+		return ctx.Err()
+	})
+	all, err := repo.BlobObjects()
+	if err != nil {
+		fail(err)
+		return
+	}
+	_ = all.ForEach(func(b *object.Blob) error {
+		if blobs[b.Hash] {
+			return ctx.Err()
+		}
+		r, err := b.Reader()
+		if err != nil {
+			fail(err)
+			return nil
+		}
+		defer r.Close()
+		fs.scanContent(ctx, r, path, "", rules, opts, func(m MatchResult) {
+			m.Location = "unreachable blob " + b.Hash.String()
+			onMatch(m)
+		}, matchCnt, errCnt)
+		return ctx.Err()
+	})
+}
+
+// gitRange resolves --git-range into the commits to start from and the
+// commits to leave out: "A..B" is B without the history of A, "B" is B's
+// history, "" is the history of every ref and HEAD.
+func gitRange(repo *git.Repository, spec string) ([]plumbing.Hash, map[plumbing.Hash]bool, error) {
+	done := map[plumbing.Hash]bool{}
+	resolve := func(rev string) (plumbing.Hash, error) {
+		if rev == "" {
+			rev = "HEAD"
+		}
+		h, err := repo.ResolveRevision(plumbing.Revision(rev))
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("%s: %w", rev, err)
+		}
+		return *h, nil
+	}
+	if spec != "" {
+		base, tip, isRange := strings.Cut(spec, "..")
+		if !isRange {
+			tip, base = base, ""
+		}
+		t, err := resolve(tip)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isRange {
+			b, err := resolve(base)
+			if err != nil {
+				return nil, nil, err
+			}
+			c, err := repo.CommitObject(b)
+			if err != nil {
+				return nil, nil, err
+			}
+			err = object.NewCommitPreorderIter(c, nil, nil).ForEach(func(c *object.Commit) error {
+				done[c.Hash] = true
+				return nil
+			})
+			if err != nil && !errors.Is(err, plumbing.ErrObjectNotFound) {
+				return nil, nil, err
+			}
+		}
+		return []plumbing.Hash{t}, done, nil
+	}
+	refs, err := repo.References()
+	if err != nil {
+		return nil, nil, err
+	}
+	var tips []plumbing.Hash
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		h := ref.Hash()
+		// annotated tags point at a tag object
+		if t, err := repo.TagObject(h); err == nil {
+			c, err := t.Commit()
+			if err != nil {
+				return nil
+			}
+			h = c.Hash
+		}
+		tips = append(tips, h)
+		return nil
+	})
+	if err != nil && !errors.Is(err, storer.ErrStop) {
+		return nil, nil, err
+	}
+	if head, err := repo.Head(); err == nil {
+		tips = append(tips, head.Hash())
+	}
+	return tips, done, nil
+}
+
+// scanCommit matches what one commit added. Every blob of its tree that
+// its first parent lacks is recorded in blobs.
+func (fs *FileScanner) scanCommit(
+	ctx context.Context,
+	repo *git.Repository,
+	c gitCommit,
+	path string,
+	rules *RuleSet,
+	opts ScanOptions,
+	onMatch func(MatchResult),
+	blobs map[plumbing.Hash]bool,
+	matchCnt, errCnt *atomic.Int64,
+) error {
+	tree, err := c.Tree()
+	if err != nil {
+		return err
+	}
+	// the first parent's tree; none for a root commit or a shallow boundary
+	var parent *object.Tree
+	var others []*object.Tree
+	for i, h := range c.ParentHashes {
+		p, err := repo.CommitObject(h)
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		t, err := p.Tree()
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			parent = t
+		} else {
+			others = append(others, t)
+		}
+	}
+	changes, err := object.DiffTreeWithOptions(ctx, parent, tree, object.DefaultDiffTreeOptions)
+	if err != nil {
+		return err
+	}
+	tag := c.tag(onMatch)
+	for _, ch := range changes {
+		if ch.To.Name == "" {
+			continue // deleted
+		}
+		blobs[ch.To.TreeEntry.Hash] = true
+		if fromParent(others, ch.To) {
+			continue // merged in unchanged, scanned in its own commit
+		}
+		if ch.From.Name == "" {
+			matchName(rules, path, ch.To.Name, "", tag, matchCnt)
+		}
+		if opts.NamesOnly {
+			continue
+		}
+		patch, err := ch.PatchContext(ctx)
+		if err != nil {
+			return err
+		}
+		for _, fp := range patch.FilePatches() {
+			if fp.IsBinary() {
+				blob, err := repo.BlobObject(ch.To.TreeEntry.Hash)
+				if err != nil {
+					return err
+				}
+				r, err := blob.Reader()
+				if err != nil {
+					return err
+				}
+				fs.scanContent(ctx, r, path, ch.To.Name, rules, opts, tag, matchCnt, errCnt)
+				r.Close()
+				continue
+			}
+			added, lines := addedLines(fp.Chunks())
+			if len(lines) == 0 {
+				continue
+			}
+			matchReader(bytes.NewReader(added), rules, opts, func(r MatchResult) {
+				if r.LineNumber < len(lines) {
+					r.LineNumber = lines[r.LineNumber]
+				}
+				tag(r)
+			}, path, ch.To.Name, matchCnt, errCnt)
+		}
+	}
+	return nil
+}
+
+// fromParent reports whether a merge took the entry unchanged from one of
+// its other parents.
+func fromParent(trees []*object.Tree, e object.ChangeEntry) bool {
+	for _, t := range trees {
+		if f, err := t.FindEntry(e.Name); err == nil && f.Hash == e.TreeEntry.Hash {
+			return true
+		}
+	}
+	return false
+}
+
+// addedLines joins the added lines of a file diff and returns the line
+// number of each in the new file.
+func addedLines(chunks []fdiff.Chunk) ([]byte, []int) {
+	var buf bytes.Buffer
+	var lines []int
+	n := 0
+	for _, ch := range chunks {
+		if ch.Type() == fdiff.Delete {
+			continue
+		}
+		content := ch.Content()
+		for len(content) > 0 {
+			line, rest, _ := strings.Cut(content, "\n")
+			content = rest
+			if ch.Type() == fdiff.Add {
+				buf.WriteString(line)
+				buf.WriteByte('\n')
+				lines = append(lines, n)
+			}
+			n++
+		}
+	}
+	return buf.Bytes(), lines
+}
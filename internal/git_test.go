@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// gitFixture builds a repository: two commits on master, a side branch
+// merged back with an extra change, then a commit dropped by a hard reset
+// and a blob that no tree holds.
+func gitFixture(t *testing.T, dir string) (hashes []plumbing.Hash, blob plumbing.Hash) {
+	t.Helper()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, _ := repo.Worktree()
+	when := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	commit := func(files map[string]string, parents ...plumbing.Hash) plumbing.Hash {
+		t.Helper()
+		for name, data := range files {
+			p := filepath.Join(dir, name)
+			if data == "" {
+				_, _ = wt.Remove(name)
+				continue
+			}
+			if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := wt.Add(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		when = when.Add(time.Hour)
+		h, err := wt.Commit(fmt.Sprintf("commit %d", len(hashes)), &git.CommitOptions{
+			Author:  &object.Signature{Name: "Dev", Email: "dev@example.com", When: when},
+			Parents: parents,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, h)
+		return h
+	}
+	c1 := commit(map[string]string{"config.txt": "user=a\npassword=one\n", "key.bin": "\x00\x01password=bin\x00"})
+	c2 := commit(map[string]string{"config.txt": "user=a\npassword=two\nother\n", "key.bin": ""})
+	c3 := commit(map[string]string{"side.txt": "x\npassword=side\n"})
+	if err := wt.Reset(&git.ResetOptions{Commit: c2, Mode: git.HardReset}); err != nil {
+		t.Fatal(err)
+	}
+	c4 := commit(map[string]string{"side.txt": "x\npassword=side\n", "config.txt": "user=a\npassword=two\nother\npassword=merge\n"}, c2, c3)
+	commit(map[string]string{"lost.txt": "password=dangling\n"})
+	if err := wt.Reset(&git.ResetOptions{Commit: c4, Mode: git.HardReset}); err != nil {
+		t.Fatal(err)
+	}
+	obj := repo.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	w, _ := obj.Writer()
+	_, _ = w.Write([]byte("stash password=blob\n"))
+	_ = w.Close()
+	if blob, err = repo.Storer.SetEncodedObject(obj); err != nil {
+		t.Fatal(err)
+	}
+	_ = c1
+	return hashes, blob
+}
+
+func TestScan_GitHistory(t *testing.T) {
+	dir := t.TempDir()
+	pf := filepath.Join(dir, "p.txt")
+	_ = os.WriteFile(pf, []byte("password=\n"), 0o644)
+	root := filepath.Join(dir, "root")
+	repoDir := filepath.Join(root, "proj")
+	hashes, blob := gitFixture(t, repoDir)
+	short := func(h string) string {
+		for i, c := range hashes {
+			if c.String() == h {
+				return fmt.Sprintf("c%d", i+1)
+			}
+		}
+		return h
+	}
+	scan := func(opts ScanOptions) []string {
+		opts.Roots, opts.PatternFile, opts.Threads = []string{root}, pf, 2
+		var got []string
+		for _, m := range collectScan(t, opts) {
+			if m.Commit == "" && m.Location == "" {
+				continue // the working tree
+			}
+			if m.FilePath != repoDir {
+				t.Errorf("file %q", m.FilePath)
+			}
+			when := ""
+			if m.Commit != "" {
+				when = m.Date.UTC().Format("15:04") + " " + m.Author
+			}
+			got = append(got, fmt.Sprintf("%s|%s|%s|%d|%s|%s", short(m.Commit), m.InnerPath, m.Location, m.LineNumber, strings.TrimSpace(m.Line), when))
+		}
+		slices.Sort(got)
+		return got
+	}
+
+	want := []string{
+		"c1|config.txt||1|password=one|13:00 Dev <dev@example.com>",
+		"c1|key.bin||0|\x00\x01password=bin\x00|13:00 Dev <dev@example.com>",
+		"c2|config.txt||1|password=two|14:00 Dev <dev@example.com>",
+		"c3|side.txt||1|password=side|15:00 Dev <dev@example.com>",
+		"c4|config.txt||3|password=merge|16:00 Dev <dev@example.com>",
+	}
+	if got := scan(ScanOptions{GitHistory: true}); !slices.Equal(got, want) {
+		t.Fatalf("history: got %q", got)
+	}
+
+	unreachable := append(slices.Clone(want),
+		"c5|lost.txt|unreachable|0|password=dangling|17:00 Dev <dev@example.com>",
+		"||unreachable blob "+blob.String()+"|0|stash password=blob|")
+	slices.Sort(unreachable)
+	if got := scan(ScanOptions{GitHistory: true, GitUnreachable: true}); !slices.Equal(got, unreachable) {
+		t.Fatalf("unreachable: got %q", got)
+	}
+
+	got := scan(ScanOptions{GitHistory: true, GitRange: hashes[0].String() + "..master"})
+	if !slices.Equal(got, want[2:]) {
+		t.Fatalf("range: got %q", got)
+	}
+
+	// without --git-history the repository is only a directory
+	if got := scan(ScanOptions{}); len(got) != 0 {
+		t.Fatalf("no history: got %q", got)
+	}
+}
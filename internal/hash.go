@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// Hash algorithms by hex digest length.
+const (
+	hashMD5    = "md5"
+	hashSHA1   = "sha1"
+	hashSHA256 = "sha256"
+)
+
+// HashPattern is a known-bad content hash (IOC).
+// Match compares against a lowercase hex digest of the same algorithm.
+type HashPattern struct {
+	algo  string
+	sum   string
+	label string
+}
+
+func (p *HashPattern) Match(sum string) bool { return sum == p.sum }
+
+func (p *HashPattern) Desc() string {
+	if p.label != "" {
+		return "hash:" + p.algo + ":" + p.sum + " " + p.label
+	}
+	return "hash:" + p.algo + ":" + p.sum
+}
+
+// parseHashLine parses "<hex> [label]". sha256sum/md5sum output works too:
+// the file name column becomes the label.
+func parseHashLine(line string) (*HashPattern, error) {
+	sum, label, _ := strings.Cut(strings.TrimSpace(line), " ")
+	sum = strings.ToLower(sum)
+	label = strings.TrimPrefix(strings.TrimSpace(label), "*") // sha256sum binary mode marker
+	if _, err := hex.DecodeString(sum); err != nil {
+		return nil, fmt.Errorf("invalid hash %q: %w", line, err)
+	}
+	var algo string
+	switch len(sum) {
+	case 32:
+		algo = hashMD5
+	case 40:
+		algo = hashSHA1
+	case 64:
+		algo = hashSHA256
+	default:
+		return nil, fmt.Errorf("invalid hash %q: want md5, sha1 or sha256 hex", line)
+	}
+	return &HashPattern{algo: algo, sum: sum, label: label}, nil
+}
+
+// loadHashFile reads one hash per line, '#' comments allowed.
+func loadHashFile(path string) ([]Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ps []Rule
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hp, err := parseHashLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		ps = append(ps, hp)
+	}
+	return ps, sc.Err()
+}
+
+// fileHasher computes sha256, reported with every finding, and the digests
+// the hash rules need while matchReader streams a file.
+type fileHasher struct {
+	hs map[string]hash.Hash
+	w  io.Writer
+}
+
+func newFileHasher(rules *RuleSet) *fileHasher {
+	algos := append([]string{hashSHA256}, rules.hashAlgos...)
+	fh := &fileHasher{hs: make(map[string]hash.Hash, 3)}
+	var ws []io.Writer
+	for _, a := range algos {
+		if _, ok := fh.hs[a]; ok {
+			continue
+		}
+		var h hash.Hash
+		switch a {
+		case hashMD5:
+			h = md5.New()
+		case hashSHA1:
+			h = sha1.New()
+		default:
+			h = sha256.New()
+		}
+		fh.hs[a] = h
+		ws = append(ws, h)
+	}
+	fh.w = io.MultiWriter(ws...)
+	return fh
+}
+
+func (fh *fileHasher) Write(p []byte) (int, error) { return fh.w.Write(p) }
+
+// sums returns the hex digest for each computed algorithm.
+func (fh *fileHasher) sums() map[string]string {
+	out := make(map[string]string, len(fh.hs))
+	for a, h := range fh.hs {
+		out[a] = hex.EncodeToString(h.Sum(nil))
+	}
+	return out
+}
+
+// primaryHash returns the digest reported with every finding as "sha256:hex".
+func primaryHash(sums map[string]string) string {
+	return hashSHA256 + ":" + sums[hashSHA256]
+}
@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseHashLine(t *testing.T) {
+	sha := strings.Repeat("ab", 32)
+	hp, err := parseHashLine(strings.ToUpper(sha) + "  *leaked.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hp.algo != hashSHA256 || hp.sum != sha || hp.label != "leaked.bin" {
+		t.Fatalf("unexpected: %+v", hp)
+	}
+	if hp, _ := parseHashLine(strings.Repeat("0", 40)); hp.algo != hashSHA1 {
+		t.Fatalf("want sha1, got %s", hp.algo)
+	}
+	for _, bad := range []string{"xyz", strings.Repeat("a", 33), strings.Repeat("g", 32)} {
+		if _, err := parseHashLine(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestLoadRules_HashesAndHashFile(t *testing.T) {
+	dir := t.TempDir()
+	md := md5.Sum([]byte("x"))
+	_ = os.WriteFile(filepath.Join(dir, "iocs.txt"), []byte("# iocs\n"+hex.EncodeToString(md[:])+" evil\n"), 0644)
+	fp := filepath.Join(dir, "p.txt")
+	_ = os.WriteFile(fp, []byte("hash:"+strings.Repeat("cd", 32)+" known bad\nhashes:iocs.txt\nsecret\n"), 0644)
+
+	rs, err := LoadRules(fp)
+	if err != nil {
+		t.Fatalf("LoadRules: %v", err)
+	}
+	if len(rs.Hashes) != 2 || len(rs.Patterns) != 1 || len(rs.hashAlgos) != 2 {
+		t.Fatalf("unexpected rule set: %d hashes, %d patterns, algos %v", len(rs.Hashes), len(rs.Patterns), rs.hashAlgos)
+	}
+	if rs.Hashes[hex.EncodeToString(md[:])].Desc() != "hash:md5:"+hex.EncodeToString(md[:])+" evil" {
+		t.Fatalf("bad desc")
+	}
+}
+
+func TestMatchReader_HashHitAndHashOnFindings(t *testing.T) {
+	data := "line one\nsecret line\n"
+	sum := sha256.Sum256([]byte(data))
+	hexSum := hex.EncodeToString(sum[:])
+	rules := &RuleSet{
+		Patterns:  []Pattern{stubPattern{sub: "secret"}},
+		Hashes:    map[string]*HashPattern{hexSum: {algo: hashSHA256, sum: hexSum, label: "ioc"}},
+		hashAlgos: []string{hashSHA256},
+	}
+
+	var got []MatchResult
+	var matchCnt, errCnt atomic.Int64
+	matchReader(bytes.NewBufferString(data), rules, ScanOptions{}, func(m MatchResult) { got = append(got, m) }, "/f.txt", "", &matchCnt, &errCnt)
+
+	if len(got) != 2 || matchCnt.Load() != 2 {
+		t.Fatalf("want line + hash match, got %+v", got)
+	}
+	if got[0].Line == "" || !got[1].HashMatch {
+		t.Fatalf("unexpected order/kinds: %+v", got)
+	}
+	for _, m := range got {
+		if m.Hash != "sha256:"+hexSum {
+			t.Fatalf("finding without file hash: %+v", m)
+		}
+	}
+}
+
+func TestMatchReader_HashWithoutRules(t *testing.T) {
+	var got []MatchResult
+	var matchCnt, errCnt atomic.Int64
+	rules := &RuleSet{Patterns: []Pattern{stubPattern{sub: "b"}}}
+	digest := matchReader(bytes.NewBufferString("a\nb\n"), rules, ScanOptions{}, func(m MatchResult) { got = append(got, m) }, "/f.txt", "", &matchCnt, &errCnt)
+	sum := sha256.Sum256([]byte("a\nb\n"))
+	if want := "sha256:" + hex.EncodeToString(sum[:]); digest != want || len(got) != 1 || got[0].Hash != want {
+		t.Fatalf("digest %q, results %+v", digest, got)
+	}
+}
+
+func TestMatchReader_NoHash(t *testing.T) {
+	var got []MatchResult
+	var matchCnt, errCnt atomic.Int64
+	rules := &RuleSet{Patterns: []Pattern{stubPattern{sub: "b"}}}
+	opts := ScanOptions{NoHash: true}
+	digest := matchReader(bytes.NewBufferString("a\nb\n"), rules, opts, func(m MatchResult) { got = append(got, m) }, "/f.txt", "", &matchCnt, &errCnt)
+	if digest != "" || len(got) != 1 || got[0].Hash != "" {
+		t.Fatalf("digest %q, results %+v", digest, got)
+	}
+
+	// hash rules are still checked
+	sum := sha256.Sum256([]byte("a\nb\n"))
+	hexSum := hex.EncodeToString(sum[:])
+	rules.Hashes = map[string]*HashPattern{hexSum: {algo: hashSHA256, sum: hexSum}}
+	rules.hashAlgos = []string{hashSHA256}
+	got = nil
+	digest = matchReader(bytes.NewBufferString("a\nb\n"), rules, opts, func(m MatchResult) { got = append(got, m) }, "/f.txt", "", &matchCnt, &errCnt)
+	if digest != "sha256:"+hexSum || len(got) != 2 || !got[1].HashMatch {
+		t.Fatalf("digest %q, results %+v", digest, got)
+	}
+}
+
+func TestMatchReader_HashAfterManyResults(t *testing.T) {
+	var got []MatchResult
+	var matchCnt, errCnt atomic.Int64
+	rules := &RuleSet{Patterns: []Pattern{stubPattern{sub: "b"}}}
+	data := strings.Repeat("b\n", maxHeldResults+5)
+	digest := matchReader(bytes.NewBufferString(data), rules, ScanOptions{}, func(m MatchResult) { got = append(got, m) }, "/f.txt", "", &matchCnt, &errCnt)
+	// the findings are not held back; the hash follows them in a record of its own
+	if len(got) != maxHeldResults+6 || matchCnt.Load() != maxHeldResults+5 {
+		t.Fatalf("got %d results, %d matches", len(got), matchCnt.Load())
+	}
+	last := got[len(got)-1]
+	if last.Matched || last.Hash != digest || last.FilePath != "/f.txt" || got[0].Hash != "" {
+		t.Fatalf("unexpected hash record %+v (first %+v)", last, got[0])
+	}
+}
+
+func TestScan_NameMatchCarriesHash(t *testing.T) {
+	dir := t.TempDir()
+	pf := filepath.Join(dir, "p.txt")
+	_ = os.WriteFile(pf, []byte("name:id_rsa\n"), 0644)
+	root := filepath.Join(dir, "root")
+	_ = os.MkdirAll(root, 0755)
+	_ = os.WriteFile(filepath.Join(root, "id_rsa"), []byte("key\n"), 0644)
+	sum := sha256.Sum256([]byte("key\n"))
+
+	got := collectScan(t, ScanOptions{Roots: []string{root}, PatternFile: pf, Threads: 1})
+	if len(got) != 1 || !got[0].NameMatch || got[0].Hash != "sha256:"+hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected: %+v", got)
+	}
+	// names only: the content is never read
+	got = collectScan(t, ScanOptions{Roots: []string{root}, PatternFile: pf, Threads: 1, NamesOnly: true})
+	if len(got) != 1 || got[0].Hash != "" {
+		t.Fatalf("unexpected: %+v", got)
+	}
+}
@@ -0,0 +1,556 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Image metadata: EXIF (JPEG APP1, PNG eXIf, WebP EXIF, TIFF itself), XMP
+// packets, PNG text chunks and GIF comments. Each text field is a unit located by its tag
+// ("EXIF ImageDescription", "XMP dc:description", "PNG tEXt Comment"); pixel
+// data is never read.
+
+const (
+	maxImageMeta = 16 << 20 // one metadata block
+	maxIFDs      = 64
+	maxIFDTags   = 4096
+)
+
+var errImage = errors.New("image: malformed metadata")
+
+func init() {
+	docExtractors[".jpg"] = extractJPEG
+	docExtractors[".jpeg"] = extractJPEG
+	docExtractors[".png"] = extractPNG
+	docExtractors[".tif"] = extractTIFF
+	docExtractors[".tiff"] = extractTIFF
+	docExtractors[".webp"] = extractWebP
+	docExtractors[".gif"] = extractGIF
+}
+
+// readBlock reads n bytes at off, refusing blocks over maxImageMeta.
+func readBlock(r io.ReaderAt, off, n int64) ([]byte, error) {
+	if n < 0 || n > maxImageMeta {
+		return nil, errImage
+	}
+	b := make([]byte, n)
+	if _, err := r.ReadAt(b, off); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+)
+
+// extractJPEG walks the marker segments up to the image data.
+func extractJPEG(r io.ReaderAt, size int64, emit func(loc string, text []byte)) error {
+	var hdr [4]byte
+	if _, err := r.ReadAt(hdr[:2], 0); err != nil || hdr[0] != 0xff || hdr[1] != 0xd8 {
+		return errors.New("jpeg: bad signature")
+	}
+	for off := int64(2); off+4 <= size; {
+		if _, err := r.ReadAt(hdr[:], off); err != nil {
+			return err
+		}
+		if hdr[0] != 0xff {
+			return errImage
+		}
+		marker := hdr[1]
+		switch {
+		case marker == 0xff: // fill byte
+			off++
+			continue
+		case marker == 0xd8 || marker >= 0xd0 && marker <= 0xd7 || marker == 0x01:
+			off += 2 // no length
+			continue
+		case marker == 0xda || marker == 0xd9: // image data or end
+			return nil
+		}
+		n := int64(binary.BigEndian.Uint16(hdr[2:])) - 2
+		if n < 0 {
+			return errImage
+		}
+		switch marker {
+		case 0xe1, 0xfe:
+			seg, err := readBlock(r, off+4, n)
+			if err != nil {
+				return err
+			}
+			switch {
+			case marker == 0xfe:
+				emitText(emit, "JPEG comment", seg)
+			case bytes.HasPrefix(seg, exifHeader):
+				tail := seg[len(exifHeader):]
+				if err := extractEXIF(bytes.NewReader(tail), int64(len(tail)), emit); err != nil {
+					return err
+				}
+			case bytes.HasPrefix(seg, xmpHeader):
+				extractXMP(seg[len(xmpHeader):], emit)
+			}
+		}
+		off += 4 + n
+	}
+	return nil
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// extractPNG reads the text, XMP and EXIF chunks and skips the rest.
+func extractPNG(r io.ReaderAt, size int64, emit func(loc string, text []byte)) error {
+	sig := make([]byte, len(pngSignature))
+	if _, err := r.ReadAt(sig, 0); err != nil || !bytes.Equal(sig, pngSignature) {
+		return errors.New("png: bad signature")
+	}
+	var hdr [8]byte
+	for off := int64(len(pngSignature)); off+12 <= size; {
+		if _, err := r.ReadAt(hdr[:], off); err != nil {
+			return err
+		}
+		n, typ := int64(binary.BigEndian.Uint32(hdr[:])), string(hdr[4:])
+		if typ == "IEND" {
+			return nil
+		}
+		if typ == "tEXt" || typ == "zTXt" || typ == "iTXt" || typ == "eXIf" {
+			data, err := readBlock(r, off+8, n)
+			if err != nil {
+				return err
+			}
+			if typ == "eXIf" {
+				if err := extractEXIF(bytes.NewReader(data), n, emit); err != nil {
+					return err
+				}
+			} else {
+				pngText(typ, data, emit)
+			}
+		}
+		off += 12 + n
+	}
+	return nil
+}
+
+// pngText decodes one text chunk; a damaged chunk is skipped. iTXt chunks
+// with the XMP keyword hold an XMP packet.
+func pngText(typ string, data []byte, emit func(loc string, text []byte)) {
+	key, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok {
+		return
+	}
+	loc := "PNG " + typ + " " + latin1(key)
+	switch typ {
+	case "tEXt":
+		emitText(emit, loc, []byte(latin1(rest)))
+	case "zTXt":
+		if len(rest) < 1 || rest[0] != 0 {
+			return
+		}
+		if text, err := inflate(rest[1:]); err == nil {
+			emitText(emit, loc, []byte(latin1(text)))
+		}
+	case "iTXt":
+		if len(rest) < 2 {
+			return
+		}
+		compressed := rest[0] == 1
+		parts := bytes.SplitN(rest[2:], []byte{0}, 3) // language, translated keyword, text
+		if len(parts) != 3 {
+			return
+		}
+		text := parts[2]
+		if compressed {
+			var err error
+			if text, err = inflate(text); err != nil {
+				return
+			}
+		}
+		if string(key) == "XML:com.adobe.xmp" {
+			extractXMP(text, emit)
+			return
+		}
+		emitText(emit, loc, text)
+	}
+}
+
+// inflate undoes zlib compression, up to maxImageMeta bytes.
+func inflate(b []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(io.LimitReader(zr, maxImageMeta))
+}
+
+// extractTIFF reads the IFDs of a TIFF file; the image itself is the EXIF structure.
+func extractTIFF(r io.ReaderAt, size int64, emit func(loc string, text []byte)) error {
+	return extractEXIF(r, size, emit)
+}
+
+// extractWebP reads the EXIF and XMP chunks of a RIFF WebP file.
+func extractWebP(r io.ReaderAt, size int64, emit func(loc string, text []byte)) error {
+	var hdr [12]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil || string(hdr[:4]) != "RIFF" || string(hdr[8:]) != "WEBP" {
+		return errors.New("webp: bad signature")
+	}
+	for off := int64(12); off+8 <= size; {
+		if _, err := r.ReadAt(hdr[:8], off); err != nil {
+			return err
+		}
+		n, typ := int64(binary.LittleEndian.Uint32(hdr[4:])), string(hdr[:4])
+		if typ == "EXIF" || typ == "XMP " {
+			data, err := readBlock(r, off+8, n)
+			if err != nil {
+				return err
+			}
+			if typ == "XMP " {
+				extractXMP(data, emit)
+			} else {
+				data = bytes.TrimPrefix(data, exifHeader)
+				if err := extractEXIF(bytes.NewReader(data), int64(len(data)), emit); err != nil {
+					return err
+				}
+			}
+		}
+		off += 8 + n + n&1 // chunks are padded to even length
+	}
+	return nil
+}
+
+var (
+	gifXMP        = []byte("XMP DataXMP")
+	gifXMPTrailer = []byte{0x01, 0xff, 0xfe, 0xfd} // start of the 258-byte "magic trailer"
+)
+
+// extractGIF reads the comment and XMP extensions of a GIF, skipping the
+// image data block by block.
+func extractGIF(r io.ReaderAt, size int64, emit func(loc string, text []byte)) error {
+	br := bufio.NewReader(io.NewSectionReader(r, 0, size))
+	var hdr [13]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil || string(hdr[:4]) != "GIF8" {
+		return errors.New("gif: bad signature")
+	}
+	if hdr[10]&0x80 != 0 { // global colour table
+		if _, err := br.Discard(3 << (hdr[10]&7 + 1)); err != nil {
+			return err
+		}
+	}
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch b {
+		case 0x3b: // trailer
+			return nil
+		case 0x2c: // image descriptor, colour table, LZW code size, data
+			var d [9]byte
+			if _, err := io.ReadFull(br, d[:]); err != nil {
+				return err
+			}
+			n := 1
+			if d[8]&0x80 != 0 {
+				n += 3 << (d[8]&7 + 1)
+			}
+			if _, err := br.Discard(n); err != nil {
+				return err
+			}
+			if _, err := gifBlocks(br, false); err != nil {
+				return err
+			}
+		case 0x21:
+			label, err := br.ReadByte()
+			if err != nil {
+				return err
+			}
+			if label == 0xff {
+				if err := gifApplication(br, emit); err != nil {
+					return err
+				}
+				continue
+			}
+			text, err := gifBlocks(br, label == 0xfe)
+			if err != nil {
+				return err
+			}
+			emitText(emit, "GIF comment", text)
+		default:
+			return errImage
+		}
+	}
+}
+
+// gifBlocks reads data sub-blocks up to the terminator, keeping their
+// content if asked.
+func gifBlocks(br *bufio.Reader, keep bool) ([]byte, error) {
+	var out []byte
+	for {
+		n, err := br.ReadByte()
+		if err != nil || n == 0 {
+			return out, err
+		}
+		if !keep || len(out)+int(n) > maxImageMeta {
+			if _, err := br.Discard(int(n)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+	}
+}
+
+// gifApplication reads an application extension. XMP is stored unblocked,
+// followed by a trailer that makes block readers skip it.
+func gifApplication(br *bufio.Reader, emit func(loc string, text []byte)) error {
+	n, err := br.ReadByte()
+	if err != nil {
+		return err
+	}
+	id := make([]byte, n)
+	if _, err := io.ReadFull(br, id); err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+	if !bytes.Equal(id, gifXMP) {
+		_, err := gifBlocks(br, false)
+		return err
+	}
+	var packet []byte
+	for !bytes.HasSuffix(packet, gifXMPTrailer) {
+		if len(packet) > maxImageMeta {
+			return errImage
+		}
+		b, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		packet = append(packet, b)
+	}
+	extractXMP(packet[:len(packet)-len(gifXMPTrailer)], emit)
+	_, err = br.Discard(258 - len(gifXMPTrailer))
+	return err
+}
+
+// exifTags names the text tags worth reporting; other ASCII tags are
+// reported by number.
+var exifTags = map[uint16]string{
+	0x010d: "DocumentName",
+	0x010e: "ImageDescription",
+	0x010f: "Make",
+	0x0110: "Model",
+	0x011d: "PageName",
+	0x0131: "Software",
+	0x0132: "DateTime",
+	0x013b: "Artist",
+	0x013c: "HostComputer",
+	0x8298: "Copyright",
+	0x9003: "DateTimeOriginal",
+	0x9004: "DateTimeDigitized",
+	0x9286: "UserComment",
+	0x9c9b: "XPTitle",
+	0x9c9c: "XPComment",
+	0x9c9d: "XPAuthor",
+	0x9c9e: "XPKeywords",
+	0x9c9f: "XPSubject",
+	0xa420: "ImageUniqueID",
+	0xa430: "CameraOwnerName",
+	0xa431: "BodySerialNumber",
+	0xa433: "LensMake",
+	0xa434: "LensModel",
+	0xa435: "LensSerialNumber",
+}
+
+const (
+	tiffASCII     = 2
+	tiffByte      = 1
+	tiffUndefined = 7
+	tagExifIFD    = 0x8769
+	tagXMP        = 0x02bc
+	tagUserComm   = 0x9286
+)
+
+// extractEXIF walks the IFD chain of a TIFF structure and its EXIF sub-IFD,
+// emitting ASCII tags, Windows XP* tags, UserComment and embedded XMP.
+func extractEXIF(r io.ReaderAt, size int64, emit func(loc string, text []byte)) error {
+	var hdr [8]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil {
+		return errImage
+	}
+	var order binary.ByteOrder
+	switch string(hdr[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return errors.New("exif: bad byte order")
+	}
+	if order.Uint16(hdr[2:]) != 42 {
+		return errors.New("exif: bad TIFF header")
+	}
+	queue := []int64{int64(order.Uint32(hdr[4:]))}
+	seen := map[int64]bool{}
+	for len(queue) > 0 && len(seen) < maxIFDs {
+		off := queue[0]
+		queue = queue[1:]
+		if off == 0 || seen[off] || off+2 > size {
+			continue
+		}
+		seen[off] = true
+		var cnt [2]byte
+		if _, err := r.ReadAt(cnt[:], off); err != nil {
+			return errImage
+		}
+		n := int64(order.Uint16(cnt[:]))
+		if n > maxIFDTags || off+2+12*n+4 > size {
+			return errImage
+		}
+		ifd, err := readBlock(r, off+2, 12*n+4)
+		if err != nil {
+			return err
+		}
+		for i := int64(0); i < n; i++ {
+			e := ifd[12*i:]
+			tag, typ, count := order.Uint16(e), order.Uint16(e[2:]), int64(order.Uint32(e[4:]))
+			if tag == tagExifIFD {
+				queue = append(queue, int64(order.Uint32(e[8:])))
+				continue
+			}
+			name, known := exifTags[tag]
+			text := typ == tiffASCII || known && (typ == tiffByte || typ == tiffUndefined) || tag == tagXMP
+			if !text || count == 0 || count > maxImageMeta {
+				continue
+			}
+			val := e[8:12]
+			if count > 4 {
+				if val, err = readBlock(r, int64(order.Uint32(e[8:])), count); err != nil {
+					continue // an out-of-range value is skipped, not fatal
+				}
+			} else {
+				val = val[:count]
+			}
+			switch {
+			case tag == tagXMP:
+				extractXMP(val, emit)
+			case tag == tagUserComm:
+				emitText(emit, "EXIF UserComment", userComment(val, order))
+			case tag >= 0x9c9b && tag <= 0x9c9f:
+				emitText(emit, "EXIF "+name, utf16Text(val, binary.LittleEndian))
+			default:
+				if !known {
+					name = fmt.Sprintf("tag 0x%04x", tag)
+				}
+				emitText(emit, "EXIF "+name, bytes.TrimRight(val, "\x00"))
+			}
+		}
+		queue = append(queue, int64(order.Uint32(ifd[12*n:])))
+	}
+	return nil
+}
+
+// userComment decodes an EXIF UserComment: an 8-byte charset id, then the text.
+func userComment(b []byte, order binary.ByteOrder) []byte {
+	if len(b) < 8 {
+		return nil
+	}
+	id, text := string(b[:8]), b[8:]
+	if strings.HasPrefix(id, "UNICODE") {
+		return utf16Text(text, order)
+	}
+	return bytes.TrimRight(text, "\x00 ")
+}
+
+func utf16Text(b []byte, order binary.ByteOrder) []byte {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = order.Uint16(b[2*i:])
+	}
+	return []byte(strings.TrimRight(string(utf16.Decode(u)), "\x00"))
+}
+
+// extractXMP emits the text of each XMP property, and of properties written
+// as attributes, under its prefixed name ("XMP dc:description"). Items of
+// rdf:Alt, rdf:Bag and rdf:Seq are reported under the property holding them.
+func extractXMP(packet []byte, emit func(loc string, text []byte)) {
+	const (
+		rdfNS = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+		xmlNS = "http://www.w3.org/XML/1998/namespace" // xml:lang
+	)
+	prefixes := map[string]string{}
+	name := func(n xml.Name) string {
+		if p := prefixes[n.Space]; p != "" {
+			return p + ":" + n.Local
+		}
+		return n.Local
+	}
+	d := xml.NewDecoder(bytes.NewReader(packet))
+	d.Strict = false
+	var props []string // innermost non-RDF element names
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			for _, a := range t.Attr {
+				if a.Name.Space == "xmlns" {
+					prefixes[a.Value] = a.Name.Local
+				}
+			}
+			for _, a := range t.Attr {
+				if a.Name.Space == "xmlns" || a.Name.Space == rdfNS || a.Name.Space == xmlNS || a.Name.Space == "" {
+					continue
+				}
+				emitText(emit, "XMP "+name(a.Name), []byte(a.Value))
+			}
+			if t.Name.Space != rdfNS {
+				props = append(props, name(t.Name))
+			}
+		case xml.EndElement:
+			if t.Name.Space != rdfNS && len(props) > 0 {
+				props = props[:len(props)-1]
+			}
+		case xml.CharData:
+			if len(props) > 0 {
+				emitText(emit, "XMP "+props[len(props)-1], t)
+			}
+		}
+	}
+}
+
+// emitText emits trimmed, non-empty text; invalid UTF-8 is read as Latin-1.
+func emitText(emit func(loc string, text []byte), loc string, text []byte) {
+	text = bytes.TrimSpace(text)
+	if len(text) == 0 {
+		return
+	}
+	if !utf8.Valid(text) {
+		text = []byte(latin1(text))
+	}
+	emit(loc, text)
+}
+
+func latin1(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		sb.WriteRune(rune(c))
+	}
+	return sb.String()
+}
@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"slices"
+	"testing"
+)
+
+// tiffIFD is one IFD entry: tag, type and raw value.
+type tiffIFD struct {
+	tag, typ uint16
+	val      []byte
+}
+
+// buildTIFF writes a little-endian TIFF structure with IFD0 and, if sub is
+// not empty, an EXIF sub-IFD.
+func buildTIFF(ifd0, sub []tiffIFD) []byte {
+	le := binary.LittleEndian
+	out := []byte("II*\x00\x08\x00\x00\x00")
+	var subFix int // where the sub-IFD offset goes
+	writeIFD := func(entries []tiffIFD) {
+		start := len(out)
+		out = le.AppendUint16(out, uint16(len(entries)))
+		out = append(out, make([]byte, 12*len(entries)+4)...)
+		for i, e := range entries {
+			p := start + 2 + 12*i
+			le.PutUint16(out[p:], e.tag)
+			le.PutUint16(out[p+2:], e.typ)
+			le.PutUint32(out[p+4:], uint32(len(e.val)))
+			if e.tag == tagExifIFD {
+				subFix = p + 8
+			} else if len(e.val) <= 4 {
+				copy(out[p+8:], e.val)
+			} else {
+				le.PutUint32(out[p+8:], uint32(len(out)))
+				out = append(out, e.val...)
+			}
+		}
+	}
+	if len(sub) > 0 {
+		ifd0 = append(ifd0, tiffIFD{tagExifIFD, 4, make([]byte, 4)})
+	}
+	writeIFD(ifd0)
+	if len(sub) > 0 {
+		le.PutUint32(out[subFix:], uint32(len(out)))
+		writeIFD(sub)
+	}
+	return out
+}
+
+const testXMP = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:CreatorTool="Screenshot Tool">
+<dc:description><rdf:Alt><rdf:li xml:lang="x-default">wallet bc1qexample</rdf:li></rdf:Alt></dc:description>
+</rdf:Description></rdf:RDF></x:xmpmeta>
+<?xpacket end="w"?>`
+
+var testEXIF = buildTIFF(
+	[]tiffIFD{
+		{0x010e, tiffASCII, []byte("seed words here\x00")},
+		{0x010f, tiffASCII, []byte("Cam\x00")},
+		{0x0100, 3, []byte{0x10, 0}}, // ImageWidth, not text
+		{0x9c9c, tiffByte, append(toUTF16LE("заметка"), 0, 0)},
+	},
+	[]tiffIFD{{tagUserComm, tiffUndefined, append([]byte("ASCII\x00\x00\x00"), "owner note"...)}},
+)
+
+var wantEXIF = []string{
+	"EXIF ImageDescription=seed words here",
+	"EXIF Make=Cam",
+	"EXIF XPComment=заметка",
+	"EXIF UserComment=owner note",
+}
+
+var wantXMP = []string{"XMP xmp:CreatorTool=Screenshot Tool", "XMP dc:description=wallet bc1qexample"}
+
+func jpegSeg(marker byte, data []byte) []byte {
+	return append([]byte{0xff, marker, byte((len(data) + 2) >> 8), byte(len(data) + 2)}, data...)
+}
+
+func TestExtractJPEG(t *testing.T) {
+	jpg := slices.Concat([]byte{0xff, 0xd8},
+		jpegSeg(0xe0, []byte("JFIF\x00\x01\x02")),
+		jpegSeg(0xe1, append(slices.Clone(exifHeader), testEXIF...)),
+		jpegSeg(0xe1, append(slices.Clone(xmpHeader), testXMP...)),
+		jpegSeg(0xfe, []byte("made with love")),
+		jpegSeg(0xda, []byte{1, 2, 3}), []byte("\xff\xd9"))
+	got := extractAll(t, extractJPEG, jpg)
+	want := slices.Concat(wantEXIF, wantXMP, []string{"JPEG comment=made with love"})
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func pngChunk(typ string, data []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	b = append(append(b, typ...), data...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b[4:]))
+}
+
+func TestExtractPNG(t *testing.T) {
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	_, _ = zw.Write([]byte("compressed caf\xe9"))
+	_ = zw.Close()
+	png := slices.Concat(pngSignature,
+		pngChunk("IHDR", make([]byte, 13)),
+		pngChunk("tEXt", []byte("Software\x00Greenshot")),
+		pngChunk("zTXt", append([]byte("Comment\x00\x00"), z.Bytes()...)),
+		pngChunk("iTXt", []byte("Title\x00\x00\x00ru\x00Заголовок\x00пароль 1234")),
+		pngChunk("iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), testXMP...)),
+		pngChunk("IDAT", []byte{1, 2, 3}),
+		pngChunk("eXIf", testEXIF),
+		pngChunk("IEND", nil))
+	got := extractAll(t, extractPNG, png)
+	want := slices.Concat([]string{
+		"PNG tEXt Software=Greenshot",
+		"PNG zTXt Comment=compressed café",
+		"PNG iTXt Title=пароль 1234",
+	}, wantXMP, wantEXIF)
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestExtractTIFFAndWebP(t *testing.T) {
+	if got := extractAll(t, extractTIFF, testEXIF); !slices.Equal(got, wantEXIF) {
+		t.Fatalf("tiff: got %q", got)
+	}
+	riffChunk := func(typ string, data []byte) []byte {
+		b := append(binary.LittleEndian.AppendUint32([]byte(typ), uint32(len(data))), data...)
+		if len(data)%2 == 1 {
+			b = append(b, 0)
+		}
+		return b
+	}
+	body := slices.Concat([]byte("WEBP"),
+		riffChunk("VP8X", make([]byte, 10)),
+		riffChunk("VP8 ", []byte{1, 2, 3}),
+		riffChunk("EXIF", append(slices.Clone(exifHeader), testEXIF...)),
+		riffChunk("XMP ", []byte(testXMP)))
+	webp := slices.Concat([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body))), body)
+	if got := extractAll(t, extractWebP, webp); !slices.Equal(got, slices.Concat(wantEXIF, wantXMP)) {
+		t.Fatalf("webp: got %q", got)
+	}
+}
@@ -0,0 +1,266 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// ISO 9660 with the Rock Ridge (POSIX names in the System Use area of the
+// primary volume) and Joliet (UCS-2 names in a supplementary volume)
+// extensions. Rock Ridge names win over Joliet, Joliet over the 8.3 names.
+
+const (
+	isoSector     = 2048
+	maxISODirSize = 16 << 20
+	maxISOSUSP    = 16 // continuation areas followed per record
+)
+
+type isoVolume struct {
+	r      io.ReaderAt
+	top    diskEntry
+	joliet bool
+	rr     bool
+	skip   int // bytes before the SUSP entries of each record (SP entry)
+}
+
+func openISO(r io.ReaderAt) (*isoVolume, error) {
+	var pvd, svd []byte
+	for i := int64(16); i < 16+64; i++ {
+		d := make([]byte, isoSector)
+		if _, err := r.ReadAt(d, i*isoSector); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(d[1:6], []byte("CD001")) {
+			return nil, errors.New("iso9660: no volume descriptor")
+		}
+		if d[0] == 255 {
+			break
+		}
+		switch esc := d[88:91]; {
+		case d[0] == 1 && pvd == nil:
+			pvd = d
+		case d[0] == 2 && svd == nil && esc[0] == '%' && esc[1] == '/' && bytes.IndexByte([]byte("@CE"), esc[2]) >= 0:
+			svd = d
+		}
+	}
+	if pvd == nil {
+		return nil, errors.New("iso9660: no primary volume descriptor")
+	}
+	v := &isoVolume{r: r}
+	root, ok := v.record(pvd[156:190])
+	if !ok {
+		return nil, errors.New("iso9660: bad root directory")
+	}
+	v.top = root
+	v.rr, v.skip = v.rockRidge(root)
+	if !v.rr && svd != nil {
+		if root, ok := v.record(svd[156:190]); ok {
+			v.top, v.joliet = root, true
+		}
+	}
+	v.top.name, v.top.dir = ".", true
+	return v, nil
+}
+
+// rockRidge looks for the SP entry in the "." record of the root.
+func (v *isoVolume) rockRidge(root diskEntry) (bool, int) {
+	rec := make([]byte, 255)
+	if _, err := v.r.ReadAt(rec, root.extents[0][0]); err != nil || rec[0] < 34 {
+		return false, 0
+	}
+	su := isoSystemUse(rec[:rec[0]])
+	if len(su) >= 7 && string(su[:2]) == "SP" && su[4] == 0xbe && su[5] == 0xef {
+		return true, int(su[6])
+	}
+	return false, 0
+}
+
+// isoSystemUse is the System Use area of a directory record, after the
+// name and its padding byte.
+func isoSystemUse(rec []byte) []byte {
+	n := int(rec[32])
+	at := 33 + n
+	if n%2 == 0 {
+		at++
+	}
+	if at > len(rec) {
+		return nil
+	}
+	return rec[at:]
+}
+
+// record decodes a directory record without its name.
+func (v *isoVolume) record(rec []byte) (diskEntry, bool) {
+	if len(rec) < 34 || int(rec[0]) > len(rec) || rec[0] < 34 {
+		return diskEntry{}, false
+	}
+	e := diskEntry{
+		dir:     rec[25]&2 != 0,
+		size:    int64(binary.LittleEndian.Uint32(rec[10:])),
+		extents: [][2]int64{{int64(binary.LittleEndian.Uint32(rec[2:])) * isoSector, int64(binary.LittleEndian.Uint32(rec[10:]))}},
+	}
+	if t := rec[18:25]; t[1] >= 1 && t[1] <= 12 {
+		zone := time.FixedZone("", int(int8(t[6]))*15*60)
+		e.mod = time.Date(1900+int(t[0]), time.Month(t[1]), int(t[2]), int(t[3]), int(t[4]), int(t[5]), 0, zone)
+	}
+	return e, true
+}
+
+func (v *isoVolume) root() diskEntry { return v.top }
+
+func (v *isoVolume) open(e diskEntry) io.Reader {
+	rs := make([]io.Reader, len(e.extents))
+	for i, x := range e.extents {
+		rs[i] = io.NewSectionReader(v.r, x[0], x[1])
+	}
+	return io.MultiReader(rs...)
+}
+
+func (v *isoVolume) readDir(dir diskEntry) ([]diskEntry, error) {
+	if dir.size > maxISODirSize {
+		return nil, fmt.Errorf("iso9660: directory of %d bytes", dir.size)
+	}
+	data, err := io.ReadAll(v.open(dir))
+	if err != nil {
+		return nil, err
+	}
+	var out []diskEntry
+	var pending [][2]int64 // extents of a multi-extent file so far
+	for pos := 0; pos < len(data); {
+		n := int(data[pos])
+		if n == 0 { // records do not cross sectors
+			pos = (pos/isoSector + 1) * isoSector
+			continue
+		}
+		if n < 34 || pos+n > len(data) {
+			break
+		}
+		rec := data[pos : pos+n]
+		pos += n
+		nameLen := int(rec[32])
+		if 33+nameLen > n {
+			continue
+		}
+		raw := rec[33 : 33+nameLen]
+		if nameLen == 1 && raw[0] <= 1 {
+			continue // "." and ".."
+		}
+		e, ok := v.record(rec)
+		if !ok {
+			continue
+		}
+		if rec[25]&0x80 != 0 { // more extents follow
+			pending = append(pending, e.extents...)
+			continue
+		}
+		if pending != nil {
+			e.extents = append(pending, e.extents...)
+			e.size = 0
+			for _, x := range e.extents {
+				e.size += x[1]
+			}
+			pending = nil
+		}
+		e.name = v.name(raw)
+		if v.rr {
+			name, keep := v.rockRidgeEntry(isoSystemUse(rec), &e)
+			if !keep {
+				continue
+			}
+			if name != "" {
+				e.name = name
+			}
+		}
+		if e.name == "" || e.name == "." || e.name == ".." || strings.ContainsRune(e.name, '/') {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// name decodes an identifier without its ";1" version and, for 8.3 names,
+// the trailing dot of an empty extension.
+func (v *isoVolume) name(raw []byte) string {
+	var s string
+	if v.joliet {
+		u := make([]uint16, len(raw)/2)
+		for i := range u {
+			u[i] = binary.BigEndian.Uint16(raw[2*i:])
+		}
+		s = string(utf16.Decode(u))
+	} else {
+		s = string(raw)
+	}
+	if i := strings.LastIndexByte(s, ';'); i >= 0 {
+		s = s[:i]
+	}
+	if !v.joliet {
+		s = strings.TrimSuffix(s, ".")
+	}
+	return s
+}
+
+// rockRidgeEntry reads the SUSP entries of a record: the NM name, a CL
+// link to a relocated directory, and RE, which marks the relocated copy
+// that is reached through its CL link instead.
+func (v *isoVolume) rockRidgeEntry(su []byte, e *diskEntry) (string, bool) {
+	if v.skip <= len(su) {
+		su = su[v.skip:]
+	}
+	var name strings.Builder
+	var cont []byte // continuation area, read once this one ends
+	for hops := 0; ; {
+		if len(su) < 4 {
+			if cont == nil || hops >= maxISOSUSP {
+				break
+			}
+			su, cont, hops = cont, nil, hops+1
+			continue
+		}
+		sig, n := string(su[:2]), int(su[2])
+		if n < 4 || n > len(su) {
+			su = nil
+			continue
+		}
+		body := su[4:n]
+		su = su[n:]
+		switch sig {
+		case "NM":
+			if len(body) >= 1 && body[0]&6 == 0 {
+				name.Write(body[1:])
+			}
+		case "RE":
+			return "", false
+		case "CL":
+			if len(body) >= 4 {
+				at := int64(binary.LittleEndian.Uint32(body)) * isoSector
+				rec := make([]byte, 255)
+				if _, err := v.r.ReadAt(rec, at); err == nil {
+					if d, ok := v.record(rec[:max(rec[0], 34)]); ok {
+						e.dir, e.size, e.extents = true, d.size, d.extents
+					}
+				}
+			}
+		case "CE":
+			if len(body) >= 24 {
+				block := int64(binary.LittleEndian.Uint32(body))
+				off := int64(binary.LittleEndian.Uint32(body[8:]))
+				size := int64(binary.LittleEndian.Uint32(body[16:]))
+				buf := make([]byte, min(size, isoSector))
+				if _, err := v.r.ReadAt(buf, block*isoSector+off); err == nil {
+					cont = buf
+				}
+			}
+		case "ST":
+			su = nil
+		}
+	}
+	return name.String(), true
+}
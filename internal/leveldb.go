@@ -0,0 +1,327 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+// LevelDB stores (Chromium extension storage, Local Storage, wallet vaults)
+// keep values in .ldb tables and numbered .log write-ahead logs, usually
+// Snappy-compressed. Every value found, live or stale, is matched on its own
+// with its key as the inner path.
+
+const (
+	maxLevelDBFile = 256 << 20
+	levelDBBlock   = 32 << 10 // log block size
+	levelDBMagic   = 0xdb4775248b80fb57
+	levelDBFooter  = 48
+	levelDBTypeVal = 1
+)
+
+var (
+	levelDBLogName = regexp.MustCompile(`^\d{6,}\.log$`)
+	errNotLevelDB  = errors.New("not a LevelDB log")
+	crc32c         = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// isLevelDB reports whether name is a LevelDB table or log. Logs are
+// recognised by LevelDB's numbered names only; their records are checked
+// before anything is treated as LevelDB.
+func isLevelDB(name string) bool {
+	base := strings.ToLower(path.Base(filepath.ToSlash(name)))
+	return strings.HasSuffix(base, ".ldb") || levelDBLogName.MatchString(base)
+}
+
+// scanLevelDB matches every value of a table or log with the line patterns;
+// the byte-level rules and the hash see the file itself. It returns the hash
+// of the file.
+func (fs *FileScanner) scanLevelDB(
+	r io.Reader,
+	filePath, innerPath string,
+	rules *RuleSet,
+	opts ScanOptions,
+	onMatch func(MatchResult),
+	matchCnt, errCnt *atomic.Int64,
+) string {
+	name := filePath
+	if innerPath != "" {
+		name = innerPath
+	}
+	table := strings.HasSuffix(strings.ToLower(name), ".ldb")
+	f, size, done, err := randomAccess(r)
+	if err != nil {
+		errCnt.Add(1)
+		onMatch(MatchResult{FilePath: filePath, InnerPath: innerPath, Error: err})
+		return ""
+	}
+	defer done()
+	valueRules, valueOpts := recordRules(rules, opts)
+	records := func(ra io.ReaderAt, size int64, emit func(MatchResult)) error {
+		whole := func() {
+			matchReader(io.NewSectionReader(ra, 0, size), valueRules, valueOpts, emit, filePath, innerPath, matchCnt, errCnt)
+		}
+		if size > maxLevelDBFile {
+			if !table {
+				// LevelDB rolls its logs over at a few MiB; this is some other log
+				whole()
+				return nil
+			}
+			return fmt.Errorf("leveldb: file larger than %d MiB, not parsed", maxLevelDBFile>>20)
+		}
+		data := make([]byte, size)
+		if _, err := ra.ReadAt(data, 0); err != nil {
+			return err
+		}
+		each := func(key, value []byte) {
+			matchReader(bytes.NewReader(levelDBValue(key, value)), valueRules, valueOpts, emit,
+				filePath, joinInner(innerPath, printableKey(key)), matchCnt, errCnt)
+		}
+		if table {
+			return readLevelDBTable(data, each)
+		}
+		err := readLevelDBLog(data, each)
+		if errors.Is(err, errNotLevelDB) {
+			// an ordinary log that happens to have a numbered name
+			whole()
+			return nil
+		}
+		return err
+	}
+	dr := &docReader{Reader: io.NewSectionReader(f, 0, size), ra: f, size: size, records: records}
+	return matchReader(dr, rules, opts, onMatch, filePath, innerPath, matchCnt, errCnt)
+}
+
+// readLevelDBLog replays the write batches of a log. A damaged record is
+// skipped; a torn tail, normal for a live log, ends the replay.
+func readLevelDBLog(data []byte, fn func(key, value []byte)) error {
+	le := binary.LittleEndian
+	var rec []byte
+	valid := false
+	for off := 0; off+7 <= len(data); {
+		left := levelDBBlock - off%levelDBBlock
+		if left < 7 {
+			off += left // block trailer
+			continue
+		}
+		crc, n, typ := le.Uint32(data[off:]), int(le.Uint16(data[off+4:])), data[off+6]
+		if typ == 0 && n == 0 {
+			off += left // preallocated zeros
+			continue
+		}
+		if 7+n > left || off+7+n > len(data) {
+			break
+		}
+		payload := data[off+7 : off+7+n]
+		off += 7 + n
+		sum := crc32.Update(crc32.Checksum([]byte{typ}, crc32c), crc32c, payload)
+		if unmaskCRC(crc) != sum {
+			if !valid {
+				return errNotLevelDB
+			}
+			rec = rec[:0]
+			continue
+		}
+		valid = true
+		switch typ {
+		case 1: // full
+			levelDBBatch(payload, fn)
+		case 2: // first
+			rec = append(rec[:0], payload...)
+		case 3: // middle
+			rec = append(rec, payload...)
+		case 4: // last
+			levelDBBatch(append(rec, payload...), fn)
+			rec = rec[:0]
+		}
+	}
+	if !valid && len(bytes.Trim(data, "\x00")) > 0 {
+		return errNotLevelDB
+	}
+	return nil
+}
+
+func unmaskCRC(c uint32) uint32 {
+	c -= 0xa282ead8
+	return c>>17 | c<<15
+}
+
+// levelDBBatch walks a write batch: sequence, count, then put/delete records.
+func levelDBBatch(b []byte, fn func(key, value []byte)) {
+	if len(b) < 12 {
+		return
+	}
+	b = b[12:]
+	for len(b) > 0 {
+		tag := b[0]
+		b = b[1:]
+		key, rest, ok := levelDBSlice(b)
+		if !ok {
+			return
+		}
+		b = rest
+		if tag != levelDBTypeVal {
+			continue // deletion: key only
+		}
+		value, rest, ok := levelDBSlice(b)
+		if !ok {
+			return
+		}
+		b = rest
+		fn(key, value)
+	}
+}
+
+// levelDBSlice reads a varint length-prefixed slice.
+func levelDBSlice(b []byte) (s, rest []byte, ok bool) {
+	n, k := binary.Uvarint(b)
+	if k <= 0 || n > uint64(len(b)-k) {
+		return nil, nil, false
+	}
+	return b[k : k+int(n)], b[k+int(n):], true
+}
+
+// readLevelDBTable walks every data block listed in a table's index.
+func readLevelDBTable(data []byte, fn func(key, value []byte)) error {
+	if len(data) < levelDBFooter || binary.LittleEndian.Uint64(data[len(data)-8:]) != levelDBMagic {
+		return errors.New("ldb: bad table magic")
+	}
+	footer := data[len(data)-levelDBFooter:]
+	_, footer, ok := levelDBHandle(footer) // metaindex, unused
+	if !ok {
+		return errors.New("ldb: bad footer")
+	}
+	index, err := levelDBTableBlock(data, footer)
+	if err != nil {
+		return err
+	}
+	return levelDBEntries(index, func(_, handle []byte) error {
+		block, err := levelDBTableBlock(data, handle)
+		if err != nil {
+			return err
+		}
+		return levelDBEntries(block, func(ikey, value []byte) error {
+			// internal key: user key, then 8 bytes of sequence<<8 | type
+			if len(ikey) >= 8 && ikey[len(ikey)-8] == levelDBTypeVal {
+				fn(ikey[:len(ikey)-8], value)
+			}
+			return nil
+		})
+	})
+}
+
+// levelDBHandle decodes a block handle (offset, size); it returns the
+// offset and size packed as [off, size] and the rest of b.
+func levelDBHandle(b []byte) ([2]uint64, []byte, bool) {
+	off, n := binary.Uvarint(b)
+	if n <= 0 {
+		return [2]uint64{}, nil, false
+	}
+	size, m := binary.Uvarint(b[n:])
+	if m <= 0 {
+		return [2]uint64{}, nil, false
+	}
+	return [2]uint64{off, size}, b[n+m:], true
+}
+
+// levelDBTableBlock reads the block a handle points to and undoes its compression.
+func levelDBTableBlock(data, handle []byte) ([]byte, error) {
+	h, _, ok := levelDBHandle(handle)
+	if !ok || h[0] > uint64(len(data)) || h[1]+5 > uint64(len(data))-h[0] {
+		return nil, errors.New("ldb: bad block handle")
+	}
+	raw := data[h[0] : h[0]+h[1]]
+	switch data[h[0]+h[1]] {
+	case 0:
+		return raw, nil
+	case 1:
+		return snappy.Decode(nil, raw)
+	default:
+		return nil, fmt.Errorf("ldb: unsupported block compression %d", data[h[0]+h[1]])
+	}
+}
+
+// levelDBEntries walks the prefix-compressed entries of a block, up to its
+// restart array. key is reused between calls.
+func levelDBEntries(b []byte, fn func(key, value []byte) error) error {
+	if len(b) < 4 {
+		return errors.New("ldb: short block")
+	}
+	restarts := uint64(binary.LittleEndian.Uint32(b[len(b)-4:]))
+	if restarts*4+4 > uint64(len(b)) {
+		return errors.New("ldb: bad restart array")
+	}
+	b = b[:len(b)-4-int(restarts)*4]
+	var key []byte
+	for len(b) > 0 {
+		var v [3]uint64
+		for i := range v {
+			n, k := binary.Uvarint(b)
+			if k <= 0 {
+				return errors.New("ldb: bad entry")
+			}
+			v[i], b = n, b[k:]
+		}
+		shared, unshared, vlen := v[0], v[1], v[2]
+		if shared > uint64(len(key)) || unshared+vlen > uint64(len(b)) {
+			return errors.New("ldb: bad entry")
+		}
+		key = append(key[:shared], b[:unshared]...)
+		if err := fn(key, b[unshared:unshared+vlen]); err != nil {
+			return err
+		}
+		b = b[unshared+vlen:]
+	}
+	return nil
+}
+
+// levelDBValue undoes Chromium Local Storage value encoding: keys look like
+// "_<origin>\x00\x01<key>" and values start with 0 for UTF-16LE, 1 for Latin-1.
+func levelDBValue(key, value []byte) []byte {
+	if len(key) == 0 || key[0] != '_' || bytes.IndexByte(key, 0) < 0 || len(value) == 0 {
+		return value
+	}
+	switch value[0] {
+	case 0:
+		u := make([]uint16, (len(value)-1)/2)
+		for i := range u {
+			u[i] = binary.LittleEndian.Uint16(value[1+2*i:])
+		}
+		return []byte(string(utf16.Decode(u)))
+	case 1:
+		out := make([]byte, 0, len(value)-1)
+		for _, c := range value[1:] {
+			out = utf8.AppendRune(out, rune(c))
+		}
+		return out
+	}
+	return value
+}
+
+// printableKey renders a binary key for an inner path: control bytes and
+// invalid UTF-8 are written as \xHH.
+func printableKey(k []byte) string {
+	var b strings.Builder
+	for i := 0; i < len(k); {
+		r, size := utf8.DecodeRune(k[i:])
+		if r == utf8.RuneError && size == 1 || r < 0x20 || r == 0x7f {
+			fmt.Fprintf(&b, `\x%02x`, k[i])
+		} else {
+			b.WriteRune(r)
+		}
+		i += size
+	}
+	return b.String()
+}
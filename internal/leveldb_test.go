@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+// ldbBatch encodes a write batch; a nil value is a deletion.
+func ldbBatch(kv ...[]byte) []byte {
+	b := make([]byte, 12)
+	binary.LittleEndian.PutUint32(b[8:], uint32(len(kv)/2))
+	for i := 0; i < len(kv); i += 2 {
+		tag := byte(levelDBTypeVal)
+		if kv[i+1] == nil {
+			tag = 0
+		}
+		b = append(b, tag)
+		b = binary.AppendUvarint(b, uint64(len(kv[i])))
+		b = append(b, kv[i]...)
+		if tag == levelDBTypeVal {
+			b = binary.AppendUvarint(b, uint64(len(kv[i+1])))
+			b = append(b, kv[i+1]...)
+		}
+	}
+	return b
+}
+
+// ldbLog frames batches into log records, fragmenting at block boundaries.
+func ldbLog(batches ...[]byte) []byte {
+	var out []byte
+	for _, b := range batches {
+		for first := true; ; first = false {
+			left := levelDBBlock - len(out)%levelDBBlock
+			if left < 7 {
+				out = append(out, make([]byte, left)...)
+				left = levelDBBlock
+			}
+			n := min(len(b), left-7)
+			last := n == len(b)
+			typ := map[[2]bool]byte{{true, true}: 1, {true, false}: 2, {false, false}: 3, {false, true}: 4}[[2]bool{first, last}]
+			c := crc32.Update(crc32.Checksum([]byte{typ}, crc32c), crc32c, b[:n])
+			out = binary.LittleEndian.AppendUint32(out, (c>>15|c<<17)+0xa282ead8)
+			out = binary.LittleEndian.AppendUint16(out, uint16(n))
+			out = append(append(out, typ), b[:n]...)
+			b = b[n:]
+			if last {
+				break
+			}
+		}
+	}
+	return out
+}
+
+// ldbTable writes one data block per group of key, value pairs; odd groups are
+// snappy-compressed. Deleted keys are written with a nil value.
+func ldbTable(groups ...[][]byte) []byte {
+	var out []byte
+	var index [][]byte
+	block := func(kv [][]byte, compress bool) []byte {
+		var b []byte
+		for i := 0; i < len(kv); i += 2 {
+			b = binary.AppendUvarint(b, 0)
+			b = binary.AppendUvarint(b, uint64(len(kv[i])))
+			b = binary.AppendUvarint(b, uint64(len(kv[i+1])))
+			b = append(append(b, kv[i]...), kv[i+1]...)
+		}
+		b = binary.LittleEndian.AppendUint32(b, 0)
+		b = binary.LittleEndian.AppendUint32(b, 1)
+		handle := binary.AppendUvarint(nil, uint64(len(out)))
+		ctype := byte(0)
+		if compress {
+			b, ctype = snappy.Encode(nil, b), 1
+		}
+		handle = binary.AppendUvarint(handle, uint64(len(b)))
+		out = append(append(out, b...), ctype, 0, 0, 0, 0)
+		return handle
+	}
+	ikey := func(k []byte, typ byte) []byte {
+		return append(append([]byte(nil), k...), typ, 0, 0, 0, 0, 0, 0, 0)
+	}
+	for g, kv := range groups {
+		var ik [][]byte
+		for i := 0; i < len(kv); i += 2 {
+			if kv[i+1] == nil {
+				ik = append(ik, ikey(kv[i], 0), []byte{})
+			} else {
+				ik = append(ik, ikey(kv[i], levelDBTypeVal), kv[i+1])
+			}
+		}
+		h := block(ik, g%2 == 1)
+		index = append(index, ik[len(ik)-2], h)
+	}
+	ih := block(index, false)
+	footer := append(binary.AppendUvarint(binary.AppendUvarint(nil, 0), 0), ih...)
+	footer = append(footer, make([]byte, 40-len(footer))...)
+	footer = binary.LittleEndian.AppendUint64(footer, levelDBMagic)
+	return append(out, footer...)
+}
+
+// utf16Value encodes a Local Storage value: a 0 prefix byte, then UTF-16LE.
+func utf16Value(s string) []byte {
+	return append([]byte{0}, toUTF16LE(s)...)
+}
+
+func TestScanLevelDB_Table(t *testing.T) {
+	vault := []byte(`{"KeyringController":{"vault":"{\"data\":\"abc\"}"}}`)
+	table := ldbTable(
+		[][]byte{[]byte("data"), vault, []byte("gone"), nil},
+		[][]byte{[]byte("_chrome-extension://id\x00\x01wallet"), utf16Value("vault=ПАРОЛЬ")},
+	)
+	// values carry the hash of the table file they were read from
+	sum := sha256.Sum256(table)
+	var got []string
+	for _, m := range scanFile(t, filepath.Join(t.TempDir(), "000005.ldb"), table, "vault") {
+		if m.Hash != "sha256:"+hex.EncodeToString(sum[:]) {
+			t.Errorf("value without the table hash: %+v", m)
+		}
+		got = append(got, m.InnerPath+"|"+strings.TrimSpace(m.Line))
+	}
+	want := []string{`data|` + string(vault), `_chrome-extension://id\x00\x01wallet|vault=ПАРОЛЬ`}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestScanLevelDB_Log(t *testing.T) {
+	big := bytes.Repeat([]byte("x"), 40<<10) // spans a block boundary
+	log := ldbLog(
+		ldbBatch([]byte("data"), []byte(`{"vault":"1"}`), []byte("old"), nil),
+		ldbBatch([]byte("blob"), append(big, []byte(" vault tail")...)),
+	)
+	hits := func(name string, data []byte) []string {
+		var out []string
+		for _, m := range scanFile(t, filepath.Join(t.TempDir(), name), data, "vault") {
+			out = append(out, m.InnerPath+"|"+strings.TrimSpace(m.Line))
+		}
+		return out
+	}
+	got := hits("000003.log", log)
+	if len(got) != 2 || got[0] != `data|{"vault":"1"}` || !strings.HasPrefix(got[1], "blob|") || !strings.HasSuffix(got[1], " vault tail") {
+		t.Fatalf("got %.80q", got)
+	}
+
+	// a text log with a LevelDB-like name is scanned as text
+	got = hits("000001.log", []byte("start\nvault opened\n"))
+	if !slices.Equal(got, []string{"|vault opened"}) {
+		t.Fatalf("plain log: got %q", got)
+	}
+}
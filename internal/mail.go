@@ -0,0 +1,436 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Mail stores: an .eml file is one RFC 5322 message, .mbox/.mbx files hold
+// many, each starting with a "From " line. Text parts are decoded (transfer
+// encoding, charset, HTML) and matched; attachments are scanned like
+// archive entries, named by their file name.
+
+const (
+	maxMailMessage = 256 << 20
+	maxMIMEDepth   = 16
+)
+
+var mailExts = map[string]bool{".eml": true, ".mbox": true, ".mbx": true}
+
+// isMail reports whether name is a mail store.
+func isMail(name string) bool {
+	return mailExts[strings.ToLower(filepath.Ext(name))]
+}
+
+var fromLine = []byte("From ")
+
+// scanMail matches the messages of a mail store with the line patterns and
+// scans their attachments; the byte-level rules and the hash see the store
+// file itself. It returns the hash of the file.
+func (fs *FileScanner) scanMail(
+	ctx context.Context,
+	r io.Reader,
+	filePath, innerPath string,
+	rules *RuleSet,
+	opts ScanOptions,
+	onMatch func(MatchResult),
+	matchCnt, errCnt *atomic.Int64,
+) string {
+	name := filePath
+	if innerPath != "" {
+		name = innerPath
+	}
+	fail := func(err error) {
+		errCnt.Add(1)
+		onMatch(MatchResult{FilePath: filePath, InnerPath: innerPath, Error: err})
+	}
+	f, size, done, err := randomAccess(r)
+	if err != nil {
+		fail(err)
+		return ""
+	}
+	defer done()
+	records := func(ra io.ReaderAt, size int64, emit func(MatchResult)) error {
+		r := io.NewSectionReader(ra, 0, size)
+		if strings.ToLower(filepath.Ext(name)) == ".eml" {
+			raw, err := io.ReadAll(io.LimitReader(r, maxMailMessage))
+			if err != nil {
+				return err
+			}
+			fs.scanMessage(ctx, raw, 0, filePath, innerPath, rules, opts, emit, onMatch, matchCnt, errCnt)
+			return nil
+		}
+		return splitMbox(r, func(n int, raw []byte, truncated bool) {
+			if truncated {
+				fail(fmt.Errorf("message %d: larger than %d MiB, truncated", n, maxMailMessage>>20))
+			}
+			fs.scanMessage(ctx, raw, n, filePath, innerPath, rules, opts, emit, onMatch, matchCnt, errCnt)
+		})
+	}
+	dr := &docReader{Reader: io.NewSectionReader(f, 0, size), ra: f, size: size, records: records}
+	return matchReader(dr, rules, opts, onMatch, filePath, innerPath, matchCnt, errCnt)
+}
+
+// splitMbox calls fn with each message of an mbox, numbered from 1, without
+// its "From " separator and with ">From " quoting undone. raw is reused
+// between calls.
+func splitMbox(r io.Reader, fn func(n int, raw []byte, truncated bool)) error {
+	br := bufio.NewReaderSize(r, 64<<10)
+	var (
+		msg       []byte
+		n         int
+		truncated bool
+		inSep     bool // inside a separator line longer than the buffer
+		atStart   = true
+		prevBlank = true
+	)
+	for {
+		chunk, err := br.ReadSlice('\n')
+		if len(chunk) > 0 {
+			nl := chunk[len(chunk)-1] == '\n'
+			switch {
+			case atStart && prevBlank && bytes.HasPrefix(chunk, fromLine):
+				if n > 0 {
+					fn(n, msg, truncated)
+				}
+				n++
+				msg, truncated = msg[:0], false
+				inSep = !nl
+			case inSep:
+				inSep = !nl
+			default:
+				if n == 0 {
+					n = 1 // no separator: a lone message
+				}
+				if atStart && chunk[0] == '>' && bytes.HasPrefix(bytes.TrimLeft(chunk, ">"), fromLine) {
+					chunk = chunk[1:]
+				}
+				if len(msg)+len(chunk) <= maxMailMessage {
+					msg = append(msg, chunk...)
+				} else {
+					truncated = true
+				}
+			}
+			if nl {
+				prevBlank = atStart && len(bytes.TrimRight(chunk, "\r\n")) == 0
+			}
+			atStart = nl
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return err
+		}
+	}
+	if n > 0 {
+		fn(n, msg, truncated)
+	}
+	return nil
+}
+
+// scanMessage matches the headers and text parts of one message with the
+// line patterns, reporting to emit, and scans its attachments like archive
+// entries, reporting to onMatch. Every result carries the message's
+// Message-ID and Subject; n > 0 numbers messages of an mbox in Location.
+func (fs *FileScanner) scanMessage(
+	ctx context.Context,
+	raw []byte,
+	n int,
+	filePath, innerPath string,
+	rules *RuleSet,
+	opts ScanOptions,
+	emit, onMatch func(MatchResult),
+	matchCnt, errCnt *atomic.Int64,
+) {
+	prefix := ""
+	if n > 0 {
+		prefix = fmt.Sprintf("message %d", n)
+	}
+	// an .eml saved from an mbox may keep its separator
+	if bytes.HasPrefix(raw, fromLine) {
+		if i := bytes.IndexByte(raw, '\n'); i >= 0 {
+			raw = raw[i+1:]
+		}
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		errCnt.Add(1)
+		onMatch(MatchResult{FilePath: filePath, InnerPath: innerPath, Location: prefix, Error: err})
+		return
+	}
+	id, subject := strings.TrimSpace(msg.Header.Get("Message-Id")), decodeMailHeader(msg.Header.Get("Subject"))
+	tagged := func(out func(MatchResult)) func(MatchResult) {
+		return func(r MatchResult) {
+			r.MessageID, r.Subject = id, subject
+			switch {
+			case prefix == "":
+			case r.Location == "":
+				r.Location = prefix
+			default:
+				r.Location = prefix + ", " + r.Location
+			}
+			out(r)
+		}
+	}
+	tag, held := tagged(onMatch), tagged(emit)
+	textRules, textOpts := recordRules(rules, opts)
+	text := func(data []byte, loc string) {
+		matchReader(bytes.NewReader(data), textRules, textOpts, func(r MatchResult) {
+			if r.Location == "" {
+				r.Location = loc
+			}
+			held(r)
+		}, filePath, innerPath, matchCnt, errCnt)
+	}
+
+	var hdr []byte
+	for _, k := range []string{"Subject", "From", "To", "Cc", "Reply-To"} {
+		if v := msg.Header.Get(k); v != "" {
+			hdr = append(hdr, k+": "+decodeMailHeader(v)+"\n"...)
+		}
+	}
+	text(hdr, "headers")
+
+	bodies, attachments := 0, 0
+	err = walkMIME(textproto.MIMEHeader(msg.Header), msg.Body, 0, func(p mailPart) error {
+		if strings.HasPrefix(p.ctype, "text/") && p.disp != "attachment" {
+			data, _ := io.ReadAll(io.LimitReader(charsetBody(p.params["charset"], p.body), maxMailMessage))
+			if p.ctype == "text/html" {
+				data = htmlText(data)
+			}
+			bodies++
+			loc := "body"
+			if bodies > 1 {
+				loc = fmt.Sprintf("body %d", bodies)
+			}
+			text(data, loc)
+			return nil
+		}
+		attachments++
+		name := p.name
+		if name == "" {
+			name = fmt.Sprintf("part%d", attachments)
+		}
+		entry := joinInner(innerPath, name)
+		matchName(rules, filePath, entry, "", tag, matchCnt)
+		fs.scanStream(ctx, p.body, filePath, entry, rules, opts, tag, matchCnt, errCnt)
+		return nil
+	})
+	if err != nil {
+		errCnt.Add(1)
+		tag(MatchResult{FilePath: filePath, InnerPath: innerPath, Error: err})
+	}
+}
+
+// mailPart is a leaf of the MIME tree with its transfer encoding removed.
+type mailPart struct {
+	ctype  string
+	params map[string]string
+	disp   string // "inline", "attachment" or ""
+	name   string // attachment file name, without directories
+	body   io.Reader
+}
+
+// walkMIME calls fn for every leaf part in order. Of the alternatives in a
+// multipart/alternative only text/plain is kept (else the last one), so the
+// same text is not reported twice. Attached messages are descended into.
+func walkMIME(h textproto.MIMEHeader, body io.Reader, depth int, fn func(mailPart) error) error {
+	ctype, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if ctype == "" || err != nil && !errors.Is(err, mime.ErrInvalidMediaParameter) {
+		ctype, params = "text/plain", map[string]string{} // RFC 2045 default
+	}
+	body = transferDecode(h.Get("Content-Transfer-Encoding"), body)
+
+	switch {
+	case strings.HasPrefix(ctype, "multipart/") && params["boundary"] != "" && depth < maxMIMEDepth:
+		type alt struct {
+			h    textproto.MIMEHeader
+			data []byte
+		}
+		var alts []alt
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if ctype == "multipart/alternative" {
+				data, err := io.ReadAll(io.LimitReader(p, maxMailMessage))
+				if err != nil {
+					return err
+				}
+				alts = append(alts, alt{p.Header, data})
+				continue
+			}
+			if err := walkMIME(p.Header, p, depth+1, fn); err != nil {
+				return err
+			}
+		}
+		if len(alts) == 0 {
+			return nil
+		}
+		pick := len(alts) - 1
+		for i, a := range alts {
+			if t, _, _ := mime.ParseMediaType(a.h.Get("Content-Type")); t == "text/plain" {
+				pick = i
+				break
+			}
+		}
+		return walkMIME(alts[pick].h, bytes.NewReader(alts[pick].data), depth+1, fn)
+	case ctype == "message/rfc822" && depth < maxMIMEDepth:
+		inner, err := mail.ReadMessage(body)
+		if err != nil {
+			return err
+		}
+		return walkMIME(textproto.MIMEHeader(inner.Header), inner.Body, depth+1, fn)
+	}
+
+	disp, dparams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+	name := dparams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+	name = path.Base(strings.ReplaceAll(decodeMailHeader(name), `\`, "/"))
+	if name == "." || name == "/" {
+		name = ""
+	}
+	return fn(mailPart{ctype: ctype, params: params, disp: disp, name: name, body: body})
+}
+
+func transferDecode(enc string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(enc)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r) // skips CR/LF
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+// charsetReader converts text in a named charset to UTF-8.
+func charsetReader(charset string, r io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return r, nil
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, err
+	}
+	return enc.NewDecoder().Reader(r), nil
+}
+
+// charsetBody is charsetReader that leaves text in unknown charsets as is.
+func charsetBody(charset string, r io.Reader) io.Reader {
+	if cr, err := charsetReader(charset, r); err == nil {
+		return cr
+	}
+	return r
+}
+
+// decodeMailHeader decodes RFC 2047 encoded words; undecodable input is kept.
+func decodeMailHeader(v string) string {
+	dec := mime.WordDecoder{CharsetReader: charsetReader}
+	if s, err := dec.DecodeHeader(v); err == nil {
+		return s
+	}
+	return v
+}
+
+var hrefRe = regexp.MustCompile(`(?i)\bhref\s*=\s*("[^"]*"|'[^']*'|[^\s>]+)`)
+
+// htmlText reduces HTML to its text: tags are dropped, block elements break
+// lines, whitespace collapses, link targets are kept in front of the link
+// text and script/style content is skipped.
+func htmlText(b []byte) []byte {
+	var out []byte
+	space := false
+	text := func(t []byte) {
+		for _, c := range t {
+			if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+				space = true
+				continue
+			}
+			if space && len(out) > 0 && out[len(out)-1] != '\n' {
+				out = append(out, ' ')
+			}
+			space = false
+			out = append(out, c)
+		}
+	}
+	for i := 0; i < len(b); {
+		lt := bytes.IndexByte(b[i:], '<')
+		if lt < 0 {
+			text(b[i:])
+			break
+		}
+		text(b[i : i+lt])
+		i += lt
+		if bytes.HasPrefix(b[i:], []byte("<!--")) {
+			end := bytes.Index(b[i:], []byte("-->"))
+			if end < 0 {
+				break
+			}
+			i += end + 3
+			continue
+		}
+		gt := bytes.IndexByte(b[i:], '>')
+		if gt < 0 {
+			break
+		}
+		tag := b[i+1 : i+gt]
+		i += gt + 1
+		closing := bytes.HasPrefix(tag, []byte("/"))
+		name := strings.ToLower(string(bytes.TrimLeft(tag, "/")))
+		if j := strings.IndexAny(name, " \t\r\n/"); j >= 0 {
+			name = name[:j]
+		}
+		switch name {
+		case "script", "style":
+			if !closing {
+				end := bytes.Index(bytes.ToLower(b[i:]), []byte("</"+name))
+				if end < 0 {
+					i = len(b)
+				} else {
+					i += end
+				}
+			}
+		case "br", "p", "div", "tr", "li", "table", "blockquote", "title", "h1", "h2", "h3", "h4", "h5", "h6":
+			if len(out) > 0 && out[len(out)-1] != '\n' {
+				out = append(out, '\n')
+			}
+			space = false
+		case "td", "th":
+			space = true
+		case "a":
+			if m := hrefRe.FindSubmatch(tag); m != nil && !closing {
+				space = true
+				text(bytes.Trim(m[1], `"'`))
+				space = true
+			}
+		}
+	}
+	return []byte(html.UnescapeString(string(out)))
+}
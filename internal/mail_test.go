@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestScanMail_EmlPartsAndAttachments(t *testing.T) {
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	w, _ := zw.Create("keys/secret.txt")
+	_, _ = w.Write([]byte("none\nseed: secret words\n"))
+	_ = zw.Close()
+	docx := zipDoc(t, "word/document.xml", `<w:document xmlns:w="w"><w:body><w:p><w:r><w:t>the secret plan</w:t></w:r></w:p></w:body></w:document>`)
+
+	eml := strings.ReplaceAll(`Message-ID: <abc@example.com>
+Subject: =?UTF-8?B?0J/QsNGA0L7Qu9C4?=
+From: Alice <alice@example.com>
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: multipart/alternative; boundary="alt"
+
+--alt
+Content-Type: text/plain; charset=koi8-r
+Content-Transfer-Encoding: quoted-printable
+
+=F0=C1=D2=CF=CC=D8: my secret=3D42
+--alt
+Content-Type: text/html; charset=utf-8
+
+<p>my secret=42</p>
+--alt--
+--outer
+Content-Type: application/zip
+Content-Disposition: attachment; filename="keys.zip"
+Content-Transfer-Encoding: base64
+
+`+base64.StdEncoding.EncodeToString(zbuf.Bytes())+`
+--outer
+Content-Type: application/vnd.openxmlformats-officedocument.wordprocessingml.document; name="=?UTF-8?Q?plan.docx?="
+Content-Transfer-Encoding: base64
+
+`+base64.StdEncoding.EncodeToString(docx)+`
+--outer--
+`, "\n", "\r\n")
+
+	got := scanFile(t, filepath.Join(t.TempDir(), "inbox.EML"), []byte(eml), "secret")
+	var seen []string
+	for _, m := range got {
+		if m.MessageID != "<abc@example.com>" || m.Subject != "Пароли" {
+			t.Errorf("missing message fields: %+v", m)
+		}
+		seen = append(seen, m.InnerPath+"|"+m.Location+"|"+strings.TrimSpace(m.Line))
+	}
+	// attachments report as they are scanned; findings in the text wait for the
+	// hash of the whole message
+	want := []string{
+		"keys.zip::keys/secret.txt||seed: secret words",
+		"plan.docx|paragraph 1|the secret plan",
+		"|body|Пароль: my secret=42",
+	}
+	if !slices.Equal(seen, want) {
+		t.Fatalf("got %q", seen)
+	}
+}
+
+func TestScanMail_RawFileRules(t *testing.T) {
+	eml := "Message-ID: <1@x>\r\nSubject: hi\r\n\r\nmy secret\r\n"
+	sum := sha256.Sum256([]byte(eml))
+	hexSum := hex.EncodeToString(sum[:])
+	bp, err := parseBytePattern("hex:73 65 63 72 65 74")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := &RuleSet{
+		Patterns:  []Pattern{&PlainPattern{s: []byte("secret")}},
+		Bytes:     []*BytePattern{bp},
+		Hashes:    map[string]*HashPattern{hexSum: {algo: hashSHA256, sum: hexSum, label: "ioc"}},
+		hashAlgos: []string{hashSHA256},
+	}
+	fp := filepath.Join(t.TempDir(), "m.eml")
+	if err := os.WriteFile(fp, []byte(eml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// byte rules and the hash see the file as it is on disk, once
+	var got []string
+	var matchCnt, errCnt atomic.Int64
+	digest := NewFileScanner().scanRegularFile(context.Background(), fp, rules, ScanOptions{}, func(m MatchResult) {
+		if m.Error != nil {
+			t.Errorf("unexpected error: %v", m.Error)
+		}
+		if m.Hash != "sha256:"+hexSum {
+			t.Errorf("finding without the file hash: %+v", m)
+		}
+		got = append(got, fmt.Sprintf("%s|%d|%t|%t", m.Location, m.Offset, m.ByteMatch, m.HashMatch))
+	}, &matchCnt, &errCnt)
+	off := strings.Index(eml, "secret")
+	want := []string{"body|0|false|false", fmt.Sprintf("|%d|true|false", off), "|0|false|true"}
+	if digest != "sha256:"+hexSum || !slices.Equal(got, want) {
+		t.Fatalf("digest %s, got %q", digest, got)
+	}
+}
+
+func TestScanMail_Mbox(t *testing.T) {
+	mbox := `From alice@example.com Mon Jan  1 00:00:00 2024
+Message-ID: <1@x>
+Subject: first
+
+nothing here
+>From the desk: no token
+From bob@example.com Mon Jan  1 00:00:01 2024
+Message-ID: <2@x>
+Subject: second token
+
+hello
+
+From carol@example.com Mon Jan  1 00:00:02 2024
+Message-ID: <3@x>
+Subject: third
+
+api token=1
+>From here
+`
+	got := scanFile(t, filepath.Join(t.TempDir(), "archive.mbox"), []byte(mbox), "token")
+	var seen []string
+	for _, m := range got {
+		seen = append(seen, m.MessageID+"|"+m.Location+"|"+strings.TrimSpace(m.Line))
+	}
+	// a "From " line not preceded by a blank line is body text
+	want := []string{
+		"<1@x>|message 1, body|From the desk: no token",
+		"<1@x>|message 1, body|Subject: second token",
+		"<3@x>|message 2, body|api token=1",
+	}
+	if !slices.Equal(seen, want) {
+		t.Fatalf("got %q", seen)
+	}
+}
+
+func TestHTMLText(t *testing.T) {
+	in := `<html><head><style>p{color:red}</style><title>T</title></head><body>
+<p>Hello,   <b>world</b>&amp;co</p><script>var k="x";</script><a href="https://h/?token=1">link</a><br>end</body></html>`
+	got := string(htmlText([]byte(in)))
+	want := "T\nHello, world&co\nhttps://h/?token=1 link\nend"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
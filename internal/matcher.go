@@ -2,42 +2,249 @@ package internal
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"os"
+	"path"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/sirupsen/logrus"
 )
 
-// Pattern - fast interface for line match.
-type Pattern interface {
-	Match(string) bool
+// Rule is anything a pattern file line can define.
+type Rule interface {
 	Desc() string // for logs/files
 }
 
+// Pattern - fast interface for line match. Implementations must not allocate
+// unless they find something: Match runs on every line.
+type Pattern interface {
+	Match(l *Line) bool
+	Find(l *Line) (start, end int) // byte span in l.Raw of the first match, or -1, -1
+	Desc() string
+}
+
+// Line is one line prepared for matching. Fold is Raw with letters lowercased,
+// computed once per line and only when some pattern is case-insensitive;
+// otherwise it aliases Raw. The fold never changes byte lengths, so spans found
+// in Fold are valid in Raw.
+type Line struct {
+	Raw  []byte
+	Fold []byte
+}
+
+// Set points l at b; the fold reuses l's buffer.
+func (l *Line) Set(b []byte, fold bool) {
+	l.Raw = b
+	if fold {
+		l.Fold = appendFold(l.Fold[:0], b)
+	} else {
+		l.Fold = b
+	}
+}
+
+// appendFold appends b lowercased to dst. Runes whose lowercase form has a
+// different UTF-8 length are kept as is.
+func appendFold(dst, b []byte) []byte {
+	for i := 0; i < len(b); {
+		c := b[i]
+		if c < utf8.RuneSelf {
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			dst = append(dst, c)
+			i++
+			continue
+		}
+		r, n := utf8.DecodeRune(b[i:])
+		if lr := unicode.ToLower(r); lr != r && utf8.RuneLen(lr) == n {
+			dst = utf8.AppendRune(dst, lr)
+		} else {
+			dst = append(dst, b[i:i+n]...)
+		}
+		i += n
+	}
+	return dst
+}
+
+// foldString is appendFold for pattern text.
+func foldString(s string) string { return string(appendFold(nil, []byte(s))) }
+
 type RegexPattern struct{ re *regexp.Regexp }
 
-func (p *RegexPattern) Match(s string) bool { return p.re.MatchString(s) }
-func (p *RegexPattern) Desc() string        { return p.re.String() }
+// Regexes see the raw line; use (?i) for case-insensitive ones.
+func (p *RegexPattern) Match(l *Line) bool { return p.re.Match(l.Raw) }
+func (p *RegexPattern) Desc() string       { return p.re.String() }
+
+func (p *RegexPattern) Find(l *Line) (int, int) {
+	loc := p.re.FindIndex(l.Raw)
+	if loc == nil {
+		return -1, -1
+	}
+	return loc[0], loc[1]
+}
 
 type PlainPattern struct {
-	s           string
+	s           []byte // folded for insensitive patterns
 	insensitive bool
 }
 
-func (p *PlainPattern) Match(s string) bool {
+func (p *PlainPattern) Match(l *Line) bool {
 	if p.insensitive {
-		return strings.Contains(strings.ToLower(s), p.s)
+		return bytes.Contains(l.Fold, p.s)
 	}
-	return strings.Contains(s, p.s)
+	return bytes.Contains(l.Raw, p.s)
+}
+
+func (p *PlainPattern) Find(l *Line) (int, int) {
+	hay := l.Raw
+	if p.insensitive {
+		hay = l.Fold
+	}
+	i := bytes.Index(hay, p.s)
+	if i < 0 {
+		return -1, -1
+	}
+	return i, i + len(p.s)
 }
 
 func (p *PlainPattern) Desc() string {
 	if p.insensitive {
-		return "plain:i:" + p.s
+		return "plain:i:" + string(p.s)
+	}
+	return string(p.s)
+}
+
+// NamePattern matches file names instead of content.
+// "name:" globs are checked against the base name, "path:" globs against
+// the trailing path segments (or the whole path when the glob starts with "/").
+// Paths are slash-separated; archive entries are "archive/inner/path".
+type NamePattern struct {
+	glob        string
+	full        bool
+	insensitive bool
+	desc        string
+}
+
+func (p *NamePattern) Match(s string) bool {
+	if p.insensitive {
+		s = strings.ToLower(s)
+	}
+	if !p.full {
+		ok, _ := path.Match(p.glob, path.Base(s))
+		return ok
 	}
-	return p.s
+	if strings.HasPrefix(p.glob, "/") {
+		ok, _ := path.Match(p.glob, s)
+		return ok
+	}
+	ok, _ := path.Match(p.glob, tailSegments(s, strings.Count(p.glob, "/")+1))
+	return ok
+}
+
+func (p *NamePattern) Desc() string { return p.desc }
+
+// tailSegments returns the last n slash-separated segments of s.
+func tailSegments(s string, n int) string {
+	i := len(s)
+	for ; n > 0; n-- {
+		j := strings.LastIndexByte(s[:i], '/')
+		if j < 0 {
+			return s
+		}
+		i = j
+	}
+	return s[i+1:]
+}
+
+func parseNamePattern(line string) (*NamePattern, error) {
+	p := &NamePattern{desc: line}
+	rest := line
+	switch {
+	case strings.HasPrefix(rest, "path:"):
+		p.full = true
+		rest = rest[5:]
+	default:
+		rest = rest[5:] // "name:"
+	}
+	if strings.HasPrefix(rest, "i:") {
+		p.insensitive = true
+		rest = strings.ToLower(rest[2:])
+	}
+	if _, err := path.Match(rest, ""); err != nil || rest == "" {
+		return nil, fmt.Errorf("invalid glob %q: %w", line, path.ErrBadPattern)
+	}
+	p.glob = rest
+	return p, nil
+}
+
+// RuleSet is a loaded pattern file split by rule kind.
+type RuleSet struct {
+	Patterns       []Pattern // content patterns, matched per line
+	HasInsensitive bool
+	Names          []*NamePattern
+	Bytes          []*BytePattern          // hex:/wide:, matched on raw chunks
+	Hashes         map[string]*HashPattern // by lowercase hex digest
+	Yara           []*YaraRule
+
+	hashAlgos []string // algorithms needed for Hashes
+}
+
+// LoadRules loads a pattern file and splits content and name rules.
+func LoadRules(path string) (*RuleSet, error) {
+	ps, hasInsensitive, err := LoadPatterns(path)
+	if err != nil {
+		return nil, err
+	}
+	rs := &RuleSet{HasInsensitive: hasInsensitive}
+	for _, p := range ps {
+		switch p := p.(type) {
+		case *NamePattern:
+			rs.Names = append(rs.Names, p)
+		case *BytePattern:
+			rs.Bytes = append(rs.Bytes, p)
+		case *HashPattern:
+			if rs.Hashes == nil {
+				rs.Hashes = make(map[string]*HashPattern)
+			}
+			if _, dup := rs.Hashes[p.sum]; dup {
+				continue
+			}
+			rs.Hashes[p.sum] = p
+			if !slices.Contains(rs.hashAlgos, p.algo) {
+				rs.hashAlgos = append(rs.hashAlgos, p.algo)
+			}
+		case Pattern:
+			rs.Patterns = append(rs.Patterns, p)
+		}
+	}
+	return rs, nil
+}
+
+// MatchHash returns hash rules hit by the computed digests.
+func (rs *RuleSet) MatchHash(sums map[string]string) []*HashPattern {
+	var out []*HashPattern
+	for _, s := range sums {
+		if p, ok := rs.Hashes[s]; ok && p.Match(s) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// MatchName returns the first name rule matching the slash path.
+func (rs *RuleSet) MatchName(slashPath string) (*NamePattern, bool) {
+	for _, p := range rs.Names {
+		if p.Match(slashPath) {
+			return p, true
+		}
+	}
+	return nil, false
 }
 
 // LoadPatterns reads patterns file.
@@ -46,14 +253,20 @@ func (p *PlainPattern) Desc() string {
 //	foo
 //	plain:i:bar
 //	re:^user=\\w+$
-func LoadPatterns(path string) ([]Pattern, bool, error) {
+//	name:*.kdbx
+//	path:i:keystore/UTC--*
+//	hex:DE AD ?? EF
+//	wide:i:password
+//	hash:<md5|sha1|sha256 hex> [label]
+//	hashes:iocs.txt (one "<hex> [label]" per line, relative to the pattern file)
+func LoadPatterns(path string) ([]Rule, bool, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, false, err
 	}
 	defer f.Close()
 
-	var ps []Pattern
+	var ps []Rule
 	hasInsensitive := false
 	sc := bufio.NewScanner(f)
 	for sc.Scan() {
@@ -68,11 +281,39 @@ func LoadPatterns(path string) ([]Pattern, bool, error) {
 				return nil, false, fmt.Errorf("invalid regex %q: %w", line, err)
 			}
 			ps = append(ps, &RegexPattern{re: re})
+		case strings.HasPrefix(line, "name:"), strings.HasPrefix(line, "path:"):
+			np, err := parseNamePattern(line)
+			if err != nil {
+				return nil, false, err
+			}
+			ps = append(ps, np)
+		case strings.HasPrefix(line, "hex:"), strings.HasPrefix(line, "wide:"):
+			bp, err := parseBytePattern(line)
+			if err != nil {
+				return nil, false, err
+			}
+			ps = append(ps, bp)
+		case strings.HasPrefix(line, "hash:"):
+			hp, err := parseHashLine(line[5:])
+			if err != nil {
+				return nil, false, err
+			}
+			ps = append(ps, hp)
+		case strings.HasPrefix(line, "hashes:"):
+			hf := strings.TrimSpace(line[7:])
+			if !filepath.IsAbs(hf) {
+				hf = filepath.Join(filepath.Dir(path), hf)
+			}
+			hps, err := loadHashFile(hf)
+			if err != nil {
+				return nil, false, err
+			}
+			ps = append(ps, hps...)
 		case strings.HasPrefix(line, "plain:i:"):
 			hasInsensitive = true
-			ps = append(ps, &PlainPattern{s: strings.ToLower(line[8:]), insensitive: true})
+			ps = append(ps, &PlainPattern{s: []byte(foldString(line[8:])), insensitive: true})
 		default:
-			ps = append(ps, &PlainPattern{s: line})
+			ps = append(ps, &PlainPattern{s: []byte(line)})
 		}
 	}
 	if err := sc.Err(); err != nil {
@@ -3,6 +3,7 @@ package internal
 import (
 	"os"
 	"path/filepath"
+	"regexp"
 	"testing"
 )
 
@@ -31,16 +32,21 @@ re:^id=\d{3}$
 	}
 
 	// check matching
-	if !ps[0].Match("hello there") {
+	match := func(r Rule, s string) bool {
+		var l Line
+		l.Set([]byte(s), hasInsensitive)
+		return r.(Pattern).Match(&l)
+	}
+	if !match(ps[0], "HELLO there") {
 		t.Errorf("plain:i should match lowercased")
 	}
-	if !ps[1].Match("say world!") {
+	if !match(ps[1], "say world!") {
 		t.Errorf("plain should match as substring")
 	}
-	if ps[1].Match("w0rld") {
+	if match(ps[1], "w0rld") {
 		t.Errorf("plain should not match wrong substring")
 	}
-	if !ps[2].Match("id=123") || ps[2].Match("id=12x") {
+	if !match(ps[2], "id=123") || match(ps[2], "id=12x") {
 		t.Errorf("regex match failed")
 	}
 
@@ -50,6 +56,41 @@ re:^id=\d{3}$
 	}
 }
 
+func TestLine_FoldKeepsSpans(t *testing.T) {
+	// Kelvin sign lowercases to a 1-byte 'k' and stays as is; Cyrillic folds in place
+	raw := []byte("ПАРОЛЬ \u212a KEY")
+	var l Line
+	l.Set(raw, true)
+	if len(l.Fold) != len(raw) || string(l.Fold) != "пароль \u212a key" {
+		t.Fatalf("fold: %q", l.Fold)
+	}
+	p := &PlainPattern{s: []byte(foldString("Key")), insensitive: true}
+	if s, e := p.Find(&l); string(raw[s:e]) != "KEY" {
+		t.Fatalf("span %d..%d", s, e)
+	}
+}
+
+func TestMatchLine_NoAllocs(t *testing.T) {
+	pats := []Pattern{
+		&PlainPattern{s: []byte("password"), insensitive: true},
+		&PlainPattern{s: []byte("token=")},
+		&RegexPattern{re: regexp.MustCompile(`id=\d{3}$`)},
+	}
+	line := []byte("Some ordinary LOG line without anything interesting in it\n")
+	var l Line
+	allocs := testing.AllocsPerRun(100, func() {
+		l.Set(line, true)
+		for _, p := range pats {
+			if p.Match(&l) {
+				t.Fatal("unexpected match")
+			}
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("got %v allocs per line", allocs)
+	}
+}
+
 func TestLoadPatterns_InvalidRegex(t *testing.T) {
 	dir := t.TempDir()
 	fp := filepath.Join(dir, "bad.txt")
@@ -59,3 +100,51 @@ func TestLoadPatterns_InvalidRegex(t *testing.T) {
 		t.Fatal("expected regex compile error")
 	}
 }
+
+func TestNamePattern_Match(t *testing.T) {
+	cases := []struct {
+		rule string
+		path string
+		want bool
+	}{
+		{"name:wallet.dat", "/home/u/.bitcoin/wallet.dat", true},
+		{"name:*.kdbx", "/home/u/db/Passwords.kdbx", true},
+		{"name:*.kdbx", "/home/u/db/kdbx.txt", false},
+		{"name:i:*.KDBX", "/home/u/db/Passwords.KdBx", true},
+		{"name:.env", "/srv/app/.env", true},
+		{"name:id_rsa", "/x/backup.zip/home/u/.ssh/id_rsa", true},
+		{"path:keystore/UTC--*", "/home/u/.ethereum/keystore/UTC--2020-01-01", true},
+		{"path:keystore/UTC--*", "/home/u/keystore/sub/UTC--2020", false},
+		{"path:/etc/shadow", "/etc/shadow", true},
+		{"path:/etc/shadow", "/backup/etc/shadow", false},
+	}
+	for _, c := range cases {
+		p, err := parseNamePattern(c.rule)
+		if err != nil {
+			t.Fatalf("%s: %v", c.rule, err)
+		}
+		if got := p.Match(c.path); got != c.want {
+			t.Errorf("%s on %s: got %v want %v", c.rule, c.path, got, c.want)
+		}
+	}
+	if _, err := parseNamePattern("name:[x"); err == nil {
+		t.Fatal("expected bad glob error")
+	}
+}
+
+func TestLoadRules_SplitsNameRules(t *testing.T) {
+	dir := t.TempDir()
+	fp := filepath.Join(dir, "patterns.txt")
+	_ = os.WriteFile(fp, []byte("secret\nname:*.kdbx\npath:i:keystore/*\n"), 0644)
+
+	rs, err := LoadRules(fp)
+	if err != nil {
+		t.Fatalf("LoadRules: %v", err)
+	}
+	if len(rs.Patterns) != 1 || len(rs.Names) != 2 {
+		t.Fatalf("want 1 content and 2 name rules, got %d/%d", len(rs.Patterns), len(rs.Names))
+	}
+	if p, ok := rs.MatchName("/a/KeyStore/x"); !ok || p.Desc() != "path:i:keystore/*" {
+		t.Fatalf("unexpected name match: %v %v", p, ok)
+	}
+}
@@ -0,0 +1,24 @@
+package internal
+
+import (
+	"errors"
+	"io"
+)
+
+var errNotMappable = errors.New("not mappable")
+
+// mappedFile is a read-only mapping of a whole regular file. matchReader
+// matches straight from data; Read serves code that only wants a stream.
+type mappedFile struct {
+	data []byte
+	off  int
+}
+
+func (m *mappedFile) Read(p []byte) (int, error) {
+	if m.off >= len(m.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[m.off:])
+	m.off += n
+	return n, nil
+}
@@ -0,0 +1,48 @@
+//go:build linux
+
+package internal
+
+import (
+	"os"
+	"syscall"
+)
+
+// networkFS lists statfs magics of network and FUSE filesystems: pages can
+// vanish under a mapping there, and mapping them is not faster anyway.
+var networkFS = map[uint32]bool{
+	0x6969:     true, // NFS
+	0x517b:     true, // SMB
+	0xff534d42: true, // CIFS
+	0xfe534d42: true, // SMB2
+	0x65735546: true, // FUSE (sshfs, rclone, ...)
+	0x01021997: true, // 9p
+	0x5346414f: true, // AFS
+	0x00c36400: true, // Ceph
+}
+
+// mapFile maps f read-only, or returns errNotMappable for files that must be streamed.
+func mapFile(f *os.File) (*mappedFile, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+	if !fi.Mode().IsRegular() || size == 0 || int64(int(size)) != size {
+		return nil, errNotMappable
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Fstatfs(int(f.Fd()), &st); err != nil {
+		return nil, err
+	}
+	if networkFS[uint32(st.Type)] {
+		return nil, errNotMappable
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	_ = syscall.Madvise(data, syscall.MADV_SEQUENTIAL)
+	return &mappedFile{data: data}, nil
+}
+
+func (m *mappedFile) Close() error { return syscall.Munmap(m.data) }
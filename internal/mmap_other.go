@@ -0,0 +1,10 @@
+//go:build !linux
+
+package internal
+
+import "os"
+
+// mapFile is only implemented on Linux; elsewhere files are streamed.
+func mapFile(*os.File) (*mappedFile, error) { return nil, errNotMappable }
+
+func (m *mappedFile) Close() error { return nil }
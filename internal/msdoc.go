@@ -0,0 +1,416 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Legacy Office files (Word 97-2003 .doc, Excel 97-2003 .xls) are CFB
+// containers. Word text is assembled from the piece table of the
+// WordDocument stream; Excel cells are read from the BIFF8 records of the
+// Workbook stream, with strings resolved through the SST.
+func init() {
+	docExtractors[".doc"] = extractDoc
+	docExtractors[".dot"] = extractDoc
+	docExtractors[".xls"] = extractXls
+	docExtractors[".xlt"] = extractXls
+}
+
+const maxDocChars = 64 << 20 // character positions assembled from a piece table
+
+// wordStories are the sub-documents of the text, in character position order.
+// The FibRgLw97 offset of each one's length; main text has no prefix, like docx.
+var wordStories = []struct {
+	prefix string
+	ccp    int
+}{
+	{"", 12}, {"footnotes ", 16}, {"headers ", 20}, {"macros ", 24},
+	{"comments ", 28}, {"endnotes ", 32}, {"textboxes ", 36}, {"header textboxes ", 40},
+}
+
+func extractDoc(r io.ReaderAt, size int64, emit func(loc string, text []byte)) error {
+	c, err := openCFB(r, size)
+	if err != nil {
+		return err
+	}
+	wd, ok, err := c.stream("WordDocument")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("doc: no WordDocument stream")
+	}
+	le := binary.LittleEndian
+	if len(wd) < 34 || le.Uint16(wd) != 0xa5ec {
+		return errors.New("doc: not a Word 97-2003 document")
+	}
+	flags := le.Uint16(wd[0x0a:])
+	if flags&0x0100 != 0 {
+		return fmt.Errorf("doc: %w", ErrEncrypted)
+	}
+	table := "0Table"
+	if flags&0x0200 != 0 {
+		table = "1Table"
+	}
+
+	// FIB: fixed base, then the counted blocks fibRgW, fibRgLw, fibRgFcLcb
+	pos := 32
+	pos += 2 + 2*int(le.Uint16(wd[pos:]))
+	if pos+2 > len(wd) {
+		return errCFB
+	}
+	cslw := int(le.Uint16(wd[pos:]))
+	lw := pos + 2
+	pos = lw + 4*cslw
+	if cslw < 11 || pos+2 > len(wd) {
+		return errors.New("doc: unsupported file information block")
+	}
+	fcLcb := pos + 2
+	if int(le.Uint16(wd[pos:])) < 34 || fcLcb+34*8 > len(wd) {
+		return errors.New("doc: unsupported file information block")
+	}
+	fcClx, lcbClx := le.Uint32(wd[fcLcb+33*8:]), le.Uint32(wd[fcLcb+33*8+4:])
+
+	tbl, ok, err := c.stream(table)
+	if err != nil {
+		return err
+	}
+	if !ok || uint64(fcClx)+uint64(lcbClx) > uint64(len(tbl)) {
+		return errors.New("doc: missing piece table")
+	}
+	text, err := wordText(wd, tbl[fcClx:fcClx+lcbClx])
+	if err != nil {
+		return err
+	}
+
+	cp := 0
+	for _, st := range wordStories {
+		n := int(le.Uint32(wd[lw+st.ccp:]))
+		if n <= 0 || cp >= len(text) {
+			continue
+		}
+		end := min(len(text), cp+n)
+		wordParagraphs(text[cp:end], func(i int, para []byte) {
+			emit(fmt.Sprintf("%sparagraph %d", st.prefix, i), para)
+		})
+		cp = end
+	}
+	return nil
+}
+
+// wordText assembles the document's characters, indexed by character
+// position, from the pieces listed in the Clx.
+func wordText(wd, clx []byte) ([]uint16, error) {
+	le := binary.LittleEndian
+	for pos := 0; pos < len(clx); {
+		switch clx[pos] {
+		case 1: // Prc: property modifiers, skipped
+			if pos+3 > len(clx) {
+				return nil, errCFB
+			}
+			pos += 3 + int(int16(le.Uint16(clx[pos+1:])))
+		case 2: // Pcdt: the piece table
+			if pos+5 > len(clx) {
+				return nil, errCFB
+			}
+			lcb := int(le.Uint32(clx[pos+1:]))
+			if lcb < 4 || pos+5+lcb > len(clx) {
+				return nil, errCFB
+			}
+			plc := clx[pos+5 : pos+5+lcb]
+			n := (lcb - 4) / 12
+			var text []uint16
+			for i := 0; i < n; i++ {
+				cpStart, cpEnd := int(le.Uint32(plc[4*i:])), int(le.Uint32(plc[4*i+4:]))
+				count := cpEnd - cpStart
+				if count <= 0 || len(text)+count > maxDocChars {
+					continue
+				}
+				fc := le.Uint32(plc[4*(n+1)+8*i+2:])
+				if fc&0x40000000 != 0 { // 8-bit cp1252 at fc/2
+					off := int(fc&0x3fffffff) / 2
+					for _, b := range wd[min(off, len(wd)):min(off+count, len(wd))] {
+						text = append(text, uint16(winAnsiRune(b)))
+					}
+				} else {
+					off := int(fc & 0x3fffffff)
+					for j := off; j+1 < len(wd) && j < off+2*count; j += 2 {
+						text = append(text, le.Uint16(wd[j:]))
+					}
+				}
+			}
+			return text, nil
+		default:
+			return nil, errCFB
+		}
+	}
+	return nil, errors.New("doc: missing piece table")
+}
+
+// wordParagraphs splits story text at paragraph, cell and page marks and
+// drops the control characters Word keeps inline (fields, anchors).
+func wordParagraphs(text []uint16, fn func(i int, para []byte)) {
+	var para []byte
+	n := 0
+	flush := func() {
+		n++
+		if len(bytes.TrimSpace(para)) > 0 {
+			fn(n, para)
+		}
+		para = para[:0]
+	}
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '\r', 0x07, 0x0c:
+			flush()
+		case 0x0b:
+			para = append(para, '\n')
+		case 0x1e:
+			para = append(para, '-')
+		case 0x01, 0x02, 0x03, 0x04, 0x05, 0x08, 0x13, 0x14, 0x15, 0x1f:
+		default:
+			r := rune(c)
+			if utf16.IsSurrogate(r) && i+1 < len(text) {
+				r = utf16.DecodeRune(r, rune(text[i+1]))
+				i++
+			}
+			para = utf8.AppendRune(para, r)
+		}
+	}
+	if len(para) > 0 {
+		flush()
+	}
+}
+
+// BIFF8 record types used below.
+const (
+	xlsFormula    = 0x0006
+	xlsEOF        = 0x000a
+	xlsFilePass   = 0x002f
+	xlsContinue   = 0x003c
+	xlsBoundSheet = 0x0085
+	xlsMulRK      = 0x00bd
+	xlsRString    = 0x00d6
+	xlsSST        = 0x00fc
+	xlsLabelSST   = 0x00fd
+	xlsNumber     = 0x0203
+	xlsLabel      = 0x0204
+	xlsString     = 0x0207
+	xlsRK         = 0x027e
+	xlsBOF        = 0x0809
+)
+
+func extractXls(r io.ReaderAt, size int64, emit func(loc string, text []byte)) error {
+	c, err := openCFB(r, size)
+	if err != nil {
+		return err
+	}
+	wb, ok, err := c.stream("Workbook")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if _, old, _ := c.stream("Book"); old {
+			return errors.New("xls: Excel 5/95 workbooks are not supported")
+		}
+		return errors.New("xls: no Workbook stream")
+	}
+
+	le := binary.LittleEndian
+	var (
+		sheets  = map[int]string{} // substream BOF offset -> sheet name
+		sst     []string
+		sheet   string
+		pending string // cell whose formula result follows in a STRING record
+	)
+	cell := func(data []byte) string {
+		return fmt.Sprintf("%s!%s%d", sheet, colName(int(le.Uint16(data[2:]))+1), int(le.Uint16(data))+1)
+	}
+	num := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	emitCell := func(ref, text string) {
+		if len(bytes.TrimSpace([]byte(text))) > 0 {
+			emit(ref, []byte(text))
+		}
+	}
+	for pos := 0; pos+4 <= len(wb); {
+		typ, n := le.Uint16(wb[pos:]), int(le.Uint16(wb[pos+2:]))
+		if pos+4+n > len(wb) {
+			return errCFB
+		}
+		data := wb[pos+4 : pos+4+n]
+		recPos := pos
+		pos += 4 + n
+		switch typ {
+		case xlsFilePass:
+			return fmt.Errorf("xls: %w", ErrEncrypted)
+		case xlsBOF:
+			sheet = sheets[recPos]
+		case xlsEOF:
+			sheet = ""
+		case xlsBoundSheet:
+			if n >= 8 {
+				name, _ := xlsChars(data[8:], int(data[6]), data[7])
+				sheets[int(le.Uint32(data))] = name
+			}
+		case xlsSST:
+			// strings may run on into CONTINUE records
+			buf := append([]byte(nil), data...)
+			var bounds []int
+			for pos+4 <= len(wb) && le.Uint16(wb[pos:]) == xlsContinue {
+				m := int(le.Uint16(wb[pos+2:]))
+				if pos+4+m > len(wb) {
+					break
+				}
+				bounds = append(bounds, len(buf))
+				buf = append(buf, wb[pos+4:pos+4+m]...)
+				pos += 4 + m
+			}
+			sst = readSST(buf, bounds)
+		case xlsString:
+			if pending != "" && n >= 3 {
+				s, _ := xlsChars(data[3:], int(le.Uint16(data)), data[2])
+				emitCell(pending, s)
+			}
+			pending = ""
+		}
+		if sheet == "" || n < 6 {
+			continue
+		}
+		switch typ {
+		case xlsLabelSST:
+			if n >= 10 {
+				if i := int(le.Uint32(data[6:])); i < len(sst) {
+					emitCell(cell(data), sst[i])
+				}
+			}
+		case xlsLabel, xlsRString:
+			if n >= 9 {
+				s, _ := xlsChars(data[9:], int(le.Uint16(data[6:])), data[8])
+				emitCell(cell(data), s)
+			}
+		case xlsNumber:
+			if n >= 14 {
+				emitCell(cell(data), num(math.Float64frombits(le.Uint64(data[6:]))))
+			}
+		case xlsRK:
+			if n >= 10 {
+				emitCell(cell(data), num(rkValue(le.Uint32(data[6:]))))
+			}
+		case xlsMulRK:
+			row := data[:2]
+			for i, off := int(le.Uint16(data[2:])), 4; off+6 <= n-2; i, off = i+1, off+6 {
+				ref := fmt.Sprintf("%s!%s%d", sheet, colName(i+1), int(le.Uint16(row))+1)
+				emitCell(ref, num(rkValue(le.Uint32(data[off+2:]))))
+			}
+		case xlsFormula:
+			if n >= 14 {
+				if data[12] == 0xff && data[13] == 0xff {
+					if data[6] == 0 {
+						pending = cell(data)
+					}
+				} else {
+					emitCell(cell(data), num(math.Float64frombits(le.Uint64(data[6:]))))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// xlsChars decodes cch characters: UTF-16LE when flags bit 0 is set,
+// otherwise one Latin-1 byte each. It returns the bytes consumed.
+func xlsChars(b []byte, cch int, flags byte) (string, int) {
+	if flags&1 == 0 {
+		cch = min(cch, len(b))
+		rs := make([]rune, cch)
+		for i, c := range b[:cch] {
+			rs[i] = rune(c)
+		}
+		return string(rs), cch
+	}
+	cch = min(cch, len(b)/2)
+	u := make([]uint16, cch)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u)), 2 * cch
+}
+
+// readSST parses the shared string table. bounds are the offsets in b where
+// CONTINUE records start: a string's characters split there resume after a
+// fresh flags byte, which may switch between 8- and 16-bit characters.
+func readSST(b []byte, bounds []int) []string {
+	le := binary.LittleEndian
+	if len(b) < 8 {
+		return nil
+	}
+	total := int(le.Uint32(b[4:]))
+	out := make([]string, 0, min(total, len(b)/3))
+	nextBound := func(pos int) int {
+		for _, bd := range bounds {
+			if bd > pos {
+				return bd
+			}
+		}
+		return len(b)
+	}
+	pos := 8
+	for len(out) < total && pos+3 <= len(b) {
+		cch, flags := int(le.Uint16(b[pos:])), b[pos+2]
+		pos += 3
+		runs, ext := 0, 0
+		if flags&0x08 != 0 && pos+2 <= len(b) {
+			runs = int(le.Uint16(b[pos:]))
+			pos += 2
+		}
+		if flags&0x04 != 0 && pos+4 <= len(b) {
+			ext = int(le.Uint32(b[pos:]))
+			pos += 4
+		}
+		var s []byte
+		for cch > 0 && pos < len(b) {
+			if _, at := slices.BinarySearch(bounds, pos); at {
+				// characters resume in a CONTINUE record after a new flags byte
+				flags = b[pos]
+				pos++
+				continue
+			}
+			width := 1 + int(flags&1)
+			avail := (nextBound(pos) - pos) / width
+			if avail == 0 {
+				break
+			}
+			take := min(cch, avail)
+			str, used := xlsChars(b[pos:], take, flags)
+			s = append(s, str...)
+			pos += used
+			cch -= take
+		}
+		out = append(out, string(s))
+		pos += 4*runs + ext
+	}
+	return out
+}
+
+// rkValue decodes an RK number: a 30-bit integer or the high bits of a
+// double, optionally divided by 100.
+func rkValue(rk uint32) float64 {
+	var v float64
+	if rk&2 != 0 {
+		v = float64(int32(rk) >> 2)
+	} else {
+		v = math.Float64frombits(uint64(rk&0xfffffffc) << 32)
+	}
+	if rk&1 != 0 {
+		v /= 100
+	}
+	return v
+}
@@ -0,0 +1,265 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+	"unicode/utf16"
+)
+
+// buildCFB writes a version 3 compound file; streams below 4096 bytes go to
+// the mini stream like Office does. streams are name, content pairs.
+func buildCFB(t *testing.T, streams ...any) []byte {
+	t.Helper()
+	le := binary.LittleEndian
+	const end, none = 0xfffffffe, 0xffffffff
+	var (
+		sectors [][]byte
+		fat     []uint32
+		mini    []byte
+		miniFAT []uint32
+	)
+	alloc := func(data []byte) uint32 {
+		if len(data) == 0 {
+			return end
+		}
+		start := uint32(len(sectors))
+		for off := 0; off < len(data); off += 512 {
+			sec := make([]byte, 512)
+			copy(sec, data[off:])
+			sectors = append(sectors, sec)
+			fat = append(fat, uint32(len(sectors)))
+		}
+		fat[len(fat)-1] = end
+		return start
+	}
+	type entry struct {
+		name  string
+		typ   byte
+		start uint32
+		size  int
+	}
+	var entries []entry
+	for i := 0; i < len(streams); i += 2 {
+		name, data := streams[i].(string), streams[i+1].([]byte)
+		e := entry{name: name, typ: 2, size: len(data)}
+		if len(data) < 4096 {
+			e.start = uint32(len(mini) / 64)
+			for off := 0; off < len(data); off += 64 {
+				blk := make([]byte, 64)
+				copy(blk, data[off:])
+				mini = append(mini, blk...)
+				miniFAT = append(miniFAT, uint32(len(mini)/64))
+			}
+			miniFAT[len(miniFAT)-1] = end
+		} else {
+			e.start = alloc(data)
+		}
+		entries = append(entries, e)
+	}
+	root := entry{name: "Root Entry", typ: 5, start: alloc(mini), size: len(mini)}
+	mf := make([]byte, 4*len(miniFAT))
+	for i, v := range miniFAT {
+		le.PutUint32(mf[4*i:], v)
+	}
+	miniFATStart := alloc(mf)
+	dir := make([]byte, 0, 128*(len(entries)+1))
+	for _, e := range append([]entry{root}, entries...) {
+		de := make([]byte, 128)
+		u := utf16.Encode([]rune(e.name))
+		for i, c := range u {
+			le.PutUint16(de[2*i:], c)
+		}
+		le.PutUint16(de[64:], uint16(2*len(u)+2))
+		de[66] = e.typ
+		le.PutUint32(de[68:], none)
+		le.PutUint32(de[72:], none)
+		le.PutUint32(de[76:], none)
+		le.PutUint32(de[116:], e.start)
+		le.PutUint32(de[120:], uint32(e.size))
+		dir = append(dir, de...)
+	}
+	dirStart := alloc(dir)
+	fatSec := uint32(len(sectors))
+	fat = append(fat, 0xfffffffd)
+	if len(fat) > 128 {
+		t.Fatal("test container needs more than one FAT sector")
+	}
+	fb := bytes.Repeat([]byte{0xff}, 512)
+	for i, v := range fat {
+		le.PutUint32(fb[4*i:], v)
+	}
+	sectors = append(sectors, fb)
+
+	hdr := make([]byte, 512)
+	copy(hdr, cfbMagic)
+	le.PutUint16(hdr[0x18:], 0x3e)
+	le.PutUint16(hdr[0x1a:], 3)
+	le.PutUint16(hdr[0x1c:], 0xfffe)
+	le.PutUint16(hdr[0x1e:], 9)
+	le.PutUint16(hdr[0x20:], 6)
+	le.PutUint32(hdr[0x2c:], 1)
+	le.PutUint32(hdr[0x30:], dirStart)
+	le.PutUint32(hdr[0x38:], 4096)
+	le.PutUint32(hdr[0x3c:], miniFATStart)
+	le.PutUint32(hdr[0x40:], uint32((len(mf)+511)/512))
+	le.PutUint32(hdr[0x44:], end)
+	for i := 0; i < 109; i++ {
+		le.PutUint32(hdr[0x4c+4*i:], none)
+	}
+	le.PutUint32(hdr[0x4c:], fatSec)
+	return slices.Concat(append([][]byte{hdr}, sectors...)...)
+}
+
+// buildWordDoc lays out a FIB, then one 8-bit and one UTF-16 piece; stories
+// are the character counts of main text, footnotes, headers, ...
+func buildWordDoc(t *testing.T, flags uint16, ansi string, uni string, stories ...uint32) []byte {
+	le := binary.LittleEndian
+	const fibLen = 32 + 2 + 28 + 2 + 88 + 2 + 93*8
+	wd := make([]byte, fibLen)
+	le.PutUint16(wd, 0xa5ec)
+	le.PutUint16(wd[0x0a:], flags|0x0200)
+	le.PutUint16(wd[32:], 14)
+	le.PutUint16(wd[62:], 22)
+	for i, n := range stories {
+		le.PutUint32(wd[64+12+4*i:], n)
+	}
+	le.PutUint16(wd[152:], 93)
+	ansiFC := len(wd)
+	wd = append(wd, ansi...)
+	uniFC := len(wd)
+	for _, c := range utf16.Encode([]rune(uni)) {
+		wd = le.AppendUint16(wd, c)
+	}
+	nAnsi, nUni := uint32(len(ansi)), uint32(len(utf16.Encode([]rune(uni))))
+
+	plc := le.AppendUint32(nil, 0)
+	plc = le.AppendUint32(plc, nAnsi)
+	plc = le.AppendUint32(plc, nAnsi+nUni)
+	plc = append(plc, 0, 0)
+	plc = le.AppendUint32(plc, uint32(ansiFC*2)|0x40000000)
+	plc = append(plc, 0, 0, 0, 0)
+	plc = le.AppendUint32(plc, uint32(uniFC))
+	plc = append(plc, 0, 0)
+	clx := []byte{1, 2, 0, 0xaa, 0xbb, 2}
+	clx = le.AppendUint32(clx, uint32(len(plc)))
+	clx = append(clx, plc...)
+	tbl := append(make([]byte, 16), clx...)
+	le.PutUint32(wd[154+33*8:], 16)
+	le.PutUint32(wd[154+33*8+4:], uint32(len(clx)))
+	return buildCFB(t, "WordDocument", wd, "1Table", tbl)
+}
+
+func TestExtractDoc_PiecesAndStories(t *testing.T) {
+	ansi := "Intro\r\rapi_key=\x93AKIA\x94\x13 HYPERLINK \x14link\x15\r"
+	uni := "Пароль:\x0bqwerty\x07\rnote\r"
+	main := uint32(len(ansi) + len(utf16.Encode([]rune("Пароль:\x0bqwerty\x07\r"))))
+	doc := buildWordDoc(t, 0, ansi, uni, main, 5)
+	got := extractAll(t, extractDoc, doc)
+	want := []string{
+		"paragraph 1=Intro",
+		"paragraph 3=api_key=“AKIA” HYPERLINK link",
+		"paragraph 4=Пароль:\nqwerty",
+		"footnotes paragraph 1=note",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q", got)
+	}
+
+	enc := buildWordDoc(t, 0x0100, "x\r", "", 2)
+	err := extractDoc(bytes.NewReader(enc), int64(len(enc)), func(string, []byte) {})
+	if !errors.Is(err, ErrEncrypted) {
+		t.Fatalf("encrypted: got %v", err)
+	}
+}
+
+func biffRec(typ uint16, data ...[]byte) []byte {
+	body := slices.Concat(data...)
+	b := binary.LittleEndian.AppendUint16(nil, typ)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(body)))
+	return append(b, body...)
+}
+
+func u16(v ...uint16) []byte {
+	var b []byte
+	for _, x := range v {
+		b = binary.LittleEndian.AppendUint16(b, x)
+	}
+	return b
+}
+
+func u32(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+
+func TestExtractXls_SSTAndCells(t *testing.T) {
+	bof := biffRec(xlsBOF, u16(0x0600, 0x0005), make([]byte, 12))
+	// SST: "hello", then "token=ПАРОЛЬ" split across a CONTINUE that switches to 16-bit chars
+	sst := biffRec(xlsSST, u32(2), u32(2), u16(5), []byte{0}, []byte("hello"), u16(12), []byte{0}, []byte("token="))
+	cont := []byte{1}
+	for _, c := range "ПАРОЛЬ" {
+		cont = binary.LittleEndian.AppendUint16(cont, uint16(c))
+	}
+	sst = append(sst, biffRec(xlsContinue, cont)...)
+
+	sheetName := "Keys"
+	boundLen := len(biffRec(xlsBoundSheet, u32(0), u16(0), []byte{byte(len(sheetName)), 0}, []byte(sheetName)))
+	sheetPos := len(bof) + boundLen + len(sst) + len(biffRec(xlsEOF))
+	globals := slices.Concat(bof,
+		biffRec(xlsBoundSheet, u32(uint32(sheetPos)), u16(0), []byte{byte(len(sheetName)), 0}, []byte(sheetName)),
+		sst, biffRec(xlsEOF))
+
+	num := binary.LittleEndian.AppendUint64(nil, math.Float64bits(4111111111111111))
+	formula := slices.Concat(u16(4, 1, 0), []byte{0, 0, 0, 0, 0, 0, 0xff, 0xff}, make([]byte, 6))
+	sheet := slices.Concat(bof,
+		biffRec(xlsLabelSST, u16(0, 0, 0), u32(0)),
+		biffRec(xlsLabelSST, u16(2, 1, 0), u32(1)),
+		biffRec(xlsNumber, u16(3, 0, 0), num),
+		biffRec(xlsRK, u16(3, 2, 0), u32(1234<<2|2)),
+		biffRec(xlsFormula, formula),
+		biffRec(xlsString, u16(3), []byte{0}, []byte("pwd")),
+		biffRec(xlsEOF))
+	xls := buildCFB(t, "Workbook", slices.Concat(globals, sheet))
+
+	got := extractAll(t, extractXls, xls)
+	want := []string{"Keys!A1=hello", "Keys!B3=token=ПАРОЛЬ", "Keys!A4=4111111111111111", "Keys!C4=1234", "Keys!B5=pwd"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestRKValue(t *testing.T) {
+	for rk, want := range map[uint32]float64{
+		1234<<2 | 2: 1234,
+		1234<<2 | 3: 12.34,
+		0x3ff00000:  1,
+		0xfffffffe:  -1, // integer -1
+	} {
+		if got := rkValue(rk); got != want {
+			t.Errorf("rkValue(%#x) = %v, want %v", rk, got, want)
+		}
+	}
+}
+
+func TestOpenCFB_DIFATChain(t *testing.T) {
+	le := binary.LittleEndian
+	base := buildCFB(t, "WordDocument", []byte("x"))
+	if _, err := openCFB(bytes.NewReader(base), int64(len(base))); err != nil {
+		t.Fatal(err)
+	}
+	// a DIFAT chain of two sectors pointing at each other, with a header
+	// count far beyond the file
+	first := uint32(len(base)/512 - 1)
+	data := base
+	for _, next := range []uint32{first + 1, first} {
+		sec := bytes.Repeat([]byte{0xff}, 512)
+		le.PutUint32(sec[508:], next)
+		data = append(data, sec...)
+	}
+	le.PutUint32(data[0x44:], first)
+	le.PutUint32(data[0x48:], 0xffffffff)
+	if _, err := openCFB(bytes.NewReader(data), int64(len(data))); !errors.Is(err, errCFB) {
+		t.Fatalf("looping DIFAT: err = %v, want errCFB", err)
+	}
+}
@@ -0,0 +1,578 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const maxDocPart = 256 << 20 // uncompressed bytes read from one XML part
+
+// Office Open XML and OpenDocument files are zip containers of XML parts.
+// The extractors below walk those parts and emit the visible text per
+// paragraph (docx, odt), slide (pptx) or cell (xlsx, ods).
+func init() {
+	for _, ext := range []string{".docx", ".docm", ".dotx"} {
+		docExtractors[ext] = extractDocx
+	}
+	for _, ext := range []string{".xlsx", ".xlsm", ".xltx"} {
+		docExtractors[ext] = extractXlsx
+	}
+	for _, ext := range []string{".pptx", ".pptm", ".potx"} {
+		docExtractors[ext] = extractPptx
+	}
+	docExtractors[".odt"] = extractOdt
+	docExtractors[".ods"] = extractOds
+}
+
+// xmlText describes how paragraphs are spelled in one XML dialect.
+type xmlText struct {
+	para    []string // paragraph elements
+	text    []string // only character data inside these counts; nil means any inside a paragraph
+	tab     []string
+	brk     []string
+	spaceEl string // ODF <text:s text:c="3"/>
+}
+
+var (
+	wordML    = xmlText{para: []string{"p"}, text: []string{"t"}, tab: []string{"tab"}, brk: []string{"br", "cr"}}
+	drawingML = xmlText{para: []string{"p"}, text: []string{"t"}, brk: []string{"br"}}
+	odfText   = xmlText{para: []string{"p", "h"}, tab: []string{"tab"}, brk: []string{"line-break"}, spaceEl: "s"}
+)
+
+// paragraphs calls fn with the text of every paragraph in r, in document
+// order; nested paragraphs (text boxes, notes) are reported on their own.
+func (x xmlText) paragraphs(r io.Reader, fn func(text []byte)) error {
+	d := xml.NewDecoder(r)
+	var stack [][]byte
+	inText := 0
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			switch {
+			case slices.Contains(x.para, name):
+				stack = append(stack, nil)
+			case len(stack) == 0:
+			case slices.Contains(x.text, name):
+				inText++
+			case slices.Contains(x.tab, name):
+				stack[len(stack)-1] = append(stack[len(stack)-1], '\t')
+			case slices.Contains(x.brk, name):
+				stack[len(stack)-1] = append(stack[len(stack)-1], '\n')
+			case name == x.spaceEl:
+				n, _ := strconv.Atoi(xmlAttr(t, "c"))
+				stack[len(stack)-1] = append(stack[len(stack)-1], strings.Repeat(" ", min(max(n, 1), 1024))...)
+			}
+		case xml.EndElement:
+			name := t.Name.Local
+			switch {
+			case slices.Contains(x.para, name) && len(stack) > 0:
+				fn(stack[len(stack)-1])
+				stack = stack[:len(stack)-1]
+			case slices.Contains(x.text, name) && inText > 0:
+				inText--
+			}
+		case xml.CharData:
+			if len(stack) > 0 && (x.text == nil || inText > 0) {
+				stack[len(stack)-1] = append(stack[len(stack)-1], t...)
+			}
+		}
+	}
+}
+
+func xmlAttr(e xml.StartElement, local string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// zipPart opens one part; a missing part is (nil, nil).
+func zipPart(z *zip.Reader, name string) (io.ReadCloser, error) {
+	for _, f := range z.File {
+		if f.Name == name {
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			return struct {
+				io.Reader
+				io.Closer
+			}{io.LimitReader(rc, maxDocPart), rc}, nil
+		}
+	}
+	return nil, nil
+}
+
+// withPart runs fn on a part if it exists.
+func withPart(z *zip.Reader, name string, fn func(io.Reader) error) error {
+	rc, err := zipPart(z, name)
+	if err != nil || rc == nil {
+		return err
+	}
+	defer rc.Close()
+	if err := fn(rc); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// relTargets maps relationship ids of a part to absolute part names.
+func relTargets(z *zip.Reader, part string) (map[string]string, error) {
+	dir := path.Dir(part)
+	rels := make(map[string]string)
+	err := withPart(z, path.Join(dir, "_rels", path.Base(part)+".rels"), func(r io.Reader) error {
+		d := xml.NewDecoder(r)
+		for {
+			tok, err := d.Token()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "Relationship" {
+				target := xmlAttr(se, "Target")
+				if strings.HasPrefix(target, "/") {
+					target = target[1:]
+				} else {
+					target = path.Join(dir, target)
+				}
+				rels[xmlAttr(se, "Id")] = target
+			}
+		}
+	})
+	return rels, err
+}
+
+// relID returns the r:id attribute (relationships namespace, local "id").
+func relID(e xml.StartElement) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == "id" && strings.Contains(a.Name.Space, "relationships") {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// numbered returns parts named prefix<N>.xml ordered by N.
+func numbered(z *zip.Reader, prefix string) []string {
+	type part struct {
+		name string
+		n    int
+	}
+	var ps []part
+	for _, f := range z.File {
+		rest, ok := strings.CutPrefix(f.Name, prefix)
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSuffix(rest, ".xml")); err == nil && strings.HasSuffix(rest, ".xml") {
+			ps = append(ps, part{f.Name, n})
+		}
+	}
+	slices.SortFunc(ps, func(a, b part) int { return a.n - b.n })
+	out := make([]string, len(ps))
+	for i, p := range ps {
+		out[i] = p.name
+	}
+	return out
+}
+
+func extractDocx(r io.ReaderAt, size int64, emit func(loc string, text []byte)) error {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	parts := []string{"word/document.xml"}
+	parts = append(parts, numbered(z, "word/header")...)
+	parts = append(parts, numbered(z, "word/footer")...)
+	parts = append(parts, "word/footnotes.xml", "word/endnotes.xml", "word/comments.xml")
+	for _, part := range parts {
+		prefix := ""
+		if part != "word/document.xml" {
+			prefix = strings.TrimSuffix(path.Base(part), ".xml") + " "
+		}
+		n := 0
+		err := withPart(z, part, func(r io.Reader) error {
+			return wordML.paragraphs(r, func(text []byte) {
+				n++
+				emit(fmt.Sprintf("%sparagraph %d", prefix, n), text)
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractPptx(r io.ReaderAt, size int64, emit func(loc string, text []byte)) error {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	// slide order comes from presentation.xml; fall back to file numbers
+	var slides []string
+	rels, err := relTargets(z, "ppt/presentation.xml")
+	if err != nil {
+		return err
+	}
+	err = withPart(z, "ppt/presentation.xml", func(r io.Reader) error {
+		d := xml.NewDecoder(r)
+		for {
+			tok, err := d.Token()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "sldId" {
+				if target, ok := rels[relID(se)]; ok {
+					slides = append(slides, target)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if len(slides) == 0 {
+		slides = numbered(z, "ppt/slides/slide")
+	}
+	// a slide is one unit, one line per paragraph
+	var buf []byte
+	collect := func(text []byte) {
+		buf = append(buf, text...)
+		buf = append(buf, '\n')
+	}
+	for i, part := range slides {
+		buf = buf[:0]
+		if err := withPart(z, part, func(r io.Reader) error { return drawingML.paragraphs(r, collect) }); err != nil {
+			return err
+		}
+		emit(fmt.Sprintf("slide %d", i+1), buf)
+	}
+	for _, part := range numbered(z, "ppt/notesSlides/notesSlide") {
+		buf = buf[:0]
+		if err := withPart(z, part, func(r io.Reader) error { return drawingML.paragraphs(r, collect) }); err != nil {
+			return err
+		}
+		emit(strings.TrimSuffix(path.Base(part), ".xml"), buf)
+	}
+	return nil
+}
+
+func extractXlsx(r io.ReaderAt, size int64, emit func(loc string, text []byte)) error {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	var sst []string
+	err = withPart(z, "xl/sharedStrings.xml", func(r io.Reader) error {
+		var err error
+		sst, err = sharedStrings(r)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	rels, err := relTargets(z, "xl/workbook.xml")
+	if err != nil {
+		return err
+	}
+	type sheet struct{ name, part string }
+	var sheets []sheet
+	err = withPart(z, "xl/workbook.xml", func(r io.Reader) error {
+		d := xml.NewDecoder(r)
+		for {
+			tok, err := d.Token()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "sheet" {
+				if target, ok := rels[relID(se)]; ok {
+					sheets = append(sheets, sheet{xmlAttr(se, "name"), target})
+				}
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for _, sh := range sheets {
+		err := withPart(z, sh.part, func(r io.Reader) error {
+			return sheetCells(r, sst, func(ref string, text []byte) { emit(sh.name+"!"+ref, text) })
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sharedStrings reads the string table of a workbook; rich text runs are
+// joined, phonetic hints (rPh) skipped.
+func sharedStrings(r io.Reader) ([]string, error) {
+	d := xml.NewDecoder(r)
+	var (
+		out      []string
+		cur      []byte
+		inT, inP bool
+	)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				cur = cur[:0]
+			case "t":
+				inT = true
+			case "rPh":
+				inP = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "si":
+				out = append(out, string(cur))
+			case "t":
+				inT = false
+			case "rPh":
+				inP = false
+			}
+		case xml.CharData:
+			if inT && !inP {
+				cur = append(cur, t...)
+			}
+		}
+	}
+}
+
+// sheetCells emits every non-empty cell of a worksheet with its A1 reference.
+func sheetCells(r io.Reader, sst []string, emit func(ref string, text []byte)) error {
+	d := xml.NewDecoder(r)
+	var (
+		row, col   int
+		ref, typ   string
+		val, inl   []byte
+		inV, inIsT bool
+	)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				if n, err := strconv.Atoi(xmlAttr(t, "r")); err == nil {
+					row = n
+				} else {
+					row++
+				}
+				col = 0
+			case "c":
+				col++
+				ref, typ = xmlAttr(t, "r"), xmlAttr(t, "t")
+				if ref == "" {
+					ref = colName(col) + strconv.Itoa(row)
+				} else if c, ok := refCol(ref); ok {
+					col = c
+				}
+				val, inl = val[:0], inl[:0]
+			case "v":
+				inV = true
+			case "t":
+				inIsT = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "v":
+				inV = false
+			case "t":
+				inIsT = false
+			case "c":
+				text := val
+				switch typ {
+				case "s":
+					if i, err := strconv.Atoi(string(val)); err == nil && i >= 0 && i < len(sst) {
+						text = []byte(sst[i])
+					}
+				case "inlineStr":
+					text = inl
+				}
+				if len(bytes.TrimSpace(text)) > 0 {
+					emit(ref, text)
+				}
+			}
+		case xml.CharData:
+			if inV {
+				val = append(val, t...)
+			} else if inIsT {
+				inl = append(inl, t...)
+			}
+		}
+	}
+}
+
+// colName converts a 1-based column index to letters: 1 -> A, 28 -> AB.
+func colName(n int) string {
+	var b []byte
+	for ; n > 0; n = (n - 1) / 26 {
+		b = append(b, byte('A'+(n-1)%26))
+	}
+	slices.Reverse(b)
+	return string(b)
+}
+
+// refCol returns the 1-based column of an A1 reference.
+func refCol(ref string) (int, bool) {
+	n := 0
+	for _, c := range ref {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		n = n*26 + int(c-'A'+1)
+	}
+	return n, n > 0
+}
+
+func extractOdt(r io.ReaderAt, size int64, emit func(loc string, text []byte)) error {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	n := 0
+	return withPart(z, "content.xml", func(r io.Reader) error {
+		return odfText.paragraphs(r, func(text []byte) {
+			n++
+			emit(fmt.Sprintf("paragraph %d", n), text)
+		})
+	})
+}
+
+func extractOds(r io.ReaderAt, size int64, emit func(loc string, text []byte)) error {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	rc, err := zipPart(z, "content.xml")
+	if err != nil {
+		return err
+	}
+	if rc == nil {
+		return errors.New("content.xml not found")
+	}
+	defer rc.Close()
+
+	d := xml.NewDecoder(rc)
+	var (
+		sheet           string
+		row, col        int // 0-based position of the current row / cell
+		rowRep, cellRep int
+		inCell, paras   int
+		inP             int
+		cell            []byte
+	)
+	repeat := func(e xml.StartElement, attr string) int {
+		n, err := strconv.Atoi(xmlAttr(e, attr))
+		if err != nil || n < 1 {
+			return 1
+		}
+		return n
+	}
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("content.xml: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "table":
+				if inCell == 0 {
+					sheet, row = xmlAttr(t, "name"), 0
+				}
+			case "table-row":
+				if inCell == 0 {
+					rowRep, col = repeat(t, "number-rows-repeated"), 0
+				}
+			case "table-cell", "covered-table-cell":
+				if inCell == 0 {
+					cellRep, cell, paras = repeat(t, "number-columns-repeated"), cell[:0], 0
+				}
+				inCell++
+			case "p", "h":
+				if inCell > 0 && paras > 0 {
+					cell = append(cell, '\n')
+				}
+				paras++
+				inP++
+			case "tab":
+				cell = append(cell, '\t')
+			case "line-break":
+				cell = append(cell, '\n')
+			case "s":
+				n, _ := strconv.Atoi(xmlAttr(t, "c"))
+				cell = append(cell, strings.Repeat(" ", min(max(n, 1), 1024))...)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "p", "h":
+				inP--
+			case "table-row":
+				if inCell == 0 {
+					row += rowRep
+				}
+			case "table-cell", "covered-table-cell":
+				inCell--
+				if inCell == 0 {
+					// a repeated cell is reported once, at its first address
+					if len(bytes.TrimSpace(cell)) > 0 {
+						emit(fmt.Sprintf("%s!%s%d", sheet, colName(col+1), row+1), cell)
+					}
+					col += cellRep
+				}
+			}
+		case xml.CharData:
+			if inCell > 0 && inP > 0 {
+				cell = append(cell, t...)
+			}
+		}
+	}
+}
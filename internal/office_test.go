@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+// zipDoc builds a zip container from name -> content pairs.
+func zipDoc(t *testing.T, parts ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i < len(parts); i += 2 {
+		w, err := zw.Create(parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(parts[i+1]))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func extractAll(t *testing.T, ex docExtractor, data []byte) []string {
+	t.Helper()
+	var out []string
+	err := ex(bytes.NewReader(data), int64(len(data)), func(loc string, text []byte) {
+		out = append(out, loc+"="+string(text))
+	})
+	if err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	return out
+}
+
+const relsNS = `xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"`
+
+func TestExtractDocx_RunsJoined(t *testing.T) {
+	doc := zipDoc(t,
+		"word/document.xml", `<w:document xmlns:w="w"><w:body>
+<w:p><w:r><w:t>my se</w:t></w:r><w:r><w:t xml:space="preserve">ed phr</w:t></w:r><w:r><w:t>ase</w:t></w:r></w:p>
+<w:p><w:r><w:t>a</w:t><w:tab/><w:t>b</w:t><w:br/><w:t>c</w:t></w:r></w:p>
+</w:body></w:document>`,
+		"word/footer1.xml", `<w:ftr xmlns:w="w"><w:p><w:r><w:t>confidential</w:t></w:r></w:p></w:ftr>`)
+	got := extractAll(t, extractDocx, doc)
+	want := []string{"paragraph 1=my seed phrase", "paragraph 2=a\tb\nc", "footer1 paragraph 1=confidential"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestExtractXlsx_SharedAndInlineStrings(t *testing.T) {
+	doc := zipDoc(t,
+		"xl/workbook.xml", `<workbook `+relsNS+`><sheets><sheet name="Keys" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels", `<Relationships><Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+		"xl/sharedStrings.xml", `<sst><si><t>password</t></si><si><r><t>hunter</t></r><r><t>2</t></r><rPh><t>x</t></rPh></si></sst>`,
+		"xl/worksheets/sheet1.xml", `<worksheet><sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>
+<row r="3"><c r="C3" t="inlineStr"><is><t>inline</t></is></c><c r="D3"><f>1+1</f><v>2</v></c></row>
+</sheetData></worksheet>`)
+	got := extractAll(t, extractXlsx, doc)
+	want := []string{"Keys!A1=password", "Keys!B1=hunter2", "Keys!C3=inline", "Keys!D3=2"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestExtractPptx_SlideOrder(t *testing.T) {
+	slide := func(s string) string {
+		return `<p:sld xmlns:p="p" xmlns:a="a"><p:txBody><a:p><a:r><a:t>` + s + `</a:t></a:r></a:p><a:p><a:r><a:t>x</a:t></a:r></a:p></p:txBody></p:sld>`
+	}
+	doc := zipDoc(t,
+		"ppt/presentation.xml", `<p:presentation xmlns:p="p" `+relsNS+`><p:sldIdLst><p:sldId id="256" r:id="rId3"/><p:sldId id="257" r:id="rId2"/></p:sldIdLst></p:presentation>`,
+		"ppt/_rels/presentation.xml.rels", `<Relationships><Relationship Id="rId2" Target="slides/slide1.xml"/><Relationship Id="rId3" Target="slides/slide2.xml"/></Relationships>`,
+		"ppt/slides/slide1.xml", slide("one"),
+		"ppt/slides/slide2.xml", slide("two"))
+	got := extractAll(t, extractPptx, doc)
+	want := []string{"slide 1=two\nx\n", "slide 2=one\nx\n"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestExtractODF(t *testing.T) {
+	odt := zipDoc(t, "content.xml", `<office:document-content xmlns:office="o" xmlns:text="t"><office:body><office:text>
+<text:h>Title</text:h>
+<text:p>seed<text:s text:c="2"/><text:span>phrase</text:span><text:note><text:note-body><text:p>note</text:p></text:note-body></text:note></text:p>
+</office:text></office:body></office:document-content>`)
+	got := extractAll(t, extractOdt, odt)
+	want := []string{"paragraph 1=Title", "paragraph 2=note", "paragraph 3=seed  phrase"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("odt: %q", got)
+	}
+
+	ods := zipDoc(t, "content.xml", `<office:document-content xmlns:office="o" xmlns:table="tb" xmlns:text="t"><office:body><office:spreadsheet>
+<table:table table:name="Wallets">
+<table:table-row table:number-rows-repeated="2"><table:table-cell table:number-columns-repeated="5"/></table:table-row>
+<table:table-row>
+  <table:table-cell table:number-columns-repeated="2"/>
+  <table:table-cell><text:p>key</text:p><text:p>line2</text:p></table:table-cell>
+</table:table-row>
+</table:table>
+</office:spreadsheet></office:body></office:document-content>`)
+	got = extractAll(t, extractOds, ods)
+	if !slices.Equal(got, []string{"Wallets!C3=key\nline2"}) {
+		t.Fatalf("ods: %q", got)
+	}
+}
+
+func TestColName(t *testing.T) {
+	for n, want := range map[int]string{1: "A", 26: "Z", 27: "AA", 28: "AB", 702: "ZZ", 703: "AAA"} {
+		if got := colName(n); got != want {
+			t.Errorf("colName(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
+
+func TestScanRegularFile_DocumentLocation(t *testing.T) {
+	dir := t.TempDir()
+	fp := filepath.Join(dir, "Notes.DOCX")
+	_ = os.WriteFile(fp, zipDoc(t, "word/document.xml",
+		`<w:document xmlns:w="w"><w:body><w:p><w:r><w:t>intro</w:t></w:r></w:p><w:p><w:r><w:t>seed </w:t></w:r><w:r><w:t>phrase: abandon</w:t></w:r></w:p></w:body></w:document>`), 0644)
+	rules := &RuleSet{Patterns: []Pattern{&PlainPattern{s: []byte("seed phrase")}}}
+	var got []MatchResult
+	var matchCnt, errCnt atomic.Int64
+	NewFileScanner().scanRegularFile(context.Background(), fp, rules, ScanOptions{}, func(m MatchResult) { got = append(got, m) }, &matchCnt, &errCnt)
+	if len(got) != 1 || got[0].Location != "paragraph 2" || got[0].Line != "seed phrase: abandon\n" || got[0].Hash == "" {
+		t.Fatalf("unexpected: %+v", got)
+	}
+}
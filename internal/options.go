@@ -2,7 +2,10 @@ package internal
 
 import (
 	"errors"
+	"io"
+	"os"
 	"runtime"
+	"time"
 )
 
 // ScanOptions - public options from CLI.
@@ -19,9 +22,39 @@ type ScanOptions struct {
 	FailFast                   bool
 	SaveMatchesFile            string
 	SaveMatchesByPatternFolder string
+	Stdin                      bool   // scan stdin as a virtual file
+	StdinName                  string // virtual file name for stdin
+	NamesOnly                  bool   // only apply name:/path: rules, never read content
+	YaraFiles                  []string
+	Binary                     string        // skip|text|strings for files detected as binary
+	StringsMin                 int           // min run length for --binary=strings
+	MaxLineLen                 int           // longer lines are matched in chunks, results carry a snippet
+	SnippetLen                 int           // >0: report this many runes around the match instead of the line
+	FullLine                   bool          // keep the whole line alongside the snippet
+	ContextBefore              int           // lines of leading context per line match (-B)
+	ContextAfter               int           // lines of trailing context per line match (-A)
+	SplitSize                  int64         // files above this size are scanned as parallel parts, 0 = never
+	NoHash                     bool          // hash only for hash rules: findings stream at once, without the file hash
+	Mmap                       bool          // map local regular files instead of streaming them
+	Decode                     bool          // also match base64, hex and percent-encoded tokens decoded
+	DecodeMin                  int           // shortest token to decode
+	DecodeDepth                int           // nested decodings per token
+	QR                         bool          // decode QR codes in PNG, JPEG and GIF images
+	QRMaxPixels                int64         // larger images are not decoded
+	QRTimeout                  time.Duration // QR search time per image
+	GitHistory                 bool          // scan the history of git repositories under the roots
+	GitRange                   string        // "A..B" or "B": commits to scan instead of all refs
+	GitUnreachable             bool          // also scan commits and blobs no ref leads to
+	Images                     bool          // read docker save tarballs and OCI layouts layer by layer
+	ArchivePasswords           string        // file of passwords tried on encrypted archive entries, one per line
+	Stats                      *AppStats     // optional, filled by Scan
 
 	whMap map[string]struct{}
 	blMap map[string]struct{}
+	stdin io.Reader
+
+	passwords []string // read from ArchivePasswords by Scan
+	noHash    bool     // a part of a split file: there is no file hash to compute
 }
 
 // Validate checks invariants.
@@ -32,6 +65,14 @@ func (o *ScanOptions) Validate() error {
 	if o.SaveFull && o.SaveFullFolder == "" {
 		return errors.New("save-full-folder must be set when --save-full is used")
 	}
+	if o.ContextBefore < 0 || o.ContextAfter < 0 {
+		return errors.New("context line counts must not be negative")
+	}
+	switch o.Binary {
+	case "", BinarySkip, BinaryText, BinaryStrings:
+	default:
+		return errors.New("binary must be one of skip, text, strings")
+	}
 	return nil
 }
 
@@ -42,6 +83,33 @@ func (o *ScanOptions) Prepare() {
 	if o.Threads <= 0 {
 		o.Threads = max(32, runtime.GOMAXPROCS(0)*4)
 	}
+	if o.Binary == "" {
+		o.Binary = BinaryText
+	}
+	if o.MaxLineLen <= 0 {
+		o.MaxLineLen = defaultMaxLineLen
+	}
+	if o.DecodeMin <= 0 {
+		o.DecodeMin = defaultDecodeMin
+	}
+	if o.DecodeDepth <= 0 {
+		o.DecodeDepth = defaultDecodeDepth
+	}
+	if o.QRMaxPixels <= 0 {
+		o.QRMaxPixels = defaultQRMaxPixels
+	}
+	if o.QRTimeout <= 0 {
+		o.QRTimeout = defaultQRTimeout
+	}
+	if o.StringsMin <= 0 {
+		o.StringsMin = 4
+	}
+	if o.StdinName == "" {
+		o.StdinName = "stdin"
+	}
+	if o.stdin == nil {
+		o.stdin = os.Stdin
+	}
 }
 
 func toSet(s []string) map[string]struct{} {
@@ -17,6 +17,10 @@ func TestScanOptions_Validate(t *testing.T) {
 	if err := o.Validate(); err != nil {
 		t.Fatalf("unexpected: %v", err)
 	}
+	o.Binary = "hexdump"
+	if err := o.Validate(); err == nil {
+		t.Fatal("expected error for unknown binary mode")
+	}
 }
 
 func TestScanOptions_PrepareAndAllowedExt(t *testing.T) {
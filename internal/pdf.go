@@ -0,0 +1,1102 @@
+package internal
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	maxPDFSize   = 256 << 20 // whole file is parsed in memory
+	maxPDFStream = 64 << 20  // decoded bytes per stream
+	maxPDFDepth  = 16        // page tree / form XObject / reference nesting
+)
+
+func init() { docExtractors[".pdf"] = extractPDF }
+
+// PDF objects: nil, bool, int64, float64, []byte (string), pdfName, []any,
+// pdfDict, pdfRef, *pdfStream, pdfOp (content stream operators only).
+type (
+	pdfName   string
+	pdfOp     string
+	pdfDict   map[pdfName]any
+	pdfRef    struct{ num, gen int64 }
+	pdfStream struct {
+		dict pdfDict
+		raw  []byte
+	}
+)
+
+// pdfLexer parses PDF syntax from a byte slice.
+type pdfLexer struct {
+	b   []byte
+	pos int
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelim(c byte) bool {
+	return bytes.IndexByte([]byte("()<>[]{}/%"), c) >= 0
+}
+
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.b) {
+		c := l.b[l.pos]
+		switch {
+		case isPDFSpace(c):
+			l.pos++
+		case c == '%':
+			for l.pos < len(l.b) && l.b[l.pos] != '\n' && l.b[l.pos] != '\r' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// regular returns the run of regular characters at pos.
+func (l *pdfLexer) regular() []byte {
+	start := l.pos
+	for l.pos < len(l.b) && !isPDFSpace(l.b[l.pos]) && !isPDFDelim(l.b[l.pos]) {
+		l.pos++
+	}
+	return l.b[start:l.pos]
+}
+
+var errPDFSyntax = errors.New("pdf syntax error")
+
+// object parses the next object; depth guards nested arrays/dicts.
+func (l *pdfLexer) object(depth int) (any, error) {
+	if depth > 64 {
+		return nil, errPDFSyntax
+	}
+	l.skipSpace()
+	if l.pos >= len(l.b) {
+		return nil, io.EOF
+	}
+	switch c := l.b[l.pos]; {
+	case c == '/':
+		l.pos++
+		return pdfName(unescapeName(l.regular())), nil
+	case c == '(':
+		return l.literal()
+	case c == '<' && l.pos+1 < len(l.b) && l.b[l.pos+1] == '<':
+		l.pos += 2
+		d := pdfDict{}
+		for {
+			l.skipSpace()
+			if l.pos+1 < len(l.b) && l.b[l.pos] == '>' && l.b[l.pos+1] == '>' {
+				l.pos += 2
+				return d, nil
+			}
+			k, err := l.object(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			name, ok := k.(pdfName)
+			if !ok {
+				return nil, errPDFSyntax
+			}
+			v, err := l.object(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			d[name] = v
+		}
+	case c == '<':
+		end := bytes.IndexByte(l.b[l.pos:], '>')
+		if end < 0 {
+			l.pos = len(l.b) // unterminated: nothing after it parses
+			return nil, errPDFSyntax
+		}
+		h := l.b[l.pos+1 : l.pos+end]
+		l.pos += end + 1
+		return decodeHexString(h), nil
+	case c == '[':
+		l.pos++
+		var arr []any
+		for {
+			l.skipSpace()
+			if l.pos < len(l.b) && l.b[l.pos] == ']' {
+				l.pos++
+				return arr, nil
+			}
+			v, err := l.object(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+	case c == ')' || c == '>' || c == ']' || c == '{' || c == '}':
+		l.pos++
+		return nil, errPDFSyntax
+	default:
+		tok := l.regular()
+		if len(tok) == 0 {
+			l.pos++
+			return nil, errPDFSyntax
+		}
+		if n, ok := pdfNumber(tok); ok {
+			// "num gen R" is a reference
+			if i, isInt := n.(int64); isInt {
+				save := l.pos
+				l.skipSpace()
+				if gen, ok := pdfNumber(l.regular()); ok {
+					if g, isInt := gen.(int64); isInt {
+						l.skipSpace()
+						if l.pos < len(l.b) && l.b[l.pos] == 'R' && (l.pos+1 == len(l.b) || isPDFSpace(l.b[l.pos+1]) || isPDFDelim(l.b[l.pos+1])) {
+							l.pos++
+							return pdfRef{i, g}, nil
+						}
+					}
+				}
+				l.pos = save
+			}
+			return n, nil
+		}
+		switch string(tok) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return pdfOp(tok), nil
+	}
+}
+
+func pdfNumber(tok []byte) (any, bool) {
+	if len(tok) == 0 || !(tok[0] == '+' || tok[0] == '-' || tok[0] == '.' || tok[0] >= '0' && tok[0] <= '9') {
+		return nil, false
+	}
+	if i, err := strconv.ParseInt(string(tok), 10, 64); err == nil {
+		return i, true
+	}
+	if f, err := strconv.ParseFloat(string(tok), 64); err == nil {
+		return f, true
+	}
+	return nil, false
+}
+
+func unescapeName(b []byte) string {
+	if bytes.IndexByte(b, '#') < 0 {
+		return string(b)
+	}
+	var out []byte
+	for i := 0; i < len(b); i++ {
+		if b[i] == '#' && i+2 < len(b) {
+			if v, err := strconv.ParseUint(string(b[i+1:i+3]), 16, 8); err == nil {
+				out = append(out, byte(v))
+				i += 2
+				continue
+			}
+		}
+		out = append(out, b[i])
+	}
+	return string(out)
+}
+
+func decodeHexString(h []byte) []byte {
+	clean := make([]byte, 0, len(h)+1)
+	for _, c := range h {
+		if !isPDFSpace(c) {
+			clean = append(clean, c)
+		}
+	}
+	if len(clean)%2 == 1 {
+		clean = append(clean, '0')
+	}
+	out := make([]byte, len(clean)/2)
+	n, _ := hex.Decode(out, clean)
+	return out[:n]
+}
+
+// literal parses a (string) with escapes and balanced parentheses.
+func (l *pdfLexer) literal() ([]byte, error) {
+	l.pos++ // (
+	var out []byte
+	depth := 1
+	for l.pos < len(l.b) {
+		c := l.b[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return out, nil
+			}
+		case '\\':
+			if l.pos >= len(l.b) {
+				return out, nil
+			}
+			e := l.b[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if l.pos < len(l.b) && l.b[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for k := 0; k < 2 && l.pos < len(l.b) && l.b[l.pos] >= '0' && l.b[l.pos] <= '7'; k++ {
+						v = v*8 + int(l.b[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// pdfDoc is a parsed file: every "N G obj" found, latest definition wins.
+type pdfDoc struct {
+	objs    map[int64]any
+	trailer pdfDict
+}
+
+var pdfObjRe = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+
+func parsePDF(data []byte) (*pdfDoc, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data[:min(len(data), 1024)], "\x00\t\n\r "), []byte("%PDF")) && bytes.Index(data[:min(len(data), 1024)], []byte("%PDF")) < 0 {
+		return nil, errors.New("not a PDF")
+	}
+	doc := &pdfDoc{objs: make(map[int64]any), trailer: pdfDict{}}
+	// scan objects front to back, skipping parsed bodies so stream data is not searched
+	for pos := 0; pos < len(data); {
+		loc := pdfObjRe.FindSubmatchIndex(data[pos:])
+		if loc == nil {
+			break
+		}
+		start := pos + loc[0]
+		if start > 0 && !isPDFSpace(data[start-1]) && !isPDFDelim(data[start-1]) {
+			pos += loc[1]
+			continue
+		}
+		num, _ := strconv.ParseInt(string(data[pos+loc[2]:pos+loc[3]]), 10, 64)
+		l := &pdfLexer{b: data, pos: pos + loc[1]}
+		v, err := l.object(0)
+		if err != nil {
+			pos += loc[1]
+			continue
+		}
+		if d, ok := v.(pdfDict); ok {
+			if s := l.stream(d); s != nil {
+				v = s
+			}
+			if d["Type"] == pdfName("XRef") {
+				doc.mergeTrailer(d)
+			}
+		}
+		doc.objs[num] = v
+		pos = l.pos
+	}
+	// classic trailers
+	for i := 0; ; {
+		j := bytes.Index(data[i:], []byte("trailer"))
+		if j < 0 {
+			break
+		}
+		l := &pdfLexer{b: data, pos: i + j + len("trailer")}
+		if v, err := l.object(0); err == nil {
+			if d, ok := v.(pdfDict); ok {
+				doc.mergeTrailer(d)
+			}
+		}
+		i += j + len("trailer")
+	}
+	doc.loadObjectStreams()
+	return doc, nil
+}
+
+// mergeTrailer keeps the keys that matter; later trailers win.
+func (doc *pdfDoc) mergeTrailer(d pdfDict) {
+	for _, k := range []pdfName{"Root", "Encrypt"} {
+		if v, ok := d[k]; ok {
+			doc.trailer[k] = v
+		}
+	}
+}
+
+// stream reads stream data following a dict at l.pos, or returns nil.
+func (l *pdfLexer) stream(d pdfDict) *pdfStream {
+	save := l.pos
+	l.skipSpace()
+	if !bytes.HasPrefix(l.b[l.pos:], []byte("stream")) {
+		l.pos = save
+		return nil
+	}
+	start := l.pos + len("stream")
+	if start < len(l.b) && l.b[start] == '\r' {
+		start++
+	}
+	if start < len(l.b) && l.b[start] == '\n' {
+		start++
+	}
+	// trust a direct /Length only if endstream follows it
+	if n, ok := d["Length"].(int64); ok && n >= 0 && start+int(n) <= len(l.b) {
+		rest := bytes.TrimLeft(l.b[start+int(n):min(len(l.b), start+int(n)+32)], "\r\n\t ")
+		if bytes.HasPrefix(rest, []byte("endstream")) {
+			l.pos = start + int(n)
+			return &pdfStream{dict: d, raw: l.b[start : start+int(n)]}
+		}
+	}
+	end := bytes.Index(l.b[start:], []byte("endstream"))
+	if end < 0 {
+		l.pos = len(l.b)
+		return &pdfStream{dict: d, raw: l.b[start:]}
+	}
+	l.pos = start + end
+	raw := l.b[start : start+end]
+	raw = bytes.TrimSuffix(raw, []byte("\n"))
+	raw = bytes.TrimSuffix(raw, []byte("\r"))
+	return &pdfStream{dict: d, raw: raw}
+}
+
+// loadObjectStreams adds objects compressed into /Type /ObjStm streams;
+// objects defined directly in the file take precedence.
+func (doc *pdfDoc) loadObjectStreams() {
+	var streams []*pdfStream
+	for _, v := range doc.objs {
+		if s, ok := v.(*pdfStream); ok && s.dict["Type"] == pdfName("ObjStm") {
+			streams = append(streams, s)
+		}
+	}
+	for _, s := range streams {
+		data, err := doc.decode(s)
+		if err != nil {
+			continue
+		}
+		n, _ := doc.resolve(s.dict["N"]).(int64)
+		first, _ := doc.resolve(s.dict["First"]).(int64)
+		if first < 0 || int(first) > len(data) {
+			continue
+		}
+		hdr := &pdfLexer{b: data[:first]}
+		for i := int64(0); i < n; i++ {
+			num, err1 := hdr.object(0)
+			off, err2 := hdr.object(0)
+			on, ok1 := num.(int64)
+			oo, ok2 := off.(int64)
+			if err1 != nil || err2 != nil || !ok1 || !ok2 {
+				break
+			}
+			if _, exists := doc.objs[on]; exists || int(first+oo) >= len(data) {
+				continue
+			}
+			l := &pdfLexer{b: data, pos: int(first + oo)}
+			if v, err := l.object(0); err == nil {
+				doc.objs[on] = v
+			}
+		}
+	}
+}
+
+// resolve follows references.
+func (doc *pdfDoc) resolve(v any) any {
+	for i := 0; i < maxPDFDepth; i++ {
+		r, ok := v.(pdfRef)
+		if !ok {
+			return v
+		}
+		v = doc.objs[r.num]
+	}
+	return nil
+}
+
+func (doc *pdfDoc) dict(v any) pdfDict {
+	switch t := doc.resolve(v).(type) {
+	case pdfDict:
+		return t
+	case *pdfStream:
+		return t.dict
+	}
+	return nil
+}
+
+var errPDFFilter = errors.New("unsupported stream filter")
+
+// decode applies the stream's filters.
+func (doc *pdfDoc) decode(s *pdfStream) ([]byte, error) {
+	data := s.raw
+	var filters []any
+	switch f := doc.resolve(s.dict["Filter"]).(type) {
+	case pdfName:
+		filters = []any{f}
+	case []any:
+		filters = f
+	}
+	var parms []any
+	switch p := doc.resolve(s.dict["DecodeParms"]).(type) {
+	case pdfDict:
+		parms = []any{p}
+	case []any:
+		parms = p
+	}
+	for i, f := range filters {
+		var parm pdfDict
+		if i < len(parms) {
+			parm = doc.dict(parms[i])
+		}
+		var err error
+		switch doc.resolve(f) {
+		case pdfName("FlateDecode"), pdfName("Fl"):
+			data, err = inflatePDF(data)
+			if err == nil {
+				data, err = unpredict(data, parm)
+			}
+		case pdfName("ASCIIHexDecode"), pdfName("AHx"):
+			end := bytes.IndexByte(data, '>')
+			if end < 0 {
+				end = len(data)
+			}
+			data = decodeHexString(data[:end])
+		case pdfName("ASCII85Decode"), pdfName("A85"):
+			data, err = decodeA85(data)
+		default:
+			return nil, errPDFFilter
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// inflatePDF decompresses zlib data, keeping what was read before a corrupt tail.
+func inflatePDF(b []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(io.LimitReader(zr, maxPDFStream))
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+	return out, nil
+}
+
+func decodeA85(b []byte) ([]byte, error) {
+	b = bytes.TrimPrefix(bytes.TrimSpace(b), []byte("<~"))
+	if end := bytes.Index(b, []byte("~>")); end >= 0 {
+		b = b[:end]
+	}
+	out := make([]byte, 4*len(b)/5+4)
+	n, _, err := ascii85.Decode(out, b, true)
+	return out[:n], err
+}
+
+// unpredict reverses PNG predictors (Predictor >= 10), used by xref and object streams.
+func unpredict(data []byte, parm pdfDict) ([]byte, error) {
+	pred, _ := parm["Predictor"].(int64)
+	if pred < 10 {
+		return data, nil
+	}
+	colors, bpc, cols := int64(1), int64(8), int64(1)
+	if v, ok := parm["Colors"].(int64); ok && v > 0 {
+		colors = v
+	}
+	if v, ok := parm["BitsPerComponent"].(int64); ok && v > 0 {
+		bpc = v
+	}
+	if v, ok := parm["Columns"].(int64); ok && v > 0 {
+		cols = v
+	}
+	bpp := int(max(1, colors*bpc/8))
+	rowLen := int((colors*bpc*cols + 7) / 8)
+	if rowLen <= 0 || rowLen > 1<<20 {
+		return nil, errPDFSyntax
+	}
+	var out []byte
+	prev := make([]byte, rowLen)
+	for i := 0; i+1 <= len(data); i += rowLen + 1 {
+		ft := data[i]
+		row := make([]byte, rowLen)
+		copy(row, data[i+1:min(len(data), i+1+rowLen)])
+		for j := range row {
+			var left, up, ul byte
+			if j >= bpp {
+				left, ul = row[j-bpp], prev[j-bpp]
+			}
+			up = prev[j]
+			switch ft {
+			case 1:
+				row[j] += left
+			case 2:
+				row[j] += up
+			case 3:
+				row[j] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[j] += paeth(left, up, ul)
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// extractPDF emits the text of every page as "page N".
+func extractPDF(r io.ReaderAt, size int64, emit func(loc string, text []byte)) error {
+	if size > maxPDFSize {
+		return fmt.Errorf("pdf: file larger than %d MiB, not parsed", maxPDFSize>>20)
+	}
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, 0); err != nil && err != io.EOF {
+		return err
+	}
+	doc, err := parsePDF(data)
+	if err != nil {
+		return fmt.Errorf("pdf: %w", err)
+	}
+	if doc.trailer["Encrypt"] != nil {
+		return fmt.Errorf("pdf: %w", ErrEncrypted)
+	}
+
+	pages := doc.pages()
+	x := &pdfText{doc: doc, fonts: make(map[any]*pdfFont)}
+	anyText := false
+	for i, pg := range pages {
+		x.out = x.out[:0]
+		x.lastY = nil
+		res := doc.dict(pg["Resources"])
+		var content []byte
+		switch c := doc.resolve(pg["Contents"]).(type) {
+		case *pdfStream:
+			content, _ = doc.decode(c)
+		case []any:
+			for _, part := range c {
+				if s, ok := doc.resolve(part).(*pdfStream); ok {
+					b, _ := doc.decode(s)
+					content = append(append(content, b...), '\n')
+				}
+			}
+		}
+		x.run(content, res, 0)
+		if text := bytes.TrimRight(x.out, " \n"); len(bytes.TrimSpace(text)) > 0 {
+			anyText = true
+			emit(fmt.Sprintf("page %d", i+1), text)
+		}
+	}
+	if !anyText {
+		if x.images > 0 {
+			return fmt.Errorf("pdf: %w (%d pages, %d images, scanned?)", ErrNoText, len(pages), x.images)
+		}
+		return fmt.Errorf("pdf: %w (%d pages)", ErrNoText, len(pages))
+	}
+	return nil
+}
+
+// pages walks the page tree; resources are inherited from parents.
+func (doc *pdfDoc) pages() []pdfDict {
+	root := doc.dict(doc.trailer["Root"])
+	if root == nil {
+		for _, v := range doc.objs {
+			if d, ok := v.(pdfDict); ok && d["Type"] == pdfName("Catalog") {
+				root = d
+				break
+			}
+		}
+	}
+	var out []pdfDict
+	seen := make(map[int64]bool)
+	var walk func(node any, res any, depth int)
+	walk = func(node any, res any, depth int) {
+		if r, ok := node.(pdfRef); ok {
+			if seen[r.num] {
+				return
+			}
+			seen[r.num] = true
+		}
+		d := doc.dict(node)
+		if d == nil || depth > maxPDFDepth {
+			return
+		}
+		if v, ok := d["Resources"]; ok {
+			res = v
+		}
+		if kids, ok := doc.resolve(d["Kids"]).([]any); ok {
+			for _, k := range kids {
+				walk(k, res, depth+1)
+			}
+			return
+		}
+		if d["Type"] == pdfName("Page") || d["Contents"] != nil {
+			pg := pdfDict{"Contents": d["Contents"], "Resources": res}
+			out = append(out, pg)
+		}
+	}
+	if root != nil {
+		walk(root["Pages"], nil, 0)
+	}
+	return out
+}
+
+// pdfText interprets content streams and collects shown text.
+type pdfText struct {
+	doc    *pdfDoc
+	fonts  map[any]*pdfFont
+	out    []byte
+	lastY  *float64
+	images int
+}
+
+func (x *pdfText) newline() {
+	if len(x.out) > 0 && x.out[len(x.out)-1] != '\n' {
+		x.out = append(x.out, '\n')
+	}
+}
+
+func (x *pdfText) space() {
+	if len(x.out) > 0 && x.out[len(x.out)-1] != ' ' && x.out[len(x.out)-1] != '\n' {
+		x.out = append(x.out, ' ')
+	}
+}
+
+func (x *pdfText) moveTo(y float64) {
+	if x.lastY != nil && *x.lastY != y {
+		x.newline()
+	}
+	x.lastY = &y
+}
+
+func pdfFloat(v any) float64 {
+	switch n := v.(type) {
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+func (x *pdfText) run(content []byte, res pdfDict, depth int) {
+	if depth > maxPDFDepth {
+		return
+	}
+	l := &pdfLexer{b: content}
+	var (
+		ops  []any
+		font *pdfFont
+		y    float64
+	)
+	for {
+		at := l.pos
+		v, err := l.object(0)
+		if err == io.EOF || err != nil && l.pos == at {
+			return
+		}
+		if err != nil {
+			ops = ops[:0]
+			continue
+		}
+		op, isOp := v.(pdfOp)
+		if !isOp {
+			ops = append(ops, v)
+			continue
+		}
+		switch op {
+		case "BT":
+			y = 0
+		case "Tf":
+			if len(ops) >= 2 {
+				font = x.font(res, ops[0])
+			}
+		case "Td", "TD":
+			if len(ops) >= 2 {
+				y += pdfFloat(ops[1])
+				x.moveTo(y)
+			}
+		case "Tm":
+			if len(ops) >= 6 {
+				y = pdfFloat(ops[5])
+				x.moveTo(y)
+			}
+		case "T*":
+			x.newline()
+		case "Tj":
+			if len(ops) >= 1 {
+				x.show(font, ops[len(ops)-1])
+			}
+		case "'", "\"":
+			x.newline()
+			if len(ops) >= 1 {
+				x.show(font, ops[len(ops)-1])
+			}
+		case "TJ":
+			if len(ops) >= 1 {
+				arr, _ := ops[len(ops)-1].([]any)
+				for _, el := range arr {
+					if s, ok := el.([]byte); ok {
+						x.show(font, s)
+					} else if pdfFloat(el) < -200 {
+						x.space() // a large kern is a word gap
+					}
+				}
+			}
+		case "ET":
+			x.space()
+		case "Do":
+			if len(ops) >= 1 {
+				x.xobject(res, ops[0], depth)
+			}
+		case "ID":
+			// inline image data runs to a whitespace-delimited EI
+			x.images++
+			end := len(content)
+			for i := l.pos + 1; i+2 <= len(content); i++ {
+				if content[i] == 'E' && content[i+1] == 'I' && isPDFSpace(content[i-1]) && (i+2 == len(content) || isPDFSpace(content[i+2])) {
+					end = i + 2
+					break
+				}
+			}
+			l.pos = end
+		}
+		ops = ops[:0]
+	}
+}
+
+func (x *pdfText) show(f *pdfFont, v any) {
+	s, ok := v.([]byte)
+	if !ok {
+		return
+	}
+	if f == nil {
+		f = &pdfFont{}
+	}
+	x.out = f.decode(x.out, s)
+}
+
+func (x *pdfText) xobject(res pdfDict, name any, depth int) {
+	n, ok := name.(pdfName)
+	if !ok {
+		return
+	}
+	xo := x.doc.dict(res["XObject"])
+	s, ok := x.doc.resolve(xo[n]).(*pdfStream)
+	if !ok {
+		return
+	}
+	switch s.dict["Subtype"] {
+	case pdfName("Image"):
+		x.images++
+	case pdfName("Form"):
+		data, err := x.doc.decode(s)
+		if err != nil {
+			return
+		}
+		fres := x.doc.dict(s.dict["Resources"])
+		if fres == nil {
+			fres = res
+		}
+		x.run(data, fres, depth+1)
+	}
+}
+
+// font returns the decoder of a font resource, cached by its reference.
+func (x *pdfText) font(res pdfDict, name any) *pdfFont {
+	n, ok := name.(pdfName)
+	if !ok {
+		return nil
+	}
+	ref := x.doc.dict(res["Font"])[n]
+	key := any(ref)
+	if _, isRef := ref.(pdfRef); !isRef {
+		key = fmt.Sprintf("%p/%s", res, n)
+	}
+	if f, ok := x.fonts[key]; ok {
+		return f
+	}
+	f := x.doc.newFont(x.doc.dict(ref))
+	x.fonts[key] = f
+	return f
+}
+
+// pdfFont maps character codes to Unicode: through a ToUnicode CMap when
+// present, else through the simple font encoding (WinAnsi + Differences).
+type pdfFont struct {
+	cmap      *pdfCMap
+	composite bool // Type0: multi-byte codes
+	diff      map[byte]rune
+}
+
+func (doc *pdfDoc) newFont(d pdfDict) *pdfFont {
+	f := &pdfFont{}
+	if d == nil {
+		return f
+	}
+	f.composite = d["Subtype"] == pdfName("Type0")
+	if s, ok := doc.resolve(d["ToUnicode"]).(*pdfStream); ok {
+		if data, err := doc.decode(s); err == nil {
+			f.cmap = parseCMap(data)
+		}
+	}
+	if enc := doc.dict(d["Encoding"]); enc != nil {
+		if arr, ok := doc.resolve(enc["Differences"]).([]any); ok {
+			f.diff = make(map[byte]rune)
+			code := int64(0)
+			for _, el := range arr {
+				switch t := el.(type) {
+				case int64:
+					code = t
+				case pdfName:
+					if r := glyphRune(string(t)); r != 0 && code >= 0 && code < 256 {
+						f.diff[byte(code)] = r
+					}
+					code++
+				}
+			}
+		}
+	}
+	return f
+}
+
+func (f *pdfFont) decode(out, s []byte) []byte {
+	if f.cmap != nil {
+		return f.cmap.decode(out, s, f.composite)
+	}
+	if f.composite {
+		return out // CIDs without ToUnicode carry no text
+	}
+	for _, c := range s {
+		if r, ok := f.diff[c]; ok {
+			out = utf8.AppendRune(out, r)
+			continue
+		}
+		out = utf8.AppendRune(out, winAnsiRune(c))
+	}
+	return out
+}
+
+// winAnsiRune maps WinAnsiEncoding (close to cp1252) to Unicode.
+func winAnsiRune(c byte) rune {
+	if c >= 0x80 && c < 0xa0 {
+		return cp1252[c-0x80]
+	}
+	return rune(c)
+}
+
+var cp1252 = [32]rune{
+	'€', '•', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '•', 'Ž', '•',
+	'•', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '•', 'ž', 'Ÿ',
+}
+
+// glyphRune knows the Adobe glyph names that matter for secrets: letters,
+// digits, ASCII punctuation and uniXXXX.
+func glyphRune(name string) rune {
+	if len(name) == 1 {
+		return rune(name[0])
+	}
+	if len(name) == 7 && name[:3] == "uni" {
+		if v, err := strconv.ParseUint(name[3:], 16, 16); err == nil {
+			return rune(v)
+		}
+	}
+	if r, ok := glyphNames[name]; ok {
+		return r
+	}
+	return 0
+}
+
+var glyphNames = map[string]rune{
+	"space": ' ', "exclam": '!', "quotedbl": '"', "numbersign": '#', "dollar": '$', "percent": '%',
+	"ampersand": '&', "quotesingle": '\'', "quoteright": '\'', "parenleft": '(', "parenright": ')',
+	"asterisk": '*', "plus": '+', "comma": ',', "hyphen": '-', "minus": '-', "period": '.', "slash": '/',
+	"zero": '0', "one": '1', "two": '2', "three": '3', "four": '4', "five": '5', "six": '6', "seven": '7',
+	"eight": '8', "nine": '9', "colon": ':', "semicolon": ';', "less": '<', "equal": '=', "greater": '>',
+	"question": '?', "at": '@', "bracketleft": '[', "backslash": '\\', "bracketright": ']',
+	"asciicircum": '^', "underscore": '_', "grave": '`', "quoteleft": '`', "braceleft": '{', "bar": '|',
+	"braceright": '}', "asciitilde": '~',
+}
+
+// pdfCMap is the subset of a ToUnicode CMap needed for text: codespace
+// ranges (code lengths) and bfchar/bfrange mappings.
+type pdfCMap struct {
+	lens []int // code lengths from codespacerange, shortest first
+	m    map[cmapKey]string
+}
+
+type cmapKey struct {
+	n    int
+	code uint32
+}
+
+func parseCMap(data []byte) *pdfCMap {
+	cm := &pdfCMap{m: make(map[cmapKey]string)}
+	l := &pdfLexer{b: data}
+	var ops []any
+	code := func(b []byte) (cmapKey, bool) {
+		if len(b) == 0 || len(b) > 4 {
+			return cmapKey{}, false
+		}
+		var c uint32
+		for _, x := range b {
+			c = c<<8 | uint32(x)
+		}
+		return cmapKey{len(b), c}, true
+	}
+	for {
+		at := l.pos
+		v, err := l.object(0)
+		if err == io.EOF || err != nil && l.pos == at {
+			break
+		}
+		if err != nil {
+			continue
+		}
+		op, isOp := v.(pdfOp)
+		if !isOp {
+			ops = append(ops, v)
+			continue
+		}
+		switch op {
+		case "endcodespacerange":
+			for i := 0; i+1 < len(ops); i += 2 {
+				if lo, ok := ops[i].([]byte); ok && len(lo) > 0 && len(lo) <= 4 {
+					cm.addLen(len(lo))
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(ops); i += 2 {
+				src, _ := ops[i].([]byte)
+				dst, _ := ops[i+1].([]byte)
+				if k, ok := code(src); ok {
+					cm.m[k] = utf16BE(dst)
+					cm.addLen(len(src))
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(ops); i += 3 {
+				lo, _ := ops[i].([]byte)
+				hi, _ := ops[i+1].([]byte)
+				klo, ok1 := code(lo)
+				khi, ok2 := code(hi)
+				if !ok1 || !ok2 || khi.code < klo.code || khi.code-klo.code > 0xffff {
+					continue
+				}
+				cm.addLen(len(lo))
+				switch dst := ops[i+2].(type) {
+				case []byte:
+					base := []rune(utf16BE(dst))
+					for c := klo.code; c <= khi.code && len(base) > 0; c++ {
+						rs := append([]rune(nil), base...)
+						rs[len(rs)-1] += rune(c - klo.code)
+						cm.m[cmapKey{len(lo), c}] = string(rs)
+					}
+				case []any:
+					for j, el := range dst {
+						if b, ok := el.([]byte); ok && klo.code+uint32(j) <= khi.code {
+							cm.m[cmapKey{len(lo), klo.code + uint32(j)}] = utf16BE(b)
+						}
+					}
+				}
+			}
+		}
+		ops = ops[:0]
+	}
+	return cm
+}
+
+func (cm *pdfCMap) addLen(n int) {
+	for _, l := range cm.lens {
+		if l == n {
+			return
+		}
+	}
+	cm.lens = append(cm.lens, n)
+	for i := len(cm.lens) - 1; i > 0 && cm.lens[i] < cm.lens[i-1]; i-- {
+		cm.lens[i], cm.lens[i-1] = cm.lens[i-1], cm.lens[i]
+	}
+}
+
+func (cm *pdfCMap) decode(out, s []byte, composite bool) []byte {
+	lens := cm.lens
+	if len(lens) == 0 {
+		lens = []int{1}
+		if composite {
+			lens = []int{2}
+		}
+	}
+	for i := 0; i < len(s); {
+		matched := false
+		for _, n := range lens {
+			if i+n > len(s) {
+				break
+			}
+			var c uint32
+			for _, b := range s[i : i+n] {
+				c = c<<8 | uint32(b)
+			}
+			if u, ok := cm.m[cmapKey{n, c}]; ok {
+				out = append(out, u...)
+				i += n
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			i += lens[0] // unmapped code: no text
+		}
+	}
+	return out
+}
+
+func utf16BE(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return string(utf16.Decode(u))
+}
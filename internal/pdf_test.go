@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// buildPDF numbers objs from 1 and writes a file with a valid xref table.
+func buildPDF(trailer string, objs ...string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n")
+	offs := make([]int, len(objs))
+	for i, o := range objs {
+		offs[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objs)+1)
+	for _, off := range offs {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d %s >>\nstartxref\n%d\n%%%%EOF\n", len(objs)+1, trailer, xref)
+	return buf.Bytes()
+}
+
+func pdfStreamObj(dict string, data []byte) string {
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+func flate(data string) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, _ = zw.Write([]byte(data))
+	_ = zw.Close()
+	return buf.Bytes()
+}
+
+func TestExtractPDF_PagesAndOperators(t *testing.T) {
+	page1 := flate("BT /F1 12 Tf 72 700 Td (api_key=) Tj (AKIA) Tj 0 -14 Td [(sec) -50 (ret) -400 (phrase)] TJ ET")
+	// \101\102 = AB; <0048> via the CMap = H
+	page2 := []byte(`BT /F2 10 Tf 1 0 0 1 72 700 Tm (line \(one\) \101\102) Tj T* (two) Tj ET BT /F3 10 Tf <00480069> Tj ET`)
+	cmap := []byte("begincmap 1 begincodespacerange <0000> <FFFF> endcodespacerange\n" +
+		"1 beginbfchar <0048> <0048> endbfchar 1 beginbfrange <0069> <0069> <0069> endbfrange endcmap")
+	data := buildPDF("/Root 1 0 R",
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 /Resources << /Font << /F1 7 0 R /F2 7 0 R /F3 8 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 5 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /Contents [6 0 R] >>",
+		pdfStreamObj("/Filter /FlateDecode", page1),
+		pdfStreamObj("", page2),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type0 /Encoding /Identity-H /ToUnicode 9 0 R >>",
+		pdfStreamObj("", cmap),
+	)
+	got := extractAll(t, extractPDF, data)
+	want := []string{"page 1=api_key=AKIA\nsecret phrase", "page 2=line (one) AB\ntwo Hi"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestExtractPDF_ObjectStream(t *testing.T) {
+	// catalog and page tree live in a compressed object stream, as written by PDF 1.5+
+	objs := []string{"<< /Type /Catalog /Pages 3 0 R >>", "<< /Type /Pages /Kids [4 0 R] /Count 1 >>", "<< /Type /Page /Contents 5 0 R >>"}
+	var hdr, inner string
+	for i, o := range objs {
+		hdr += fmt.Sprintf("%d %d ", i+2, len(inner))
+		inner += o + " "
+	}
+	data := buildPDF("/Root 2 0 R",
+		pdfStreamObj(fmt.Sprintf("/Type /ObjStm /N 3 /First %d /Filter /FlateDecode", len(hdr)), flate(hdr+inner)),
+		"null", "null", "null",
+		pdfStreamObj("", []byte("BT (token=abc) Tj ET")),
+	)
+	// the placeholders 2-4 are defined directly and would win; drop them
+	data = bytes.Replace(data, []byte("2 0 obj\nnull\nendobj\n"), nil, 1)
+	data = bytes.Replace(data, []byte("3 0 obj\nnull\nendobj\n"), nil, 1)
+	data = bytes.Replace(data, []byte("4 0 obj\nnull\nendobj\n"), nil, 1)
+	got := extractAll(t, extractPDF, data)
+	if !slices.Equal(got, []string{"page 1=token=abc"}) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestExtractPDF_EncryptedAndImageOnly(t *testing.T) {
+	enc := buildPDF("/Root 1 0 R /Encrypt 3 0 R",
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [] /Count 0 >>",
+		"<< /Filter /Standard /V 2 >>")
+	err := extractPDF(bytes.NewReader(enc), int64(len(enc)), func(string, []byte) { t.Error("unexpected text") })
+	if !errors.Is(err, ErrEncrypted) {
+		t.Fatalf("encrypted: got %v", err)
+	}
+
+	img := buildPDF("/Root 1 0 R",
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Contents 4 0 R /Resources << /XObject << /Im0 5 0 R >> >> >>",
+		pdfStreamObj("", []byte("q 595 0 0 842 0 0 cm /Im0 Do Q")),
+		pdfStreamObj("/Type /XObject /Subtype /Image /Width 1 /Height 1 /Filter /DCTDecode", []byte{0xff, 0xd8, 0xff, 0xd9}),
+	)
+	err = extractPDF(bytes.NewReader(img), int64(len(img)), func(string, []byte) { t.Error("unexpected text") })
+	if !errors.Is(err, ErrNoText) {
+		t.Fatalf("image-only: got %v", err)
+	}
+}
+
+func TestExtractPDF_UnterminatedHex(t *testing.T) {
+	// a hex string without ">" used to leave the lexer in place forever
+	for _, content := range []string{"BT <41 Tj", "[ <41", "<< /A <41", "BT (key=1) Tj ET <41"} {
+		data := buildPDF("/Root 1 0 R",
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+			"<< /Type /Page /Contents 4 0 R >>",
+			pdfStreamObj("", []byte(content)),
+		)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_ = extractPDF(bytes.NewReader(data), int64(len(data)), func(string, []byte) {})
+			parseCMap([]byte("begincmap " + content))
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q: no progress", content)
+		}
+	}
+}
+
+func TestUnpredictPNGUp(t *testing.T) {
+	// two rows of 2 columns, filter 2 (Up) on the second
+	got, err := unpredict([]byte{0, 1, 2, 2, 1, 1}, pdfDict{"Predictor": int64(12), "Columns": int64(2)})
+	if err != nil || !bytes.Equal(got, []byte{1, 2, 2, 3}) {
+		t.Fatalf("got %v, %v", got, err)
+	}
+}
+
+func TestScanRegularFile_PDF(t *testing.T) {
+	dir := t.TempDir()
+	fp := filepath.Join(dir, "invoice.pdf")
+	_ = os.WriteFile(fp, buildPDF("/Root 1 0 R",
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 >>",
+		"<< /Type /Page /Contents 5 0 R >>",
+		"<< /Type /Page /Contents 6 0 R >>",
+		pdfStreamObj("", []byte("BT (intro) Tj ET")),
+		pdfStreamObj("/Filter /FlateDecode", flate("BT (IBAN) Tj ( DE89) Tj ET")),
+	), 0644)
+	rules := &RuleSet{Patterns: []Pattern{&PlainPattern{s: []byte("IBAN DE")}}}
+	var got []MatchResult
+	var matchCnt, errCnt atomic.Int64
+	NewFileScanner().scanRegularFile(context.Background(), fp, rules, ScanOptions{}, func(m MatchResult) { got = append(got, m) }, &matchCnt, &errCnt)
+	if len(got) != 1 || got[0].Location != "page 2" || got[0].LineNumber != 0 || errCnt.Load() != 0 {
+		t.Fatalf("unexpected: %+v", got)
+	}
+
+	_ = os.WriteFile(fp, buildPDF("/Root 1 0 R /Encrypt 2 0 R", "<< /Type /Catalog >>", "<< /Filter /Standard >>"), 0644)
+	got = nil
+	NewFileScanner().scanRegularFile(context.Background(), fp, rules, ScanOptions{}, func(m MatchResult) { got = append(got, m) }, &matchCnt, &errCnt)
+	if len(got) != 1 || !errors.Is(got[0].Error, ErrEncrypted) || errCnt.Load() != 1 {
+		t.Fatalf("unexpected: %+v", got)
+	}
+}
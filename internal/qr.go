@@ -0,0 +1,705 @@
+package internal
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif" // decoders for --qr
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// With --qr the pixels of PNG, JPEG and GIF images (the first frame) are
+// searched for QR codes and every decoded payload becomes a unit located by
+// the symbol's bounding box in the picture ("QR 120,40-360,280"). Images over
+// --qr-max-pixels are not decoded; the search of one image stops after
+// --qr-timeout and keeps what it found by then.
+
+const (
+	defaultQRMaxPixels = 40_000_000
+	defaultQRTimeout   = 5 * time.Second
+	maxQRCodes         = 16  // per image
+	maxQRFinders       = 12  // finder candidates tried in triples
+	qrMinBinarize      = 40  // smaller images use the global threshold only
+	qrBlock            = 8   // local threshold block size
+	qrMinContrast      = 24  // blocks with less spread are taken as light
+	qrMaxModules       = 177 // version 40
+	qrRowSkip          = 3   // scan line step; a finder centre is 3 modules tall
+)
+
+var qrExts = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true}
+
+// withQR appends the QR codes found in the picture to the units of an image
+// extractor.
+func withQR(ex docExtractor, opts ScanOptions) docExtractor {
+	return func(r io.ReaderAt, size int64, emit func(loc string, text []byte)) error {
+		err := ex(r, size, emit)
+		for _, c := range decodeQRImage(io.NewSectionReader(r, 0, size), opts.QRMaxPixels, opts.QRTimeout) {
+			emitText(emit, c.loc, c.text)
+		}
+		return err
+	}
+}
+
+// qrCode is a decoded symbol and its location.
+type qrCode struct {
+	loc  string
+	text []byte
+}
+
+// decodeQRImage decodes the image and returns the QR codes found within
+// the limits; undecodable images yield nothing.
+func decodeQRImage(r io.ReadSeeker, maxPixels int64, timeout time.Duration) []qrCode {
+	if maxPixels <= 0 {
+		maxPixels = defaultQRMaxPixels
+	}
+	if timeout <= 0 {
+		timeout = defaultQRTimeout
+	}
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil || int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return nil
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil
+	}
+	lum, w, h := luminance(img)
+	var codes []qrCode
+	seen := map[string]bool{}
+	for _, bin := range []func([]uint8, int, int) *bitImage{hybridBinarize, globalBinarize} {
+		if w < qrMinBinarize || h < qrMinBinarize {
+			bin = globalBinarize
+		}
+		for _, c := range bin(lum, w, h).findQR(deadline) {
+			if !seen[c.loc+string(c.text)] {
+				seen[c.loc+string(c.text)] = true
+				codes = append(codes, c)
+			}
+		}
+		if len(codes) > 0 || time.Now().After(deadline) {
+			break
+		}
+	}
+	return codes
+}
+
+// isQRImage reports whether --qr applies to name.
+func isQRImage(name string) bool {
+	return qrExts[strings.ToLower(filepath.Ext(name))]
+}
+
+// luminance converts an image to 8-bit grey, composing transparency over
+// white.
+func luminance(img image.Image) ([]uint8, int, int) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	lum := make([]uint8, w*h)
+	switch m := img.(type) {
+	case *image.YCbCr:
+		for y := range h {
+			copy(lum[y*w:(y+1)*w], m.Y[m.YOffset(b.Min.X, b.Min.Y+y):])
+		}
+	case *image.Gray:
+		for y := range h {
+			copy(lum[y*w:(y+1)*w], m.Pix[m.PixOffset(b.Min.X, b.Min.Y+y):])
+		}
+	case *image.Paletted:
+		pal := make([]uint8, 256)
+		for i, c := range m.Palette {
+			pal[i] = grey(c.RGBA())
+		}
+		for y := range h {
+			row := m.Pix[m.PixOffset(b.Min.X, b.Min.Y+y):]
+			for x := range w {
+				lum[y*w+x] = pal[row[x]]
+			}
+		}
+	default:
+		for y := range h {
+			for x := range w {
+				lum[y*w+x] = grey(img.At(b.Min.X+x, b.Min.Y+y).RGBA())
+			}
+		}
+	}
+	return lum, w, h
+}
+
+// grey is the luma of a premultiplied colour laid over white.
+func grey(r, g, b, a uint32) uint8 {
+	bg := 0xffff - a
+	return uint8(((r+bg)*299 + (g+bg)*587 + (b+bg)*114) / 1000 >> 8)
+}
+
+// bitImage is a thresholded image, true for dark.
+type bitImage struct {
+	w, h int
+	dark []bool
+}
+
+func (g *bitImage) at(x, y int) bool { return g.dark[y*g.w+x] }
+
+func (g *bitImage) in(x, y int) bool { return x >= 0 && y >= 0 && x < g.w && y < g.h }
+
+// globalBinarize thresholds the whole image at Otsu's level.
+func globalBinarize(lum []uint8, w, h int) *bitImage {
+	var hist [256]int
+	for _, v := range lum {
+		hist[v]++
+	}
+	var sum, sumB float64
+	for i, n := range hist {
+		sum += float64(i * n)
+	}
+	best, level, wB := 0.0, 127, 0
+	for t, n := range hist {
+		if wB += n; wB == 0 {
+			continue
+		}
+		wF := len(lum) - wB
+		if wF == 0 {
+			break
+		}
+		sumB += float64(t * n)
+		mB, mF := sumB/float64(wB), (sum-sumB)/float64(wF)
+		if v := float64(wB) * float64(wF) * (mB - mF) * (mB - mF); v > best {
+			best, level = v, t
+		}
+	}
+	g := &bitImage{w: w, h: h, dark: make([]bool, len(lum))}
+	for i, v := range lum {
+		g.dark[i] = int(v) <= level
+	}
+	return g
+}
+
+// hybridBinarize thresholds each 8x8 block at the mean of the 5x5 blocks
+// around it; flat blocks borrow their neighbours' level, so the solid
+// centre of a large finder pattern stays dark.
+func hybridBinarize(lum []uint8, w, h int) *bitImage {
+	bw, bh := (w+qrBlock-1)/qrBlock, (h+qrBlock-1)/qrBlock
+	black := make([]int, bw*bh)
+	for by := range bh {
+		for bx := range bw {
+			sum, n, lo, hi := 0, 0, 255, 0
+			for y := by * qrBlock; y < min((by+1)*qrBlock, h); y++ {
+				for x := bx * qrBlock; x < min((bx+1)*qrBlock, w); x++ {
+					v := int(lum[y*w+x])
+					sum += v
+					n++
+					lo, hi = min(lo, v), max(hi, v)
+				}
+			}
+			avg := sum / n
+			if hi-lo <= qrMinContrast {
+				avg = lo / 2
+				if bx > 0 && by > 0 {
+					nb := (black[(by-1)*bw+bx] + 2*black[by*bw+bx-1] + black[(by-1)*bw+bx-1]) / 4
+					if lo < nb {
+						avg = nb
+					}
+				}
+			}
+			black[by*bw+bx] = avg
+		}
+	}
+	g := &bitImage{w: w, h: h, dark: make([]bool, len(lum))}
+	for by := range bh {
+		cy := min(max(by, 2), max(bh-3, 2))
+		for bx := range bw {
+			cx := min(max(bx, 2), max(bw-3, 2))
+			sum, n := 0, 0
+			for y := cy - 2; y <= cy+2; y++ {
+				for x := cx - 2; x <= cx+2; x++ {
+					if x >= 0 && y >= 0 && x < bw && y < bh {
+						sum += black[y*bw+x]
+						n++
+					}
+				}
+			}
+			t := sum / n
+			for y := by * qrBlock; y < min((by+1)*qrBlock, h); y++ {
+				for x := bx * qrBlock; x < min((bx+1)*qrBlock, w); x++ {
+					g.dark[y*w+x] = int(lum[y*w+x]) <= t
+				}
+			}
+		}
+	}
+	return g
+}
+
+type qrPoint struct{ x, y float64 }
+
+func dist(a, b qrPoint) float64 { return math.Hypot(a.x-b.x, a.y-b.y) }
+
+// qrFinder is a finder pattern candidate: centre, module size, how many
+// scan lines confirmed it and whether its diagonal has the proportions too.
+type qrFinder struct {
+	qrPoint
+	size  float64
+	count int
+	diag  bool
+}
+
+// finderRatio checks runs against the 1:1:3:1:1 finder proportions.
+func finderRatio(st [5]int) bool {
+	total := 0
+	for _, n := range st {
+		if n == 0 {
+			return false
+		}
+		total += n
+	}
+	if total < 7 {
+		return false
+	}
+	m := float64(total) / 7
+	v := m / 2
+	return math.Abs(m-float64(st[0])) < v && math.Abs(m-float64(st[1])) < v &&
+		math.Abs(3*m-float64(st[2])) < 3*v && math.Abs(m-float64(st[3])) < v && math.Abs(m-float64(st[4])) < v
+}
+
+// crossCheck counts the finder runs through (x, y) along (dx, dy) and
+// returns the centre's offset from (x, y) along that direction and the
+// pattern's width, which must be within 40% of total unless total is 0.
+func (g *bitImage) crossCheck(x, y, dx, dy, maxCount, total int) (float64, int, bool) {
+	run := func(k, step int, dark bool, limit int) (int, int) {
+		n := 0
+		for g.in(x+k*dx, y+k*dy) && g.at(x+k*dx, y+k*dy) == dark && n <= limit {
+			n++
+			k += step
+		}
+		return n, k
+	}
+	var st [5]int
+	var k int
+	st[2], k = run(0, -1, true, math.MaxInt)
+	if !g.in(x+k*dx, y+k*dy) {
+		return 0, 0, false
+	}
+	if st[1], k = run(k, -1, false, maxCount); !g.in(x+k*dx, y+k*dy) || st[1] > maxCount {
+		return 0, 0, false
+	}
+	if st[0], _ = run(k, -1, true, maxCount); st[0] > maxCount {
+		return 0, 0, false
+	}
+	var n int
+	n, k = run(1, 1, true, math.MaxInt)
+	st[2] += n
+	if !g.in(x+k*dx, y+k*dy) {
+		return 0, 0, false
+	}
+	if st[3], k = run(k, 1, false, maxCount); !g.in(x+k*dx, y+k*dy) || st[3] > maxCount {
+		return 0, 0, false
+	}
+	if st[4], k = run(k, 1, true, maxCount); st[4] > maxCount {
+		return 0, 0, false
+	}
+	sum := st[0] + st[1] + st[2] + st[3] + st[4]
+	if total > 0 && 5*abs(sum-total) >= 2*total || !finderRatio(st) {
+		return 0, 0, false
+	}
+	return float64(k-st[4]-st[3]) - float64(st[2])/2, sum, true
+}
+
+// finders scans the rows for finder patterns, confirming each hit across.
+func (g *bitImage) finders(deadline time.Time) []qrFinder {
+	var found []qrFinder
+	add := func(st [5]int, x, y int) {
+		total := st[0] + st[1] + st[2] + st[3] + st[4]
+		cx := float64(x-st[4]-st[3]) - float64(st[2])/2
+		off, vTotal, ok := g.crossCheck(int(cx), y, 0, 1, st[2], total)
+		if !ok {
+			return
+		}
+		cy := float64(y) + off
+		off, hTotal, ok := g.crossCheck(int(cx), int(cy), 1, 0, st[2], total)
+		if !ok {
+			return
+		}
+		cx = float64(int(cx)) + off
+		_, _, diag := g.crossCheck(int(cx), int(cy), 1, 1, 2*st[2], 0)
+		size := float64(vTotal+hTotal) / 14
+		for i, f := range found {
+			if math.Abs(cy-f.y) <= size && math.Abs(cx-f.x) <= size && math.Abs(size-f.size) <= max(1, f.size) {
+				n := float64(f.count)
+				found[i] = qrFinder{qrPoint{(f.x*n + cx) / (n + 1), (f.y*n + cy) / (n + 1)}, (f.size*n + size) / (n + 1), f.count + 1, f.diag || diag}
+				return
+			}
+		}
+		found = append(found, qrFinder{qrPoint{cx, cy}, size, 1, diag})
+	}
+	for y := qrRowSkip - 1; y < g.h; y += qrRowSkip {
+		if time.Now().After(deadline) {
+			break
+		}
+		var st [5]int
+		cur := 0
+		for x := range g.w {
+			if g.at(x, y) {
+				if cur&1 == 1 {
+					cur++
+				}
+				st[cur]++
+				continue
+			}
+			if cur&1 == 1 {
+				st[cur]++
+				continue
+			}
+			if cur < 4 {
+				cur++
+				st[cur]++
+				continue
+			}
+			if finderRatio(st) {
+				add(st, x, y)
+				st, cur = [5]int{}, 0
+				continue
+			}
+			st, cur = [5]int{st[2], st[3], st[4], 1, 0}, 3
+		}
+		if cur == 4 && finderRatio(st) {
+			add(st, g.w, y)
+		}
+	}
+	// candidates failing the diagonal check are mostly noise, but a smudged
+	// corner can cause that too
+	slices.SortStableFunc(found, func(a, b qrFinder) int {
+		if a.diag != b.diag {
+			return int(b2i(b.diag) - b2i(a.diag))
+		}
+		return b.count - a.count
+	})
+	return found
+}
+
+// findQR decodes the symbols whose finder patterns form a right angle,
+// most square-looking triples first.
+func (g *bitImage) findQR(deadline time.Time) []qrCode {
+	fs := g.finders(deadline)
+	if len(fs) > maxQRFinders {
+		fs = fs[:maxQRFinders]
+	}
+	type triple struct {
+		tl, tr, bl int
+		score      float64
+	}
+	var cands []triple
+	for i := range fs {
+		for j := i + 1; j < len(fs); j++ {
+			for k := j + 1; k < len(fs); k++ {
+				lo := min(fs[i].size, fs[j].size, fs[k].size)
+				if max(fs[i].size, fs[j].size, fs[k].size) > 1.5*lo {
+					continue
+				}
+				// the corner opposite the longest side is top-left
+				var t triple
+				dij, djk, dik := dist(fs[i].qrPoint, fs[j].qrPoint), dist(fs[j].qrPoint, fs[k].qrPoint), dist(fs[i].qrPoint, fs[k].qrPoint)
+				switch {
+				case djk >= dij && djk >= dik:
+					t = triple{i, j, k, 0}
+				case dik >= dij && dik >= djk:
+					t = triple{j, i, k, 0}
+				default:
+					t = triple{k, i, j, 0}
+				}
+				a, b, c := fs[t.tl], fs[t.tr], fs[t.bl]
+				if (b.x-a.x)*(c.y-a.y)-(b.y-a.y)*(c.x-a.x) < 0 {
+					t.tr, t.bl = t.bl, t.tr
+				}
+				l1, l2, hyp := dist(a.qrPoint, b.qrPoint), dist(a.qrPoint, c.qrPoint), max(dij, djk, dik)
+				if min(l1, l2) < 7*lo || math.Abs(l1-l2) > 0.5*max(l1, l2) {
+					continue
+				}
+				t.score = math.Abs(l1-l2)/max(l1, l2) + math.Abs(hyp*hyp-l1*l1-l2*l2)/(hyp*hyp)
+				if t.score < 0.8 {
+					cands = append(cands, t)
+				}
+			}
+		}
+	}
+	slices.SortStableFunc(cands, func(a, b triple) int {
+		switch {
+		case a.score < b.score:
+			return -1
+		case a.score > b.score:
+			return 1
+		}
+		return 0
+	})
+	var codes []qrCode
+	used := make([]bool, len(fs))
+	for _, t := range cands {
+		if len(codes) == maxQRCodes || time.Now().After(deadline) {
+			break
+		}
+		if used[t.tl] || used[t.tr] || used[t.bl] {
+			continue
+		}
+		text, loc, ok := g.decodeAt(fs[t.tl], fs[t.tr], fs[t.bl])
+		if !ok {
+			continue
+		}
+		used[t.tl], used[t.tr], used[t.bl] = true, true, true
+		codes = append(codes, qrCode{loc, text})
+	}
+	return codes
+}
+
+// decodeAt samples and decodes the symbol anchored by three finder patterns.
+func (g *bitImage) decodeAt(tl, tr, bl qrFinder) ([]byte, string, bool) {
+	size := g.moduleSize(tl, tr, bl)
+	n := (int(math.Round(dist(tl.qrPoint, tr.qrPoint)/size))+int(math.Round(dist(tl.qrPoint, bl.qrPoint)/size)))/2 + 7
+	var dims []int
+	switch n & 3 {
+	case 0:
+		dims = []int{n + 1, n - 3, n + 5}
+	case 1:
+		dims = []int{n, n - 4, n + 4}
+	case 2:
+		dims = []int{n - 1, n + 3, n - 5}
+	default:
+		dims = []int{n - 2, n + 2}
+	}
+	for _, dim := range dims {
+		if dim < 21 || dim > qrMaxModules {
+			continue
+		}
+		if text, loc, ok := g.decodeDim(tl, tr, bl, size, dim, true); ok {
+			return text, loc, true
+		}
+	}
+	return nil, "", false
+}
+
+// moduleSize measures the finder rings along the sides of the symbol, which
+// unlike the scan lines are not stretched by rotation.
+func (g *bitImage) moduleSize(tl, tr, bl qrFinder) float64 {
+	var sum float64
+	n := 0
+	for _, s := range [][2]qrPoint{{tl.qrPoint, tr.qrPoint}, {tr.qrPoint, tl.qrPoint}, {tl.qrPoint, bl.qrPoint}, {bl.qrPoint, tl.qrPoint}} {
+		p, q := s[0], s[1]
+		in, out := g.ringDist(p, q), g.ringDist(p, qrPoint{2*p.x - q.x, 2*p.y - q.y})
+		switch {
+		case in > 0 && out > 0:
+			sum += (in + out) / 7
+			n++
+		case in > 0:
+			sum += in / 3.5
+			n++
+		}
+	}
+	if n == 0 {
+		return (tl.size + tr.size + bl.size) / 3
+	}
+	return sum / float64(n)
+}
+
+// ringDist is the distance from the finder centre p toward q to the outer
+// edge of its dark ring, 3.5 modules; 0 if the ring is not found.
+func (g *bitImage) ringDist(p, q qrPoint) float64 {
+	d := dist(p, q)
+	if d == 0 {
+		return 0
+	}
+	dx, dy := (q.x-p.x)/d, (q.y-p.y)/d
+	state := 0 // centre, light ring, dark ring
+	for s := 0.0; s < d; s++ {
+		x, y := int(p.x+dx*s), int(p.y+dy*s)
+		if !g.in(x, y) {
+			return 0
+		}
+		if g.at(x, y) != (state != 1) {
+			if state++; state == 3 {
+				return s
+			}
+		}
+	}
+	return 0
+}
+
+func (g *bitImage) decodeDim(tl, tr, bl qrFinder, size float64, dim int, checkVersion bool) ([]byte, string, bool) {
+	version := (dim - 17) / 4
+	br := qrPoint{tr.x - tl.x + bl.x, tr.y - tl.y + bl.y}
+	src := float64(dim) - 3.5
+	var tries []qrPoint
+	if version >= 2 {
+		// the bottom-right alignment pattern sits 3 modules in from the corner
+		c := 1 - 3/(float64(dim)-7)
+		est := qrPoint{tl.x + c*(br.x-tl.x), tl.y + c*(br.y-tl.y)}
+		for _, allow := range []float64{4, 8, 16} {
+			if p, ok := g.alignment(est, size, allow*size); ok {
+				tries = append(tries, p)
+				break
+			}
+		}
+	}
+	tries = append(tries, br)
+	for i, p := range tries {
+		s := src
+		if i < len(tries)-1 {
+			s -= 3
+		}
+		t := quadToQuad(3.5, 3.5, float64(dim)-3.5, 3.5, s, s, 3.5, float64(dim)-3.5,
+			tl.x, tl.y, tr.x, tr.y, p.x, p.y, bl.x, bl.y)
+		m := g.sample(t, dim)
+		if checkVersion && version >= 7 {
+			if v := qrVersionInfo(m); v != 0 && v != version {
+				return g.decodeDim(tl, tr, bl, size, 17+4*v, false)
+			}
+		}
+		text, err := decodeQRMatrix(m, version)
+		if err != nil {
+			text, err = decodeQRMatrix(m.transpose(), version) // mirrored
+		}
+		if err != nil {
+			continue
+		}
+		x0, y0, x1, y1 := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+		for _, c := range [][2]float64{{0, 0}, {float64(dim), 0}, {float64(dim), float64(dim)}, {0, float64(dim)}} {
+			x, y := t.apply(c[0], c[1])
+			x0, y0, x1, y1 = min(x0, x), min(y0, y), max(x1, x), max(y1, y)
+		}
+		clamp := func(v float64, hi int) int { return min(max(int(math.Round(v)), 0), hi) }
+		return text, fmt.Sprintf("QR %d,%d-%d,%d", clamp(x0, g.w), clamp(y0, g.h), clamp(x1, g.w), clamp(y1, g.h)), true
+	}
+	return nil, "", false
+}
+
+// alignment looks for an alignment pattern (dark module in a light ring in
+// a dark ring) within radius of est and returns the centre closest to it.
+func (g *bitImage) alignment(est qrPoint, size, radius float64) (qrPoint, bool) {
+	near := func(n int) bool { return math.Abs(float64(n)-size) < size/2+1 }
+	// runs returns the centre offset of the dark run through (x, y) along
+	// (dx, dy) if it is framed by module-sized light runs and dark beyond
+	runs := func(x, y, dx, dy int) (float64, bool) {
+		count := func(k, step int, dark bool) (int, int) {
+			n := 0
+			for g.in(x+k*dx, y+k*dy) && g.at(x+k*dx, y+k*dy) == dark && n <= int(2*size)+2 {
+				n++
+				k += step
+			}
+			return n, k
+		}
+		c1, k1 := count(0, -1, true)
+		c2, k2 := count(1, 1, true)
+		l1, k1 := count(k1, -1, false)
+		l2, k2 := count(k2, 1, false)
+		d1, _ := count(k1, -1, true)
+		d2, _ := count(k2, 1, true)
+		if !near(c1+c2) || !near(l1) || !near(l2) || float64(d1) < size/2 || float64(d2) < size/2 {
+			return 0, false
+		}
+		return float64(c2-c1+1) / 2, true
+	}
+	best, bestD := qrPoint{}, math.Inf(1)
+	for y := max(int(est.y-radius), 0); y < min(int(est.y+radius), g.h); y++ {
+		for x := max(int(est.x-radius), 0); x < min(int(est.x+radius), g.w); x++ {
+			// test each dark run once, at its first pixel
+			if !g.at(x, y) || x > 0 && g.at(x-1, y) {
+				continue
+			}
+			ox, ok := runs(x, y, 1, 0)
+			if !ok {
+				continue
+			}
+			cx := int(float64(x) + ox)
+			oy, ok := runs(cx, y, 0, 1)
+			if !ok {
+				continue
+			}
+			if _, ok := runs(cx, int(float64(y)+oy), 1, 0); !ok {
+				continue
+			}
+			p := qrPoint{float64(x) + ox + 0.5, float64(y) + oy + 0.5}
+			if d := dist(p, est); d < bestD {
+				best, bestD = p, d
+			}
+		}
+	}
+	return best, !math.IsInf(bestD, 1)
+}
+
+// sample reads the module centres through the transform; modules outside
+// the image are light.
+func (g *bitImage) sample(t perspective, dim int) *qrMatrix {
+	m := newQRMatrix(dim)
+	for y := range dim {
+		for x := range dim {
+			px, py := t.apply(float64(x)+0.5, float64(y)+0.5)
+			ix, iy := int(math.Floor(px)), int(math.Floor(py))
+			if g.in(ix, iy) {
+				m.set(x, y, g.at(ix, iy))
+			}
+		}
+	}
+	return m
+}
+
+func (m *qrMatrix) transpose() *qrMatrix {
+	t := newQRMatrix(m.n)
+	for y := range m.n {
+		for x := range m.n {
+			t.set(y, x, m.get(x, y))
+		}
+	}
+	return t
+}
+
+// perspective maps (x, y) to ((a11 x + a21 y + a31) / d, (a12 x + a22 y +
+// a32) / d) with d = a13 x + a23 y + a33.
+type perspective struct{ a11, a21, a31, a12, a22, a32, a13, a23, a33 float64 }
+
+func (p perspective) apply(x, y float64) (float64, float64) {
+	d := p.a13*x + p.a23*y + p.a33
+	return (p.a11*x + p.a21*y + p.a31) / d, (p.a12*x + p.a22*y + p.a32) / d
+}
+
+// quadToQuad maps the quadrilateral (x0,y0)...(x3,y3) onto (u0,v0)...(u3,v3).
+func quadToQuad(x0, y0, x1, y1, x2, y2, x3, y3, u0, v0, u1, v1, u2, v2, u3, v3 float64) perspective {
+	return squareToQuad(u0, v0, u1, v1, u2, v2, u3, v3).times(squareToQuad(x0, y0, x1, y1, x2, y2, x3, y3).adjoint())
+}
+
+func squareToQuad(x0, y0, x1, y1, x2, y2, x3, y3 float64) perspective {
+	dx3, dy3 := x0-x1+x2-x3, y0-y1+y2-y3
+	if dx3 == 0 && dy3 == 0 {
+		return perspective{x1 - x0, x2 - x1, x0, y1 - y0, y2 - y1, y0, 0, 0, 1}
+	}
+	dx1, dx2, dy1, dy2 := x1-x2, x3-x2, y1-y2, y3-y2
+	den := dx1*dy2 - dx2*dy1
+	a13 := (dx3*dy2 - dx2*dy3) / den
+	a23 := (dx1*dy3 - dx3*dy1) / den
+	return perspective{x1 - x0 + a13*x1, x3 - x0 + a23*x3, x0, y1 - y0 + a13*y1, y3 - y0 + a23*y3, y0, a13, a23, 1}
+}
+
+func (p perspective) adjoint() perspective {
+	return perspective{
+		p.a22*p.a33 - p.a23*p.a32, p.a23*p.a31 - p.a21*p.a33, p.a21*p.a32 - p.a22*p.a31,
+		p.a13*p.a32 - p.a12*p.a33, p.a11*p.a33 - p.a13*p.a31, p.a12*p.a31 - p.a11*p.a32,
+		p.a12*p.a23 - p.a13*p.a22, p.a13*p.a21 - p.a11*p.a23, p.a11*p.a22 - p.a12*p.a21,
+	}
+}
+
+func (p perspective) times(o perspective) perspective {
+	return perspective{
+		p.a11*o.a11 + p.a21*o.a12 + p.a31*o.a13,
+		p.a11*o.a21 + p.a21*o.a22 + p.a31*o.a23,
+		p.a11*o.a31 + p.a21*o.a32 + p.a31*o.a33,
+		p.a12*o.a11 + p.a22*o.a12 + p.a32*o.a13,
+		p.a12*o.a21 + p.a22*o.a22 + p.a32*o.a23,
+		p.a12*o.a31 + p.a22*o.a32 + p.a32*o.a33,
+		p.a13*o.a11 + p.a23*o.a12 + p.a33*o.a13,
+		p.a13*o.a21 + p.a23*o.a22 + p.a33*o.a23,
+		p.a13*o.a31 + p.a23*o.a32 + p.a33*o.a33,
+	}
+}
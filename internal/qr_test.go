@@ -0,0 +1,236 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// Symbols made by an independent encoder: version 1-L "password=hunter2"
+// and version 4-Q, which has an alignment pattern.
+var (
+	qrPassword = []string{
+		"#######...##..#######",
+		"#.....#..###..#.....#",
+		"#.###.#.#.#...#.###.#",
+		"#.###.#..#.#..#.###.#",
+		"#.###.#..#.#..#.###.#",
+		"#.....#..##...#.....#",
+		"#######.#.#.#.#######",
+		"........#.#..........",
+		"###.#####.#####...#..",
+		"##.##..#.####..###.##",
+		"....#####.###.#######",
+		"...###..##.#.#.##..#.",
+		"#.....#.##.###..##..#",
+		"........###.#.#.##..#",
+		"#######.##########.##",
+		"#.....#.#..##...#..#.",
+		"#.###.#.##.#...#.#..#",
+		"#.###.#....#######...",
+		"#.###.#.##.##.####..#",
+		"#.....#.#.......#..#.",
+		"#######.#.####.###.##",
+	}
+	qrSeed = []string{
+		"#######.#..##.#..###.###..#######",
+		"#.....#.#####.....##..###.#.....#",
+		"#.###.#.###.#....##.####..#.###.#",
+		"#.###.#.#....#.####.#####.#.###.#",
+		"#.###.#.#..####...#.###.#.#.###.#",
+		"#.....#..####.#..#.###.##.#.....#",
+		"#######.#.#.#.#.#.#.#.#.#.#######",
+		"........##...##.####.###.........",
+		".##.#.##....##...#..##....#.#####",
+		".#.##..#.##.##..##..##..###.....#",
+		"..#####.#######.#.#.#.#.###.#####",
+		"##.......#.......#######..#.#..#.",
+		"#..#..##.......##.#.#.#.####....#",
+		"#.#.##..##..#.....###.#####..####",
+		".#.##.#...#..#.##.####.####.#####",
+		"#..#.#..#.#.##..###.##..#..#....#",
+		"#....###...##.#.#..#.#.####.....#",
+		"..##.#...#..#####.#.#....##....##",
+		".###..#...#..######.##..#.#.###.#",
+		"....##.###..#...#..#...##..#....#",
+		"##.##.##.#...###.......####......",
+		"....##.#...#.###.###...#..##....#",
+		"#...#.#..##.##.#.....#...##..####",
+		".#.#.........#..#.##.#.##.##...##",
+		"#..#.##.#....##.....##..#####...#",
+		"........###.###.#.#..#.##...#..##",
+		"#######.##..#....#..#.###.#.#.###",
+		"#.....#..#.##....#...##.#...##.##",
+		"#.###.#.###.#..####.#.#.######.#.",
+		"#.###.#..#...#...######....###.##",
+		"#.###.#.##.###.######...#...###.#",
+		"#.....#.##..#..###..#####..##..#.",
+		"#######.....##...###.#.##....#.##",
+	}
+)
+
+// drawQR paints a symbol with scale-pixel modules at (x0, y0), turned a
+// quarter clockwise if rot is set.
+func drawQR(img *image.Gray, grid []string, x0, y0, scale int, rot bool) {
+	n := len(grid)
+	for y, row := range grid {
+		for x, c := range row {
+			if c != '#' {
+				continue
+			}
+			px, py := x, y
+			if rot {
+				px, py = n-1-y, x
+			}
+			for dy := range scale {
+				for dx := range scale {
+					img.SetGray(x0+px*scale+dx, y0+py*scale+dy, color.Gray{})
+				}
+			}
+		}
+	}
+}
+
+func qrScene() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 300, 200))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	drawQR(img, qrPassword, 20, 20, 4, false)
+	drawQR(img, qrSeed, 140, 30, 4, true)
+	return img
+}
+
+func qrTexts(codes []qrCode, withLoc bool) []string {
+	var out []string
+	for _, c := range codes {
+		if withLoc {
+			out = append(out, c.loc+"="+string(c.text))
+		} else {
+			out = append(out, string(c.text))
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+func TestDecodeQRImage(t *testing.T) {
+	var p, j bytes.Buffer
+	if err := png.Encode(&p, qrScene()); err != nil {
+		t.Fatal(err)
+	}
+	got := qrTexts(decodeQRImage(bytes.NewReader(p.Bytes()), 0, 0), true)
+	want := []string{"QR 140,30-272,162=seed: legal winner thank year wave", "QR 20,20-104,104=password=hunter2"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("png: got %q", got)
+	}
+
+	if err := jpeg.Encode(&j, qrScene(), &jpeg.Options{Quality: 40}); err != nil {
+		t.Fatal(err)
+	}
+	if got := qrTexts(decodeQRImage(bytes.NewReader(j.Bytes()), 0, 0), false); len(got) != 2 || got[0] != "password=hunter2" {
+		t.Fatalf("jpeg: got %q", got)
+	}
+
+	if got := decodeQRImage(bytes.NewReader(p.Bytes()), 300*200-1, 0); got != nil {
+		t.Fatalf("over the pixel limit: got %q", qrTexts(got, true))
+	}
+}
+
+// rsEncode appends ec Reed-Solomon check bytes to data.
+func rsEncode(data []byte, ec int) []byte {
+	gen := []byte{1} // highest degree first
+	for i := range ec {
+		next := make([]byte, len(gen)+1)
+		for j, g := range gen {
+			next[j] ^= g
+			next[j+1] ^= gfMul(g, gfExp[i])
+		}
+		gen = next
+	}
+	rem := make([]byte, ec)
+	for _, d := range data {
+		f := d ^ rem[0]
+		copy(rem, rem[1:])
+		rem[ec-1] = 0
+		for j := range rem {
+			rem[j] ^= gfMul(gen[j+1], f)
+		}
+	}
+	return append(slices.Clone(data), rem...)
+}
+
+func TestRSCorrect(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, ec := range []int{7, 10, 22, 30} {
+		data := make([]byte, 40)
+		rng.Read(data)
+		block := rsEncode(data, ec)
+		for errs := 0; errs <= ec/2; errs++ {
+			bad := slices.Clone(block)
+			for _, i := range rng.Perm(len(bad))[:errs] {
+				bad[i] ^= byte(1 + rng.Intn(255))
+			}
+			if err := rsCorrect(bad, ec); err != nil || !bytes.Equal(bad, block) {
+				t.Fatalf("ec %d, %d errors: %v", ec, errs, err)
+			}
+		}
+	}
+}
+
+func TestScanRegularFile_QR(t *testing.T) {
+	// a GIF with a comment extension after the header and global palette
+	pal := image.NewPaletted(image.Rect(0, 0, 300, 200), []color.Color{color.White, color.Black})
+	scene := qrScene()
+	for i, v := range scene.Pix {
+		if v == 0 {
+			pal.Pix[i] = 1
+		}
+	}
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, pal, nil); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	at := 13 + 3<<(b[10]&7+1)
+	comment := append([]byte{0x21, 0xfe, 10}, "hunter2 me"...)
+	b = slices.Concat(b[:at], append(comment, 0), b[at:])
+	if got := extractAll(t, extractGIF, b); !slices.Equal(got, []string{"GIF comment=hunter2 me"}) {
+		t.Fatalf("gif: got %q", got)
+	}
+
+	fp := filepath.Join(t.TempDir(), "Screenshot.gif")
+	if err := os.WriteFile(fp, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rules := &RuleSet{Patterns: []Pattern{&PlainPattern{s: []byte("hunter2")}}}
+	scan := func(opts ScanOptions) []string {
+		var got []string
+		var matchCnt, errCnt atomic.Int64
+		NewFileScanner().scanRegularFile(context.Background(), fp, rules, opts, func(m MatchResult) {
+			if m.Error != nil {
+				t.Errorf("unexpected error: %v", m.Error)
+			}
+			got = append(got, m.Location+"|"+strings.TrimSpace(m.Line))
+		}, &matchCnt, &errCnt)
+		return got
+	}
+	if got := scan(ScanOptions{}); !slices.Equal(got, []string{"GIF comment|hunter2 me"}) {
+		t.Fatalf("without --qr: got %q", got)
+	}
+	want := []string{"GIF comment|hunter2 me", "QR 20,20-104,104|password=hunter2"}
+	if got := scan(ScanOptions{QR: true}); !slices.Equal(got, want) {
+		t.Fatalf("with --qr: got %q", got)
+	}
+}
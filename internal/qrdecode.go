@@ -0,0 +1,553 @@
+package internal
+
+import (
+	"errors"
+	"math/bits"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+// Decoding of a sampled QR symbol: format and version information, data
+// unmasking, codeword order, Reed-Solomon correction and the data segments.
+
+var errQR = errors.New("qr: cannot decode")
+
+// qrVersions holds, per version, the first alignment pattern offset and the
+// stride of the next ones (as in the ISO tables), the total codeword count
+// and, for levels L, M, Q, H, the number of blocks and EC codewords per block.
+var qrVersions = [41]struct {
+	apos, astride, total int
+	level                [4][2]int
+}{
+	{},
+	{100, 100, 26, [4][2]int{{1, 7}, {1, 10}, {1, 13}, {1, 17}}},
+	{16, 100, 44, [4][2]int{{1, 10}, {1, 16}, {1, 22}, {1, 28}}},
+	{20, 100, 70, [4][2]int{{1, 15}, {1, 26}, {2, 18}, {2, 22}}},
+	{24, 100, 100, [4][2]int{{1, 20}, {2, 18}, {2, 26}, {4, 16}}},
+	{28, 100, 134, [4][2]int{{1, 26}, {2, 24}, {4, 18}, {4, 22}}},
+	{32, 100, 172, [4][2]int{{2, 18}, {4, 16}, {4, 24}, {4, 28}}},
+	{20, 16, 196, [4][2]int{{2, 20}, {4, 18}, {6, 18}, {5, 26}}},
+	{22, 18, 242, [4][2]int{{2, 24}, {4, 22}, {6, 22}, {6, 26}}},
+	{24, 20, 292, [4][2]int{{2, 30}, {5, 22}, {8, 20}, {8, 24}}},
+	{26, 22, 346, [4][2]int{{4, 18}, {5, 26}, {8, 24}, {8, 28}}},
+	{28, 24, 404, [4][2]int{{4, 20}, {5, 30}, {8, 28}, {11, 24}}},
+	{30, 26, 466, [4][2]int{{4, 24}, {8, 22}, {10, 26}, {11, 28}}},
+	{32, 28, 532, [4][2]int{{4, 26}, {9, 22}, {12, 24}, {16, 22}}},
+	{24, 20, 581, [4][2]int{{4, 30}, {9, 24}, {16, 20}, {16, 24}}},
+	{24, 22, 655, [4][2]int{{6, 22}, {10, 24}, {12, 30}, {18, 24}}},
+	{24, 24, 733, [4][2]int{{6, 24}, {10, 28}, {17, 24}, {16, 30}}},
+	{28, 24, 815, [4][2]int{{6, 28}, {11, 28}, {16, 28}, {19, 28}}},
+	{28, 26, 901, [4][2]int{{6, 30}, {13, 26}, {18, 28}, {21, 28}}},
+	{28, 28, 991, [4][2]int{{7, 28}, {14, 26}, {21, 26}, {25, 26}}},
+	{32, 28, 1085, [4][2]int{{8, 28}, {16, 26}, {20, 30}, {25, 28}}},
+	{26, 22, 1156, [4][2]int{{8, 28}, {17, 26}, {23, 28}, {25, 30}}},
+	{24, 24, 1258, [4][2]int{{9, 28}, {17, 28}, {23, 30}, {34, 24}}},
+	{28, 24, 1364, [4][2]int{{9, 30}, {18, 28}, {25, 30}, {30, 30}}},
+	{26, 26, 1474, [4][2]int{{10, 30}, {20, 28}, {27, 30}, {32, 30}}},
+	{30, 26, 1588, [4][2]int{{12, 26}, {21, 28}, {29, 30}, {35, 30}}},
+	{28, 28, 1706, [4][2]int{{12, 28}, {23, 28}, {34, 28}, {37, 30}}},
+	{32, 28, 1828, [4][2]int{{12, 30}, {25, 28}, {34, 30}, {40, 30}}},
+	{24, 24, 1921, [4][2]int{{13, 30}, {26, 28}, {35, 30}, {42, 30}}},
+	{28, 24, 2051, [4][2]int{{14, 30}, {28, 28}, {38, 30}, {45, 30}}},
+	{24, 26, 2185, [4][2]int{{15, 30}, {29, 28}, {40, 30}, {48, 30}}},
+	{28, 26, 2323, [4][2]int{{16, 30}, {31, 28}, {43, 30}, {51, 30}}},
+	{32, 26, 2465, [4][2]int{{17, 30}, {33, 28}, {45, 30}, {54, 30}}},
+	{28, 28, 2611, [4][2]int{{18, 30}, {35, 28}, {48, 30}, {57, 30}}},
+	{32, 28, 2761, [4][2]int{{19, 30}, {37, 28}, {51, 30}, {60, 30}}},
+	{28, 24, 2876, [4][2]int{{19, 30}, {38, 28}, {53, 30}, {63, 30}}},
+	{22, 26, 3034, [4][2]int{{20, 30}, {40, 28}, {56, 30}, {66, 30}}},
+	{26, 26, 3196, [4][2]int{{21, 30}, {43, 28}, {59, 30}, {70, 30}}},
+	{30, 26, 3362, [4][2]int{{22, 30}, {45, 28}, {62, 30}, {74, 30}}},
+	{24, 28, 3532, [4][2]int{{24, 30}, {47, 28}, {65, 30}, {77, 30}}},
+	{28, 28, 3706, [4][2]int{{25, 30}, {49, 28}, {68, 30}, {81, 30}}},
+}
+
+// qrMatrix is a square grid of modules, true for dark.
+type qrMatrix struct {
+	n    int
+	dark []bool
+}
+
+func newQRMatrix(n int) *qrMatrix { return &qrMatrix{n: n, dark: make([]bool, n*n)} }
+
+func (m *qrMatrix) get(x, y int) bool    { return m.dark[y*m.n+x] }
+func (m *qrMatrix) set(x, y int, v bool) { m.dark[y*m.n+x] = v }
+
+// rect marks a w x h region, clipped to the grid.
+func (m *qrMatrix) rect(x, y, w, h int) {
+	for j := max(y, 0); j < min(y+h, m.n); j++ {
+		for i := max(x, 0); i < min(x+w, m.n); i++ {
+			m.set(i, j, true)
+		}
+	}
+}
+
+// qrAlignment lists the alignment pattern centres of a version on one axis.
+func qrAlignment(version int) []int {
+	n := 17 + 4*version
+	v := qrVersions[version]
+	pos := []int{6}
+	for x := v.apos; x+5 < n; x += v.astride {
+		pos = append(pos, x+2)
+	}
+	if len(pos) == 1 {
+		return nil
+	}
+	return pos
+}
+
+// qrFunction marks the modules that carry no data.
+func qrFunction(version int) *qrMatrix {
+	n := 17 + 4*version
+	f := newQRMatrix(n)
+	f.rect(0, 0, 9, 9)
+	f.rect(n-8, 0, 8, 9)
+	f.rect(0, n-8, 9, 8)
+	pos := qrAlignment(version)
+	for _, x := range pos {
+		for _, y := range pos {
+			if x == 6 && (y == 6 || y == pos[len(pos)-1]) || y == 6 && x == pos[len(pos)-1] {
+				continue // under a finder pattern
+			}
+			f.rect(x-2, y-2, 5, 5)
+		}
+	}
+	f.rect(6, 9, 1, n-17)
+	f.rect(9, 6, n-17, 1)
+	if version >= 7 {
+		f.rect(n-11, 0, 3, 6)
+		f.rect(0, n-11, 6, 3)
+	}
+	return f
+}
+
+// bchCode appends the BCH check bits of data for generator gen of degree deg.
+func bchCode(data, gen, deg int) int {
+	r := data << deg
+	for i := bits.Len(uint(r)) - 1; i >= deg; i-- {
+		if r>>i&1 != 0 {
+			r ^= gen << (i - deg)
+		}
+	}
+	return data<<deg | r
+}
+
+// closestCode returns the value whose code is nearest to the read bits if
+// at most 3 bits differ.
+func closestCode(read []int, codes func(v int) int, n int) (int, bool) {
+	best, bestDist := -1, 4
+	for v := range n {
+		c := codes(v)
+		for _, r := range read {
+			if d := bits.OnesCount(uint(r ^ c)); d < bestDist {
+				best, bestDist = v, d
+			}
+		}
+	}
+	return best, best >= 0
+}
+
+// qrFormat reads both copies of the format information: EC level index
+// (L, M, Q, H) and mask.
+func qrFormat(m *qrMatrix) (level, mask int, ok bool) {
+	n := m.n
+	var a, b int
+	bit := func(acc *int, x, y int) {
+		*acc <<= 1
+		if m.get(x, y) {
+			*acc |= 1
+		}
+	}
+	for x := 0; x < 6; x++ {
+		bit(&a, x, 8)
+	}
+	bit(&a, 7, 8)
+	bit(&a, 8, 8)
+	bit(&a, 8, 7)
+	for y := 5; y >= 0; y-- {
+		bit(&a, 8, y)
+	}
+	for y := n - 1; y >= n-7; y-- {
+		bit(&b, 8, y)
+	}
+	for x := n - 8; x < n; x++ {
+		bit(&b, x, 8)
+	}
+	d, ok := closestCode([]int{a, b}, func(v int) int { return bchCode(v, 0x537, 10) ^ 0x5412 }, 32)
+	if !ok {
+		return 0, 0, false
+	}
+	return [4]int{1, 0, 3, 2}[d>>3], d & 7, true
+}
+
+// qrVersionInfo reads the version information of a symbol of version 7 or
+// more; it returns 0 if neither copy can be read.
+func qrVersionInfo(m *qrMatrix) int {
+	n := m.n
+	var a, b int
+	for y := 5; y >= 0; y-- {
+		for x := n - 9; x >= n-11; x-- {
+			a = a<<1 | int(b2i(m.get(x, y)))
+		}
+	}
+	for x := 5; x >= 0; x-- {
+		for y := n - 9; y >= n-11; y-- {
+			b = b<<1 | int(b2i(m.get(x, y)))
+		}
+	}
+	v, ok := closestCode([]int{a, b}, func(v int) int { return bchCode(v, 0x1f25, 12) }, 41)
+	if !ok || v < 7 {
+		return 0
+	}
+	return v
+}
+
+func qrMasked(mask, i, j int) bool { // i row, j column
+	switch mask {
+	case 0:
+		return (i+j)%2 == 0
+	case 1:
+		return i%2 == 0
+	case 2:
+		return j%3 == 0
+	case 3:
+		return (i+j)%3 == 0
+	case 4:
+		return (i/2+j/3)%2 == 0
+	case 5:
+		return i*j%2+i*j%3 == 0
+	case 6:
+		return (i*j%2+i*j%3)%2 == 0
+	default:
+		return ((i+j)%2+i*j%3)%2 == 0
+	}
+}
+
+// decodeQRMatrix decodes a sampled symbol of the given version.
+func decodeQRMatrix(m *qrMatrix, version int) ([]byte, error) {
+	level, mask, ok := qrFormat(m)
+	if !ok {
+		return nil, errQR
+	}
+	fn := qrFunction(version)
+	n := m.n
+	// codewords are read in two-module columns, right to left, zigzagging up and down
+	raw := make([]byte, 0, qrVersions[version].total)
+	var cur byte
+	nbits := 0
+	up := true
+	for j := n - 1; j > 0; j -= 2 {
+		if j == 6 {
+			j--
+		}
+		for c := range n {
+			i := c
+			if up {
+				i = n - 1 - c
+			}
+			for col := range 2 {
+				x := j - col
+				if fn.get(x, i) {
+					continue
+				}
+				cur <<= 1
+				if m.get(x, i) != qrMasked(mask, i, x) {
+					cur |= 1
+				}
+				if nbits++; nbits == 8 {
+					raw = append(raw, cur)
+					cur, nbits = 0, 0
+				}
+			}
+		}
+		up = !up
+	}
+	v := qrVersions[version]
+	if len(raw) < v.total {
+		return nil, errQR
+	}
+	nblocks, ecLen := v.level[level][0], v.level[level][1]
+	dataTotal := v.total - nblocks*ecLen
+	short := dataTotal / nblocks
+	long := nblocks - dataTotal%nblocks // index of the first block with one more data codeword
+	blocks := make([][]byte, nblocks)
+	k := 0
+	for i := 0; i <= short; i++ {
+		for b := range blocks {
+			if i < short || b >= long {
+				blocks[b] = append(blocks[b], raw[k])
+				k++
+			}
+		}
+	}
+	for range ecLen {
+		for b := range blocks {
+			blocks[b] = append(blocks[b], raw[k])
+			k++
+		}
+	}
+	var data []byte
+	for _, blk := range blocks {
+		if err := rsCorrect(blk, ecLen); err != nil {
+			return nil, err
+		}
+		data = append(data, blk[:len(blk)-ecLen]...)
+	}
+	return qrSegments(data, version)
+}
+
+// GF(256) with the QR polynomial x^8+x^4+x^3+x^2+1.
+var gfExp, gfLog = func() (exp [512]byte, log [256]byte) {
+	x := 1
+	for i := range 255 {
+		exp[i] = byte(x)
+		log[x] = byte(i)
+		if x <<= 1; x >= 256 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < 512; i++ {
+		exp[i] = exp[i-255]
+	}
+	return
+}()
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// gfEval evaluates a polynomial with coefficients lowest degree first.
+func gfEval(p []byte, x byte) byte {
+	var y byte
+	for i := len(p) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ p[i]
+	}
+	return y
+}
+
+// rsCorrect fixes up to ec/2 byte errors of a block in place
+// (Berlekamp-Massey, Chien search, Forney).
+func rsCorrect(block []byte, ec int) error {
+	n := len(block)
+	synd := make([]byte, ec)
+	clean := true
+	for i := range synd {
+		x := gfExp[i]
+		var s byte
+		for _, c := range block {
+			s = gfMul(s, x) ^ c
+		}
+		synd[i] = s
+		clean = clean && s == 0
+	}
+	if clean {
+		return nil
+	}
+	// error locator
+	lambda, prev := []byte{1}, []byte{1}
+	l, shift, b := 0, 1, byte(1)
+	for r := range ec {
+		d := synd[r]
+		for i := 1; i <= l && i < len(lambda); i++ {
+			d ^= gfMul(lambda[i], synd[r-i])
+		}
+		if d == 0 {
+			shift++
+			continue
+		}
+		next := append([]byte(nil), lambda...)
+		coef := gfDiv(d, b)
+		for len(next) < len(prev)+shift {
+			next = append(next, 0)
+		}
+		for i, p := range prev {
+			next[i+shift] ^= gfMul(coef, p)
+		}
+		if 2*l <= r {
+			l, prev, b, shift = r+1-l, lambda, d, 1
+		} else {
+			shift++
+		}
+		lambda = next
+	}
+	if 2*l > ec {
+		return errQR
+	}
+	// error evaluator: synd * lambda mod x^ec
+	omega := make([]byte, ec)
+	for i := range omega {
+		for j := 0; j <= i && j < len(lambda); j++ {
+			omega[i] ^= gfMul(lambda[j], synd[i-j])
+		}
+	}
+	found := 0
+	for k := range n {
+		p := n - 1 - k // power of x at byte k
+		xInv := gfExp[(255-p)%255]
+		if gfEval(lambda, xInv) != 0 {
+			continue
+		}
+		var deriv byte // formal derivative: odd terms only
+		for i := 1; i < len(lambda); i += 2 {
+			deriv ^= gfMul(lambda[i], gfExp[(int(gfLog[xInv])*(i-1))%255])
+		}
+		if deriv == 0 {
+			return errQR
+		}
+		block[k] ^= gfMul(gfExp[p], gfDiv(gfEval(omega, xInv), deriv))
+		found++
+	}
+	if found != l {
+		return errQR
+	}
+	return nil
+}
+
+// qrBits reads a bit stream most significant bit first.
+type qrBits struct {
+	b   []byte
+	pos int
+}
+
+func (r *qrBits) left() int { return 8*len(r.b) - r.pos }
+
+func (r *qrBits) read(n int) int {
+	v := 0
+	for range n {
+		v = v<<1 | int(r.b[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+	return v
+}
+
+const qrAlnum = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+// qrSegments decodes the data segments: numeric, alphanumeric, byte and
+// kanji, with ECI switching the character set of byte segments.
+func qrSegments(data []byte, version int) ([]byte, error) {
+	r := &qrBits{b: data}
+	size := 0 // count field width class
+	switch {
+	case version >= 27:
+		size = 2
+	case version >= 10:
+		size = 1
+	}
+	var out strings.Builder
+	eci := -1
+	for r.left() >= 4 {
+		mode := r.read(4)
+		switch mode {
+		case 0: // terminator
+			return []byte(out.String()), nil
+		case 1:
+			n := r.read([3]int{10, 12, 14}[size])
+			for ; n >= 3; n -= 3 {
+				if r.left() < 10 {
+					return nil, errQR
+				}
+				v := r.read(10)
+				if v > 999 {
+					return nil, errQR
+				}
+				out.WriteString(string([]byte{byte('0' + v/100), byte('0' + v/10%10), byte('0' + v%10)}))
+			}
+			switch n {
+			case 2:
+				v := r.read(7)
+				out.WriteString(string([]byte{byte('0' + v/10%10), byte('0' + v%10)}))
+			case 1:
+				out.WriteByte(byte('0' + r.read(4)%10))
+			}
+		case 2:
+			n := r.read([3]int{9, 11, 13}[size])
+			for ; n >= 2; n -= 2 {
+				if r.left() < 11 {
+					return nil, errQR
+				}
+				v := r.read(11)
+				if v >= 45*45 {
+					return nil, errQR
+				}
+				out.WriteByte(qrAlnum[v/45])
+				out.WriteByte(qrAlnum[v%45])
+			}
+			if n == 1 {
+				out.WriteByte(qrAlnum[r.read(6)%45])
+			}
+		case 4:
+			n := r.read([3]int{8, 16, 16}[size])
+			if r.left() < 8*n {
+				return nil, errQR
+			}
+			b := make([]byte, n)
+			for i := range b {
+				b[i] = byte(r.read(8))
+			}
+			out.Write(qrBytes(b, eci))
+		case 8:
+			n := r.read([3]int{8, 10, 12}[size])
+			if r.left() < 13*n {
+				return nil, errQR
+			}
+			sjis := make([]byte, 0, 2*n)
+			for range n {
+				v := r.read(13)
+				c := v/0xc0<<8 | v%0xc0
+				if c < 0x1f00 {
+					c += 0x8140
+				} else {
+					c += 0xc140
+				}
+				sjis = append(sjis, byte(c>>8), byte(c))
+			}
+			out.Write(qrBytes(sjis, 20))
+		case 7: // ECI designator: 1, 2 or 3 bytes
+			switch {
+			case r.left() >= 8 && r.b[r.pos/8]>>(7-r.pos%8)&1 == 0:
+				eci = r.read(8)
+			case r.left() >= 16 && r.read(2) == 2:
+				eci = r.read(14)
+			case r.left() >= 21:
+				r.read(1)
+				eci = r.read(21)
+			default:
+				return nil, errQR
+			}
+		case 3: // structured append: sequence and parity
+			r.read(16)
+		case 5: // FNC1 in first position
+		case 9: // FNC1 in second position: application indicator
+			r.read(8)
+		default:
+			return nil, errQR
+		}
+	}
+	return []byte(out.String()), nil
+}
+
+// qrBytes converts a byte segment to UTF-8: Shift JIS for ECI 20, Latin-1
+// for ECI 1 and 3 and for bytes that are not UTF-8.
+func qrBytes(b []byte, eci int) []byte {
+	switch {
+	case eci == 20:
+		if s, err := japanese.ShiftJIS.NewDecoder().Bytes(b); err == nil {
+			return s
+		}
+	case eci != 1 && eci != 3 && utf8.Valid(b):
+		return b
+	}
+	return []byte(latin1(b))
+}
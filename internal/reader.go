@@ -3,40 +3,72 @@ package internal
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"unicode/utf8"
+)
+
+const (
+	defaultMaxLineLen = 1 << 20 // longer lines are matched in chunks
+	longLineOverlap   = 4 << 10
+	longLineContext   = 64   // snippet runes around a match in a long line without --snippet
+	maxHeldResults    = 1000 // results held for the file hash; past that they stream and a hash record follows
 )
 
 // matchReader streams file lines and reports matches.
-// If saveFull && folder != "", content is written to a temp file via Tee.
-// On first match the temp file is moved to final destination; otherwise it's removed.
+// If opts.SaveFull && folder != "", content is written to a temp file via Tee.
+// After EOF the temp file is moved to final destination if anything matched; otherwise it's removed.
 // This keeps memory low and avoids double-reading.
+// The file hash and YARA strings are computed in the same pass. Results are held until EOF so
+// each one carries the hash; a file with more than maxHeldResults findings streams them instead
+// and reports its hash in a record of its own (Hash set, not Matched) after them.
+// It returns the hash, "" if the content could not be read to its end.
 func matchReader(
 	reader io.Reader,
-	patterns []Pattern,
-	hasInsensitive bool,
-	saveFull bool,
-	saveFullFolder string,
+	rules *RuleSet,
+	opts ScanOptions,
 	onMatch func(MatchResult),
 	filePath, innerPath string,
 	matchCount, errorCount *atomic.Int64,
-) {
+) string {
 	var (
-		tee     = reader
-		tmpPath string
-		tmpFile *os.File
-		err     error
+		tee            = reader
+		tmpFile        *os.File
+		err            error
+		saveFull       = opts.SaveFull
+		saveFullFolder = opts.SaveFullFolder
+		patterns       = rules.Patterns
+		snipCtx        = opts.SnippetLen
 	)
+	if snipCtx <= 0 {
+		snipCtx = longLineContext
+	}
+
+	// a mapped file is matched in place; byte consumers get the mapping directly instead of a tee
+	mapped, _ := reader.(*mappedFile)
+	// a document streams its raw bytes to the byte consumers; line rules see its extracted text
+	doc, _ := reader.(*docReader)
+	location := "" // of the document unit being matched
+	var direct []io.Writer
+	consume := func(w io.Writer) {
+		if mapped != nil {
+			direct = append(direct, w)
+		} else {
+			tee = io.TeeReader(tee, w)
+		}
+	}
 
 	// Prepare tee into temp file only if we might save full content
 	if saveFull && saveFullFolder != "" {
 		if err = os.MkdirAll(saveFullFolder, 0755); err == nil {
 			tmpFile, err = os.CreateTemp(saveFullFolder, "ff-*")
 			if err == nil {
-				tee = io.TeeReader(reader, tmpFile)
+				consume(tmpFile)
 			}
 		}
 		// if any error, silently fall back to no-full-save
@@ -45,75 +77,383 @@ func matchReader(
 		}
 	}
 
-	br := bufio.NewReaderSize(tee, 64*1024)
-	lineNum := 0
-	lowerBuf := new(bytes.Buffer) // reuse for lowercasing lines
+	// hash in the same pass; hold results until the digest is known
+	var hasher *fileHasher
+	emit := onMatch
+	var (
+		held     []MatchResult
+		streamed bool // held results overflowed and went out without the hash
+	)
+	if !opts.noHash && (!opts.NoHash || len(rules.Hashes) > 0) {
+		hasher = newFileHasher(rules)
+		consume(hasher)
+		emit = func(r MatchResult) {
+			if !streamed && len(held) < maxHeldResults {
+				held = append(held, r)
+				return
+			}
+			for _, h := range held {
+				onMatch(h)
+			}
+			held, streamed = nil, true
+			onMatch(r)
+		}
+	}
+
+	// yara rules see the same stream and are evaluated at EOF
+	var ys *yaraScanner
+	if len(rules.Yara) > 0 {
+		ys = newYaraScanner(rules.Yara)
+		consume(ys)
+	}
 
-	var matchedPattern string
 	found := false
 
-	for {
-		b, err := br.ReadBytes('\n')
-		if len(b) > 0 {
+	// byte patterns scan raw overlapping chunks and report offsets
+	var bs *byteScanner
+	if len(rules.Bytes) > 0 {
+		bs = newByteScanner(rules.Bytes, func(p *BytePattern, off int64, m []byte) {
+			found = true
+			matchCount.Add(1)
+			emit(MatchResult{FilePath: filePath, InnerPath: innerPath, Offset: off, Line: hexPreview(m), Matched: true, ByteMatch: true, Pattern: p.Desc()})
+		})
+		consume(bs)
+	}
+	// per-worker buffers: the reader, the line being assembled and its fold
+	sc := scratchPool.Get().(*scratch)
+	defer scratchPool.Put(sc)
+	ln := &sc.line
+	var br *bufio.Reader
+	if mapped == nil {
+		if sc.br == nil {
+			sc.br = bufio.NewReaderSize(tee, 64*1024)
+		} else {
+			sc.br.Reset(tee)
+		}
+		br = sc.br
+		defer br.Reset(nil) // drop the stream reference while pooled
+	}
+
+	// line results wait for their trailing context lines (-A/-B/-C)
+	emitLine := emit
+	var lc *lineContext
+	if !saveFull && (opts.ContextBefore > 0 || opts.ContextAfter > 0) {
+		lc = newLineContext(opts.ContextBefore, opts.ContextAfter, emit)
+		emitLine = lc.add
+	}
+
+	// lineResult reports pattern p, which matched line b, the way the options ask for
+	lineResult := func(p Pattern, b []byte, lineNum int, offset int64) MatchResult {
+		if saveFull {
+			return MatchResult{FilePath: filePath, InnerPath: innerPath, Location: location, Offset: offset, FullFile: nil, Matched: true, Pattern: p.Desc()}
+		}
+		res := MatchResult{FilePath: filePath, InnerPath: innerPath, Location: location, LineNumber: lineNum, Offset: offset, Matched: true, Pattern: p.Desc()}
+		start, end := p.Find(ln)
+		if start >= 0 {
+			res.Column = start + 1
+		}
+		if opts.SnippetLen > 0 && start >= 0 {
+			res.Snippet = snippet(b, start, end, opts.SnippetLen)
+		}
+		if res.Snippet == "" || opts.FullLine {
+			// ensure newline
 			line := string(b)
-			// Lowercase once per line if we have insensitive patterns
-			var lineForCheck string
-			if hasInsensitive {
-				lowerBuf.Reset()
-				lowerBuf.WriteString(strings.ToLower(line))
-				lineForCheck = lowerBuf.String()
-			} else {
-				lineForCheck = line
-			}
-			for _, p := range patterns {
-				if p.Match(lineForCheck) {
-					matchedPattern = p.Desc()
-					found = true
-					if saveFull {
-						// flush temp and move it
-						if tmpFile != nil {
-							tmpFile.Sync()
-							tmpPath = finalSavePath(saveFullFolder, filePath, innerPath)
-							_ = tmpFile.Close()
-							// rename (atomic on same fs)
-							_ = os.Rename(tmpFile.Name(), tmpPath)
-						}
-						onMatch(MatchResult{FilePath: filePath, InnerPath: innerPath, FullFile: nil, Matched: true, Pattern: matchedPattern})
-					} else {
-						// ensure newline
-						if !strings.HasSuffix(line, "\n") {
-							line += "\n"
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+			res.Line = line
+		}
+		return res
+	}
+
+	decodeMin, decodeDepth := opts.DecodeMin, opts.DecodeDepth
+	if decodeMin <= 0 {
+		decodeMin = defaultDecodeMin
+	}
+	if decodeDepth <= 0 {
+		decodeDepth = defaultDecodeDepth
+	}
+	// matchDecoded runs line patterns over the encoded tokens of a line (--decode);
+	// a hit carries the decoding chain, the decoded line and the column of the token
+	matchDecoded := func(b []byte, lineNum int, offset int64) {
+		decodeTokens(b, decodeMin, decodeDepth, func(dec []byte, chain string, col int) bool {
+			for line := range bytes.Lines(dec) {
+				ln.Set(line, rules.HasInsensitive)
+				for _, p := range patterns {
+					if p.Match(ln) {
+						res := lineResult(p, line, lineNum, offset)
+						res.Decoded = chain
+						if !saveFull {
+							res.Column = col + 1
 						}
-						onMatch(MatchResult{FilePath: filePath, InnerPath: innerPath, LineNumber: lineNum, Line: line, Matched: true, Pattern: matchedPattern})
+						emitLine(res)
+						found = true
+						matchCount.Add(1)
+						return true
 					}
-					matchCount.Add(1)
-					// do not break reading: we still need to drain if tee is active for archives;
-					// but we can stop sending more matches for that file.
-					// So we only break the pattern loop.
-					break
 				}
 			}
-			lineNum++
+			return false
+		})
+	}
+
+	// matchLine runs line patterns over one line (or extracted string) and reports the first hit.
+	// Nothing is allocated unless a pattern matches.
+	matchLine := func(b []byte, lineNum int, offset int64) {
+		ln.Set(b, rules.HasInsensitive)
+		for _, p := range patterns {
+			if p.Match(ln) {
+				emitLine(lineResult(p, b, lineNum, offset))
+				found = true
+				matchCount.Add(1)
+				// do not break reading: we still need to drain if tee is active for archives;
+				// but we can stop sending more matches for that file.
+				// So we only break the pattern loop.
+				return
+			}
 		}
-		if err != nil {
-			if err != io.EOF {
-				errorCount.Add(1)
-				onMatch(MatchResult{FilePath: filePath, InnerPath: innerPath, Error: err})
+		if opts.Decode {
+			matchDecoded(b, lineNum, offset)
+		}
+	}
+
+	// matchChunk is matchLine for windows of an over-long line: it reports the first
+	// hit starting before limit with its offset and a snippet instead of the line
+	// base is the offset of win within the line starting at lineOff
+	matchChunk := func(win []byte, limit int, lineNum int, lineOff, base int64) {
+		ln.Set(win, rules.HasInsensitive)
+		for _, p := range patterns {
+			start, end := p.Find(ln)
+			if start < 0 || start >= limit {
+				continue
+			}
+			res := MatchResult{FilePath: filePath, InnerPath: innerPath, Location: location, LineNumber: lineNum, Offset: lineOff + base + int64(start), Matched: true, Pattern: p.Desc()}
+			if !saveFull {
+				res.Column = int(base) + start + 1
+				res.Snippet = snippet(win, start, end, snipCtx)
 			}
+			emitLine(res)
+			found = true
+			matchCount.Add(1)
 			break
 		}
 	}
 
-	// cleanup temp if no match
-	if saveFull && tmpFile != nil {
-		info, _ := os.Stat(tmpFile.Name())
-		_ = tmpFile.Close()
-		if !found {
-			if info != nil {
-				_ = os.Remove(tmpFile.Name())
+	var readErr error
+	var sample []byte
+	switch {
+	case doc != nil:
+		// containers are binary by nature; only their text is matched
+	case mapped != nil:
+		readErr = feedMapped(direct, mapped.data)
+		sample = mapped.data[:min(len(mapped.data), binarySniffSize)]
+	default:
+		// classify by the first bytes; Peek does not consume anything
+		sample, _ = br.Peek(binarySniffSize)
+	}
+	binary := isBinary(sample)
+	if binary && opts.Stats != nil {
+		opts.Stats.BinaryFiles.Add(1)
+	}
+
+	maxLine := opts.MaxLineLen
+	if maxLine <= 0 {
+		maxLine = defaultMaxLineLen
+	}
+	// matchLong matches a line longer than maxLine in overlapping chunks
+	matchLong := func(lineNum int, lineOff int64) *windowScanner {
+		return newWindowScanner(maxLine, longLineOverlap, func(win []byte, base int64, limit int) {
+			matchChunk(win, limit, lineNum, lineOff, base)
+		})
+	}
+
+	// matchBuffer matches in-memory text line by line; lines are slices of data, nothing is copied
+	matchBuffer := func(data []byte) {
+		for lineNum, offset := 0, 0; offset < len(data); lineNum++ {
+			end := len(data)
+			if i := bytes.IndexByte(data[offset:], '\n'); i >= 0 {
+				end = offset + i + 1
 			}
+			line := data[offset:end]
+			if len(line) > maxLine {
+				long := matchLong(lineNum, int64(offset))
+				_ = feedChunks(long, line)
+				_ = long.Close()
+				if lc != nil {
+					lc.line(lineNum, fmt.Appendf(nil, "[line of %d bytes]", len(line)))
+				}
+			} else {
+				matchLine(line, lineNum, int64(offset))
+				if lc != nil {
+					lc.line(lineNum, line)
+				}
+			}
+			offset = end
 		}
 	}
+
+	var extractErr error
+	switch {
+	case readErr != nil:
+	case doc != nil:
+		if saveFull || hasher != nil || ys != nil || bs != nil {
+			_, readErr = io.Copy(io.Discard, br)
+		}
+		switch {
+		case readErr != nil:
+		case doc.records != nil:
+			// records are matched by matchReader calls of their own; their results
+			// are held here like any other, to carry the container's hash
+			extractErr = doc.records(doc.ra, doc.size, func(r MatchResult) {
+				found = found || r.Matched
+				emit(r)
+			})
+		default:
+			// units are matched separately: line numbers and context restart in each
+			// a failed extraction leaves the raw stream, and so its digests, intact
+			extractErr = doc.extract(doc.ra, doc.size, func(loc string, text []byte) {
+				location = loc
+				matchBuffer(text)
+				if lc != nil {
+					lc.reset()
+				}
+			})
+			location = ""
+		}
+	case binary && opts.Binary == BinarySkip:
+		// byte-level consumers (save-full, hashes, yara, hex:) still need the whole stream
+		if mapped == nil && (saveFull || hasher != nil || ys != nil || bs != nil) {
+			_, readErr = io.Copy(io.Discard, br)
+		}
+	case binary && opts.Binary == BinaryStrings:
+		// line number is the index of the extracted string, like `strings | grep -n`
+		var src io.Reader = br
+		if mapped != nil {
+			src = bytes.NewReader(mapped.data)
+		}
+		idx := 0
+		readErr = extractStrings(src, opts.StringsMin, func(run []byte, off int64) {
+			matchLine(run, idx, off)
+			if lc != nil {
+				lc.line(idx, run)
+			}
+			idx++
+		})
+	case mapped != nil:
+		matchBuffer(mapped.data)
+	default:
+		lineNum := 0
+		var (
+			offset  int64 // of the current line
+			lineBuf = sc.buf[:0]
+			long    *windowScanner // set while inside a line longer than maxLine
+		)
+		for {
+			frag, rerr := br.ReadSlice('\n')
+			if long == nil && len(lineBuf)+len(frag) > maxLine {
+				// too long to buffer - match the rest of this line in overlapping chunks
+				long = matchLong(lineNum, offset)
+				_, _ = long.Write(lineBuf)
+				lineBuf = lineBuf[:0]
+			}
+			if long != nil {
+				_, _ = long.Write(frag)
+			} else {
+				lineBuf = append(lineBuf, frag...)
+			}
+			if rerr == bufio.ErrBufferFull {
+				continue
+			}
+			// end of line, EOF or error
+			var n int64
+			if long != nil {
+				n = long.size
+				_ = long.Close()
+				long = nil
+				if lc != nil {
+					lc.line(lineNum, fmt.Appendf(nil, "[line of %d bytes]", n))
+				}
+			} else if len(lineBuf) > 0 {
+				n = int64(len(lineBuf))
+				matchLine(lineBuf, lineNum, offset)
+				if lc != nil {
+					lc.line(lineNum, lineBuf)
+				}
+				lineBuf = lineBuf[:0]
+			}
+			if n > 0 {
+				lineNum++
+				offset += n
+			}
+			if rerr != nil {
+				if rerr != io.EOF {
+					readErr = rerr
+				}
+				break
+			}
+		}
+		sc.buf = lineBuf[:0]
+	}
+	if lc != nil {
+		lc.end()
+	}
+	readOK := readErr == nil
+	if readErr != nil {
+		errorCount.Add(1)
+		onMatch(MatchResult{FilePath: filePath, InnerPath: innerPath, Error: readErr})
+	}
+	if extractErr != nil {
+		errorCount.Add(1)
+		onMatch(MatchResult{FilePath: filePath, InnerPath: innerPath, Error: extractErr})
+	}
+
+	if bs != nil {
+		_ = bs.Close()
+	}
+	if ys != nil && readOK {
+		for _, h := range ys.finish() {
+			found = true
+			matchCount.Add(1)
+			emit(MatchResult{FilePath: filePath, InnerPath: innerPath, Matched: true, Pattern: "yara:" + h.Rule, Rule: h.Rule, Strings: h.Strings})
+		}
+	}
+
+	var digest string
+	if hasher != nil {
+		// digests of a partially read file are meaningless
+		if readOK {
+			sums := hasher.sums()
+			digest = primaryHash(sums)
+			for _, hp := range rules.MatchHash(sums) {
+				found = true
+				matchCount.Add(1)
+				emit(MatchResult{FilePath: filePath, InnerPath: innerPath, Matched: true, HashMatch: true, Pattern: hp.Desc(), Hash: digest})
+			}
+		}
+		for _, r := range held {
+			r.Hash = digest
+			onMatch(r)
+		}
+		if streamed && digest != "" {
+			onMatch(MatchResult{FilePath: filePath, InnerPath: innerPath, Hash: digest})
+		}
+	}
+
+	// move temp to final destination on match, cleanup otherwise
+	if saveFull && tmpFile != nil {
+		if found {
+			tmpFile.Sync()
+			_ = tmpFile.Close()
+			// rename (atomic on same fs); archive entries land in a per-archive dir
+			dst := finalSavePath(saveFullFolder, filePath, innerPath)
+			_ = os.MkdirAll(filepath.Dir(dst), 0755)
+			_ = os.Rename(tmpFile.Name(), dst)
+		} else {
+			_ = tmpFile.Close()
+			_ = os.Remove(tmpFile.Name())
+		}
+	}
+	return digest
 }
 
 func finalSavePath(folder, filePath, innerPath string) string {
@@ -130,3 +470,59 @@ func finalSavePath(folder, filePath, innerPath string) string {
 	}
 	return filepath.ToSlash(filepath.Join(folder, base))
 }
+
+// feedMapped hands a mapped file to the byte consumers that a stream would be teed into.
+func feedMapped(ws []io.Writer, data []byte) error {
+	for _, w := range ws {
+		if err := feedChunks(w, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// feedChunks writes data in 1 MiB pieces so buffering writers stay small.
+func feedChunks(w io.Writer, data []byte) error {
+	for len(data) > 0 {
+		n := min(len(data), 1<<20)
+		if _, err := w.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// scratch holds the buffers one worker reuses from file to file.
+type scratch struct {
+	br   *bufio.Reader
+	buf  []byte // line assembly
+	line Line
+}
+
+var scratchPool = sync.Pool{New: func() any { return new(scratch) }}
+
+// snippet returns b[start:end] with up to ctx runes around it; "..." marks cut edges.
+// Cuts never split a UTF-8 sequence; invalid bytes count as one rune each.
+func snippet(b []byte, start, end, ctx int) string {
+	start = min(max(start, 0), len(b))
+	end = max(start, min(end, len(b)))
+	from := start
+	for i := 0; i < ctx && from > 0; i++ {
+		_, n := utf8.DecodeLastRune(b[:from])
+		from -= n
+	}
+	to := end
+	for i := 0; i < ctx && to < len(b); i++ {
+		_, n := utf8.DecodeRune(b[to:])
+		to += n
+	}
+	out := string(bytes.TrimRight(b[from:to], "\r\n"))
+	if from > 0 {
+		out = "..." + out
+	}
+	if to < len(b) && len(bytes.TrimRight(b[to:], "\r\n")) > 0 {
+		out += "..."
+	}
+	return out
+}
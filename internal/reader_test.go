@@ -2,8 +2,11 @@ package internal
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync/atomic"
 	"testing"
@@ -13,8 +16,9 @@ type stubPattern struct {
 	sub string
 }
 
-func (p stubPattern) Match(s string) bool { return strings.Contains(s, p.sub) }
-func (p stubPattern) Desc() string        { return p.sub }
+func (p stubPattern) Match(l *Line) bool      { return bytes.Contains(l.Raw, []byte(p.sub)) }
+func (p stubPattern) Find(l *Line) (int, int) { return -1, -1 }
+func (p stubPattern) Desc() string            { return p.sub }
 
 func TestFinalSavePath(t *testing.T) {
 	p := finalSavePath("/out", "/var/log/sys.log", "")
@@ -41,7 +45,7 @@ func TestMatchReader_LinesMode(t *testing.T) {
 	}
 
 	var matchCnt, errCnt atomic.Int64
-	matchReader(bytes.NewBufferString(data), pats, false, false, "", on, "/f.txt", "", &matchCnt, &errCnt)
+	matchReader(bytes.NewBufferString(data), &RuleSet{Patterns: pats}, ScanOptions{}, on, "/f.txt", "", &matchCnt, &errCnt)
 
 	if matches != 1 || matchCnt.Load() != 1 {
 		t.Fatalf("want 1 match, got %d", matches)
@@ -64,7 +68,7 @@ func TestMatchReader_SaveFullMode(t *testing.T) {
 		}
 	}
 
-	matchReader(bytes.NewBufferString(data), pats, false, true, dir, on, "/tmp/file.txt", "", &matchCnt, &errCnt)
+	matchReader(bytes.NewBufferString(data), &RuleSet{Patterns: pats}, ScanOptions{SaveFull: true, SaveFullFolder: dir}, on, "/tmp/file.txt", "", &matchCnt, &errCnt)
 	if !found {
 		t.Fatal("expected match")
 	}
@@ -76,3 +80,91 @@ func TestMatchReader_SaveFullMode(t *testing.T) {
 		t.Fatalf("expected saved file at %s: %v", glob, err)
 	}
 }
+
+func TestMatchReader_LongLineChunked(t *testing.T) {
+	// one 10 KiB line with a hit straddling the first chunk edge, then a short line
+	long := strings.Repeat("x", 1020) + "SECRET" + strings.Repeat("y", 9000)
+	data := long + "\nshort SECRET\n"
+	pats := []Pattern{&PlainPattern{s: []byte("SECRET")}}
+
+	var got []MatchResult
+	var matchCnt, errCnt atomic.Int64
+	opts := ScanOptions{MaxLineLen: 1024}
+	matchReader(bytes.NewBufferString(data), &RuleSet{Patterns: pats}, opts, func(m MatchResult) { got = append(got, m) }, "/min.js", "", &matchCnt, &errCnt)
+
+	if len(got) != 2 {
+		t.Fatalf("want 2 matches, got %+v", got)
+	}
+	if got[0].Line != "" || got[0].Offset != 1020 || got[0].Column != 1021 || got[0].LineNumber != 0 {
+		t.Fatalf("unexpected long-line result: %+v", got[0])
+	}
+	if !strings.Contains(got[0].Snippet, "SECRET") || !strings.HasPrefix(got[0].Snippet, "...") || len(got[0].Snippet) > 6+2*longLineContext+6 {
+		t.Fatalf("bad snippet: %q", got[0].Snippet)
+	}
+	if got[1].Line != "short SECRET\n" || got[1].LineNumber != 1 || got[1].Offset != int64(len(long)+1) {
+		t.Fatalf("line after long line: %+v", got[1])
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	if s := snippet([]byte("abcdefghij"), 4, 6, 2); s != "...cdefgh..." {
+		t.Fatalf("got %q", s)
+	}
+	if s := snippet([]byte("abc"), 0, 3, 5); s != "abc" {
+		t.Fatalf("got %q", s)
+	}
+	// context is counted in runes, never splitting a multi-byte character
+	if s := snippet([]byte("пароль=KEY;ключ"), 13, 16, 2); s != "...ь=KEY;к..." {
+		t.Fatalf("got %q", s)
+	}
+}
+
+func TestMatchReader_SnippetOption(t *testing.T) {
+	data := "head " + strings.Repeat("a", 100) + " token=abc " + strings.Repeat("b", 100) + "\n"
+	pats := []Pattern{&PlainPattern{s: []byte("token=")}}
+	run := func(opts ScanOptions) MatchResult {
+		var got []MatchResult
+		var matchCnt, errCnt atomic.Int64
+		matchReader(bytes.NewBufferString(data), &RuleSet{Patterns: pats}, opts, func(m MatchResult) { got = append(got, m) }, "/f", "", &matchCnt, &errCnt)
+		if len(got) != 1 {
+			t.Fatalf("want 1 match, got %+v", got)
+		}
+		return got[0]
+	}
+
+	m := run(ScanOptions{SnippetLen: 4})
+	if m.Line != "" || m.Snippet != "...aaa token=abc ..." || m.Column != 107 || m.Offset != 0 {
+		t.Fatalf("snippet result: %+v", m)
+	}
+	m = run(ScanOptions{SnippetLen: 4, FullLine: true})
+	if m.Line != data || m.Snippet == "" {
+		t.Fatalf("full line opt-in: %+v", m)
+	}
+	m = run(ScanOptions{})
+	if m.Line != data || m.Snippet != "" || m.Column != 107 {
+		t.Fatalf("default keeps the line: %+v", m)
+	}
+}
+
+func TestScanRegularFile_MmapMatchesStream(t *testing.T) {
+	dir := t.TempDir()
+	fp := filepath.Join(dir, "data.txt")
+	data := "first SECRET\n" + strings.Repeat("z", 3000) + "SECRET" + strings.Repeat("z", 100) + "\nno\n\x00\x01 wide hex \xde\xad\xbe\xef tail SECRET"
+	_ = os.WriteFile(fp, []byte(data), 0644)
+	hp, _ := parseBytePattern("hex:DE AD BE EF")
+	rules := &RuleSet{Patterns: []Pattern{&PlainPattern{s: []byte("SECRET")}}, Bytes: []*BytePattern{hp}}
+
+	run := func(mmap bool) []string {
+		var out []string
+		var matchCnt, errCnt atomic.Int64
+		opts := ScanOptions{Mmap: mmap, MaxLineLen: 1024}
+		NewFileScanner().scanRegularFile(context.Background(), fp, rules, opts, func(m MatchResult) {
+			out = append(out, fmt.Sprintf("%d@%d %q %q %s %v", m.LineNumber, m.Offset, m.Line, m.Snippet, m.Hash, m.Error))
+		}, &matchCnt, &errCnt)
+		return out
+	}
+	stream, mapped := run(false), run(true)
+	if len(stream) != 4 || !slices.Equal(stream, mapped) {
+		t.Fatalf("results differ:\nstream %v\nmapped %v", stream, mapped)
+	}
+}
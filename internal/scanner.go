@@ -7,12 +7,12 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"runtime/debug"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/mholt/archives"
 	"github.com/panjf2000/ants/v2"
 	"github.com/sirupsen/logrus"
 )
@@ -29,11 +29,34 @@ type MatchResult struct {
 	FilePath   string
 	InnerPath  string
 	LineNumber int
+	Offset     int64 // byte offset of the matched line (or of the match for byte patterns and long lines)
+	Column     int   // 1-based byte column of the match in its line, 0 if unknown
 	Line       string
+	Snippet    string    // context around the match (--snippet, or when the line is too long to report)
+	Location   string    // where in a document the text is: "Sheet1!B3", "slide 3", "paragraph 12"
+	MessageID  string    // Message-ID of the mail the match came from
+	Subject    string    // decoded Subject of that mail
+	Commit     string    // git commit that added the line (--git-history)
+	Author     string    // its author, "Name <email>"
+	Date       time.Time // its author date
+	Layer      string    // digest of the container image layer the file is in (--images)
+	LayerCmd   string    // Dockerfile instruction that created that layer
+	Decoded    string    // decoding chain ("base64>hex") when the match is in a decoded token; Line is the decoded text
+	Before     []string  // context lines preceding LineNumber, oldest first, without line endings
+	After      []string  // context lines following LineNumber
+	GroupEnd   bool      // last result of a context group; "--" follows it
 	FullFile   []byte
 	Matched    bool
 	Error      error
 	Pattern    string
+	NameMatch  bool     // matched by a name:/path: rule
+	HashMatch  bool     // content hash is a known IOC
+	Hash       string   // "sha256:<hex>" of the file/entry, "" if it was not read whole
+	Rule       string   // YARA rule name
+	Strings    []string // YARA string identifiers that matched
+	ByteMatch  bool     // hex:/wide: pattern; Line holds a hex preview of the matched bytes
+	Encrypted  bool     // encrypted archive entry, or archive when InnerPath is empty
+	Password   string   // the --archive-passwords entry that opened it
 }
 
 // NewResultSink returns a closure writing matches/errs counters + file sinks.
@@ -45,28 +68,101 @@ func NewResultSink(opts ScanOptions, stats *AppStats) func(MatchResult) {
 	return func(res MatchResult) {
 		if res.Error != nil {
 			stats.Errors.Add(1)
-			logrus.WithFields(logrus.Fields{"file": res.FilePath, "inner": res.InnerPath, "err": res.Error}).Error("process error")
+			fields := logrus.Fields{"file": res.FilePath, "inner": res.InnerPath, "err": res.Error}
+			if errors.Is(res.Error, ErrEncrypted) || errors.Is(res.Error, ErrNoText) {
+				logrus.WithFields(fields).Warn("not scanned")
+				return
+			}
+			logrus.WithFields(fields).Error("process error")
 			return
 		}
 		if !res.Matched {
+			// the hash of a file whose findings were too many to hold for it
+			if res.Hash != "" {
+				logrus.WithFields(logrus.Fields{"file": res.FilePath, "inner": res.InnerPath, "hash": res.Hash}).Info("File hash")
+			}
 			return
 		}
 		// log basic info
-		if res.Line != "" {
-			logrus.WithFields(logrus.Fields{"file": res.FilePath, "line": res.LineNumber}).Info("Match found")
-		} else {
-			logrus.WithFields(logrus.Fields{"file": res.FilePath, "inner": res.InnerPath}).Info("Match found (full file)")
+		entry := logrus.NewEntry(logrus.StandardLogger())
+		if res.Location != "" {
+			entry = entry.WithField("location", res.Location)
+		}
+		if res.MessageID != "" || res.Subject != "" {
+			entry = entry.WithFields(logrus.Fields{"message_id": res.MessageID, "subject": res.Subject})
+		}
+		if res.Commit != "" {
+			entry = entry.WithFields(logrus.Fields{"commit": res.Commit, "author": res.Author, "date": res.Date.Format(time.RFC3339)})
+		}
+		if res.Layer != "" {
+			entry = entry.WithFields(logrus.Fields{"layer": res.Layer, "created_by": res.LayerCmd})
+		}
+		if res.Hash != "" {
+			entry = entry.WithField("hash", res.Hash)
+		}
+		if res.Decoded != "" {
+			entry = entry.WithField("decoded", res.Decoded)
+		}
+		switch {
+		case res.NameMatch:
+			entry.WithFields(logrus.Fields{"file": res.FilePath, "inner": res.InnerPath, "rule": res.Pattern}).Info("Match found (name)")
+		case res.Rule != "":
+			entry.WithFields(logrus.Fields{"file": res.FilePath, "inner": res.InnerPath, "rule": res.Rule, "strings": strings.Join(res.Strings, ",")}).Info("Match found (yara)")
+		case res.ByteMatch:
+			entry.WithFields(logrus.Fields{"file": res.FilePath, "inner": res.InnerPath, "offset": res.Offset}).Info("Match found (bytes)")
+		case res.HashMatch:
+			entry.WithFields(logrus.Fields{"file": res.FilePath, "inner": res.InnerPath, "rule": res.Pattern}).Info("Match found (hash)")
+		case res.Encrypted:
+			if res.Password != "" {
+				entry = entry.WithField("password", res.Password)
+			}
+			entry.WithFields(logrus.Fields{"file": res.FilePath, "inner": res.InnerPath}).Info("Match found (encrypted)")
+		case res.Line != "" || res.Snippet != "":
+			if res.Column > 0 {
+				entry = entry.WithField("column", res.Column)
+			}
+			if res.Snippet != "" {
+				entry = entry.WithField("snippet", res.Snippet)
+			}
+			if len(res.Before) > 0 {
+				entry = entry.WithField("before", strings.Join(res.Before, "\n"))
+			}
+			if len(res.After) > 0 {
+				entry = entry.WithField("after", strings.Join(res.After, "\n"))
+			}
+			if res.InnerPath != "" {
+				entry = entry.WithField("inner", res.InnerPath)
+			}
+			entry.WithFields(logrus.Fields{"file": res.FilePath, "line": res.LineNumber}).Info("Match found")
+		default:
+			entry.WithFields(logrus.Fields{"file": res.FilePath, "inner": res.InnerPath}).Info("Match found (full file)")
 		}
 		stats.Matches.Add(1)
 
+		// non-line matches - record the path (and offset) instead
+		line := res.Line
+		switch {
+		case line == "" && res.Snippet != "":
+			line = res.Snippet
+		case res.ByteMatch:
+			line = fmt.Sprintf("%s@%d %s", DisplayPath(res.FilePath, res.InnerPath), res.Offset, res.Line)
+		case res.Rule != "":
+			line = DisplayPath(res.FilePath, res.InnerPath) + " " + res.Rule + " " + strings.Join(res.Strings, ",")
+		case res.NameMatch || res.HashMatch || res.Encrypted:
+			line = DisplayPath(res.FilePath, res.InnerPath)
+		}
+		if line != "" && (len(res.Before)+len(res.After) > 0 || res.GroupEnd) {
+			line = withContext(res.Before, line, res.After, res.GroupEnd)
+		}
+
 		// single sink file
-		if opts.SaveMatchesFile != "" && res.Line != "" {
+		if opts.SaveMatchesFile != "" && line != "" {
 			matchesFileMu.Lock()
 			if f, err := os.OpenFile(opts.SaveMatchesFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err == nil {
-				if !strings.HasSuffix(res.Line, "\n") {
-					_, _ = io.WriteString(f, res.Line+"\n")
+				if !strings.HasSuffix(line, "\n") {
+					_, _ = io.WriteString(f, line+"\n")
 				} else {
-					_, _ = io.WriteString(f, res.Line)
+					_, _ = io.WriteString(f, line)
 				}
 				_ = f.Close()
 			}
@@ -74,7 +170,7 @@ func NewResultSink(opts ScanOptions, stats *AppStats) func(MatchResult) {
 		}
 
 		// per-pattern files
-		if opts.SaveMatchesByPatternFolder != "" && res.Line != "" && res.Pattern != "" {
+		if opts.SaveMatchesByPatternFolder != "" && line != "" && res.Pattern != "" {
 			_ = os.MkdirAll(opts.SaveMatchesByPatternFolder, 0755)
 			name := Sanitize(res.Pattern) + ".txt"
 			path := filepath.Join(opts.SaveMatchesByPatternFolder, name)
@@ -82,10 +178,10 @@ func NewResultSink(opts ScanOptions, stats *AppStats) func(MatchResult) {
 			mu := muAny.(*sync.Mutex)
 			mu.Lock()
 			if f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err == nil {
-				if !strings.HasSuffix(res.Line, "\n") {
-					_, _ = io.WriteString(f, res.Line+"\n")
+				if !strings.HasSuffix(line, "\n") {
+					_, _ = io.WriteString(f, line+"\n")
 				} else {
-					_, _ = io.WriteString(f, res.Line)
+					_, _ = io.WriteString(f, line)
 				}
 				_ = f.Close()
 			}
@@ -94,12 +190,48 @@ func NewResultSink(opts ScanOptions, stats *AppStats) func(MatchResult) {
 	}
 }
 
+// withContext surrounds a matched line with its context lines; "--" closes
+// the last block of a group like grep's group separator, and closes a file's
+// last group too, since groups from different files interleave.
+func withContext(before []string, line string, after []string, end bool) string {
+	var b strings.Builder
+	for _, l := range before {
+		b.WriteString(l)
+		b.WriteByte('\n')
+	}
+	b.WriteString(strings.TrimSuffix(line, "\n"))
+	b.WriteByte('\n')
+	for _, l := range after {
+		b.WriteString(l)
+		b.WriteByte('\n')
+	}
+	if end {
+		b.WriteString("--")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
 // Scan is the main pipeline.
 func (fs *FileScanner) Scan(ctx context.Context, opts ScanOptions, onMatch func(MatchResult)) error {
-	patterns, hasInsensitive, err := LoadPatterns(opts.PatternFile)
+	rules, err := LoadRules(opts.PatternFile)
 	if err != nil {
 		return err
 	}
+	if opts.NamesOnly && len(rules.Names) == 0 {
+		return errors.New("names-only: pattern file has no name: or path: rules")
+	}
+	if len(opts.YaraFiles) > 0 {
+		if rules.Yara, err = LoadYaraRules(opts.YaraFiles...); err != nil {
+			return err
+		}
+		logrus.Debugf("Loaded %d yara rules", len(rules.Yara))
+	}
+	if opts.ArchivePasswords != "" {
+		if opts.passwords, err = loadPasswords(opts.ArchivePasswords); err != nil {
+			return err
+		}
+		logrus.Debugf("Loaded %d archive passwords", len(opts.passwords))
+	}
 
 	var (
 		found     atomic.Int64
@@ -107,6 +239,13 @@ func (fs *FileScanner) Scan(ctx context.Context, opts ScanOptions, onMatch func(
 		errorsC   atomic.Int64
 		matches   atomic.Int64
 	)
+	if opts.Stats != nil {
+		opts.Stats.BinaryMode = opts.Binary
+		defer func() {
+			opts.Stats.FilesFound.Store(found.Load())
+			opts.Stats.FilesProcessed.Store(processed.Load())
+		}()
+	}
 
 	fileCh := make(chan Task, 2048)
 	var wg sync.WaitGroup
@@ -117,11 +256,27 @@ func (fs *FileScanner) Scan(ctx context.Context, opts ScanOptions, onMatch func(
 			return
 		}
 		t := i.(Task)
-		processed.Add(1)
-		if t.isArchive {
-			fs.scanArchiveFile(t.path, t.innerPath, patterns, hasInsensitive, opts, onMatch, &matches, &errorsC)
-		} else {
-			fs.scanRegularFile(t.path, patterns, hasInsensitive, opts, onMatch, &matches, &errorsC)
+		if t.split == nil || t.part == 0 {
+			processed.Add(1)
+		}
+		switch {
+		case t.isGit:
+			fs.scanGitRepo(ctx, t.path, rules, opts, onMatch, &matches, &errorsC)
+		case t.isImage:
+			fs.scanImage(ctx, t.path, rules, opts, onMatch, &matches, &errorsC)
+		case t.split != nil:
+			fs.scanFilePart(t.split, t.part, rules, opts, &matches, &errorsC)
+		case t.isStdin:
+			fs.scanStdin(ctx, rules, opts, onMatch, &matches, &errorsC)
+		case t.encrypted:
+			matches.Add(1)
+			onMatch(MatchResult{FilePath: t.path, Matched: true, Encrypted: true, Password: t.password, Pattern: encryptedPattern})
+		case t.isArchive:
+			hash := fs.scanArchiveFile(ctx, t.path, t.innerPath, t.password, rules, opts, onMatch, &matches, &errorsC)
+			matchName(rules, t.path, t.innerPath, hash, onMatch, &matches)
+		default:
+			hash := fs.scanRegularFile(ctx, t.path, rules, opts, onMatch, &matches, &errorsC)
+			matchName(rules, t.path, "", hash, onMatch, &matches)
 		}
 	})
 	if err != nil {
@@ -133,6 +288,14 @@ func (fs *FileScanner) Scan(ctx context.Context, opts ScanOptions, onMatch func(
 	walkErr := make(chan error, 1)
 	go func() {
 		defer close(walkErr)
+		if opts.Stdin {
+			found.Add(1)
+			select {
+			case fileCh <- Task{path: opts.StdinName, isStdin: true}:
+			case <-ctx.Done():
+				return
+			}
+		}
 		for _, root := range opts.Roots {
 			if ctx.Err() != nil {
 				return
@@ -149,6 +312,32 @@ func (fs *FileScanner) Scan(ctx context.Context, opts ScanOptions, onMatch func(
 					}
 					return nil
 				}
+				if opts.GitHistory {
+					if repo, ok := gitRepoAt(path, d); ok {
+						found.Add(1)
+						select {
+						case fileCh <- Task{path: repo, isGit: true}:
+						case <-ctx.Done():
+							return ctx.Err()
+						}
+						if d.IsDir() {
+							return filepath.SkipDir // objects are read through the history
+						}
+						return nil
+					}
+				}
+				if opts.Images && imageAt(path, d) {
+					found.Add(1)
+					select {
+					case fileCh <- Task{path: path, isImage: true}:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+					if d.IsDir() {
+						return filepath.SkipDir // blobs are read through the manifests
+					}
+					return nil
+				}
 				if d.IsDir() {
 					return nil
 				}
@@ -156,8 +345,17 @@ func (fs *FileScanner) Scan(ctx context.Context, opts ScanOptions, onMatch func(
 				if !opts.allowedExt(ext) {
 					return nil
 				}
-				if opts.Archives && IsArchive(path) {
+				// a file or entry whose content is read reports its name hit
+				// from the worker, once its hash is known
+				if opts.Archives && (IsArchive(path) || isDiskImage(path)) {
+					matchName(rules, path, "", "", onMatch, &matches)
 					WalkArchive(ctx, path, func(t Task) {
+						if opts.NamesOnly {
+							if !t.encrypted {
+								matchName(rules, t.path, t.innerPath, "", onMatch, &matches)
+							}
+							return
+						}
 						select {
 						case fileCh <- t:
 							found.Add(1)
@@ -167,10 +365,28 @@ func (fs *FileScanner) Scan(ctx context.Context, opts ScanOptions, onMatch func(
 					return nil
 				}
 				found.Add(1)
-				select {
-				case fileCh <- Task{path: path}:
-				case <-ctx.Done():
-					return ctx.Err()
+				if opts.NamesOnly {
+					matchName(rules, path, "", "", onMatch, &matches)
+					return nil
+				}
+				tasks := []Task{{path: path}}
+				if opts.SplitSize > 0 {
+					if info, err := d.Info(); err == nil {
+						if sf := newSplitFile(path, info.Size(), rules, opts, onMatch); sf != nil {
+							matchName(rules, path, "", "", onMatch, &matches)
+							tasks = tasks[:0]
+							for i := range sf.parts {
+								tasks = append(tasks, Task{path: path, split: sf, part: i})
+							}
+						}
+					}
+				}
+				for _, t := range tasks {
+					select {
+					case fileCh <- t:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
 				}
 				return nil
 			})
@@ -198,8 +414,12 @@ func (fs *FileScanner) Scan(ctx context.Context, opts ScanOptions, onMatch func(
 				}
 			}
 		case <-ticker.C:
-			logrus.Infof("Stats: found=%d processed=%d matches=%d errors=%d",
-				found.Load(), processed.Load(), matches.Load(), errorsC.Load())
+			var binaries int64
+			if opts.Stats != nil {
+				binaries = opts.Stats.BinaryFiles.Load()
+			}
+			logrus.Infof("Stats: found=%d processed=%d matches=%d errors=%d binary=%d (%s)",
+				found.Load(), processed.Load(), matches.Load(), errorsC.Load(), binaries, opts.Binary)
 		case <-ctx.Done():
 			return ctx.Err()
 		case err := <-walkErr:
@@ -212,8 +432,9 @@ func (fs *FileScanner) Scan(ctx context.Context, opts ScanOptions, onMatch func(
 				}
 				return err
 			}
-			// walker done - close input
+			// walker done - close input; nil channel so this case never fires again
 			close(fileCh)
+			walkErr = nil
 		}
 	}
 
@@ -221,52 +442,106 @@ func (fs *FileScanner) Scan(ctx context.Context, opts ScanOptions, onMatch func(
 	return nil
 }
 
+// matchName reports a name rule hit for a file or archive entry, with the
+// hash of its content if that was read.
+func matchName(rules *RuleSet, filePath, innerPath, hash string, onMatch func(MatchResult), matchCnt *atomic.Int64) {
+	if len(rules.Names) == 0 {
+		return
+	}
+	full := filepath.ToSlash(filePath)
+	if innerPath != "" {
+		full += "/" + innerPath
+	}
+	if p, ok := rules.MatchName(full); ok {
+		matchCnt.Add(1)
+		onMatch(MatchResult{FilePath: filePath, InnerPath: innerPath, Matched: true, NameMatch: true, Pattern: p.Desc(), Hash: hash})
+	}
+}
+
+// DisplayPath joins a file path and an optional inner archive path for output.
+func DisplayPath(filePath, innerPath string) string {
+	if innerPath == "" {
+		return filePath
+	}
+	return filePath + "::" + innerPath
+}
+
+// scanRegularFile scans a file on disk and returns its hash, "" if it has none.
 func (fs *FileScanner) scanRegularFile(
+	ctx context.Context,
 	path string,
-	patterns []Pattern,
-	hasInsensitive bool,
+	rules *RuleSet,
 	opts ScanOptions,
 	onMatch func(MatchResult),
 	matchCnt, errCnt *atomic.Int64,
-) {
-	if IsArchive(path) {
-		return
+) string {
+	if IsArchive(path) || isSQLiteWAL(path) {
+		return ""
 	}
 	f, err := os.Open(path)
 	if err != nil {
 		errCnt.Add(1)
 		onMatch(MatchResult{FilePath: path, Error: err})
-		return
+		return ""
 	}
 	defer f.Close()
 
-	matchReader(f, patterns, hasInsensitive, opts.SaveFull, opts.SaveFullFolder, onMatch, path, "", matchCnt, errCnt)
+	if isStructured(path) {
+		return fs.scanContent(ctx, f, path, "", rules, opts, onMatch, matchCnt, errCnt)
+	}
+	if hasSQLiteMagic(f) {
+		return fs.scanSQLite(f, path, "", rules, opts, onMatch, matchCnt, errCnt)
+	}
+	if opts.Mmap {
+		if m, err := mapFile(f); err == nil {
+			defer m.Close()
+			// a file truncated under the mapping faults; report it instead of crashing
+			defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+			defer func() {
+				if r := recover(); r != nil {
+					errCnt.Add(1)
+					onMatch(MatchResult{FilePath: path, Error: fmt.Errorf("mmap read: %v", r)})
+				}
+			}()
+			return matchReader(m, rules, opts, onMatch, path, "", matchCnt, errCnt)
+		}
+	}
+	return matchReader(f, rules, opts, onMatch, path, "", matchCnt, errCnt)
 }
 
+// scanArchiveFile scans an archive entry and returns its hash, "" if it has none.
 func (fs *FileScanner) scanArchiveFile(
-	archivePath, innerPath string,
-	patterns []Pattern,
-	hasInsensitive bool,
+	ctx context.Context,
+	archivePath, innerPath, password string,
+	rules *RuleSet,
 	opts ScanOptions,
 	onMatch func(MatchResult),
 	matchCnt, errCnt *atomic.Int64,
-) {
-	fsys, err := archives.FileSystem(context.Background(), archivePath, nil)
+) string {
+	fsys, err := archiveFS(ctx, archivePath)
 	if err != nil {
 		errCnt.Add(1)
 		onMatch(MatchResult{FilePath: archivePath, InnerPath: innerPath, Error: err})
-		return
+		return ""
 	}
 	if closer, ok := fsys.(io.Closer); ok {
 		defer closer.Close()
 	}
+	if password != "" {
+		if locked, ok := withPassword(fsys, password); ok {
+			fsys = locked
+		}
+	}
 	f, err := fsys.Open(innerPath)
 	if err != nil {
+		if open := lockedEntry(fsys, err, archivePath, innerPath); open != nil {
+			return fs.scanEncrypted(ctx, open, archivePath, innerPath, rules, opts, onMatch, matchCnt, errCnt)
+		}
 		errCnt.Add(1)
 		onMatch(MatchResult{FilePath: archivePath, InnerPath: innerPath, Error: err})
-		return
+		return ""
 	}
 	defer f.Close()
 
-	matchReader(f, patterns, hasInsensitive, opts.SaveFull, opts.SaveFullFolder, onMatch, archivePath, innerPath, matchCnt, errCnt)
+	return fs.scanArchiveEntry(ctx, fsys, f, archivePath, innerPath, rules, opts, onMatch, matchCnt, errCnt)
 }
@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func collectScan(t *testing.T, opts ScanOptions) []MatchResult {
+	t.Helper()
+	opts.Prepare()
+	var mu sync.Mutex
+	var got []MatchResult
+	err := NewFileScanner().Scan(context.Background(), opts, func(m MatchResult) {
+		if m.Matched {
+			mu.Lock()
+			got = append(got, m)
+			mu.Unlock()
+		}
+	})
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	return got
+}
+
+// scanFile writes data to path unless it is nil and scans the file for one
+// plain pattern; any error fails the test.
+func scanFile(t *testing.T, path string, data []byte, pattern string) []MatchResult {
+	t.Helper()
+	if data != nil {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rules := &RuleSet{Patterns: []Pattern{&PlainPattern{s: []byte(pattern)}}}
+	var got []MatchResult
+	var matchCnt, errCnt atomic.Int64
+	NewFileScanner().scanRegularFile(context.Background(), path, rules, ScanOptions{}, func(m MatchResult) {
+		if m.Error != nil {
+			t.Errorf("unexpected error: %v", m.Error)
+		}
+		got = append(got, m)
+	}, &matchCnt, &errCnt)
+	return got
+}
+
+func TestScan_NamesOnly(t *testing.T) {
+	dir := t.TempDir()
+	pf := filepath.Join(dir, "p.txt")
+	_ = os.WriteFile(pf, []byte("secret\nname:*.kdbx\n"), 0644)
+	root := filepath.Join(dir, "root")
+	_ = os.MkdirAll(root, 0755)
+	_ = os.WriteFile(filepath.Join(root, "vault.kdbx"), []byte("binary"), 0644)
+	_ = os.WriteFile(filepath.Join(root, "notes.txt"), []byte("secret\n"), 0644)
+
+	got := collectScan(t, ScanOptions{Roots: []string{root}, PatternFile: pf, NamesOnly: true, Threads: 2})
+	if len(got) != 1 || !got[0].NameMatch || filepath.Base(got[0].FilePath) != "vault.kdbx" {
+		t.Fatalf("unexpected results: %+v", got)
+	}
+
+	// without names-only both name and content rules fire
+	got = collectScan(t, ScanOptions{Roots: []string{root}, PatternFile: pf, Threads: 2})
+	if len(got) != 2 {
+		t.Fatalf("want 2 results, got %+v", got)
+	}
+}
+
+func TestScan_NamesOnlyWithoutNameRules(t *testing.T) {
+	dir := t.TempDir()
+	pf := filepath.Join(dir, "p.txt")
+	_ = os.WriteFile(pf, []byte("secret\n"), 0644)
+	opts := ScanOptions{Roots: []string{dir}, PatternFile: pf, NamesOnly: true}
+	opts.Prepare()
+	if err := NewFileScanner().Scan(context.Background(), opts, func(MatchResult) {}); err == nil {
+		t.Fatal("expected error without name rules")
+	}
+}
@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// splitMinPart is the smallest part a large file is cut into (var for tests).
+var splitMinPart int64 = 16 << 20
+
+// splitFile is a large regular file scanned as parallel parts. Part
+// boundaries sit right after a newline, so every line belongs to one part
+// and the parts need no overlap: a plain string never crosses a newline.
+// Parts report local line numbers and offsets; results are held per part and
+// flushed in file order once all earlier parts are done, rebased onto the
+// lines and bytes before them. No part reads the whole file, so a file is
+// only split when its results need no hash: with NoHash and no hash rules.
+type splitFile struct {
+	path    string
+	size    int64
+	parts   int
+	onMatch func(MatchResult)
+
+	mu       sync.Mutex
+	done     []bool
+	lines    []int
+	results  [][]MatchResult
+	next     int // first part not flushed yet
+	lineBase int // lines in flushed parts
+}
+
+// newSplitFile returns nil when the file is small or the rule set needs the
+// whole stream in one reader (save-full, the file hash, hash rules, YARA, byte
+// rules, context, regular expressions).
+func newSplitFile(path string, size int64, rules *RuleSet, opts ScanOptions, onMatch func(MatchResult)) *splitFile {
+	if opts.SplitSize <= 0 || size <= opts.SplitSize || len(rules.Patterns) == 0 || isStructured(path) || isSQLiteFile(path) {
+		return nil
+	}
+	if opts.SaveFull || !opts.NoHash || len(rules.Hashes) > 0 || len(rules.Yara) > 0 || len(rules.Bytes) > 0 ||
+		opts.ContextBefore > 0 || opts.ContextAfter > 0 || opts.Binary != BinaryText || hasRegex(rules) {
+		return nil
+	}
+	parts := int(min(int64(opts.Threads), (size+splitMinPart-1)/splitMinPart))
+	if parts < 2 {
+		return nil
+	}
+	return &splitFile{
+		path: path, size: size, parts: parts, onMatch: onMatch,
+		done: make([]bool, parts), lines: make([]int, parts), results: make([][]MatchResult, parts),
+	}
+}
+
+// hasRegex reports whether a content rule is a regular expression. Flags such
+// as (?s) let one reach past a newline, where a part may end, so a file is
+// only split for plain strings.
+func hasRegex(rules *RuleSet) bool {
+	for _, p := range rules.Patterns {
+		if _, ok := p.(*RegexPattern); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// boundary returns where part i starts: the byte after the first newline at
+// or past its nominal start. Both neighbours compute it the same way.
+func (sf *splitFile) boundary(f io.ReaderAt, i int) (int64, error) {
+	if i == 0 {
+		return 0, nil
+	}
+	if i >= sf.parts {
+		return sf.size, nil
+	}
+	buf := make([]byte, 64<<10)
+	for pos := int64(i)*sf.size/int64(sf.parts) - 1; pos < sf.size; {
+		n, err := f.ReadAt(buf, pos)
+		if j := bytes.IndexByte(buf[:n], '\n'); j >= 0 {
+			return pos + int64(j) + 1, nil
+		}
+		pos += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return sf.size, nil
+}
+
+// finish records part i and flushes every part that is now in order.
+func (sf *splitFile) finish(i, lines int, results []MatchResult) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.done[i], sf.lines[i], sf.results[i] = true, lines, results
+	for sf.next < sf.parts && sf.done[sf.next] {
+		for _, r := range sf.results[sf.next] {
+			r.LineNumber += sf.lineBase
+			sf.onMatch(r)
+		}
+		sf.lineBase += sf.lines[sf.next]
+		sf.results[sf.next] = nil
+		sf.next++
+	}
+}
+
+// lineCounter counts newlines passing through, which is the number of lines
+// matchReader has seen in a part that ends after a newline.
+type lineCounter struct {
+	r io.Reader
+	n int
+}
+
+func (c *lineCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += bytes.Count(p[:n], []byte{'\n'})
+	return n, err
+}
+
+func (fs *FileScanner) scanFilePart(
+	sf *splitFile,
+	part int,
+	rules *RuleSet,
+	opts ScanOptions,
+	matchCnt, errCnt *atomic.Int64,
+) {
+	var results []MatchResult
+	collect := func(r MatchResult) { results = append(results, r) }
+	fail := func(err error) {
+		errCnt.Add(1)
+		sf.finish(part, 0, []MatchResult{{FilePath: sf.path, Error: err}})
+	}
+
+	f, err := os.Open(sf.path)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer f.Close()
+	start, err := sf.boundary(f, part)
+	if err != nil {
+		fail(err)
+		return
+	}
+	end, err := sf.boundary(f, part+1)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if part > 0 {
+		opts.Stats = nil // binary sniffing counts once, on the file head
+	}
+	opts.noHash = true
+	lc := &lineCounter{r: io.NewSectionReader(f, start, max(0, end-start))}
+	matchReader(lc, rules, opts, func(r MatchResult) {
+		r.Offset += start
+		collect(r)
+	}, sf.path, "", matchCnt, errCnt)
+	sf.finish(part, lc.n, results)
+}
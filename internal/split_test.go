@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestScan_SplitLargeFileMatchesStreaming(t *testing.T) {
+	old := splitMinPart
+	splitMinPart = 1 << 10
+	defer func() { splitMinPart = old }()
+
+	dir := t.TempDir()
+	pf := filepath.Join(dir, "p.txt")
+	_ = os.WriteFile(pf, []byte("secret\n"), 0644)
+	root := filepath.Join(dir, "root")
+	_ = os.MkdirAll(root, 0755)
+	var b strings.Builder
+	for i := range 2000 {
+		if i%97 == 0 {
+			fmt.Fprintf(&b, "line %d has a secret\n", i)
+		} else {
+			fmt.Fprintf(&b, "line %d is %s\n", i, strings.Repeat("x", i%40))
+		}
+	}
+	data := b.String()
+	_ = os.WriteFile(filepath.Join(root, "big.log"), []byte(data), 0644)
+
+	key := func(rs []MatchResult) []string {
+		var out []string
+		for _, r := range rs {
+			out = append(out, fmt.Sprintf("%d@%d %s", r.LineNumber, r.Offset, r.Line))
+		}
+		return out
+	}
+	want := key(collectScan(t, ScanOptions{Roots: []string{root}, PatternFile: pf, Threads: 4, NoHash: true}))
+	got := key(collectScan(t, ScanOptions{Roots: []string{root}, PatternFile: pf, Threads: 4, NoHash: true, SplitSize: 1}))
+	if len(want) != 21 || !slices.Equal(got, want) {
+		t.Fatalf("split results differ:\n got %v\nwant %v", got, want)
+	}
+	for _, r := range collectScan(t, ScanOptions{Roots: []string{root}, PatternFile: pf, Threads: 4, NoHash: true, SplitSize: 1}) {
+		if !strings.HasPrefix(data[r.Offset:], r.Line) {
+			t.Fatalf("offset %d does not point at %q", r.Offset, r.Line)
+		}
+	}
+}
+
+func TestSplitFile_Eligibility(t *testing.T) {
+	rules := &RuleSet{Patterns: []Pattern{&PlainPattern{s: []byte("x")}}}
+	opts := ScanOptions{SplitSize: 1, Threads: 8, Binary: BinaryText}
+	if newSplitFile("/f", 100*splitMinPart, rules, opts, nil) != nil {
+		t.Fatal("findings need the file hash")
+	}
+	opts.NoHash = true
+	if sf := newSplitFile("/f", 100*splitMinPart, rules, opts, nil); sf == nil || sf.parts != 8 {
+		t.Fatalf("expected 8 parts: %+v", sf)
+	}
+	if newSplitFile("/f", splitMinPart, rules, opts, nil) != nil {
+		t.Fatal("one part is not a split")
+	}
+	rules.Hashes = map[string]*HashPattern{"ab": {algo: hashSHA256, sum: "ab"}}
+	if newSplitFile("/f", 100*splitMinPart, rules, opts, nil) != nil {
+		t.Fatal("hash rules need the whole stream")
+	}
+	rules.Hashes = nil
+	rules.Patterns = append(rules.Patterns, &RegexPattern{re: regexp.MustCompile(`(?s)a.b`)})
+	if newSplitFile("/f", 100*splitMinPart, rules, opts, nil) != nil {
+		t.Fatal("a regex may span a cut")
+	}
+}
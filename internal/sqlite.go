@@ -0,0 +1,558 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync/atomic"
+	"unicode/utf16"
+)
+
+// SQLite databases (browser profiles, messengers, wallets) are recognised by
+// their header and read page by page with a small b-tree walker: every text
+// and blob cell of every rowid table is matched on its own as table.column,
+// with the rowid as location. The file is only ever opened for reading; a
+// -wal file next to it is overlaid in memory the way SQLite would read it,
+// and no -shm or journal file is created.
+
+const (
+	sqliteMagic   = "SQLite format 3\x00"
+	sqliteHeader  = 100
+	maxSQLiteCell = 256 << 20
+	maxSQLiteWAL  = 256 << 20
+	maxSQLiteTree = 64 // b-tree depth
+	walMagic      = 0x377f0682
+	walHeader     = 32
+	walFrame      = 24
+)
+
+var errSQLite = errors.New("sqlite: malformed database")
+
+// hasSQLiteMagic reports whether r starts with the SQLite header string.
+func hasSQLiteMagic(r io.ReaderAt) bool {
+	var b [len(sqliteMagic)]byte
+	_, err := r.ReadAt(b[:], 0)
+	return err == nil && string(b[:]) == sqliteMagic
+}
+
+// isSQLiteFile reports whether the file at path is a database.
+func isSQLiteFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return hasSQLiteMagic(f)
+}
+
+// isSQLiteWAL reports whether path is the write-ahead log of a database next
+// to it; such a log is read together with its database, not on its own.
+func isSQLiteWAL(path string) bool {
+	db, ok := strings.CutSuffix(path, "-wal")
+	return ok && isSQLiteFile(db)
+}
+
+// sniffSQLite reports whether the stream r holds a database; it returns a
+// reader that still yields the whole stream.
+func sniffSQLite(r io.Reader) (io.Reader, bool) {
+	br := bufio.NewReader(r)
+	b, _ := br.Peek(len(sqliteMagic))
+	return br, string(b) == sqliteMagic
+}
+
+// scanSQLite matches every cell of the database with the line patterns; the
+// byte-level rules and the hash see the database file itself. It returns the
+// hash of the file.
+func (fs *FileScanner) scanSQLite(
+	r io.Reader,
+	filePath, innerPath string,
+	rules *RuleSet,
+	opts ScanOptions,
+	onMatch func(MatchResult),
+	matchCnt, errCnt *atomic.Int64,
+) string {
+	fail := func(err error) {
+		errCnt.Add(1)
+		onMatch(MatchResult{FilePath: filePath, InnerPath: innerPath, Error: err})
+	}
+	f, size, done, err := randomAccess(r)
+	if err != nil {
+		fail(err)
+		return ""
+	}
+	defer done()
+	cellRules, cellOpts := recordRules(rules, opts)
+	records := func(ra io.ReaderAt, size int64, emit func(MatchResult)) error {
+		db, err := openSQLite(ra, size)
+		if err != nil {
+			return err
+		}
+		if innerPath == "" {
+			if wal, err := os.Open(filePath + "-wal"); err == nil {
+				err = db.applyWAL(wal)
+				wal.Close()
+				if err != nil {
+					fail(err)
+				}
+			}
+		}
+		tables, err := db.tables()
+		if err != nil {
+			return err
+		}
+		for _, t := range tables {
+			err := db.rows(t.root, func(rowid int64, rec []byte) error {
+				return sqliteRecord(rec, func(col int, text bool, v []byte) {
+					if text {
+						v = db.text(v)
+					}
+					name := fmt.Sprintf("%s.%s", t.name, t.column(col))
+					loc := fmt.Sprintf("rowid %d", rowid)
+					matchReader(bytes.NewReader(v), cellRules, cellOpts, func(m MatchResult) {
+						if m.Location == "" {
+							m.Location = loc
+						}
+						emit(m)
+					}, filePath, joinInner(innerPath, name), matchCnt, errCnt)
+				})
+			})
+			if err != nil {
+				fail(fmt.Errorf("table %s: %w", t.name, err))
+			}
+		}
+		return nil
+	}
+	dr := &docReader{Reader: io.NewSectionReader(f, 0, size), ra: f, size: size, records: records}
+	return matchReader(dr, rules, opts, onMatch, filePath, innerPath, matchCnt, errCnt)
+}
+
+type sqliteDB struct {
+	r        io.ReaderAt
+	pageSize int
+	usable   int
+	pages    uint32
+	utf16    binary.ByteOrder // nil for UTF-8
+	wal      map[uint32][]byte
+}
+
+type sqliteTable struct {
+	name    string
+	root    uint32
+	columns []string
+}
+
+// column names the col-th record field, falling back to its position when
+// the schema could not be parsed.
+func (t sqliteTable) column(col int) string {
+	if col < len(t.columns) {
+		return t.columns[col]
+	}
+	return fmt.Sprintf("col%d", col+1)
+}
+
+func openSQLite(r io.ReaderAt, size int64) (*sqliteDB, error) {
+	hdr := make([]byte, sqliteHeader)
+	if _, err := r.ReadAt(hdr, 0); err != nil || string(hdr[:16]) != sqliteMagic {
+		return nil, errors.New("sqlite: bad header")
+	}
+	be := binary.BigEndian
+	db := &sqliteDB{r: r, pageSize: int(be.Uint16(hdr[16:]))}
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	if db.pageSize < 512 || db.pageSize&(db.pageSize-1) != 0 {
+		return nil, fmt.Errorf("sqlite: bad page size %d", db.pageSize)
+	}
+	db.usable = db.pageSize - int(hdr[20])
+	if db.usable < 480 {
+		return nil, errSQLite
+	}
+	db.pages = uint32(size / int64(db.pageSize))
+	if n := be.Uint32(hdr[28:]); n != 0 && be.Uint32(hdr[24:]) == be.Uint32(hdr[92:]) {
+		db.pages = min(db.pages, n)
+	}
+	switch be.Uint32(hdr[56:]) {
+	case 2:
+		db.utf16 = binary.LittleEndian
+	case 3:
+		db.utf16 = binary.BigEndian
+	}
+	return db, nil
+}
+
+// applyWAL overlays the pages of the committed transactions of a -wal file.
+// Frames after the last commit, or with a wrong salt or checksum, are left
+// out like SQLite itself does.
+func (db *sqliteDB) applyWAL(f *os.File) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() > maxSQLiteWAL {
+		return fmt.Errorf("sqlite: wal larger than %d MiB, not read", maxSQLiteWAL>>20)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	be := binary.BigEndian
+	if len(data) < walHeader || be.Uint32(data)&^1 != walMagic {
+		return nil // empty or reset log
+	}
+	if int(be.Uint32(data[8:])) != db.pageSize {
+		return errors.New("sqlite: wal page size differs from database")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if be.Uint32(data)&1 == 1 {
+		order = binary.BigEndian
+	}
+	s0, s1 := walChecksum(order, data[:24], 0, 0)
+	if s0 != be.Uint32(data[24:]) || s1 != be.Uint32(data[28:]) {
+		return nil
+	}
+	salt := data[16:24]
+	pending := map[uint32][]byte{}
+	for off := walHeader; off+walFrame+db.pageSize <= len(data); off += walFrame + db.pageSize {
+		fh, page := data[off:off+walFrame], data[off+walFrame:off+walFrame+db.pageSize]
+		if !bytes.Equal(fh[8:16], salt) {
+			break
+		}
+		s0, s1 = walChecksum(order, fh[:8], s0, s1)
+		s0, s1 = walChecksum(order, page, s0, s1)
+		if s0 != be.Uint32(fh[16:]) || s1 != be.Uint32(fh[20:]) {
+			break
+		}
+		pending[be.Uint32(fh)] = page
+		if commit := be.Uint32(fh[4:]); commit != 0 {
+			if db.wal == nil {
+				db.wal = map[uint32][]byte{}
+			}
+			for n, p := range pending {
+				db.wal[n] = p
+			}
+			clear(pending)
+			db.pages = commit
+		}
+	}
+	return nil
+}
+
+// walChecksum continues the running checksum of a WAL over b.
+func walChecksum(order binary.ByteOrder, b []byte, s0, s1 uint32) (uint32, uint32) {
+	for i := 0; i+8 <= len(b); i += 8 {
+		s0 += order.Uint32(b[i:]) + s1
+		s1 += order.Uint32(b[i+4:]) + s0
+	}
+	return s0, s1
+}
+
+func (db *sqliteDB) page(n uint32) ([]byte, error) {
+	if n == 0 || n > db.pages {
+		return nil, fmt.Errorf("sqlite: page %d out of range", n)
+	}
+	if p, ok := db.wal[n]; ok {
+		return p, nil
+	}
+	p := make([]byte, db.pageSize)
+	if _, err := db.r.ReadAt(p, int64(n-1)*int64(db.pageSize)); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// tables lists the rowid tables in sqlite_schema. WITHOUT ROWID and
+// virtual tables have no table b-tree of their own and are left out.
+func (db *sqliteDB) tables() ([]sqliteTable, error) {
+	var tables []sqliteTable
+	err := db.rows(1, func(_ int64, rec []byte) error {
+		var f [5][]byte
+		var root int64
+		err := sqliteRecord(rec, func(col int, _ bool, v []byte) {
+			if col < len(f) {
+				f[col] = v
+			}
+		})
+		if err != nil {
+			return err
+		}
+		root, _ = sqliteInt(rec, 3)
+		name, sql := string(db.text(f[1])), string(db.text(f[4]))
+		if string(db.text(f[0])) != "table" || root <= 0 || strings.HasPrefix(name, "sqlite_") ||
+			strings.Contains(strings.ToUpper(sql), "WITHOUT ROWID") {
+			return nil
+		}
+		tables = append(tables, sqliteTable{name: name, root: uint32(root), columns: sqliteColumns(sql)})
+		return nil
+	})
+	return tables, err
+}
+
+// rows walks a table b-tree in rowid order and hands fn each record.
+func (db *sqliteDB) rows(root uint32, fn func(rowid int64, rec []byte) error) error {
+	seen := map[uint32]bool{}
+	var walk func(n uint32, depth int) error
+	walk = func(n uint32, depth int) error {
+		if depth > maxSQLiteTree || seen[n] {
+			return errSQLite
+		}
+		seen[n] = true
+		p, err := db.page(n)
+		if err != nil {
+			return err
+		}
+		h := 0
+		if n == 1 {
+			h = sqliteHeader
+		}
+		if len(p) < h+12 {
+			return errSQLite
+		}
+		be := binary.BigEndian
+		typ, cells := p[h], int(be.Uint16(p[h+3:]))
+		ptrs := h + 8
+		if typ == 0x05 {
+			ptrs = h + 12
+		}
+		if ptrs+2*cells > db.usable {
+			return errSQLite
+		}
+		for i := range cells {
+			off := int(be.Uint16(p[ptrs+2*i:]))
+			if off < ptrs || off >= db.usable {
+				return errSQLite
+			}
+			switch typ {
+			case 0x05: // interior: left child, rowid
+				if off+4 > len(p) {
+					return errSQLite
+				}
+				if err := walk(be.Uint32(p[off:]), depth+1); err != nil {
+					return err
+				}
+			case 0x0d: // leaf: payload size, rowid, payload
+				size, k := sqliteVarint(p[off:db.usable])
+				if k == 0 {
+					return errSQLite
+				}
+				rowid, m := sqliteVarint(p[off+k : db.usable])
+				if m == 0 {
+					return errSQLite
+				}
+				rec, err := db.payload(p, off+k+m, size)
+				if err != nil {
+					return err
+				}
+				if err := fn(int64(rowid), rec); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("sqlite: page %d is not a table page", n)
+			}
+		}
+		if typ == 0x05 {
+			return walk(be.Uint32(p[h+8:]), depth+1)
+		}
+		return nil
+	}
+	return walk(root, 0)
+}
+
+// payload returns a cell's payload, following its overflow chain if it does
+// not fit on the page.
+func (db *sqliteDB) payload(p []byte, off int, size uint64) ([]byte, error) {
+	if size > maxSQLiteCell {
+		return nil, fmt.Errorf("sqlite: cell larger than %d MiB", maxSQLiteCell>>20)
+	}
+	u, n := db.usable, int(size)
+	local := n
+	if x := u - 35; n > x {
+		m := (u-12)*32/255 - 23
+		local = m + (n-m)%(u-4)
+		if local > x {
+			local = m
+		}
+	}
+	if off+local > u || local < n && off+local+4 > u {
+		return nil, errSQLite
+	}
+	if local == n {
+		return p[off : off+n], nil
+	}
+	out := make([]byte, 0, n)
+	out = append(out, p[off:off+local]...)
+	next := binary.BigEndian.Uint32(p[off+local:])
+	for len(out) < n {
+		if next == 0 {
+			return nil, errSQLite
+		}
+		ov, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		next = binary.BigEndian.Uint32(ov)
+		out = append(out, ov[4:min(u, 4+n-len(out))]...)
+	}
+	return out, nil
+}
+
+// text converts a text value to UTF-8.
+func (db *sqliteDB) text(v []byte) []byte {
+	if db.utf16 == nil {
+		return v
+	}
+	u := make([]uint16, len(v)/2)
+	for i := range u {
+		u[i] = db.utf16.Uint16(v[2*i:])
+	}
+	return []byte(string(utf16.Decode(u)))
+}
+
+// sqliteVarint decodes a big-endian varint of up to 9 bytes; k is 0 if b
+// is too short.
+func sqliteVarint(b []byte) (v uint64, k int) {
+	for i := 0; i < 9 && i < len(b); i++ {
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// sqliteFields walks the serial types of a record, handing fn each field's
+// index, type and bytes.
+func sqliteFields(rec []byte, fn func(col int, typ uint64, v []byte)) error {
+	hlen, k := sqliteVarint(rec)
+	if k == 0 || hlen > uint64(len(rec)) {
+		return errSQLite
+	}
+	body := int(hlen)
+	for col, pos := 0, k; pos < int(hlen); col++ {
+		typ, m := sqliteVarint(rec[pos:hlen])
+		if m == 0 {
+			return errSQLite
+		}
+		pos += m
+		var n uint64
+		switch {
+		case typ <= 4:
+			n = typ
+		case typ == 5:
+			n = 6
+		case typ == 6 || typ == 7:
+			n = 8
+		case typ >= 12:
+			n = (typ - 12) / 2
+		}
+		if n > uint64(len(rec)-body) {
+			return errSQLite
+		}
+		fn(col, typ, rec[body:body+int(n)])
+		body += int(n)
+	}
+	return nil
+}
+
+// sqliteRecord hands fn the text and blob fields of a record.
+func sqliteRecord(rec []byte, fn func(col int, text bool, v []byte)) error {
+	return sqliteFields(rec, func(col int, typ uint64, v []byte) {
+		if typ >= 12 {
+			fn(col, typ%2 == 1, v)
+		}
+	})
+}
+
+// sqliteInt returns the integer in field col of a record.
+func sqliteInt(rec []byte, col int) (int64, bool) {
+	var v int64
+	ok := false
+	_ = sqliteFields(rec, func(i int, typ uint64, b []byte) {
+		if i != col {
+			return
+		}
+		switch {
+		case typ >= 1 && typ <= 6:
+			for _, c := range b {
+				v = v<<8 | int64(c)
+			}
+			v = v << (64 - 8*len(b)) >> (64 - 8*len(b)) // sign-extend
+			ok = true
+		case typ == 8 || typ == 9:
+			v, ok = int64(typ-8), true
+		case typ == 7:
+			f := math.Float64frombits(binary.BigEndian.Uint64(b))
+			v, ok = int64(f), true
+		}
+	})
+	return v, ok
+}
+
+// sqliteColumns pulls column names out of a CREATE TABLE statement; table
+// constraints after the columns are skipped.
+func sqliteColumns(sql string) []string {
+	open, end := strings.IndexByte(sql, '('), strings.LastIndexByte(sql, ')')
+	if open < 0 || end < open {
+		return nil
+	}
+	var defs []string
+	depth, start := 0, open+1
+	var quote byte
+	for i := open + 1; i < end; i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			defs = append(defs, sql[start:i])
+			start = i + 1
+		}
+	}
+	defs = append(defs, sql[start:end])
+
+	var cols []string
+	for _, d := range defs {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		var name string
+		if q := d[0]; q == '"' || q == '`' || q == '[' {
+			closeQ := q
+			if q == '[' {
+				closeQ = ']'
+			}
+			i := strings.IndexByte(d[1:], closeQ)
+			if i < 0 {
+				return cols
+			}
+			name = d[1 : 1+i]
+		} else {
+			name, _, _ = strings.Cut(strings.Join(strings.Fields(d), " "), " ")
+			switch strings.ToUpper(name) {
+			case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+				return cols
+			}
+		}
+		cols = append(cols, name)
+	}
+	return cols
+}
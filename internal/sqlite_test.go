@@ -0,0 +1,276 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const testPageSize = 512
+
+// sqlDB lays out a database with 512-byte pages; page 1 is reserved for
+// the schema.
+type sqlDB struct{ pages [][]byte }
+
+func sqlVarint(b []byte, v uint64) []byte {
+	if v > 1<<56-1 {
+		panic("varint too large for test")
+	}
+	var tmp []byte
+	for {
+		tmp = append(tmp, byte(v&0x7f))
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+	for i := len(tmp) - 1; i >= 0; i-- {
+		c := tmp[i]
+		if i > 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// sqlRecord encodes nil, int64, string (text) and []byte (blob) values.
+func sqlRecord(vals ...any) []byte {
+	var hdr, body []byte
+	for _, v := range vals {
+		switch v := v.(type) {
+		case nil:
+			hdr = sqlVarint(hdr, 0)
+		case int64:
+			hdr = sqlVarint(hdr, 6)
+			body = binary.BigEndian.AppendUint64(body, uint64(v))
+		case string:
+			hdr = sqlVarint(hdr, uint64(2*len(v)+13))
+			body = append(body, v...)
+		case []byte:
+			hdr = sqlVarint(hdr, uint64(2*len(v)+12))
+			body = append(body, v...)
+		}
+	}
+	return slices.Concat(sqlVarint(nil, uint64(len(hdr)+1)), hdr, body)
+}
+
+func (d *sqlDB) alloc() uint32 {
+	d.pages = append(d.pages, make([]byte, testPageSize))
+	return uint32(len(d.pages))
+}
+
+// cell builds a table leaf cell, spilling to overflow pages as SQLite does.
+func (d *sqlDB) cell(rowid int64, payload []byte) []byte {
+	c := sqlVarint(sqlVarint(nil, uint64(len(payload))), uint64(rowid))
+	u, n := testPageSize, len(payload)
+	if n <= u-35 {
+		return append(c, payload...)
+	}
+	m := (u-12)*32/255 - 23
+	local := m + (n-m)%(u-4)
+	if local > u-35 {
+		local = m
+	}
+	c = append(c, payload[:local]...)
+	rest := payload[local:]
+	next := d.alloc()
+	c = binary.BigEndian.AppendUint32(c, next)
+	for len(rest) > 0 {
+		p := d.pages[next-1]
+		k := copy(p[4:], rest)
+		rest = rest[k:]
+		if len(rest) > 0 {
+			next = d.alloc()
+			binary.BigEndian.PutUint32(p, next)
+		}
+	}
+	return c
+}
+
+// page lays out a table b-tree page; interior pages have a right-most child.
+func (d *sqlDB) page(pgno uint32, typ byte, cells [][]byte, right uint32) []byte {
+	p := make([]byte, testPageSize)
+	h := 0
+	if pgno == 1 {
+		h = sqliteHeader
+	}
+	p[h] = typ
+	binary.BigEndian.PutUint16(p[h+3:], uint16(len(cells)))
+	ptrs := h + 8
+	if typ == 0x05 {
+		binary.BigEndian.PutUint32(p[h+8:], right)
+		ptrs = h + 12
+	}
+	end := testPageSize
+	for i, c := range cells {
+		end -= len(c)
+		copy(p[end:], c)
+		binary.BigEndian.PutUint16(p[ptrs+2*i:], uint16(end))
+	}
+	binary.BigEndian.PutUint16(p[h+5:], uint16(end))
+	return p
+}
+
+func (d *sqlDB) set(pgno uint32, p []byte) { d.pages[pgno-1] = p }
+
+func (d *sqlDB) bytes() []byte {
+	hdr := d.pages[0]
+	copy(hdr, sqliteMagic)
+	binary.BigEndian.PutUint16(hdr[16:], testPageSize)
+	hdr[18], hdr[19], hdr[21], hdr[22], hdr[23] = 2, 2, 64, 32, 32
+	binary.BigEndian.PutUint32(hdr[24:], 1)
+	binary.BigEndian.PutUint32(hdr[28:], uint32(len(d.pages)))
+	binary.BigEndian.PutUint32(hdr[44:], 4)
+	binary.BigEndian.PutUint32(hdr[56:], 1)
+	binary.BigEndian.PutUint32(hdr[92:], 1)
+	return slices.Concat(d.pages...)
+}
+
+// sqlWAL writes frames (page number, page) and marks frame commit as the
+// end of a transaction; later frames stay uncommitted.
+func sqlWAL(dbPages uint32, commit int, frames ...any) []byte {
+	le := binary.LittleEndian
+	be := binary.BigEndian
+	w := be.AppendUint32(nil, walMagic)
+	w = be.AppendUint32(w, 3007000)
+	w = be.AppendUint32(w, testPageSize)
+	w = be.AppendUint32(w, 0)
+	w = append(w, 1, 2, 3, 4, 5, 6, 7, 8)
+	s0, s1 := walChecksum(le, w, 0, 0)
+	w = be.AppendUint32(be.AppendUint32(w, s0), s1)
+	for i := 0; i < len(frames); i += 2 {
+		fh := be.AppendUint32(nil, frames[i].(uint32))
+		size := uint32(0)
+		if i/2 == commit {
+			size = dbPages
+		}
+		fh = be.AppendUint32(fh, size)
+		fh = append(fh, 1, 2, 3, 4, 5, 6, 7, 8)
+		s0, s1 = walChecksum(le, fh[:8], s0, s1)
+		s0, s1 = walChecksum(le, frames[i+1].([]byte), s0, s1)
+		fh = be.AppendUint32(be.AppendUint32(fh, s0), s1)
+		w = append(append(w, fh...), frames[i+1].([]byte)...)
+	}
+	return w
+}
+
+func TestScanSQLite_TablesAndWAL(t *testing.T) {
+	d := &sqlDB{}
+	d.alloc()
+	root, left, right := d.alloc(), d.alloc(), d.alloc()
+	long := strings.Repeat("x", 1000) + " token=tail"
+	row1 := func(note any) []byte {
+		return d.cell(1, sqlRecord(nil, "https://a", []byte("\x00token=abc"), note))
+	}
+	d.set(left, d.page(left, 0x0d, [][]byte{row1(nil)}, 0))
+	d.set(right, d.page(right, 0x0d, [][]byte{d.cell(7, sqlRecord(nil, "b", nil, long))}, 0))
+	// an interior cell is the left child, then the largest rowid under it
+	d.set(root, d.page(root, 0x05, [][]byte{sqlVarint(binary.BigEndian.AppendUint32(nil, left), 1)}, right))
+	sql := "CREATE TABLE logins (id INTEGER PRIMARY KEY, origin TEXT, \"password value\" BLOB, note TEXT, UNIQUE(origin))"
+	d.set(1, d.page(1, 0x0d, [][]byte{
+		d.cell(1, sqlRecord("table", "logins", "logins", int64(root), sql)),
+		d.cell(2, sqlRecord("index", "sqlite_autoindex_logins_1", "logins", int64(0), nil)),
+	}, 0))
+	data := d.bytes()
+
+	dir := t.TempDir()
+	fp := filepath.Join(dir, "Login Data")
+	hits := func(path string, data []byte) []string {
+		var out []string
+		for _, m := range scanFile(t, path, data, "token") {
+			out = append(out, m.InnerPath+"|"+m.Location+"|"+strings.TrimSpace(m.Line))
+		}
+		return out
+	}
+	got := hits(fp, data)
+	want := []string{
+		"logins.password value|rowid 1|\x00token=abc",
+		"logins.note|rowid 7|" + long,
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %.120q", got)
+	}
+
+	// a committed WAL frame rewrites row 1; the frame after it is not committed
+	walRow := d.page(left, 0x0d, [][]byte{row1("token in wal")}, 0)
+	torn := d.page(right, 0x0d, [][]byte{d.cell(7, sqlRecord(nil, "token uncommitted"))}, 0)
+	wal := sqlWAL(uint32(len(d.pages)), 0, left, walRow, right, torn)
+	if err := os.WriteFile(fp+"-wal", wal, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got = hits(fp, nil)
+	want = slices.Insert(want, 1, "logins.note|rowid 1|token in wal")
+	if !slices.Equal(got, want) {
+		t.Fatalf("with wal: got %.120q", got)
+	}
+	if got := hits(fp+"-wal", nil); len(got) != 0 {
+		t.Fatalf("wal scanned on its own: %q", got)
+	}
+
+	after, _ := os.ReadFile(fp)
+	entries, _ := os.ReadDir(dir)
+	if !bytes.Equal(after, data) || len(entries) != 2 {
+		t.Fatalf("database touched: %d entries", len(entries))
+	}
+}
+
+func TestScanSQLite_FileLevelRules(t *testing.T) {
+	d := &sqlDB{}
+	d.alloc()
+	root := d.alloc()
+	d.set(root, d.page(root, 0x0d, [][]byte{d.cell(1, sqlRecord("token=abc", "token=def"))}, 0))
+	d.set(1, d.page(1, 0x0d, [][]byte{
+		d.cell(1, sqlRecord("table", "t", "t", int64(root), "CREATE TABLE t (a, b)")),
+	}, 0))
+	data := d.bytes()
+	sum := sha256.Sum256(data)
+	hexSum := hex.EncodeToString(sum[:])
+	magic, err := parseBytePattern("hex:53 51 4C 69 74 65")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := &RuleSet{
+		Patterns:  []Pattern{&PlainPattern{s: []byte("token")}},
+		Bytes:     []*BytePattern{magic},
+		Hashes:    map[string]*HashPattern{hexSum: {algo: hashSHA256, sum: hexSum, label: "ioc"}},
+		hashAlgos: []string{hashSHA256},
+	}
+	fp := filepath.Join(t.TempDir(), "db.sqlite")
+	if err := os.WriteFile(fp, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the hash and byte rules see the file once; only cells are matched per column
+	var got []string
+	var matchCnt, errCnt atomic.Int64
+	digest := NewFileScanner().scanRegularFile(context.Background(), fp, rules, ScanOptions{}, func(m MatchResult) {
+		if m.Error != nil {
+			t.Errorf("unexpected error: %v", m.Error)
+		}
+		if m.Hash != "sha256:"+hexSum {
+			t.Errorf("finding without the database hash: %+v", m)
+		}
+		got = append(got, fmt.Sprintf("%s|%d|%t", m.InnerPath, m.Offset, m.HashMatch))
+	}, &matchCnt, &errCnt)
+	want := []string{"t.a|0|false", "t.b|0|false", "|0|false", "|0|true"}
+	if digest != "sha256:"+hexSum || !slices.Equal(got, want) {
+		t.Fatalf("digest %s, got %q", digest, got)
+	}
+}
+
+func TestSQLiteColumns(t *testing.T) {
+	got := sqliteColumns("CREATE TABLE \"t\" (\n  [a b] TEXT,\n  `c` DECIMAL(10, 2) DEFAULT (1),\n  d,\n  CONSTRAINT pk PRIMARY KEY (d))")
+	if want := []string{"a b", "c", "d"}; !slices.Equal(got, want) {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -12,6 +12,8 @@ type AppStats struct {
 	FilesProcessed atomic.Int64
 	Matches        atomic.Int64
 	Errors         atomic.Int64
+	BinaryFiles    atomic.Int64
+	BinaryMode     string
 }
 
 func (s *AppStats) Start() {
@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mholt/archives"
+)
+
+// StdinArg is the CLI root that means "read from stdin".
+const StdinArg = "-"
+
+// maxNestedDepth bounds containers opened inside containers (attachments of
+// attachments, archives in mail).
+const maxNestedDepth = 8
+
+// scanStdin streams stdin as a virtual file named opts.StdinName.
+func (fs *FileScanner) scanStdin(
+	ctx context.Context,
+	rules *RuleSet,
+	opts ScanOptions,
+	onMatch func(MatchResult),
+	matchCnt, errCnt *atomic.Int64,
+) {
+	fs.scanStream(ctx, opts.stdin, opts.StdinName, "", rules, opts, onMatch, matchCnt, errCnt)
+}
+
+// scanStream scans a stream of unknown type as filePath::innerPath. Documents
+// and mail stores are recognised by name; otherwise the stream is sniffed:
+// archives are extracted entry by entry (entry names joined with "::"),
+// compressed streams are decompressed, anything else goes to scanContent.
+func (fs *FileScanner) scanStream(
+	ctx context.Context,
+	r io.Reader,
+	filePath, innerPath string,
+	rules *RuleSet,
+	opts ScanOptions,
+	onMatch func(MatchResult),
+	matchCnt, errCnt *atomic.Int64,
+) {
+	name := filePath
+	if innerPath != "" {
+		name = innerPath
+	}
+	if isStructured(name) {
+		// a document is a zip too; --stdin-name report.docx asks for its text
+		if !opts.NamesOnly {
+			fs.scanContent(ctx, r, filePath, innerPath, rules, opts, onMatch, matchCnt, errCnt)
+		}
+		return
+	}
+	if strings.Count(innerPath, "::") >= maxNestedDepth {
+		if !opts.NamesOnly {
+			matchReader(r, rules, opts, onMatch, filePath, innerPath, matchCnt, errCnt)
+		}
+		return
+	}
+	fail := func(err error) {
+		errCnt.Add(1)
+		onMatch(MatchResult{FilePath: filePath, InnerPath: innerPath, Error: err})
+	}
+	// hide Seek: os.Stdin is an *os.File, but seeking a pipe fails
+	in := struct{ io.Reader }{r}
+	format, stream, err := archives.Identify(ctx, "", in)
+	if err != nil && !errors.Is(err, archives.NoMatch) {
+		fail(err)
+		return
+	}
+	if stream == nil {
+		stream = in
+	}
+
+	switch f := format.(type) {
+	case archives.Extractor:
+		src := stream
+		// zip and 7z need random access - spool them to a temp file first
+		switch f.(type) {
+		case archives.Zip, archives.SevenZip:
+			tmp, err := spoolTemp(stream)
+			if err != nil {
+				fail(err)
+				return
+			}
+			defer os.Remove(tmp.Name())
+			defer tmp.Close()
+			src = tmp
+		}
+		count := 0
+		err := f.Extract(ctx, src, func(ctx context.Context, fi archives.FileInfo) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if fi.IsDir() {
+				return nil
+			}
+			if count >= maxArchiveFiles {
+				return errors.New("archive file limit reached")
+			}
+			ext := strings.ToLower(filepath.Ext(fi.NameInArchive))
+			if !opts.allowedExt(ext) {
+				return nil
+			}
+			count++
+			entry := joinInner(innerPath, fi.NameInArchive)
+			if opts.NamesOnly {
+				matchName(rules, filePath, entry, "", onMatch, matchCnt)
+				return nil
+			}
+			rc, err := fi.Open()
+			if err != nil {
+				errCnt.Add(1)
+				onMatch(MatchResult{FilePath: filePath, InnerPath: entry, Error: err})
+				matchName(rules, filePath, entry, "", onMatch, matchCnt)
+				return nil
+			}
+			defer rc.Close()
+			hash := fs.scanContent(ctx, rc, filePath, entry, rules, opts, onMatch, matchCnt, errCnt)
+			matchName(rules, filePath, entry, hash, onMatch, matchCnt)
+			return nil
+		})
+		if err != nil && ctx.Err() == nil {
+			fail(err)
+		}
+	case archives.Decompressor:
+		if opts.NamesOnly {
+			return
+		}
+		rc, err := f.OpenReader(stream)
+		if err != nil {
+			fail(err)
+			return
+		}
+		defer rc.Close()
+		fs.scanContent(ctx, rc, filePath, innerPath, rules, opts, onMatch, matchCnt, errCnt)
+	default:
+		if opts.NamesOnly {
+			return
+		}
+		fs.scanContent(ctx, stream, filePath, innerPath, rules, opts, onMatch, matchCnt, errCnt)
+	}
+}
+
+// joinInner nests an entry name under the inner path of its container.
+func joinInner(innerPath, name string) string {
+	if innerPath == "" {
+		return name
+	}
+	return innerPath + "::" + name
+}
+
+// spoolTemp copies r into a temp file and rewinds it. Caller removes the file.
+func spoolTemp(r io.Reader) (*os.File, error) {
+	tmp, err := os.CreateTemp("", "ff-stdin-*")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return tmp, nil
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"sync/atomic"
+	"testing"
+)
+
+func scanStdinData(t *testing.T, data []byte) []MatchResult {
+	t.Helper()
+	opts := ScanOptions{PatternFile: "p.txt", Stdin: true, stdin: bytes.NewReader(data)}
+	opts.Prepare()
+	var got []MatchResult
+	on := func(m MatchResult) {
+		if m.Error != nil {
+			t.Errorf("unexpected error: %v", m.Error)
+		}
+		if m.Matched {
+			got = append(got, m)
+		}
+	}
+	var matchCnt, errCnt atomic.Int64
+	rules := &RuleSet{Patterns: []Pattern{stubPattern{sub: "secret"}}}
+	NewFileScanner().scanStdin(context.Background(), rules, opts, on, &matchCnt, &errCnt)
+	return got
+}
+
+func TestScanStdin_PlainText(t *testing.T) {
+	got := scanStdinData(t, []byte("nothing\nmy secret\n"))
+	if len(got) != 1 {
+		t.Fatalf("want 1 match, got %d", len(got))
+	}
+	if got[0].FilePath != "stdin" || got[0].InnerPath != "" || got[0].LineNumber != 1 {
+		t.Fatalf("unexpected result: %+v", got[0])
+	}
+}
+
+func TestScanStdin_TarGz(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, body := range map[string]string{"a.txt": "clean\n", "dir/b.txt": "x\nsecret\n"} {
+		_ = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), Typeflag: tar.TypeReg})
+		_, _ = tw.Write([]byte(body))
+	}
+	_ = tw.Close()
+	_ = gz.Close()
+
+	got := scanStdinData(t, buf.Bytes())
+	if len(got) != 1 || got[0].InnerPath != "dir/b.txt" {
+		t.Fatalf("unexpected results: %+v", got)
+	}
+}
+
+func TestScanStdin_Zip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("inner.log")
+	_, _ = w.Write([]byte("the secret is here\n"))
+	_ = zw.Close()
+
+	got := scanStdinData(t, buf.Bytes())
+	if len(got) != 1 || got[0].InnerPath != "inner.log" {
+		t.Fatalf("unexpected results: %+v", got)
+	}
+}
+
+func TestScanStdin_Gzip(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, _ = gz.Write([]byte("secret in a compressed stream\n"))
+	_ = gz.Close()
+
+	got := scanStdinData(t, buf.Bytes())
+	if len(got) != 1 || got[0].InnerPath != "" {
+		t.Fatalf("unexpected results: %+v", got)
+	}
+}
+
+func TestScanStdin_DocumentInsideZip(t *testing.T) {
+	docx := zipDoc(t, "word/document.xml", `<w:document xmlns:w="w"><w:body><w:p><w:r><w:t>sec</w:t></w:r><w:r><w:t>ret</w:t></w:r></w:p></w:body></w:document>`)
+	got := scanStdinData(t, zipDoc(t, "hr/report.docx", string(docx)))
+	if len(got) != 1 || got[0].InnerPath != "hr/report.docx" || got[0].Location != "paragraph 1" {
+		t.Fatalf("unexpected: %+v", got)
+	}
+}
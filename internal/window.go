@@ -0,0 +1,43 @@
+package internal
+
+// windowScanner cuts a stream into fixed-size windows that overlap by
+// `overlap` bytes. scan gets the window, the absolute offset of win[0] and
+// a limit: only matches starting before limit belong to this window, later
+// starts are seen again at the beginning of the next one. A match is found
+// across window edges as long as it is not longer than the overlap.
+type windowScanner struct {
+	chunk, overlap int
+	buf            []byte
+	base           int64 // absolute offset of buf[0]
+	prev           int   // byte before buf[0], -1 at start
+	size           int64 // bytes written so far
+	scan           func(win []byte, base int64, limit int)
+}
+
+func newWindowScanner(chunk, overlap int, scan func(win []byte, base int64, limit int)) *windowScanner {
+	return &windowScanner{chunk: chunk, overlap: overlap, prev: -1, scan: scan}
+}
+
+func (w *windowScanner) Write(p []byte) (int, error) {
+	w.size += int64(len(p))
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.chunk+w.overlap {
+		w.flush(len(w.buf) - w.overlap)
+	}
+	return len(p), nil
+}
+
+// Close scans whatever is left; every remaining start position is final.
+func (w *windowScanner) Close() error {
+	w.flush(len(w.buf))
+	return nil
+}
+
+func (w *windowScanner) flush(limit int) {
+	w.scan(w.buf, w.base, limit)
+	if limit > 0 {
+		w.prev = int(w.buf[limit-1])
+	}
+	w.base += int64(limit)
+	w.buf = append(w.buf[:0], w.buf[limit:]...)
+}
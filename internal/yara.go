@@ -0,0 +1,1193 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// YARA-compatible rule subset.
+//
+// Supported:
+//
+//	rule Name : tags { meta: ... strings: ... condition: ... }, private/global modifiers
+//	text strings "..." with nocase, wide, ascii, fullword, private
+//	hex strings { 4D 5A ?? ?0 [2-4] ( 01 | 02 ) } of at most yaraOverlap bytes with the longest jumps
+//	regex strings /.../is with nocase
+//	conditions: and/or/not, comparisons, $a, #a, $a at N, $a in (N..M),
+//	any/all/none/N of them|($a, $b*), filesize, KB/MB suffixes, true/false,
+//	references to earlier rules
+//
+// Modules (import "pe" ...) and integer functions (uint16(0) ...) are not supported,
+// nor are unbounded jumps ([4-]). All rules share one namespace: when a global
+// rule fails, no rule matches.
+//
+// Rules run over the whole content stream in fixed windows that overlap by
+// yaraOverlap bytes, so a string is found across window edges as long as its
+// match is not longer than the overlap.
+
+const (
+	yaraChunk      = 1 << 20
+	yaraOverlap    = 4 << 10
+	yaraMaxOffsets = 1000 // offsets kept per string for "at"/"in"
+)
+
+// YaraRule is one parsed rule.
+type YaraRule struct {
+	Name    string
+	Tags    []string
+	Private bool
+	Global  bool
+	strs    []*yaraString
+	cond    yaraExpr
+}
+
+type yaraString struct {
+	id       string // "$a"
+	needles  [][]byte
+	nocase   bool
+	fullword bool
+	hex      []hexTok
+	re       *regexp.Regexp
+}
+
+// LoadYaraRules parses rule files into a single list; rules may reference
+// rules defined earlier, including in previous files.
+func LoadYaraRules(paths ...string) ([]*YaraRule, error) {
+	var rules []*YaraRule
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		p := &yaraParser{src: string(src), rules: rules}
+		if err := p.parse(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		rules = p.rules
+	}
+	return rules, nil
+}
+
+// ParseYara parses rules from source text.
+func ParseYara(src string) ([]*YaraRule, error) {
+	p := &yaraParser{src: src}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	return p.rules, nil
+}
+
+// ---- parser ----
+
+type yaraParser struct {
+	src   string
+	pos   int
+	rules []*YaraRule
+	cur   *YaraRule
+}
+
+func (p *yaraParser) errorf(format string, args ...any) error {
+	line := strings.Count(p.src[:min(p.pos, len(p.src))], "\n") + 1
+	return fmt.Errorf("yara line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// skip skips whitespace and comments.
+func (p *yaraParser) skip() {
+	for p.pos < len(p.src) {
+		switch {
+		case strings.HasPrefix(p.src[p.pos:], "//"):
+			if i := strings.IndexByte(p.src[p.pos:], '\n'); i >= 0 {
+				p.pos += i + 1
+			} else {
+				p.pos = len(p.src)
+			}
+		case strings.HasPrefix(p.src[p.pos:], "/*"):
+			if i := strings.Index(p.src[p.pos+2:], "*/"); i >= 0 {
+				p.pos += i + 4
+			} else {
+				p.pos = len(p.src)
+			}
+		case strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0:
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *yaraParser) eof() bool {
+	p.skip()
+	return p.pos >= len(p.src)
+}
+
+func isIdentByte(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+}
+
+// ident reads an identifier, returns "" if none.
+func (p *yaraParser) ident() string {
+	p.skip()
+	start := p.pos
+	for p.pos < len(p.src) && isIdentByte(p.src[p.pos], p.pos == start) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *yaraParser) peekIdent() string {
+	save := p.pos
+	id := p.ident()
+	p.pos = save
+	return id
+}
+
+func (p *yaraParser) accept(s string) bool {
+	p.skip()
+	if strings.HasPrefix(p.src[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *yaraParser) expect(s string) error {
+	if !p.accept(s) {
+		return p.errorf("expected %q", s)
+	}
+	return nil
+}
+
+func (p *yaraParser) parse() error {
+	for !p.eof() {
+		kw := p.ident()
+		switch kw {
+		case "import", "include":
+			return p.errorf("%s is not supported", kw)
+		case "private", "global":
+			private, global := kw == "private", kw == "global"
+			for {
+				next := p.ident()
+				if next == "rule" {
+					break
+				}
+				if next == "private" {
+					private = true
+				} else if next == "global" {
+					global = true
+				} else {
+					return p.errorf("expected rule")
+				}
+			}
+			if err := p.parseRule(private, global); err != nil {
+				return err
+			}
+		case "rule":
+			if err := p.parseRule(false, false); err != nil {
+				return err
+			}
+		default:
+			return p.errorf("unexpected %q", kw)
+		}
+	}
+	return nil
+}
+
+func (p *yaraParser) parseRule(private, global bool) error {
+	r := &YaraRule{Name: p.ident(), Private: private, Global: global}
+	if r.Name == "" {
+		return p.errorf("rule name expected")
+	}
+	if p.findRule(r.Name) >= 0 {
+		return p.errorf("duplicate rule %s", r.Name)
+	}
+	if p.accept(":") {
+		for p.peekIdent() != "" {
+			r.Tags = append(r.Tags, p.ident())
+		}
+	}
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	p.cur = r
+	for {
+		section := p.ident()
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+		switch section {
+		case "meta":
+			if err := p.skipMeta(); err != nil {
+				return err
+			}
+		case "strings":
+			if err := p.parseStrings(); err != nil {
+				return err
+			}
+		case "condition":
+			toks, err := p.condTokens()
+			if err != nil {
+				return err
+			}
+			cp := &yaraCondParser{toks: toks, p: p}
+			cond, err := cp.expr()
+			if err != nil {
+				return err
+			}
+			if cp.i != len(cp.toks) {
+				return p.errorf("unexpected %q in condition", cp.toks[cp.i])
+			}
+			r.cond = cond
+			if err := p.expect("}"); err != nil {
+				return err
+			}
+			p.rules = append(p.rules, r)
+			return nil
+		default:
+			return p.errorf("unknown section %q", section)
+		}
+	}
+}
+
+func (p *yaraParser) findRule(name string) int {
+	for i, r := range p.rules {
+		if r.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// skipMeta skips "key = value" pairs until the next section.
+func (p *yaraParser) skipMeta() error {
+	for {
+		save := p.pos
+		key := p.ident()
+		if key == "" {
+			return p.errorf("meta key expected")
+		}
+		if p.accept(":") {
+			p.pos = save // next section
+			return nil
+		}
+		if err := p.expect("="); err != nil {
+			return err
+		}
+		p.skip()
+		if p.pos < len(p.src) && p.src[p.pos] == '"' {
+			if _, err := p.quoted(); err != nil {
+				return err
+			}
+		} else {
+			start := p.pos
+			for p.pos < len(p.src) && strings.IndexByte(" \t\r\n", p.src[p.pos]) < 0 {
+				p.pos++
+			}
+			if start == p.pos {
+				return p.errorf("meta value expected")
+			}
+		}
+	}
+}
+
+func (p *yaraParser) parseStrings() error {
+	for {
+		p.skip()
+		if p.pos >= len(p.src) || p.src[p.pos] != '$' {
+			return nil
+		}
+		p.pos++
+		s := &yaraString{id: "$" + p.identRaw()}
+		for _, o := range p.cur.strs {
+			if s.id != "$" && o.id == s.id {
+				return p.errorf("duplicate string %s", s.id)
+			}
+		}
+		if err := p.expect("="); err != nil {
+			return err
+		}
+		p.skip()
+		if p.pos >= len(p.src) {
+			return p.errorf("string value expected")
+		}
+		var (
+			text string
+			kind = p.src[p.pos]
+			err  error
+		)
+		switch kind {
+		case '"':
+			text, err = p.quoted()
+		case '{':
+			s.hex, err = p.hexString()
+		case '/':
+			text, err = p.regexLiteral()
+		default:
+			err = p.errorf("string value expected")
+		}
+		if err != nil {
+			return err
+		}
+		var wide, ascii bool
+		for {
+			switch p.peekIdent() {
+			case "nocase":
+				s.nocase = true
+			case "wide":
+				wide = true
+			case "ascii":
+				ascii = true
+			case "fullword":
+				s.fullword = true
+			case "private":
+			default:
+				goto done
+			}
+			p.ident()
+		}
+	done:
+		switch kind {
+		case '"':
+			raw := []byte(text)
+			if s.nocase {
+				raw = foldASCII(raw)
+			}
+			if !wide || ascii {
+				s.needles = append(s.needles, raw)
+			}
+			if wide {
+				s.needles = append(s.needles, toUTF16LE(string(raw)))
+			}
+		case '{':
+			if s.nocase || wide || s.fullword {
+				return p.errorf("modifiers are not allowed on hex string %s", s.id)
+			}
+		case '/':
+			if wide {
+				return p.errorf("wide regex %s is not supported", s.id)
+			}
+			if s.nocase {
+				text = "(?i)" + text
+			}
+			if s.re, err = regexp.Compile(text); err != nil {
+				return p.errorf("regex %s: %v", s.id, err)
+			}
+		}
+		p.cur.strs = append(p.cur.strs, s)
+	}
+}
+
+// identRaw reads identifier chars without skipping space (for "$name").
+func (p *yaraParser) identRaw() string {
+	start := p.pos
+	for p.pos < len(p.src) && isIdentByte(p.src[p.pos], false) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// quoted reads a "..." literal with YARA escapes.
+func (p *yaraParser) quoted() (string, error) {
+	p.pos++ // opening quote
+	var sb strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return sb.String(), nil
+		case '\n':
+			return "", p.errorf("unterminated string")
+		case '\\':
+			if p.pos >= len(p.src) {
+				return "", p.errorf("unterminated string")
+			}
+			e := p.src[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case '"', '\\':
+				sb.WriteByte(e)
+			case 'x':
+				if p.pos+2 > len(p.src) {
+					return "", p.errorf("bad \\x escape")
+				}
+				v, err := strconv.ParseUint(p.src[p.pos:p.pos+2], 16, 8)
+				if err != nil {
+					return "", p.errorf("bad \\x escape")
+				}
+				sb.WriteByte(byte(v))
+				p.pos += 2
+			default:
+				return "", p.errorf("unknown escape \\%c", e)
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+// regexLiteral reads /.../flags and returns a Go regexp source.
+func (p *yaraParser) regexLiteral() (string, error) {
+	p.pos++ // opening slash
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] != '/' {
+		if p.src[p.pos] == '\\' {
+			p.pos++
+		}
+		if p.pos < len(p.src) && p.src[p.pos] == '\n' {
+			return "", p.errorf("unterminated regex")
+		}
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		return "", p.errorf("unterminated regex")
+	}
+	body := strings.ReplaceAll(p.src[start:p.pos], `\/`, "/")
+	p.pos++
+	flags := ""
+	for p.pos < len(p.src) && (p.src[p.pos] == 'i' || p.src[p.pos] == 's') {
+		flags += string(p.src[p.pos])
+		p.pos++
+	}
+	if flags != "" {
+		body = "(?" + flags + ")" + body
+	}
+	return body, nil
+}
+
+// ---- hex strings ----
+
+const (
+	hexByte = iota
+	hexJump
+	hexAlt
+)
+
+type hexTok struct {
+	kind      int
+	val, mask byte
+	min, max  int // jump range, max < 0 - unbounded
+	alts      [][]hexTok
+}
+
+func (p *yaraParser) hexString() ([]hexTok, error) {
+	p.pos++ // {
+	toks, end, err := p.hexSeq("}")
+	if err != nil {
+		return nil, err
+	}
+	if end != '}' {
+		return nil, p.errorf("unterminated hex string")
+	}
+	if len(toks) == 0 || toks[0].kind == hexJump || toks[len(toks)-1].kind == hexJump {
+		return nil, p.errorf("hex string must start and end with bytes")
+	}
+	// a longer match could span the window overlap and miss
+	if n := hexSpan(toks); n > yaraOverlap {
+		return nil, p.errorf("hex string spans up to %d bytes, more than %d", n, yaraOverlap)
+	}
+	return toks, nil
+}
+
+// hexSpan returns the longest match of toks: its bytes, every jump at its
+// maximum and the longest of each set of alternatives.
+func hexSpan(toks []hexTok) int {
+	n := 0
+	for _, t := range toks {
+		switch t.kind {
+		case hexByte:
+			n++
+		case hexJump:
+			n += t.max
+		case hexAlt:
+			longest := 0
+			for _, alt := range t.alts {
+				longest = max(longest, hexSpan(alt))
+			}
+			n += longest
+		}
+	}
+	return n
+}
+
+// hexSeq parses tokens until one of the stop chars and returns it.
+func (p *yaraParser) hexSeq(stop string) ([]hexTok, byte, error) {
+	var toks []hexTok
+	for {
+		p.skip()
+		if p.pos >= len(p.src) {
+			return nil, 0, p.errorf("unterminated hex string")
+		}
+		c := p.src[p.pos]
+		if strings.IndexByte(stop, c) >= 0 {
+			p.pos++
+			return toks, c, nil
+		}
+		switch c {
+		case '[':
+			end := strings.IndexByte(p.src[p.pos:], ']')
+			if end < 0 {
+				return nil, 0, p.errorf("unterminated jump")
+			}
+			spec := strings.TrimSpace(p.src[p.pos+1 : p.pos+end])
+			p.pos += end + 1
+			t := hexTok{kind: hexJump}
+			lo, hi, isRange := strings.Cut(spec, "-")
+			var err error
+			if t.min, err = atoiDefault(lo, 0); err != nil {
+				return nil, 0, p.errorf("bad jump [%s]", spec)
+			}
+			t.max = t.min
+			if isRange {
+				if t.max, err = atoiDefault(hi, -1); err != nil {
+					return nil, 0, p.errorf("bad jump [%s]", spec)
+				}
+			}
+			if t.max >= 0 && t.max < t.min {
+				return nil, 0, p.errorf("bad jump [%s]", spec)
+			}
+			// a longer jump could span the window overlap and miss
+			if t.max < 0 || t.max > yaraOverlap {
+				return nil, 0, p.errorf("jump [%s] must be bounded by %d bytes", spec, yaraOverlap)
+			}
+			toks = append(toks, t)
+		case '(':
+			p.pos++
+			t := hexTok{kind: hexAlt}
+			for {
+				alt, end, err := p.hexSeq("|)")
+				if err != nil {
+					return nil, 0, err
+				}
+				t.alts = append(t.alts, alt)
+				if end == ')' {
+					break
+				}
+			}
+			toks = append(toks, t)
+		default:
+			if p.pos+2 > len(p.src) {
+				return nil, 0, p.errorf("bad hex byte")
+			}
+			t := hexTok{kind: hexByte}
+			for i := 0; i < 2; i++ {
+				t.val <<= 4
+				t.mask <<= 4
+				ch := p.src[p.pos+i]
+				if ch == '?' {
+					continue
+				}
+				v, err := strconv.ParseUint(string(ch), 16, 8)
+				if err != nil {
+					return nil, 0, p.errorf("bad hex byte %q", p.src[p.pos:p.pos+2])
+				}
+				t.val |= byte(v)
+				t.mask |= 0xF
+			}
+			p.pos += 2
+			toks = append(toks, t)
+		}
+	}
+}
+
+func atoiDefault(s string, def int) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return def, nil
+	}
+	return strconv.Atoi(s)
+}
+
+// matchHex returns the end of a match of toks at data[i:].
+func matchHex(data []byte, i int, toks []hexTok) (int, bool) {
+	for len(toks) > 0 {
+		t := toks[0]
+		switch t.kind {
+		case hexByte:
+			if i >= len(data) || data[i]&t.mask != t.val {
+				return 0, false
+			}
+			i++
+			toks = toks[1:]
+		case hexJump:
+			for n := t.min; n <= t.max && i+n <= len(data); n++ {
+				if end, ok := matchHex(data, i+n, toks[1:]); ok {
+					return end, true
+				}
+			}
+			return 0, false
+		case hexAlt:
+			for _, alt := range t.alts {
+				seq := append(slices.Clip(alt), toks[1:]...)
+				if end, ok := matchHex(data, i, seq); ok {
+					return end, true
+				}
+			}
+			return 0, false
+		}
+	}
+	return i, true
+}
+
+// ---- conditions ----
+
+type yaraExpr interface {
+	eval(c *yaraEval) int64
+}
+
+type yaraEval struct {
+	filesize int64
+	counts   []int
+	offsets  [][]int64
+	results  []bool // results of rules evaluated so far
+}
+
+type (
+	yNum      int64
+	yFilesize struct{}
+	yStr      int // $a - matched at least once
+	yCount    int // #a
+	yAt       struct {
+		idx int
+		off yaraExpr
+	}
+	yIn struct {
+		idx    int
+		lo, hi yaraExpr
+	}
+	yOf struct {
+		quant string // "any", "all", "none" or "" for n
+		n     yaraExpr
+		idxs  []int
+	}
+	yNot     struct{ x yaraExpr }
+	yAnd     struct{ l, r yaraExpr }
+	yOr      struct{ l, r yaraExpr }
+	yRuleRef int
+	yCmp     struct {
+		op   string
+		l, r yaraExpr
+	}
+)
+
+func b2i(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (e yNum) eval(*yaraEval) int64       { return int64(e) }
+func (yFilesize) eval(c *yaraEval) int64  { return c.filesize }
+func (e yStr) eval(c *yaraEval) int64     { return b2i(c.counts[e] > 0) }
+func (e yCount) eval(c *yaraEval) int64   { return int64(c.counts[e]) }
+func (e yNot) eval(c *yaraEval) int64     { return b2i(e.x.eval(c) == 0) }
+func (e yAnd) eval(c *yaraEval) int64     { return b2i(e.l.eval(c) != 0 && e.r.eval(c) != 0) }
+func (e yOr) eval(c *yaraEval) int64      { return b2i(e.l.eval(c) != 0 || e.r.eval(c) != 0) }
+func (e yRuleRef) eval(c *yaraEval) int64 { return b2i(c.results[e]) }
+func (e yAt) eval(c *yaraEval) int64      { return b2i(slices.Contains(c.offsets[e.idx], e.off.eval(c))) }
+func (e yIn) eval(c *yaraEval) int64 {
+	lo, hi := e.lo.eval(c), e.hi.eval(c)
+	for _, o := range c.offsets[e.idx] {
+		if o >= lo && o <= hi {
+			return 1
+		}
+	}
+	return 0
+}
+
+func (e yOf) eval(c *yaraEval) int64 {
+	hit := 0
+	for _, i := range e.idxs {
+		if c.counts[i] > 0 {
+			hit++
+		}
+	}
+	switch e.quant {
+	case "any":
+		return b2i(hit > 0)
+	case "all":
+		return b2i(hit == len(e.idxs))
+	case "none":
+		return b2i(hit == 0)
+	}
+	return b2i(int64(hit) >= e.n.eval(c))
+}
+
+func (e yCmp) eval(c *yaraEval) int64 {
+	l, r := e.l.eval(c), e.r.eval(c)
+	switch e.op {
+	case "<":
+		return b2i(l < r)
+	case "<=":
+		return b2i(l <= r)
+	case ">":
+		return b2i(l > r)
+	case ">=":
+		return b2i(l >= r)
+	case "==":
+		return b2i(l == r)
+	}
+	return b2i(l != r)
+}
+
+// condTokens splits the condition up to the closing brace of the rule.
+func (p *yaraParser) condTokens() ([]string, error) {
+	var toks []string
+	for {
+		p.skip()
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated rule")
+		}
+		c := p.src[p.pos]
+		switch {
+		case c == '}':
+			return toks, nil
+		case strings.HasPrefix(p.src[p.pos:], ".."):
+			toks = append(toks, "..")
+			p.pos += 2
+		case strings.IndexByte("<>=!", c) >= 0:
+			op := string(c)
+			if p.pos+1 < len(p.src) && p.src[p.pos+1] == '=' {
+				op += "="
+			}
+			if op == "=" || op == "!" {
+				return nil, p.errorf("unexpected %q in condition", op)
+			}
+			toks = append(toks, op)
+			p.pos += len(op)
+		case strings.IndexByte("(),", c) >= 0:
+			toks = append(toks, string(c))
+			p.pos++
+		case c == '$' || c == '#' || c == '@':
+			p.pos++
+			id := string(c) + p.identRaw()
+			if p.pos < len(p.src) && p.src[p.pos] == '*' {
+				id += "*"
+				p.pos++
+			}
+			toks = append(toks, id)
+		case c >= '0' && c <= '9':
+			start := p.pos
+			for p.pos < len(p.src) && isIdentByte(p.src[p.pos], false) {
+				p.pos++
+			}
+			toks = append(toks, p.src[start:p.pos])
+		case isIdentByte(c, true):
+			toks = append(toks, p.identRaw())
+		default:
+			return nil, p.errorf("unexpected %q in condition", c)
+		}
+	}
+}
+
+type yaraCondParser struct {
+	toks []string
+	i    int
+	p    *yaraParser
+}
+
+func (cp *yaraCondParser) peek() string {
+	if cp.i < len(cp.toks) {
+		return cp.toks[cp.i]
+	}
+	return ""
+}
+
+func (cp *yaraCondParser) next() string {
+	t := cp.peek()
+	cp.i++
+	return t
+}
+
+func (cp *yaraCondParser) expr() (yaraExpr, error) {
+	l, err := cp.and()
+	if err != nil {
+		return nil, err
+	}
+	for cp.peek() == "or" {
+		cp.next()
+		r, err := cp.and()
+		if err != nil {
+			return nil, err
+		}
+		l = yOr{l, r}
+	}
+	return l, nil
+}
+
+func (cp *yaraCondParser) and() (yaraExpr, error) {
+	l, err := cp.not()
+	if err != nil {
+		return nil, err
+	}
+	for cp.peek() == "and" {
+		cp.next()
+		r, err := cp.not()
+		if err != nil {
+			return nil, err
+		}
+		l = yAnd{l, r}
+	}
+	return l, nil
+}
+
+func (cp *yaraCondParser) not() (yaraExpr, error) {
+	if cp.peek() == "not" {
+		cp.next()
+		x, err := cp.not()
+		if err != nil {
+			return nil, err
+		}
+		return yNot{x}, nil
+	}
+	return cp.cmp()
+}
+
+func (cp *yaraCondParser) cmp() (yaraExpr, error) {
+	l, err := cp.term()
+	if err != nil {
+		return nil, err
+	}
+	switch op := cp.peek(); op {
+	case "<", "<=", ">", ">=", "==", "!=":
+		cp.next()
+		r, err := cp.term()
+		if err != nil {
+			return nil, err
+		}
+		return yCmp{op, l, r}, nil
+	}
+	return l, nil
+}
+
+func (cp *yaraCondParser) term() (yaraExpr, error) {
+	t := cp.next()
+	switch {
+	case t == "":
+		return nil, cp.p.errorf("unexpected end of condition")
+	case t == "(":
+		x, err := cp.expr()
+		if err != nil {
+			return nil, err
+		}
+		if cp.next() != ")" {
+			return nil, cp.p.errorf("expected ) in condition")
+		}
+		return x, nil
+	case t == "true":
+		return yNum(1), nil
+	case t == "false":
+		return yNum(0), nil
+	case t == "filesize":
+		return yFilesize{}, nil
+	case t == "any" || t == "all" || t == "none":
+		return cp.of(t, nil)
+	case t[0] >= '0' && t[0] <= '9':
+		n, err := parseYaraInt(t)
+		if err != nil {
+			return nil, cp.p.errorf("bad number %q", t)
+		}
+		if cp.peek() == "of" {
+			return cp.of("", yNum(n))
+		}
+		return yNum(n), nil
+	case t[0] == '#':
+		idx, err := cp.strIndex("$" + t[1:])
+		if err != nil {
+			return nil, err
+		}
+		return yCount(idx), nil
+	case t[0] == '$':
+		idx, err := cp.strIndex(t)
+		if err != nil {
+			return nil, err
+		}
+		switch cp.peek() {
+		case "at":
+			cp.next()
+			off, err := cp.term()
+			if err != nil {
+				return nil, err
+			}
+			return yAt{idx, off}, nil
+		case "in":
+			cp.next()
+			if cp.next() != "(" {
+				return nil, cp.p.errorf("expected ( after in")
+			}
+			lo, err := cp.term()
+			if err != nil {
+				return nil, err
+			}
+			if cp.next() != ".." {
+				return nil, cp.p.errorf("expected .. in range")
+			}
+			hi, err := cp.term()
+			if err != nil {
+				return nil, err
+			}
+			if cp.next() != ")" {
+				return nil, cp.p.errorf("expected ) after range")
+			}
+			return yIn{idx, lo, hi}, nil
+		}
+		return yStr(idx), nil
+	case isIdentByte(t[0], true):
+		if i := cp.p.findRule(t); i >= 0 {
+			return yRuleRef(i), nil
+		}
+		return nil, cp.p.errorf("unknown identifier %q", t)
+	}
+	return nil, cp.p.errorf("unexpected %q in condition", t)
+}
+
+// of parses "of them" / "of ($a, $b*)" after the quantifier.
+func (cp *yaraCondParser) of(quant string, n yaraExpr) (yaraExpr, error) {
+	if cp.next() != "of" {
+		return nil, cp.p.errorf("expected of")
+	}
+	e := yOf{quant: quant, n: n}
+	strs := cp.p.cur.strs
+	if cp.peek() == "them" {
+		cp.next()
+		for i := range strs {
+			e.idxs = append(e.idxs, i)
+		}
+	} else {
+		if cp.next() != "(" {
+			return nil, cp.p.errorf("expected them or ( after of")
+		}
+		for {
+			id := cp.next()
+			if id == "" || id[0] != '$' {
+				return nil, cp.p.errorf("string identifier expected in set")
+			}
+			prefix, wildcard := strings.CutSuffix(id, "*")
+			matched := false
+			for i, s := range strs {
+				if s.id == id || wildcard && strings.HasPrefix(s.id, prefix) {
+					if !slices.Contains(e.idxs, i) {
+						e.idxs = append(e.idxs, i)
+					}
+					matched = true
+				}
+			}
+			if !matched {
+				return nil, cp.p.errorf("undefined string %s", id)
+			}
+			if t := cp.next(); t == ")" {
+				break
+			} else if t != "," {
+				return nil, cp.p.errorf("expected , or ) in set")
+			}
+		}
+	}
+	if len(e.idxs) == 0 {
+		return nil, cp.p.errorf("empty string set")
+	}
+	return e, nil
+}
+
+func (cp *yaraCondParser) strIndex(id string) (int, error) {
+	for i, s := range cp.p.cur.strs {
+		if s.id == id {
+			return i, nil
+		}
+	}
+	return 0, cp.p.errorf("undefined string %s", id)
+}
+
+func parseYaraInt(s string) (int64, error) {
+	mul := int64(1)
+	switch {
+	case strings.HasSuffix(s, "KB"):
+		mul, s = 1<<10, strings.TrimSuffix(s, "KB")
+	case strings.HasSuffix(s, "MB"):
+		mul, s = 1<<20, strings.TrimSuffix(s, "MB")
+	}
+	n, err := strconv.ParseInt(s, 0, 64)
+	return n * mul, err
+}
+
+// ---- streaming scanner ----
+
+// yaraScanner is fed the content stream by matchReader and evaluates rules at EOF.
+type yaraScanner struct {
+	*windowScanner
+	rules    []*YaraRule
+	counts   [][]int
+	offsets  [][][]int64
+	reSkip   [][]int64 // regex: absolute end of last counted match
+	fold     []byte
+	needFold bool
+}
+
+// YaraHit is a matched rule with the identifiers of strings that matched.
+type YaraHit struct {
+	Rule    string
+	Strings []string
+}
+
+func newYaraScanner(rules []*YaraRule) *yaraScanner {
+	ys := &yaraScanner{rules: rules}
+	ys.windowScanner = newWindowScanner(yaraChunk, yaraOverlap, ys.scan)
+	for _, r := range rules {
+		ys.counts = append(ys.counts, make([]int, len(r.strs)))
+		ys.offsets = append(ys.offsets, make([][]int64, len(r.strs)))
+		ys.reSkip = append(ys.reSkip, make([]int64, len(r.strs)))
+		for _, s := range r.strs {
+			ys.needFold = ys.needFold || s.nocase && s.re == nil
+		}
+	}
+	return ys
+}
+
+// scan counts matches starting before limit.
+func (ys *yaraScanner) scan(win []byte, base int64, limit int) {
+	if ys.needFold {
+		ys.fold = append(ys.fold[:0], win...)
+		foldASCIIInPlace(ys.fold)
+	}
+	for ri, r := range ys.rules {
+		for si, s := range r.strs {
+			ys.scanString(ri, si, s, win, base, limit)
+		}
+	}
+}
+
+func (ys *yaraScanner) hit(ri, si int, abs int64) {
+	ys.counts[ri][si]++
+	if len(ys.offsets[ri][si]) < yaraMaxOffsets {
+		ys.offsets[ri][si] = append(ys.offsets[ri][si], abs)
+	}
+}
+
+func (ys *yaraScanner) scanString(ri, si int, s *yaraString, win []byte, base int64, limit int) {
+	switch {
+	case s.re != nil:
+		for _, loc := range s.re.FindAllIndex(win, -1) {
+			if loc[0] >= limit {
+				break
+			}
+			abs := base + int64(loc[0])
+			if abs < ys.reSkip[ri][si] {
+				continue
+			}
+			ys.reSkip[ri][si] = base + int64(loc[1])
+			ys.hit(ri, si, abs)
+		}
+	case s.hex != nil:
+		first := s.hex[0]
+		for i := 0; i < limit; i++ {
+			if first.kind == hexByte && first.mask == 0xFF {
+				j := bytes.IndexByte(win[i:limit], first.val)
+				if j < 0 {
+					break
+				}
+				i += j
+			}
+			if _, ok := matchHex(win, i, s.hex); ok {
+				ys.hit(ri, si, base+int64(i))
+			}
+		}
+	default:
+		hay := win
+		if s.nocase {
+			hay = ys.fold
+		}
+		for _, needle := range s.needles {
+			if len(needle) == 0 {
+				continue
+			}
+			for i := 0; i < limit; {
+				j := bytes.Index(hay[i:], needle)
+				if j < 0 || i+j >= limit {
+					break
+				}
+				pos := i + j
+				if !s.fullword || isWordAt(win, ys.prev, pos, pos+len(needle)) {
+					ys.hit(ri, si, base+int64(pos))
+				}
+				i = pos + 1
+			}
+		}
+	}
+}
+
+// isWordAt reports whether win[start:end] is not glued to alphanumerics;
+// prev is the byte before win[0] or -1.
+func isWordAt(win []byte, prev, start, end int) bool {
+	before := prev
+	if start > 0 {
+		before = int(win[start-1])
+	}
+	if before >= 0 && isAlnum(byte(before)) {
+		return false
+	}
+	return end >= len(win) || !isAlnum(win[end])
+}
+
+func isAlnum(c byte) bool { return isIdentByte(c, false) && c != '_' }
+
+// finish scans the remaining buffer and evaluates rules in order; a failed
+// global rule clears every hit.
+func (ys *yaraScanner) finish() []YaraHit {
+	_ = ys.Close()
+	var hits []YaraHit
+	results := make([]bool, len(ys.rules))
+	for ri, r := range ys.rules {
+		ev := &yaraEval{filesize: ys.size, counts: ys.counts[ri], offsets: ys.offsets[ri], results: results}
+		results[ri] = r.cond.eval(ev) != 0
+		if !results[ri] && r.Global {
+			return nil
+		}
+		if !results[ri] || r.Private {
+			continue
+		}
+		h := YaraHit{Rule: r.Name}
+		for si, s := range r.strs {
+			if ys.counts[ri][si] > 0 && s.id != "$" {
+				h.Strings = append(h.Strings, s.id)
+			}
+		}
+		hits = append(hits, h)
+	}
+	return hits
+}
+
+func foldASCII(b []byte) []byte {
+	out := slices.Clone(b)
+	foldASCIIInPlace(out)
+	return out
+}
+
+// foldASCIIInPlace lowercases ASCII letters only, so offsets never move.
+func foldASCIIInPlace(b []byte) {
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+}
+
+func toUTF16LE(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	out := make([]byte, 0, len(u)*2)
+	for _, c := range u {
+		out = append(out, byte(c), byte(c>>8))
+	}
+	return out
+}
@@ -0,0 +1,183 @@
+package internal
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func yaraHits(t *testing.T, src string, data []byte) map[string][]string {
+	t.Helper()
+	rules, err := ParseYara(src)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	ys := newYaraScanner(rules)
+	// feed in small writes to exercise buffering
+	for i := 0; i < len(data); i += 7000 {
+		_, _ = ys.Write(data[i:min(i+7000, len(data))])
+	}
+	out := map[string][]string{}
+	for _, h := range ys.finish() {
+		out[h.Rule] = h.Strings
+	}
+	return out
+}
+
+func TestYara_TextModifiers(t *testing.T) {
+	src := `
+rule Seed : crypto {
+  meta:
+    author = "ir"
+    score = 80
+  strings:
+    $a = "seed phrase" nocase
+    $b = "mnemonic" wide
+    $c = "key" fullword
+  condition:
+    any of them
+}
+rule AllOf { strings: $a = "seed" nocase $b = "mnemonic" wide ascii condition: all of ($a, $b) }
+`
+	data := append([]byte("My SEED Phrase is ... keyboard\n"), toUTF16LE("mnemonic")...)
+	hits := yaraHits(t, src, data)
+	if got := hits["Seed"]; !slices.Equal(got, []string{"$a", "$b"}) {
+		t.Fatalf("Seed strings: %v", got)
+	}
+	if _, ok := hits["AllOf"]; !ok {
+		t.Fatalf("AllOf must match: %v", hits)
+	}
+	// fullword must not hit inside "keyboard" alone
+	hits = yaraHits(t, src, []byte("keyboard"))
+	if len(hits) != 0 {
+		t.Fatalf("unexpected hits: %v", hits)
+	}
+	hits = yaraHits(t, src, []byte("the key."))
+	if got := hits["Seed"]; !slices.Equal(got, []string{"$c"}) {
+		t.Fatalf("fullword: %v", got)
+	}
+}
+
+func TestYara_HexAndRegex(t *testing.T) {
+	src := `
+rule Hex {
+  strings:
+    $mz = { 4D 5A }
+    $h = { DE AD ?? EF [1-2] ( 01 | 02 ) C? }
+    $re = /user=[a-z]+/i
+  condition:
+    $mz at 0 and $h and $re
+}
+`
+	data := []byte("MZ....\xde\xad\x00\xef\xff\x02\xc7 USER=admin")
+	hits := yaraHits(t, src, data)
+	if got := hits["Hex"]; !slices.Equal(got, []string{"$mz", "$h", "$re"}) {
+		t.Fatalf("Hex: %v (%v)", got, hits)
+	}
+	// $mz not at 0
+	if hits := yaraHits(t, src, append([]byte("x"), data...)); len(hits) != 0 {
+		t.Fatalf("at 0 must fail: %v", hits)
+	}
+}
+
+func TestYara_CountsFilesizeAndRefs(t *testing.T) {
+	src := `
+private rule Many { strings: $a = "ERR" condition: #a > 3 }
+rule Small { condition: filesize < 1KB }
+rule Both { condition: Many and Small }
+rule Two { strings: $x1 = "one" $x2 = "two" $y = "three" condition: 2 of ($x*) and not $y }
+rule Range { strings: $a = "ERR" condition: $a in (0..10) and none of ($b*) strings_end }
+`
+	if _, err := ParseYara(src); err == nil {
+		t.Fatal("expected parse error for unknown identifier")
+	}
+	src = strings.Replace(src, " strings_end", "", 1)
+	if _, err := ParseYara(src); err == nil {
+		t.Fatal("expected parse error for undefined $b*")
+	}
+	src = strings.Replace(src, " and none of ($b*)", "", 1)
+
+	hits := yaraHits(t, src, []byte("ERR ERR ERR ERR one two"))
+	for _, r := range []string{"Small", "Both", "Two", "Range"} {
+		if _, ok := hits[r]; !ok {
+			t.Errorf("%s must match: %v", r, hits)
+		}
+	}
+	if _, ok := hits["Many"]; ok {
+		t.Fatal("private rule must not be reported")
+	}
+
+	hits = yaraHits(t, src, []byte("ERR ERR ERR one"))
+	if _, ok := hits["Both"]; ok {
+		t.Fatalf("#a > 3 must fail with 3 hits: %v", hits)
+	}
+}
+
+func TestYara_Global(t *testing.T) {
+	src := `
+global rule Text { strings: $z = { 00 00 } condition: not $z }
+global private rule Small { condition: filesize < 1KB }
+rule Key { strings: $a = "key" condition: $a }
+`
+	hits := yaraHits(t, src, []byte("key"))
+	if _, ok := hits["Key"]; !ok {
+		t.Fatalf("Key must match: %v", hits)
+	}
+	if _, ok := hits["Text"]; !ok {
+		t.Fatalf("a global rule is reported like any other: %v", hits)
+	}
+	if _, ok := hits["Small"]; ok {
+		t.Fatal("private rule must not be reported")
+	}
+	for _, data := range [][]byte{[]byte("key\x00\x00"), append([]byte("key"), bytes.Repeat([]byte{'.'}, 2048)...)} {
+		if hits := yaraHits(t, src, data); len(hits) != 0 {
+			t.Fatalf("failed global rule must suppress all: %v", hits)
+		}
+	}
+}
+
+func TestYara_AcrossChunks(t *testing.T) {
+	src := `rule Edge { strings: $a = "needle-in-haystack" $h = { AA BB CC } condition: #a == 2 and #h == 1 }`
+	data := bytes.Repeat([]byte{'.'}, yaraChunk+yaraOverlap-5)
+	data = append(data, "needle-in-haystack"...)
+	data = append(data, bytes.Repeat([]byte{'.'}, yaraChunk)...)
+	data = append(data, "needle-in-haystack\xaa\xbb\xcc"...)
+	if hits := yaraHits(t, src, data); hits["Edge"] == nil {
+		t.Fatalf("expected Edge: %v", hits)
+	}
+}
+
+func TestYara_ParseErrors(t *testing.T) {
+	bad := []string{
+		`import "pe" rule A { condition: true }`,
+		`rule A { strings: $a = "x" condition: $b }`,
+		`rule A { strings: $a = { 4D [2] } condition: $a }`,
+		`rule A { strings: $a = { 4D [4-] 5A } condition: $a }`,
+		`rule A { strings: $a = { 4D [0-10000] 5A } condition: $a }`,
+		`rule A { strings: $a = { AA [0-4096] BB [0-4096] CC } condition: $a }`,
+		`rule A { strings: $a = { AA ( BB [0-4096] CC | DD ) } condition: $a }`,
+		`rule A { strings: $a = /x/ wide condition: $a }`,
+		`rule A { condition: true } rule A { condition: true }`,
+		`rule A { condition: true`,
+	}
+	for _, src := range bad {
+		if _, err := ParseYara(src); err == nil {
+			t.Errorf("expected error for %q", src)
+		}
+	}
+}
+
+func TestMatchReader_Yara(t *testing.T) {
+	rules, err := ParseYara(`rule Creds { strings: $u = "user" $p = "pass" condition: all of them }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []MatchResult
+	var matchCnt, errCnt atomic.Int64
+	matchReader(bytes.NewBufferString("user=a\npass=b\n"), &RuleSet{Yara: rules}, ScanOptions{}, func(m MatchResult) { got = append(got, m) }, "/f", "", &matchCnt, &errCnt)
+	if len(got) != 1 || got[0].Rule != "Creds" || !slices.Equal(got[0].Strings, []string{"$u", "$p"}) {
+		t.Fatalf("unexpected: %+v", got)
+	}
+}
@@ -0,0 +1,268 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	deflate "compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	iofs "io/fs"
+	"path"
+)
+
+// Encrypted zip entries: traditional PKWARE encryption (ZipCrypto) and
+// WinZip AES (method 99). The entry is decrypted, inflated and checked
+// against its CRC or, for AES, its authentication code, so a wrong password
+// fails at the end of the entry at the latest.
+
+var errZipPassword = errors.New("zip: wrong password")
+
+// openZipEncrypted opens the encrypted entry innerPath of a zip archive.
+func openZipEncrypted(archivePath, innerPath, password string) (io.ReadCloser, error) {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range zr.File {
+		if path.Clean(f.Name) != innerPath {
+			continue
+		}
+		r, err := zipDecrypt(f, password)
+		if err != nil {
+			zr.Close()
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{r, zr}, nil
+	}
+	zr.Close()
+	return nil, &iofs.PathError{Op: "open", Path: innerPath, Err: iofs.ErrNotExist}
+}
+
+// zipDecrypt returns the plain content of an encrypted entry.
+func zipDecrypt(f *zip.File, password string) (io.Reader, error) {
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	method, checkCRC := f.Method, true
+	var r io.Reader
+	if method == 99 {
+		ae, err := winzipAES(f, raw, password)
+		if err != nil {
+			return nil, err
+		}
+		r, method = ae, ae.method
+		checkCRC = ae.version == 1 // AE-2 leaves the CRC zero
+	} else {
+		if r, err = zipCrypto(f, raw, password); err != nil {
+			return nil, err
+		}
+	}
+	src := r
+	switch method {
+	case zip.Store:
+	case zip.Deflate:
+		r = deflate.NewReader(r)
+	case 12:
+		r = bzip2.NewReader(r)
+	default:
+		return nil, fmt.Errorf("%w: method %d", zip.ErrAlgorithm, method)
+	}
+	return &zipCRCReader{r: r, src: src, crc: crc32.NewIEEE(), want: f.CRC32, check: checkCRC}, nil
+}
+
+// zipKeys is the key state of traditional PKWARE encryption.
+type zipKeys [3]uint32
+
+func newZipKeys(password string) *zipKeys {
+	k := &zipKeys{0x12345678, 0x23456789, 0x34567890}
+	for i := 0; i < len(password); i++ {
+		k.update(password[i])
+	}
+	return k
+}
+
+func zipCRCByte(crc uint32, b byte) uint32 {
+	return crc32.IEEETable[byte(crc)^b] ^ crc>>8
+}
+
+func (k *zipKeys) update(p byte) {
+	k[0] = zipCRCByte(k[0], p)
+	k[1] = (k[1]+k[0]&0xff)*134775813 + 1
+	k[2] = zipCRCByte(k[2], byte(k[1]>>24))
+}
+
+func (k *zipKeys) stream() byte {
+	t := k[2] | 2
+	return byte(t * (t ^ 1) >> 8)
+}
+
+func (k *zipKeys) decrypt(b []byte) {
+	for i, c := range b {
+		b[i] = c ^ k.stream()
+		k.update(b[i])
+	}
+}
+
+type zipCryptoReader struct {
+	r io.Reader
+	k *zipKeys
+}
+
+func (z *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := z.r.Read(p)
+	z.k.decrypt(p[:n])
+	return n, err
+}
+
+// zipCrypto checks the password against the last byte of the 12-byte
+// encryption header: the high byte of the CRC, or of the modification time
+// when a data descriptor carries the CRC.
+func zipCrypto(f *zip.File, raw io.Reader, password string) (io.Reader, error) {
+	k := newZipKeys(password)
+	hdr := make([]byte, 12)
+	if _, err := io.ReadFull(raw, hdr); err != nil {
+		return nil, err
+	}
+	k.decrypt(hdr)
+	check := byte(f.CRC32 >> 24)
+	if f.Flags&8 != 0 {
+		check = byte(f.ModifiedTime >> 8)
+	}
+	if hdr[11] != check {
+		return nil, errZipPassword
+	}
+	return &zipCryptoReader{r: raw, k: k}, nil
+}
+
+// winzipReader decrypts AES-CTR with a little-endian counter from 1 and
+// checks the HMAC-SHA1 authentication code that follows the data.
+type winzipReader struct {
+	r       io.Reader // encrypted data
+	tail    io.Reader // authentication code
+	block   cipher.Block
+	ctr, ks [16]byte
+	pos     int
+	mac     hash.Hash
+	done    bool // authentication code checked
+	method  uint16
+	version uint16
+}
+
+func winzipAES(f *zip.File, raw io.Reader, password string) (*winzipReader, error) {
+	var strength byte
+	var version, method uint16
+	found := false
+	for ex := f.Extra; len(ex) >= 4; {
+		id, n := binary.LittleEndian.Uint16(ex), int(binary.LittleEndian.Uint16(ex[2:]))
+		if 4+n > len(ex) {
+			break
+		}
+		if id == 0x9901 && n >= 7 {
+			version, strength = binary.LittleEndian.Uint16(ex[4:]), ex[8]
+			method, found = binary.LittleEndian.Uint16(ex[9:]), true
+		}
+		ex = ex[4+n:]
+	}
+	if !found || strength < 1 || strength > 3 {
+		return nil, errors.New("zip: bad AES extra field")
+	}
+	keyLen := 8 + 8*int(strength)
+	saltLen := keyLen / 2
+	size := int64(f.CompressedSize64) - int64(saltLen) - 2 - 10
+	if size < 0 {
+		return nil, zip.ErrFormat
+	}
+	head := make([]byte, saltLen+2)
+	if _, err := io.ReadFull(raw, head); err != nil {
+		return nil, err
+	}
+	dk, err := pbkdf2.Key(sha1.New, password, head[:saltLen], 1000, 2*keyLen+2)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(dk[2*keyLen:], head[saltLen:]) {
+		return nil, errZipPassword
+	}
+	block, err := aes.NewCipher(dk[:keyLen])
+	if err != nil {
+		return nil, err
+	}
+	return &winzipReader{
+		r:       io.LimitReader(raw, size),
+		tail:    raw,
+		block:   block,
+		pos:     16,
+		mac:     hmac.New(sha1.New, dk[keyLen:2*keyLen]),
+		method:  method,
+		version: version,
+	}, nil
+}
+
+func (w *winzipReader) Read(p []byte) (int, error) {
+	n, err := w.r.Read(p)
+	w.mac.Write(p[:n])
+	for i := range p[:n] {
+		if w.pos == 16 {
+			for j := range w.ctr {
+				if w.ctr[j]++; w.ctr[j] != 0 {
+					break
+				}
+			}
+			w.block.Encrypt(w.ks[:], w.ctr[:])
+			w.pos = 0
+		}
+		p[i] ^= w.ks[w.pos]
+		w.pos++
+	}
+	if err == io.EOF && !w.done {
+		w.done = true
+		code := make([]byte, 10)
+		if _, err := io.ReadFull(w.tail, code); err != nil {
+			return n, err
+		}
+		if !hmac.Equal(code, w.mac.Sum(nil)[:10]) {
+			return n, zip.ErrChecksum
+		}
+	}
+	return n, err
+}
+
+// zipCRCReader checks the CRC of the plain content at its end, and drains
+// the decrypted stream a decompressor may stop short of, so the AES
+// authentication code is checked too.
+type zipCRCReader struct {
+	r     io.Reader
+	src   io.Reader
+	crc   hash.Hash32
+	want  uint32
+	check bool
+}
+
+func (z *zipCRCReader) Read(p []byte) (int, error) {
+	n, err := z.r.Read(p)
+	z.crc.Write(p[:n])
+	if err != io.EOF {
+		return n, err
+	}
+	if _, err := io.Copy(io.Discard, z.src); err != nil {
+		return n, err
+	}
+	if z.check && z.crc.Sum32() != z.want {
+		return n, zip.ErrChecksum
+	}
+	return n, err
+}